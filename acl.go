@@ -0,0 +1,164 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// Stored in the root repository.
+	RegistryACLKey = "acl.json"
+
+	RoleReader = "reader"
+	RoleWriter = "writer"
+	RoleOwner  = "owner"
+
+	// Attempts to update the ACL when it's changed concurrently.
+	aclUpdateAttempts = 5
+)
+
+var (
+	ErrInvalidRole      = errors.New("Invalid role")
+	ErrInvalidPrincipal = errors.New("Invalid principal")
+	ErrInvalidPrefix    = errors.New("Invalid package prefix")
+	ErrNoRoleBinding    = errors.New("Role binding does not exist")
+)
+
+// Role of the principal (like user:alice or group:dev) on packages under
+// the prefix. Empty prefix is the whole registry.
+type RoleBinding struct {
+	Prefix    string `json:"prefix"`
+	Principal string `json:"principal"`
+	Role      string `json:"role"`
+}
+
+type ACL struct {
+	ApiVersion string        `json:"api_version"`
+	Bindings   []RoleBinding `json:"bindings"`
+	UpdatedAt  UnixTimestamp `json:"updated_at"`
+}
+
+func NewRoleBinding(prefix, principal, role string) (binding RoleBinding, err error) {
+	prefix = strings.Trim(prefix, "/")
+	switch {
+	case prefix != "" && !IsValidPackageName(prefix):
+		err = fmt.Errorf("%w: %s", ErrInvalidPrefix, prefix)
+	case principal == "" || strings.IndexFunc(principal, unicode.IsSpace) >= 0:
+		err = fmt.Errorf("%w: %q", ErrInvalidPrincipal, principal)
+	case role != RoleReader && role != RoleWriter && role != RoleOwner:
+		err = fmt.Errorf("%w: %s", ErrInvalidRole, role)
+	default:
+		binding = RoleBinding{
+			Prefix:    prefix,
+			Principal: principal,
+			Role:      role,
+		}
+	}
+	return
+}
+
+// Whether the binding applies to packages under the prefix.
+func (b RoleBinding) Covers(prefix string) bool {
+	prefix = strings.Trim(prefix, "/")
+	return b.Prefix == "" || b.Prefix == prefix || strings.HasPrefix(prefix, b.Prefix+"/")
+}
+
+// Add the binding, returns false if it's already there.
+func (a *ACL) Grant(binding RoleBinding) bool {
+	for _, existing := range a.Bindings {
+		if existing == binding {
+			return false
+		}
+	}
+	a.Bindings = append(a.Bindings, binding)
+	sort.Slice(a.Bindings, func(i, j int) bool {
+		x, y := a.Bindings[i], a.Bindings[j]
+		if x.Prefix != y.Prefix {
+			return x.Prefix < y.Prefix
+		}
+		if x.Principal != y.Principal {
+			return x.Principal < y.Principal
+		}
+		return x.Role < y.Role
+	})
+	return true
+}
+
+// Remove the binding, returns false if it's not there.
+func (a *ACL) Revoke(binding RoleBinding) bool {
+	for i, existing := range a.Bindings {
+		if existing == binding {
+			a.Bindings = append(a.Bindings[:i], a.Bindings[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Bindings applying to the prefix, including ones inherited from parent
+// prefixes.
+func (a ACL) Effective(prefix string) []RoleBinding {
+	result := []RoleBinding{}
+	for _, binding := range a.Bindings {
+		if binding.Covers(prefix) {
+			result = append(result, binding)
+		}
+	}
+	return result
+}
+
+// Registry without the ACL has an empty one.
+func (c *RegistryImpl) GetACL(ctx context.Context) (*ACL, error) {
+	acl := &ACL{}
+	_, err := c.getACL(ctx, acl)
+	if err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+func (c *RegistryImpl) getACL(ctx context.Context, acl *ACL) (string, error) {
+	etag, err := c.rootRepository.GetJSONWithETag(ctx, RegistryACLKey, acl)
+	if errors.Is(err, os.ErrNotExist) {
+		*acl = ACL{ApiVersion: LatestVersion}
+		return "", nil
+	}
+	return etag, err
+}
+
+// Apply the update to the current ACL and store it. The update is retried
+// if the ACL is changed concurrently.
+func (c *RegistryImpl) UpdateACL(ctx context.Context, update func(*ACL) error) (*ACL, error) {
+	if !c.cfg.Admin {
+		return nil, fmt.Errorf("%w: UpdateACL", ErrRegistryAdminIsNotAllowed)
+	}
+
+	var err error
+	for attempt := 0; attempt < aclUpdateAttempts; attempt++ {
+		acl := &ACL{}
+		var etag string
+		if etag, err = c.getACL(ctx, acl); err != nil {
+			return nil, err
+		}
+		if err = update(acl); err != nil {
+			return nil, err
+		}
+
+		acl.ApiVersion = LatestVersion
+		acl.UpdatedAt = UnixTimestamp{time.Now()}
+		err = c.rootRepository.PutJSONIf(ctx, RegistryACLKey, acl, etag)
+		if err == nil {
+			return acl, nil
+		}
+		if !errors.Is(err, ErrConditionFailed) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,430 @@
+package shop
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnsafeSymlink = errors.New("Symlink points outside of the instance")
+	ErrSpecialMode   = errors.New("File has setuid, setgid or sticky bit")
+)
+
+const specialModes = fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// Mode of files in archives: ones with any executable bit are 0755, other
+// ones are 0644, and so are directories 0755. Special bits are kept, symlinks
+// are left as is.
+func normalizeMode(mode fs.FileMode) fs.FileMode {
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return mode
+	case mode.IsDir() || mode&0111 != 0:
+		return mode&^fs.ModePerm | 0755
+	default:
+		return mode&^fs.ModePerm | 0644
+	}
+}
+
+func checkSpecialMode(name string, mode fs.FileMode, allow bool) error {
+	if mode&specialModes != 0 && !allow {
+		return fmt.Errorf("%w: %s: %s", ErrSpecialMode, name, mode)
+	}
+	return nil
+}
+
+// Mode bits of tar headers.
+func tarMode(mode fs.FileMode) int64 {
+	result := int64(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		result |= 04000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		result |= 02000
+	}
+	if mode&fs.ModeSticky != 0 {
+		result |= 01000
+	}
+	return result
+}
+
+type normalizedFileInfo struct {
+	fs.FileInfo
+}
+
+func (fi normalizedFileInfo) Mode() fs.FileMode {
+	return normalizeMode(fi.FileInfo.Mode())
+}
+
+// Directory tree for MakeArchive. Unlike os.DirFS, symlinks in it could be
+// read on any Go version.
+func DirFS(dir string) fs.FS {
+	return dirFS{os.DirFS(dir), dir}
+}
+
+type dirFS struct {
+	fs.FS
+	dir string
+}
+
+func (f dirFS) ReadLink(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	target, err := os.Readlink(filepath.Join(f.dir, filepath.FromSlash(name)))
+	return filepath.ToSlash(target), err
+}
+
+type readLinkFS interface {
+	ReadLink(name string) (string, error)
+}
+
+func readLink(fsys fs.FS, name string) (string, error) {
+	if fsys, ok := fsys.(readLinkFS); ok {
+		return fsys.ReadLink(name)
+	}
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: errors.ErrUnsupported}
+}
+
+// Whether the symlink at name points to an absolute path, or outside of the
+// tree it's in.
+func isUnsafeSymlink(name, target string) bool {
+	if path.IsAbs(target) || filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return true
+	}
+	resolved := path.Join(path.Dir(name), target)
+	return resolved == ".." || strings.HasPrefix(resolved, "../")
+}
+
+// Fail with ErrUnsafeSymlink if any symlink under dir points to an absolute
+// path or outside of dir.
+func CheckSymlinks(dir string) error {
+	return filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.Type() != fs.ModeSymlink {
+			return err
+		}
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		target, err := os.Readlink(file)
+		if err != nil {
+			return err
+		}
+		if isUnsafeSymlink(filepath.ToSlash(name), filepath.ToSlash(target)) {
+			return fmt.Errorf("%w: %s -> %s", ErrUnsafeSymlink, filepath.ToSlash(name), target)
+		}
+		return nil
+	})
+}
+
+// Writer of instance archives, tar ones compressed with the compression, zip
+// ones, or tar ones of instances stored file by file.
+type archiveWriter interface {
+	// Entry of the directory, regular file or symlink, content of files is
+	// read from src.
+	WriteEntry(name string, info fs.FileInfo, target string, src io.Reader) error
+	WriteFile(file ArchiveFile, modTime time.Time, src io.Reader) error
+	Close() error
+}
+
+type pendingDir struct {
+	name string
+	info fs.FileInfo
+}
+
+// Write the tree into the archive, as archive/tar.Writer.AddFS does, but with
+// symlinks kept as link entries, modes normalized and ignored files left out.
+// With includes, directories are only written along with files in them.
+func addFS(archive archiveWriter, fsys fs.FS, opts ArchiveOptions) error {
+	var pending []pendingDir
+	return fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return err
+		}
+		if opts.Ignore.Ignored(name, entry.IsDir()) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err = checkSpecialMode(name, info.Mode(), opts.AllowSpecialModes); err != nil {
+			return err
+		}
+		info = normalizedFileInfo{info}
+
+		for len(pending) > 0 && !strings.HasPrefix(name, pending[len(pending)-1].name+"/") {
+			pending = pending[:len(pending)-1]
+		}
+		if !opts.Include.includes(name, entry.IsDir()) {
+			if entry.IsDir() {
+				pending = append(pending, pendingDir{name, info})
+			}
+			return nil
+		}
+		for _, dir := range pending {
+			if err = archive.WriteEntry(dir.name, dir.info, "", nil); err != nil {
+				return err
+			}
+		}
+		pending = nil
+
+		switch {
+		case entry.IsDir():
+			return archive.WriteEntry(name, info, "", nil)
+		case entry.Type() == fs.ModeSymlink:
+			target, err := readLink(fsys, name)
+			if err != nil {
+				return err
+			}
+			return archive.WriteEntry(name, info, target, nil)
+		case !entry.Type().IsRegular():
+			return fmt.Errorf("%w: %s: not a regular file, directory or symlink", ErrInvalidArchive, name)
+		}
+
+		file, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return archive.WriteEntry(name, info, "", file)
+	})
+}
+
+func newArchiveWriter(dst io.Writer, compression string) (archiveWriter, error) {
+	switch compression {
+	case CompressionZip:
+		return zipArchiveWriter{zip.NewWriter(dst)}, nil
+	case CompressionFiles, CompressionDelta:
+		return fileManifestArchiveWriter{tar.NewWriter(dst)}, nil
+	}
+	compressor, err := newCompressor(dst, compression)
+	if err != nil {
+		return nil, err
+	}
+	return tarArchiveWriter{tar.NewWriter(compressor), compressor}, nil
+}
+
+type tarArchiveWriter struct {
+	*tar.Writer
+	compressor io.WriteCloser
+}
+
+func (w tarArchiveWriter) WriteEntry(name string, info fs.FileInfo, target string, src io.Reader) error {
+	header, err := tar.FileInfoHeader(info, target)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	err = w.WriteHeader(header)
+	if err == nil && src != nil {
+		_, err = io.Copy(w.Writer, src)
+	}
+	return err
+}
+
+func (w tarArchiveWriter) WriteFile(file ArchiveFile, modTime time.Time, src io.Reader) error {
+	err := w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     file.Path,
+		Size:     file.Size,
+		Mode:     tarMode(file.Mode),
+		ModTime:  modTime,
+	})
+	if err == nil {
+		_, err = io.Copy(w.Writer, src)
+	}
+	return err
+}
+
+func (w tarArchiveWriter) Close() error {
+	if err := w.Writer.Close(); err != nil {
+		return err
+	}
+	return w.compressor.Close()
+}
+
+// Modes are kept in the external attributes, as zip does on unix. Symlinks
+// are entries with the target as their content.
+type zipArchiveWriter struct {
+	*zip.Writer
+}
+
+func (w zipArchiveWriter) WriteEntry(name string, info fs.FileInfo, target string, src io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	header.Method = zip.Deflate
+	if target != "" {
+		header.Method = zip.Store
+		src = strings.NewReader(target)
+	}
+	dst, err := w.CreateHeader(header)
+	if err == nil && src != nil {
+		_, err = io.Copy(dst, src)
+	}
+	return err
+}
+
+func (w zipArchiveWriter) WriteFile(file ArchiveFile, modTime time.Time, src io.Reader) error {
+	header := &zip.FileHeader{
+		Name:     file.Path,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	}
+	header.SetMode(file.Mode)
+	dst, err := w.CreateHeader(header)
+	if err == nil {
+		_, err = io.Copy(dst, src)
+	}
+	return err
+}
+
+// Reader of instance archives. Entries of zip archives are given as tar
+// headers too.
+type archiveReader interface {
+	io.ReadCloser
+	Next() (*tar.Header, error)
+}
+
+// Archive reader picked by the first bytes of src.
+func openArchive(src io.Reader) (archiveReader, error) {
+	buffered := bufio.NewReader(src)
+	header, err := buffered.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if DetectCompression(header) == CompressionZip {
+		return openZipArchive(buffered)
+	}
+	decompressor, err := newDecompressor(buffered)
+	if err != nil {
+		return nil, err
+	}
+	return tarArchiveReader{tar.NewReader(decompressor), decompressor}, nil
+}
+
+type tarArchiveReader struct {
+	*tar.Reader
+	decompressor io.ReadCloser
+}
+
+func (r tarArchiveReader) Close() error {
+	return r.decompressor.Close()
+}
+
+// Central directory of zip archives is at the end, so they are spooled into
+// an anonymous temporary file first.
+func openZipArchive(src io.Reader) (archiveReader, error) {
+	file, err := os.CreateTemp("", "shop-zip-*")
+	if err != nil {
+		return nil, err
+	}
+	r := &zipArchiveReader{file: file}
+	err = os.Remove(file.Name())
+	var size int64
+	if err == nil {
+		size, err = io.Copy(file, src)
+	}
+	var archive *zip.Reader
+	if err == nil {
+		archive, err = zip.NewReader(file, size)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r.files = archive.File
+	return r, nil
+}
+
+const maxSymlinkTargetSize = 4096
+
+type zipArchiveReader struct {
+	file    *os.File
+	files   []*zip.File
+	current io.ReadCloser
+}
+
+func (r *zipArchiveReader) Next() (*tar.Header, error) {
+	if r.current != nil {
+		r.current.Close()
+		r.current = nil
+	}
+	if len(r.files) == 0 {
+		return nil, io.EOF
+	}
+	file := r.files[0]
+	r.files = r.files[1:]
+
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     file.Name,
+		Size:     int64(file.UncompressedSize64),
+		Mode:     tarMode(file.Mode()),
+		ModTime:  file.Modified,
+	}
+	switch {
+	case file.Mode().IsDir():
+		header.Typeflag, header.Size = tar.TypeDir, 0
+		return header, nil
+	case file.Mode()&fs.ModeSymlink != 0:
+		header.Typeflag, header.Size = tar.TypeSymlink, 0
+	case !file.Mode().IsRegular():
+		// Rejected as unsupported by the readers.
+		header.Typeflag = tar.TypeChar
+	}
+
+	current, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	if header.Typeflag == tar.TypeSymlink {
+		target, err := io.ReadAll(io.LimitReader(current, maxSymlinkTargetSize))
+		current.Close()
+		if err != nil {
+			return nil, err
+		}
+		header.Linkname = string(target)
+		return header, nil
+	}
+	r.current = current
+	return header, nil
+}
+
+// Content of the current file, checked against its CRC-32 at the end.
+func (r *zipArchiveReader) Read(p []byte) (int, error) {
+	if r.current == nil {
+		return 0, io.EOF
+	}
+	return r.current.Read(p)
+}
+
+func (r *zipArchiveReader) Close() error {
+	if r.current != nil {
+		r.current.Close()
+	}
+	return r.file.Close()
+}
@@ -0,0 +1,267 @@
+package shop
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	MustRegisterBackend(Backend{
+		Scheme:       "artifactory",
+		Factory:      NewArtifactoryFS,
+		Capabilities: BackendCapabilities{Range: true, Copy: true, RemoveAll: true},
+		Jobs:         8,
+	})
+}
+
+const (
+	DefaultArtifactoryContextPath = "artifactory"
+)
+
+var (
+	ErrInvalidArtifactoryURL = errors.New("Artifactory url must be artifactory://host/repo-key[/path]")
+)
+
+// Artifactory generic repository. The url has form
+// artifactory://host/repo-key/path, which is served from
+// https://host/artifactory/repo-key/path.
+type ArtifactoryFS struct {
+	httpClient
+	cfg     RepositoryConfig
+	api     *url.URL
+	repoKey string
+	path    string
+}
+
+func NewArtifactoryFS(ctx context.Context, cfg RepositoryConfig) (RepositoryFS, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(strings.Trim(u.Path, "/"), "/", 2)
+	if parts[0] == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidArtifactoryURL, cfg.URL)
+	}
+
+	access := ArtifactoryAccessConfig{}
+	if cfg.Artifactory != nil {
+		access = *cfg.Artifactory
+	}
+	if access.ContextPath == "" {
+		access.ContextPath = DefaultArtifactoryContextPath
+	}
+
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case access.Token != "":
+		client.header.Set("Authorization", "Bearer "+access.Token)
+	case access.APIKey != "":
+		client.header.Set("X-JFrog-Art-Api", access.APIKey)
+	}
+
+	f := ArtifactoryFS{
+		httpClient: client,
+		cfg:        cfg,
+		api: &url.URL{
+			Scheme: "https",
+			Host:   u.Host,
+			Path:   "/" + strings.Trim(access.ContextPath, "/"),
+		},
+		repoKey: parts[0],
+		path:    "/",
+	}
+	if len(parts) > 1 {
+		f.path = path.Clean("/" + parts[1])
+	}
+	return f, nil
+}
+
+// Path of the key relative to the Artifactory repository.
+func (f ArtifactoryFS) itemPath(key string) string {
+	return path.Join(f.path, key)
+}
+
+func (f ArtifactoryFS) url(elem ...string) string {
+	u := *f.api
+	u.Path = path.Join(append([]string{u.Path}, elem...)...)
+	return u.String()
+}
+
+func (f ArtifactoryFS) Read(ctx context.Context, key string) ([]byte, error) {
+	body, err := f.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+func (f ArtifactoryFS) Write(ctx context.Context, key string, data []byte) error {
+	return f.doDiscard(ctx, http.MethodPut, f.url(f.repoKey, f.itemPath(key)), bytes.NewReader(data), nil)
+}
+
+func (f ArtifactoryFS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := f.doOK(ctx, http.MethodGet, f.url(f.repoKey, f.itemPath(key)), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (f ArtifactoryFS) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	return f.openRange(ctx, f.url(f.repoKey, f.itemPath(key)), offset, length)
+}
+
+func (f ArtifactoryFS) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	ok, err := f.Exists(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, fmt.Errorf("%s: %w", key, os.ErrExist)
+	}
+	return f.upload(ctx, http.MethodPut, f.url(f.repoKey, f.itemPath(key)), nil), nil
+}
+
+func (f ArtifactoryFS) MakeDir(ctx context.Context, key string) error {
+	// Trailing slash tells Artifactory to create a folder.
+	return f.doDiscard(ctx, http.MethodPut, f.url(f.repoKey, f.itemPath(key))+"/", nil, nil)
+}
+
+type artifactoryAQLResult struct {
+	Results []struct {
+		Repo string `json:"repo"`
+		Path string `json:"path"`
+		Name string `json:"name"`
+		Type string `json:"type"`
+	} `json:"results"`
+}
+
+func (f ArtifactoryFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	dir := strings.TrimPrefix(f.itemPath(key), "/")
+	if dir == "" {
+		dir = "."
+	}
+
+	query, err := json.Marshal(map[string]string{
+		"repo": f.repoKey,
+		"path": dir,
+		"type": "any",
+	})
+	if err != nil {
+		return NewErrorCursor[Entry](err)
+	}
+
+	aql := fmt.Sprintf(`items.find(%s).include("name","type")`, query)
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain")
+
+	resp, err := f.doOK(ctx, http.MethodPost, f.url("api/search/aql"), strings.NewReader(aql), header)
+	if err != nil {
+		return NewErrorCursor[Entry](err)
+	}
+	defer resp.Body.Close()
+
+	var result artifactoryAQLResult
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return NewErrorCursor[Entry](err)
+	}
+
+	entries := make([]Entry, 0, len(result.Results))
+	for _, item := range result.Results {
+		if item.Name == "." {
+			continue
+		}
+		entries = append(entries, Entry{
+			Key:      item.Name,
+			IsPrefix: item.Type == "folder",
+		})
+	}
+	return NewSliceCursor(entries)
+}
+
+func (f ArtifactoryFS) Copy(ctx context.Context, src, dst string) error {
+	if ok, err := f.Exists(ctx, dst); err != nil {
+		return err
+	} else if ok {
+		return fmt.Errorf("%s: %w", dst, os.ErrExist)
+	}
+
+	u := f.url("api/copy", f.repoKey, f.itemPath(src)) + "?to=" + url.QueryEscape(path.Join("/", f.repoKey, f.itemPath(dst)))
+	return f.doDiscard(ctx, http.MethodPost, u, nil, nil)
+}
+
+func (f ArtifactoryFS) Remove(ctx context.Context, key string) error {
+	return f.doDiscard(ctx, http.MethodDelete, f.url(f.repoKey, f.itemPath(key)), nil, nil)
+}
+
+// Deleting a folder deletes everything in it.
+func (f ArtifactoryFS) RemoveAll(ctx context.Context, key string) error {
+	err := f.doDiscard(ctx, http.MethodDelete, f.url(f.repoKey, f.itemPath(key))+"/", nil, nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (f ArtifactoryFS) Exists(ctx context.Context, key string) (bool, error) {
+	err := f.doDiscard(ctx, http.MethodGet, f.url("api/storage", f.repoKey, f.itemPath(key)), nil, nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type artifactoryStorageInfo struct {
+	Size         string    `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	Checksums    struct {
+		Sha1 string `json:"sha1"`
+	} `json:"checksums"`
+	Children []json.RawMessage `json:"children"`
+}
+
+func (f ArtifactoryFS) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	resp, err := f.doOK(ctx, http.MethodGet, f.url("api/storage", f.repoKey, f.itemPath(key)), nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	var storage artifactoryStorageInfo
+	if err = json.NewDecoder(resp.Body).Decode(&storage); err != nil {
+		return ObjectInfo{}, err
+	}
+	// Only folders have children.
+	if storage.Children != nil {
+		return ObjectInfo{}, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	}
+
+	size, err := strconv.ParseInt(storage.Size, 10, 64)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Key:     key,
+		Size:    size,
+		ModTime: storage.LastModified,
+		ETag:    storage.Checksums.Sha1,
+	}, nil
+}
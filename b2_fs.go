@@ -0,0 +1,805 @@
+package shop
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	MustRegisterBackend(Backend{
+		Scheme:       "b2",
+		Factory:      NewB2FS,
+		Capabilities: BackendCapabilities{Range: true, Copy: true, RemoveAll: true, Multipart: true, SignURL: true},
+		Jobs:         8,
+	})
+}
+
+const (
+	B2AuthorizeURL = "https://api.backblazeb2.com/b2api/v2/b2_authorize_account"
+	b2ListPageSize = 1000
+)
+
+var (
+	ErrB2NoCredentials  = errors.New("B2 application key is not configured")
+	ErrB2KeyScope       = errors.New("B2 application key does not allow access to the repository")
+	ErrB2BucketNotFound = errors.New("B2 bucket not found")
+)
+
+type B2Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e B2Error) Error() string {
+	return fmt.Sprintf("B2: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+func (e B2Error) Is(target error) bool {
+	switch target {
+	case os.ErrNotExist:
+		return e.Status == http.StatusNotFound
+	case os.ErrPermission:
+		return e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden
+	default:
+		return false
+	}
+}
+
+func (e B2Error) Transient() bool {
+	return e.Status == http.StatusRequestTimeout ||
+		e.Status == http.StatusTooManyRequests ||
+		e.Status == http.StatusInternalServerError ||
+		e.Status == http.StatusServiceUnavailable
+}
+
+func newB2Error(resp *http.Response) error {
+	e := B2Error{}
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Status == 0 {
+		return NewHTTPStatusError(resp)
+	}
+	return e
+}
+
+type b2Authorization struct {
+	AccountId           string `json:"accountId"`
+	AuthorizationToken  string `json:"authorizationToken"`
+	APIURL              string `json:"apiUrl"`
+	DownloadURL         string `json:"downloadUrl"`
+	RecommendedPartSize int64  `json:"recommendedPartSize"`
+	MinimumPartSize     int64  `json:"absoluteMinimumPartSize"`
+	Allowed             struct {
+		BucketId   string `json:"bucketId"`
+		BucketName string `json:"bucketName"`
+		NamePrefix string `json:"namePrefix"`
+	} `json:"allowed"`
+}
+
+// Backblaze B2 repository using the native B2 API. The url has form
+// b2://bucket/path.
+type B2FS struct {
+	cfg      RepositoryConfig
+	client   *http.Client
+	auth     b2Authorization
+	bucketId string
+	bucket   string
+	path     string
+	partSize int64
+}
+
+func NewB2FS(ctx context.Context, cfg RepositoryConfig) (RepositoryFS, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	access := B2AccessConfig{}
+	if cfg.B2 != nil {
+		access = *cfg.B2
+	}
+	if access.KeyId == "" {
+		access.KeyId = os.Getenv("B2_APPLICATION_KEY_ID")
+	}
+	if access.ApplicationKey == "" {
+		access.ApplicationKey = os.Getenv("B2_APPLICATION_KEY")
+	}
+	if access.KeyId == "" || access.ApplicationKey == "" {
+		return nil, ErrB2NoCredentials
+	}
+
+	client, err := newHTTPStdClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &B2FS{
+		cfg:    cfg,
+		client: client,
+		bucket: u.Host,
+		path:   strings.Trim(u.Path, "/"),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, B2AuthorizeURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(access.KeyId, access.ApplicationKey)
+	if err = f.doJSON(req, &f.auth); err != nil {
+		return nil, err
+	}
+
+	// Application keys could be restricted to a single bucket and a name
+	// prefix. Fail early instead of getting 401 on every request.
+	allowed := f.auth.Allowed
+	if allowed.BucketName != "" && allowed.BucketName != f.bucket {
+		return nil, fmt.Errorf("%w: bucket %s", ErrB2KeyScope, f.bucket)
+	}
+	if allowed.NamePrefix != "" && !strings.HasPrefix(f.path+"/", allowed.NamePrefix) {
+		return nil, fmt.Errorf("%w: prefix %s", ErrB2KeyScope, f.path)
+	}
+
+	if allowed.BucketId != "" {
+		f.bucketId = allowed.BucketId
+	} else if err = f.lookupBucket(ctx); err != nil {
+		return nil, err
+	}
+
+	f.partSize = access.PartSize
+	if f.partSize == 0 {
+		f.partSize = f.auth.RecommendedPartSize
+	}
+	f.partSize = max(f.partSize, f.auth.MinimumPartSize)
+
+	return f, nil
+}
+
+func (f *B2FS) lookupBucket(ctx context.Context) error {
+	var resp struct {
+		Buckets []struct {
+			BucketId   string `json:"bucketId"`
+			BucketName string `json:"bucketName"`
+		} `json:"buckets"`
+	}
+	err := f.call(ctx, "b2_list_buckets", map[string]string{
+		"accountId":  f.auth.AccountId,
+		"bucketName": f.bucket,
+	}, &resp)
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range resp.Buckets {
+		if bucket.BucketName == f.bucket {
+			f.bucketId = bucket.BucketId
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrB2BucketNotFound, f.bucket)
+}
+
+func (f *B2FS) doJSON(req *http.Request, output any) error {
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newB2Error(resp)
+	}
+	if output == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(output)
+}
+
+func (f *B2FS) call(ctx context.Context, name string, input, output any) error {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.auth.APIURL+"/b2api/v2/"+name, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", f.auth.AuthorizationToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	return f.doJSON(req, output)
+}
+
+func (f *B2FS) fileName(key string) string {
+	return strings.TrimPrefix(path.Join("/", f.path, key), "/")
+}
+
+func escapeB2FileName(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func (f *B2FS) Read(ctx context.Context, key string) ([]byte, error) {
+	body, err := f.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+type b2UploadURL struct {
+	UploadURL          string `json:"uploadUrl"`
+	AuthorizationToken string `json:"authorizationToken"`
+}
+
+func (f *B2FS) uploadData(ctx context.Context, target b2UploadURL, data []byte, header http.Header) error {
+	sum := sha1.Sum(data)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.UploadURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Authorization", target.AuthorizationToken)
+	req.Header.Set("X-Bz-Content-Sha1", hex.EncodeToString(sum[:]))
+	req.ContentLength = int64(len(data))
+
+	return f.doJSON(req, nil)
+}
+
+func (f *B2FS) Write(ctx context.Context, key string, data []byte) error {
+	var target b2UploadURL
+	err := f.call(ctx, "b2_get_upload_url", map[string]string{"bucketId": f.bucketId}, &target)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("X-Bz-File-Name", escapeB2FileName(f.fileName(key)))
+	header.Set("Content-Type", "b2/x-auto")
+	return f.uploadData(ctx, target, data, header)
+}
+
+func (f *B2FS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := f.download(ctx, key, http.Header{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (f *B2FS) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return http.NoBody, nil
+	}
+	resp, err := f.download(ctx, key, httpRangeHeader(offset, length))
+	if err != nil {
+		return nil, err
+	}
+	return httpRangeBody(resp, offset, length)
+}
+
+func (f *B2FS) download(ctx context.Context, key string, header http.Header) (*http.Response, error) {
+	u := f.auth.DownloadURL + "/file/" + url.PathEscape(f.bucket) + "/" + escapeB2FileName(f.fileName(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	req.Header.Set("Authorization", f.auth.AuthorizationToken)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		return nil, newB2Error(resp)
+	}
+	return resp, nil
+}
+
+const (
+	b2MaxDownloadAuthorizationTTL = 7 * 24 * time.Hour
+)
+
+func (f *B2FS) SignURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if ttl < time.Second || ttl > b2MaxDownloadAuthorizationTTL {
+		return "", fmt.Errorf("%w: link ttl must be between 1s and %s", ErrInvalidURLTTL, b2MaxDownloadAuthorizationTTL)
+	}
+
+	name := f.fileName(key)
+	var resp struct {
+		AuthorizationToken string `json:"authorizationToken"`
+	}
+	err := f.call(ctx, "b2_get_download_authorization", map[string]any{
+		"bucketId":               f.bucketId,
+		"fileNamePrefix":         name,
+		"validDurationInSeconds": int64(ttl / time.Second),
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	return f.auth.DownloadURL + "/file/" + url.PathEscape(f.bucket) + "/" + escapeB2FileName(name) + "?Authorization=" + url.QueryEscape(resp.AuthorizationToken), nil
+}
+
+// Writer which keeps the data in memory until it exceeds the part size, and
+// then switches to a B2 large file upload session.
+type b2Writer struct {
+	fs     *B2FS
+	ctx    context.Context
+	key    string
+	buffer []byte
+
+	fileId     string
+	partSha1s  []string
+	partTarget b2UploadURL
+}
+
+func (w *b2Writer) Write(data []byte) (n int, err error) {
+	for len(data) > 0 {
+		// Full buffer is only flushed when there is more data, so large
+		// file always has at least two parts.
+		if int64(len(w.buffer)) == w.fs.partSize {
+			if err = w.flushPart(); err != nil {
+				w.Abort()
+				return
+			}
+		}
+
+		chunk := min(int64(len(data)), w.fs.partSize-int64(len(w.buffer)))
+		w.buffer = append(w.buffer, data[:chunk]...)
+		data = data[chunk:]
+		n += int(chunk)
+	}
+	return
+}
+
+func (w *b2Writer) start() error {
+	var resp struct {
+		FileId string `json:"fileId"`
+	}
+	err := w.fs.call(w.ctx, "b2_start_large_file", map[string]string{
+		"bucketId":    w.fs.bucketId,
+		"fileName":    w.fs.fileName(w.key),
+		"contentType": "b2/x-auto",
+	}, &resp)
+	if err != nil {
+		return err
+	}
+	w.fileId = resp.FileId
+
+	return w.fs.call(w.ctx, "b2_get_upload_part_url", map[string]string{"fileId": w.fileId}, &w.partTarget)
+}
+
+func (w *b2Writer) flushPart() error {
+	if w.fileId == "" {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+
+	sum := sha1.Sum(w.buffer)
+	header := http.Header{}
+	header.Set("X-Bz-Part-Number", strconv.Itoa(len(w.partSha1s)+1))
+	if err := w.fs.uploadData(w.ctx, w.partTarget, w.buffer, header); err != nil {
+		return err
+	}
+
+	w.partSha1s = append(w.partSha1s, hex.EncodeToString(sum[:]))
+	w.buffer = w.buffer[:0]
+	return nil
+}
+
+// Cancel unfinished large file, so it doesn't consume storage.
+func (w *b2Writer) Abort() (err error) {
+	if w.fileId != "" {
+		err = w.fs.call(context.WithoutCancel(w.ctx), "b2_cancel_large_file", map[string]string{"fileId": w.fileId}, nil)
+		w.fileId = ""
+	}
+	return
+}
+
+func (w *b2Writer) Close() error {
+	if w.fileId == "" {
+		return w.fs.Write(w.ctx, w.key, w.buffer)
+	}
+
+	if len(w.buffer) > 0 {
+		if err := w.flushPart(); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+
+	err := w.fs.call(w.ctx, "b2_finish_large_file", map[string]any{
+		"fileId":        w.fileId,
+		"partSha1Array": w.partSha1s,
+	}, nil)
+	if err != nil {
+		w.Abort()
+	}
+	return err
+}
+
+func (f *B2FS) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	ok, err := f.Exists(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, fmt.Errorf("%s: %w", key, os.ErrExist)
+	}
+
+	return &b2Writer{
+		fs:  f,
+		ctx: ctx,
+		key: key,
+	}, nil
+}
+
+// B2 has no directories, folders exist as long as there are files in them.
+func (f *B2FS) MakeDir(ctx context.Context, key string) error {
+	return nil
+}
+
+type b2FileNames struct {
+	Files []struct {
+		FileName        string `json:"fileName"`
+		FileId          string `json:"fileId"`
+		Action          string `json:"action"`
+		ContentLength   int64  `json:"contentLength"`
+		UploadTimestamp int64  `json:"uploadTimestamp"`
+	} `json:"files"`
+	NextFileName *string `json:"nextFileName"`
+	NextFileId   *string `json:"nextFileId"`
+}
+
+func (f *B2FS) listFileNames(ctx context.Context, prefix, start string, count int) (resp b2FileNames, err error) {
+	req := map[string]any{
+		"bucketId":     f.bucketId,
+		"prefix":       prefix,
+		"delimiter":    "/",
+		"maxFileCount": count,
+	}
+	if start != "" {
+		req["startFileName"] = start
+	}
+	err = f.call(ctx, "b2_list_file_names", req, &resp)
+	return
+}
+
+type b2Cursor struct {
+	fs      *B2FS
+	prefix  string
+	next    string
+	done    bool
+	entries []Entry
+}
+
+func (c *b2Cursor) GetNext(ctx context.Context) (*Entry, error) {
+	if len(c.entries) == 0 && !c.done {
+		resp, err := c.fs.listFileNames(ctx, c.prefix, c.next, b2ListPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range resp.Files {
+			name := strings.TrimPrefix(file.FileName, c.prefix)
+			c.entries = append(c.entries, Entry{
+				Key:      strings.TrimSuffix(name, "/"),
+				IsPrefix: file.Action == "folder",
+			})
+		}
+
+		if resp.NextFileName == nil {
+			c.done = true
+		} else {
+			c.next = *resp.NextFileName
+		}
+	}
+
+	if len(c.entries) == 0 {
+		return nil, nil
+	}
+
+	entry := c.entries[0]
+	c.entries = c.entries[1:]
+	return &entry, nil
+}
+
+func (f *B2FS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	prefix := f.fileName(key)
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &b2Cursor{
+		fs:     f,
+		prefix: prefix,
+	}
+}
+
+func (f *B2FS) Remove(ctx context.Context, key string) error {
+	name := f.fileName(key)
+
+	var resp b2FileNames
+	err := f.call(ctx, "b2_list_file_versions", map[string]any{
+		"bucketId":      f.bucketId,
+		"startFileName": name,
+		"prefix":        name,
+		"maxFileCount":  b2ListPageSize,
+	}, &resp)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, file := range resp.Files {
+		if file.FileName != name {
+			continue
+		}
+		found = true
+		err = f.call(ctx, "b2_delete_file_version", map[string]string{
+			"fileName": file.FileName,
+			"fileId":   file.FileId,
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !found {
+		// B2 has no directories, so the only way to have a folder is
+		// to have files in it.
+		ok, err := f.Exists(ctx, key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return fmt.Errorf("%s: directory is not empty: %w", key, os.ErrExist)
+		}
+		return fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	}
+	return nil
+}
+
+// Delete every version of every file under the prefix.
+func (f *B2FS) RemoveAll(ctx context.Context, key string) error {
+	prefix := f.fileName(key)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var startName, startId *string
+	for {
+		req := map[string]any{
+			"bucketId":     f.bucketId,
+			"prefix":       prefix,
+			"maxFileCount": b2ListPageSize,
+		}
+		if startName != nil {
+			req["startFileName"] = *startName
+		}
+		if startId != nil {
+			req["startFileId"] = *startId
+		}
+
+		var resp b2FileNames
+		err := f.call(ctx, "b2_list_file_versions", req, &resp)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range resp.Files {
+			err = f.call(ctx, "b2_delete_file_version", map[string]string{
+				"fileName": file.FileName,
+				"fileId":   file.FileId,
+			}, nil)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+
+		if resp.NextFileName == nil {
+			return nil
+		}
+		startName, startId = resp.NextFileName, resp.NextFileId
+	}
+}
+
+func (f *B2FS) Exists(ctx context.Context, key string) (bool, error) {
+	name := f.fileName(key)
+	resp, err := f.listFileNames(ctx, name, name, 1)
+	if err != nil {
+		return false, err
+	}
+	if len(resp.Files) > 0 && resp.Files[0].FileName == name {
+		return true, nil
+	}
+
+	resp, err = f.listFileNames(ctx, name+"/", "", 1)
+	if err != nil {
+		return false, err
+	}
+	return len(resp.Files) > 0, nil
+}
+
+func (f *B2FS) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	name := f.fileName(key)
+	resp, err := f.listFileNames(ctx, name, name, 1)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if len(resp.Files) == 0 || resp.Files[0].FileName != name || resp.Files[0].Action == "folder" {
+		return ObjectInfo{}, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	}
+
+	file := resp.Files[0]
+	return ObjectInfo{
+		Key:     key,
+		Size:    file.ContentLength,
+		ModTime: time.UnixMilli(file.UploadTimestamp),
+		ETag:    file.FileId,
+	}, nil
+}
+
+const (
+	// Larger files could only be copied part by part.
+	b2MaxCopySize = 5_000_000_000
+)
+
+func (f *B2FS) Copy(ctx context.Context, src, dst string) error {
+	info, err := f.Stat(ctx, src)
+	if err != nil {
+		return err
+	}
+	if info.Size > b2MaxCopySize {
+		return fmt.Errorf("%w: copy of large file %s", ErrUnimplemented, src)
+	}
+
+	if ok, err := f.Exists(ctx, dst); err != nil {
+		return err
+	} else if ok {
+		return fmt.Errorf("%s: %w", dst, os.ErrExist)
+	}
+
+	return f.call(ctx, "b2_copy_file", map[string]string{
+		"sourceFileId": info.ETag,
+		"fileName":     f.fileName(dst),
+	}, nil)
+}
+
+func (f *B2FS) MinPartSize() int64 {
+	return f.auth.MinimumPartSize
+}
+
+type b2MultipartUpload struct {
+	fs     *B2FS
+	fileId string
+
+	lock      sync.Mutex
+	partSha1s map[int]string
+}
+
+func (f *B2FS) CreateMultipart(ctx context.Context, key string) (MultipartUpload, error) {
+	var resp struct {
+		FileId string `json:"fileId"`
+	}
+	err := f.call(ctx, "b2_start_large_file", map[string]string{
+		"bucketId":    f.bucketId,
+		"fileName":    f.fileName(key),
+		"contentType": "b2/x-auto",
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &b2MultipartUpload{
+		fs:        f,
+		fileId:    resp.FileId,
+		partSha1s: map[int]string{},
+	}, nil
+}
+
+// Parts uploaded so far are listed, their hashes are needed to finish the file.
+func (f *B2FS) ResumeMultipart(ctx context.Context, key, uploadId string) (MultipartUpload, map[int]int64, error) {
+	upload := &b2MultipartUpload{
+		fs:        f,
+		fileId:    uploadId,
+		partSha1s: map[int]string{},
+	}
+	sizes := map[int]int64{}
+	next := 1
+	for next != 0 {
+		var resp struct {
+			Parts []struct {
+				PartNumber    int    `json:"partNumber"`
+				ContentLength int64  `json:"contentLength"`
+				ContentSha1   string `json:"contentSha1"`
+			} `json:"parts"`
+			NextPartNumber *int `json:"nextPartNumber"`
+		}
+		err := f.call(ctx, "b2_list_parts", map[string]any{
+			"fileId":          uploadId,
+			"startPartNumber": next,
+			"maxPartCount":    1000,
+		}, &resp)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, part := range resp.Parts {
+			upload.partSha1s[part.PartNumber] = part.ContentSha1
+			sizes[part.PartNumber] = part.ContentLength
+		}
+		next = 0
+		if resp.NextPartNumber != nil {
+			next = *resp.NextPartNumber
+		}
+	}
+	return upload, sizes, nil
+}
+
+func (u *b2MultipartUpload) UploadId() string {
+	return u.fileId
+}
+
+func (u *b2MultipartUpload) UploadPart(ctx context.Context, number int, data []byte) error {
+	// Upload url could only be used by one request at a time, so every
+	// concurrent part gets its own.
+	var target b2UploadURL
+	err := u.fs.call(ctx, "b2_get_upload_part_url", map[string]string{"fileId": u.fileId}, &target)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("X-Bz-Part-Number", strconv.Itoa(number))
+	if err = u.fs.uploadData(ctx, target, data, header); err != nil {
+		return err
+	}
+
+	sum := sha1.Sum(data)
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.partSha1s[number] = hex.EncodeToString(sum[:])
+	return nil
+}
+
+func (u *b2MultipartUpload) Complete(ctx context.Context) error {
+	u.lock.Lock()
+	partSha1s := make([]string, len(u.partSha1s))
+	for number, sha1 := range u.partSha1s {
+		partSha1s[number-1] = sha1
+	}
+	u.lock.Unlock()
+
+	return u.fs.call(ctx, "b2_finish_large_file", map[string]any{
+		"fileId":        u.fileId,
+		"partSha1Array": partSha1s,
+	}, nil)
+}
+
+func (u *b2MultipartUpload) Abort(ctx context.Context) error {
+	return u.fs.call(ctx, "b2_cancel_large_file", map[string]string{"fileId": u.fileId}, nil)
+}
@@ -0,0 +1,163 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrBackendExists  = errors.New("Repository backend is already registered")
+	ErrInvalidBackend = errors.New("Repository backend must have a scheme and a factory")
+)
+
+type RepositoryFactory func(ctx context.Context, cfg RepositoryConfig) (RepositoryFS, error)
+
+// Optional features of a backend. Optional RepositoryFS interfaces are only
+// used when the matching flag is set, so backends which implement all of
+// them (e.g. proxies to another process) could still opt out. Without
+// ConditionalWrite, conditional writes are emulated with Create or Stat and
+// Write. Without Copy, objects are copied through the client.
+type BackendCapabilities struct {
+	ConditionalWrite bool // ConditionalWriteFS
+	Copy             bool // CopyFS
+	RemoveAll        bool // RemoveAllFS
+	Multipart        bool // MultipartFS
+	SignURL          bool // URLSignerFS
+	Range            bool // RangeReaderFS
+}
+
+// Repository backend, which handles urls with the scheme.
+type Backend struct {
+	Scheme       string
+	Factory      RepositoryFactory
+	Capabilities BackendCapabilities
+	// Parallel operations of batch commands, DefaultJobs if zero.
+	Jobs int
+}
+
+var (
+	backendsLock sync.RWMutex
+	backends     = map[string]Backend{}
+)
+
+// Make the backend available for repository urls with its scheme. Usually
+// called from init of the package implementing it.
+func RegisterBackend(backend Backend) error {
+	if backend.Scheme == "" || backend.Factory == nil {
+		return fmt.Errorf("%w: %q", ErrInvalidBackend, backend.Scheme)
+	}
+
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+
+	if _, ok := backends[backend.Scheme]; ok {
+		return fmt.Errorf("%w: %s", ErrBackendExists, backend.Scheme)
+	}
+	backends[backend.Scheme] = backend
+	return nil
+}
+
+func MustRegisterBackend(backend Backend) {
+	if err := RegisterBackend(backend); err != nil {
+		panic(err)
+	}
+}
+
+func LookupBackend(scheme string) (Backend, bool) {
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+
+	backend, ok := backends[scheme]
+	return backend, ok
+}
+
+// Registered backends sorted by scheme.
+func Backends() []Backend {
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+
+	result := make([]Backend, 0, len(backends))
+	for _, backend := range backends {
+		result = append(result, backend)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Scheme < result[j].Scheme
+	})
+	return result
+}
+
+// Reports whether findCapability could use the optional interface T, passed
+// as a nil *T.
+func (c BackendCapabilities) allows(capability any) bool {
+	switch capability.(type) {
+	case *ConditionalWriteFS:
+		return c.ConditionalWrite
+	case *CopyFS:
+		return c.Copy
+	case *RemoveAllFS:
+		return c.RemoveAll
+	case *MultipartFS:
+		return c.Multipart
+	case *URLSignerFS:
+		return c.SignURL
+	case *RangeReaderFS:
+		return c.Range
+	default:
+		return true
+	}
+}
+
+// Innermost wrapper of every backend, which hides optional interfaces the
+// backend did not declare.
+type backendFS struct {
+	RepositoryFS
+	capabilities BackendCapabilities
+}
+
+func (f backendFS) Unwrap() RepositoryFS {
+	return f.RepositoryFS
+}
+
+// Could be embedded into third-party backends, so they keep compiling when
+// new methods are added to RepositoryFS.
+type UnimplementedFS struct{}
+
+func (UnimplementedFS) Read(ctx context.Context, key string) ([]byte, error) {
+	return nil, fmt.Errorf("%w: read %s", ErrUnimplemented, key)
+}
+
+func (UnimplementedFS) Write(ctx context.Context, key string, data []byte) error {
+	return fmt.Errorf("%w: write %s", ErrUnimplemented, key)
+}
+
+func (UnimplementedFS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("%w: open %s", ErrUnimplemented, key)
+}
+
+func (UnimplementedFS) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("%w: create %s", ErrUnimplemented, key)
+}
+
+func (UnimplementedFS) MakeDir(ctx context.Context, key string) error {
+	return fmt.Errorf("%w: mkdir %s", ErrUnimplemented, key)
+}
+
+func (UnimplementedFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	return NewErrorCursor[Entry](fmt.Errorf("%w: list %s", ErrUnimplemented, key))
+}
+
+func (UnimplementedFS) Remove(ctx context.Context, key string) error {
+	return fmt.Errorf("%w: remove %s", ErrUnimplemented, key)
+}
+
+func (UnimplementedFS) Exists(ctx context.Context, key string) (bool, error) {
+	return false, fmt.Errorf("%w: exists %s", ErrUnimplemented, key)
+}
+
+func (UnimplementedFS) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	return ObjectInfo{}, fmt.Errorf("%w: stat %s", ErrUnimplemented, key)
+}
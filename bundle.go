@@ -0,0 +1,207 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	BundleExtension   = ".bundle"
+	BundleManifestKey = "bundle.json"
+)
+
+var (
+	ErrInvalidBundle = errors.New("Invalid bundle")
+)
+
+type BundleManifest struct {
+	ApiVersion string        `json:"api_version"`
+	Registry   string        `json:"registry"`
+	Prefix     string        `json:"prefix"`
+	CreatedAt  UnixTimestamp `json:"created_at"`
+	// Packages resolved from the ensure file by "bundle create".
+	Packages []EnsureLockPackage `json:"packages,omitempty"`
+}
+
+// Bundle is an archive of a file registry with packages, instances, refs,
+// tags and CAS archives, for moving packages between registries without
+// connectivity between them. It's staged in a temporary directory.
+func bundleRepositoryConfig(dir string) RepositoryConfig {
+	return RepositoryConfig{
+		URL:   "file://" + filepath.ToSlash(dir),
+		Admin: true,
+		Write: true,
+	}
+}
+
+func openBundleRegistry(ctx context.Context, dir string) (Registry, error) {
+	repoCfg := bundleRepositoryConfig(dir)
+	return NewRegistry(ctx, RegistryConfig{
+		URL:      repoCfg.URL,
+		RootRepo: repoCfg,
+		Admin:    true,
+		Write:    true,
+	})
+}
+
+func createBundleRegistry(ctx context.Context, repo Repository, dir, name string) (Registry, error) {
+	repoManifest := RepositoryManifest{
+		ApiVersion: LatestVersion,
+		URL:        repo.GetConfig().URL,
+		Name:       "bundle",
+		UpdatedAt:  UnixTimestamp{time.Now()},
+	}
+	if err := repo.PutManifest(ctx, repoManifest); err != nil {
+		return nil, err
+	}
+	err := repo.PutJSON(ctx, RegistryManifestKey, RegistryManifest{
+		ApiVersion: LatestVersion,
+		Name:       name,
+		RootRepo:   repoManifest,
+		UpdatedAt:  UnixTimestamp{time.Now()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return openBundleRegistry(ctx, dir)
+}
+
+// Stage a bundle registry in a temporary directory, fill it and archive
+// it into dst along with the manifest.
+func writeBundle(ctx context.Context, registry Registry, manifest BundleManifest, dst io.Writer, fill func(bundle Registry) ([]SyncChange, error)) ([]SyncChange, error) {
+	dir, err := os.MkdirTemp("", "shop-bundle-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	registryManifest, err := registry.GetManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := NewRepository(ctx, bundleRepositoryConfig(dir))
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := createBundleRegistry(ctx, repo, dir, registryManifest.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := fill(bundle)
+	if err != nil {
+		return changes, err
+	}
+
+	manifest.ApiVersion = LatestVersion
+	manifest.Registry = registryManifest.Name
+	manifest.CreatedAt = UnixTimestamp{time.Now()}
+	if err = repo.PutJSON(ctx, BundleManifestKey, manifest); err != nil {
+		return changes, err
+	}
+
+	_, err = MakeArchive(dst, os.DirFS(dir), ArchiveOptions{})
+	return changes, err
+}
+
+// Write packages under the prefix into the bundle. All archives are stored
+// in the bundle itself, regardless of the repos they are in.
+func ExportBundle(ctx context.Context, registry Registry, prefix string, dst io.Writer) ([]SyncChange, error) {
+	return writeBundle(ctx, registry, BundleManifest{Prefix: prefix}, dst, func(bundle Registry) ([]SyncChange, error) {
+		return SyncRegistries(ctx, registry, bundle, SyncOptions{Prefix: prefix, RootRepo: true})
+	})
+}
+
+// Write instances resolved from the ensure file into the bundle, with their
+// tags and refs pointing to them, so the ensure file resolves to the same
+// instances when installed from the bundle without the registry.
+func CreateEnsureBundle(ctx context.Context, registry Registry, file EnsureFile, dst io.Writer) (*EnsureLockFile, []SyncChange, error) {
+	lock, err := file.Resolve(ctx, registry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifest := BundleManifest{Packages: lock.Packages}
+	changes, err := writeBundle(ctx, registry, manifest, dst, func(bundle Registry) ([]SyncChange, error) {
+		var changes []SyncChange
+		for _, pkg := range lock.Packages {
+			instance, err := registry.GetPackageInstanceInfo(ctx, pkg.Package, pkg.Id)
+			if err != nil {
+				return changes, err
+			}
+			copied, err := CopyInstance(ctx, registry, bundle, *instance, CopyInstanceOptions{})
+			changes = append(changes, copied...)
+			if err != nil {
+				return changes, err
+			}
+		}
+		return changes, nil
+	})
+	return lock, changes, err
+}
+
+// Bundle extracted into a temporary directory, which is removed on Close.
+type Bundle struct {
+	Manifest BundleManifest
+	Registry Registry
+
+	dir string
+}
+
+func OpenBundle(ctx context.Context, src io.Reader) (*Bundle, error) {
+	dir, err := os.MkdirTemp("", "shop-bundle-*")
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{dir: dir}
+
+	if err = b.open(ctx, src); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Bundle) open(ctx context.Context, src io.Reader) error {
+	if err := ExtractArchive(src, b.dir, ExtractOptions{}); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBundle, err)
+	}
+
+	repo, err := NewRepository(ctx, bundleRepositoryConfig(b.dir))
+	if err != nil {
+		return err
+	}
+	if err = repo.GetJSON(ctx, BundleManifestKey, &b.Manifest); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBundle, err)
+	}
+
+	if b.Registry, err = openBundleRegistry(ctx, b.dir); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBundle, err)
+	}
+	return nil
+}
+
+func (b *Bundle) Close() error {
+	return os.RemoveAll(b.dir)
+}
+
+// Copy packages from the bundle into the registry. Packages which are
+// already in the registry keep their repos, new ones are created in the
+// root repo.
+func ImportBundle(ctx context.Context, src io.Reader, registry Registry, dryRun bool) (*BundleManifest, []SyncChange, error) {
+	bundle, err := OpenBundle(ctx, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer bundle.Close()
+
+	changes, err := SyncRegistries(ctx, bundle.Registry, registry, SyncOptions{DryRun: dryRun, RootRepo: true})
+	return &bundle.Manifest, changes, err
+}
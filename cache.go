@@ -0,0 +1,261 @@
+package shop
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Hit and miss counters of all processes using the cache dir.
+	CacheStatsFile = "stats.json"
+	// Temporary files of unfinished downloads which are older are dropped
+	// by VerifyCache.
+	CacheTempMaxAge = time.Hour
+)
+
+type CacheStats struct {
+	Dir     string `json:"dir"`
+	Size    int64  `json:"size"`
+	Entries int    `json:"entries"`
+	Hits    int64  `json:"hits"`
+	Misses  int64  `json:"misses"`
+}
+
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type cacheCounters struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func isCacheTempFile(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, ".") && strings.Contains(name, ".tmp-")
+}
+
+func isCachedArchive(path string) bool {
+	_, _, ok := ParseCASArchiveName(filepath.Base(path))
+	return ok && filepath.Base(filepath.Dir(path)) == strings.Trim(RegistryCASPrefix, "/")
+}
+
+func isCacheLockFile(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".lock")
+}
+
+// Cached repository objects and archives, and extracted instances as single
+// entries, oldest first.
+func listCacheEntries(dir string) ([]cacheEntry, error) {
+	var entries []cacheEntry
+	for _, sub := range []string{CacheRepositoriesDir, CacheCASDir} {
+		err := filepath.WalkDir(filepath.Join(dir, sub), func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() || isCacheLockFile(p) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			entries = append(entries, cacheEntry{p, info.Size(), info.ModTime()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	extracted, err := os.ReadDir(filepath.Join(dir, CacheExtractedDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, d := range extracted {
+		p := filepath.Join(dir, CacheExtractedDir, d.Name())
+		info, err := d.Info()
+		if err != nil || !d.IsDir() {
+			continue
+		}
+		entry := cacheEntry{path: p, modTime: info.ModTime()}
+		filepath.WalkDir(p, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				if info, err := d.Info(); err == nil {
+					entry.size += info.Size()
+				}
+			}
+			return nil
+		})
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+	return entries, nil
+}
+
+func GetCacheStats(dir string) (CacheStats, error) {
+	stats := CacheStats{Dir: dir}
+	entries, err := listCacheEntries(dir)
+	if err != nil {
+		return stats, err
+	}
+	for _, entry := range entries {
+		if !isCacheTempFile(entry.path) {
+			stats.Size += entry.size
+			stats.Entries++
+		}
+	}
+
+	counters := readCacheCounters(dir)
+	stats.Hits = counters.Hits
+	stats.Misses = counters.Misses
+	return stats, nil
+}
+
+func readCacheCounters(dir string) (counters cacheCounters) {
+	if data, err := os.ReadFile(filepath.Join(dir, CacheStatsFile)); err == nil {
+		_ = json.Unmarshal(data, &counters)
+	}
+	return
+}
+
+// Add hits and misses of this process to the counters in cache dirs. Best
+// effort, concurrent flushes of other processes could be lost.
+func FlushCacheStats() {
+	cacheStates.Range(func(key, value any) bool {
+		dir, state := key.(string), value.(*cacheState)
+		hits, misses := state.hits.Swap(0), state.misses.Swap(0)
+		if hits+misses == 0 {
+			return true
+		}
+
+		counters := readCacheCounters(dir)
+		counters.Hits += hits
+		counters.Misses += misses
+		data, err := json.Marshal(counters)
+		if err != nil {
+			return true
+		}
+
+		tmp, err := os.CreateTemp(dir, "."+CacheStatsFile+".tmp-*")
+		if err != nil {
+			return true
+		}
+		_, err = tmp.Write(data)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), filepath.Join(dir, CacheStatsFile))
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+		return true
+	})
+}
+
+type CacheCleanResult struct {
+	Removed int   `json:"removed"`
+	Freed   int64 `json:"freed"`
+	Size    int64 `json:"size"`
+}
+
+// Remove entries not modified for olderThan (if it's not zero), then the
+// oldest ones until total size fits into maxSize (if it's not negative).
+func CleanCache(dir string, maxSize int64, olderThan time.Duration) (CacheCleanResult, error) {
+	result := CacheCleanResult{}
+	entries, err := listCacheEntries(dir)
+	if err != nil {
+		return result, err
+	}
+	for _, entry := range entries {
+		result.Size += entry.size
+	}
+
+	for _, entry := range entries {
+		expired := olderThan != 0 && time.Since(entry.modTime) > olderThan
+		if !expired && (maxSize < 0 || result.Size <= maxSize) {
+			continue
+		}
+		if os.RemoveAll(entry.path) == nil {
+			result.Removed++
+			result.Freed += entry.size
+			result.Size -= entry.size
+		}
+	}
+	return result, nil
+}
+
+// Drop entries which could not be used: CAS archives with content not
+// matching their id, unreadable metadata and stale temporary files. Returns
+// paths of removed entries relative to the cache dir.
+func VerifyCache(dir string) ([]string, error) {
+	entries, err := listCacheEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	for _, entry := range entries {
+		var valid bool
+		switch {
+		case isCacheTempFile(entry.path):
+			valid = time.Since(entry.modTime) <= CacheTempMaxAge
+		case isCachedArchive(entry.path):
+			valid = checkCachedArchive(entry.path)
+		case strings.HasSuffix(entry.path, ".json"):
+			valid = checkCachedMetadata(entry.path)
+		default:
+			valid = true
+		}
+		if valid {
+			continue
+		}
+
+		if err = os.RemoveAll(entry.path); err != nil {
+			return removed, err
+		}
+		rel, _ := filepath.Rel(dir, entry.path)
+		removed = append(removed, filepath.ToSlash(rel))
+	}
+	return removed, nil
+}
+
+func checkCachedArchive(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	id, _, _ := ParseCASArchiveName(filepath.Base(path))
+	h := instanceIdHashOf(id)
+	if _, err = io.Copy(h, file); err != nil {
+		return false
+	}
+	return h.Id() == id
+}
+
+func checkCachedMetadata(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	var output any
+	return decodeMetadata(data, &output) == nil
+}
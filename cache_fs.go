@@ -0,0 +1,344 @@
+package shop
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultCacheTTL     = 5 * time.Minute
+	DefaultCacheMaxSize = 1 << 30
+	// Subdirectory of the cache dir for repository objects.
+	CacheRepositoriesDir = "repos"
+	// Subdirectory of the cache dir for CAS archives of all repositories.
+	CacheCASDir = "cas"
+)
+
+// Local cache settings, filled in from Config by Config.Registry.
+type CacheConfig struct {
+	Dir     string
+	TTL     time.Duration
+	MaxSize int64
+}
+
+type cacheState struct {
+	lock   sync.Mutex
+	loaded bool
+	size   int64
+
+	hits, misses atomic.Int64
+}
+
+var (
+	// Approximate size of every cache dir used by this process.
+	cacheStates sync.Map
+)
+
+// Read-through RepositoryFS wrapper which keeps Read and Open results under
+// the local cache dir for the configured TTL. Writes through the wrapper
+// drop the cached copy. CAS archives are content addressed instead: they are
+// shared by all repositories, never expire, are checked against their id
+// before they are stored, and are downloaded by one process at a time.
+// Caching is best effort, local failures are ignored.
+type CacheFS struct {
+	fs    RepositoryFS
+	cfg   CacheConfig
+	root  string
+	state *cacheState
+}
+
+func NewCacheFS(fs RepositoryFS, cfg RepositoryConfig) RepositoryFS {
+	cache := *cfg.Cache
+	if cache.TTL == 0 {
+		cache.TTL = DefaultCacheTTL
+	}
+	if cache.MaxSize == 0 {
+		cache.MaxSize = DefaultCacheMaxSize
+	}
+
+	sum := sha256.Sum256([]byte(cfg.URL))
+	state, _ := cacheStates.LoadOrStore(cache.Dir, &cacheState{})
+
+	return CacheFS{
+		fs:    fs,
+		cfg:   cache,
+		root:  filepath.Join(cache.Dir, CacheRepositoriesDir, hex.EncodeToString(sum[:16])),
+		state: state.(*cacheState),
+	}
+}
+
+func (f CacheFS) Unwrap() RepositoryFS {
+	return f.fs
+}
+
+// Instance id of the CAS archive key.
+func cacheArchiveId(key string) (string, bool) {
+	name, ok := strings.CutPrefix(path.Clean("/"+key), RegistryCASPrefix)
+	if !ok {
+		return "", false
+	}
+	id, _, ok := ParseCASArchiveName(name)
+	return id, ok
+}
+
+func (f CacheFS) path(key string) string {
+	if _, ok := cacheArchiveId(key); ok {
+		return filepath.Join(f.cfg.Dir, CacheCASDir, path.Base(key))
+	}
+	return filepath.Join(f.root, filepath.FromSlash(key))
+}
+
+func (f CacheFS) fresh(key string) (string, bool) {
+	p := f.path(key)
+	info, err := os.Stat(p)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	if _, ok := cacheArchiveId(key); !ok && time.Since(info.ModTime()) > f.cfg.TTL {
+		return "", false
+	}
+	return p, true
+}
+
+func (f CacheFS) tempFile(key string) (*os.File, error) {
+	p := f.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".tmp-*")
+}
+
+// Move complete temporary file into place.
+func (f CacheFS) commit(key string, tmp *os.File, size int64) {
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), f.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	f.grow(size)
+}
+
+func (f CacheFS) store(key string, data []byte) {
+	if id, ok := cacheArchiveId(key); ok {
+		h := instanceIdHashOf(id)
+		if h.Write(data); h.Id() != id {
+			return
+		}
+	}
+	tmp, err := f.tempFile(key)
+	if err != nil {
+		return
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return
+	}
+	f.commit(key, tmp, int64(len(data)))
+}
+
+// Archives are immutable, so they stay cached for other repositories.
+func (f CacheFS) invalidate(key string) {
+	if _, ok := cacheArchiveId(key); !ok {
+		os.Remove(f.path(key))
+	}
+}
+
+func (f CacheFS) grow(size int64) {
+	f.state.lock.Lock()
+	defer f.state.lock.Unlock()
+
+	if !f.state.loaded {
+		f.state.size, _ = f.evict(-1)
+		f.state.loaded = true
+	} else {
+		f.state.size += size
+	}
+
+	if f.state.size > f.cfg.MaxSize {
+		f.state.size, _ = f.evict(f.cfg.MaxSize)
+	}
+}
+
+// Remove oldest files until total size fits into maxSize (negative to only
+// compute the size). Returns the remaining size.
+func (f CacheFS) evict(maxSize int64) (int64, error) {
+	result, err := CleanCache(f.cfg.Dir, maxSize, 0)
+	return result.Size, err
+}
+
+func (f CacheFS) Read(ctx context.Context, key string) ([]byte, error) {
+	if p, ok := f.fresh(key); ok {
+		if data, err := os.ReadFile(p); err == nil {
+			f.state.hits.Add(1)
+			return data, nil
+		}
+	}
+
+	f.state.misses.Add(1)
+	data, err := f.fs.Read(ctx, key)
+	if err == nil {
+		f.store(key, data)
+	}
+	return data, err
+}
+
+func (f CacheFS) Write(ctx context.Context, key string, data []byte) error {
+	f.invalidate(key)
+	return f.fs.Write(ctx, key, data)
+}
+
+// Copies everything read from the repository into a temporary file, which
+// is moved into the cache once the whole body is read. Archives are only
+// moved if their content matches the id.
+type cacheReader struct {
+	io.ReadCloser
+	fs     CacheFS
+	key    string
+	tmp    *os.File
+	size   int64
+	id     string
+	hash   *InstanceIdHash
+	unlock func()
+}
+
+func (r *cacheReader) Read(data []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(data)
+	if r.tmp != nil && n > 0 {
+		if _, werr := r.tmp.Write(data[:n]); werr != nil {
+			r.discard()
+		} else if r.hash != nil {
+			r.hash.Write(data[:n])
+		}
+		r.size += int64(n)
+	}
+	if r.tmp != nil && errors.Is(err, io.EOF) {
+		if r.hash != nil && r.hash.Id() != r.id {
+			r.discard()
+		} else {
+			r.fs.commit(r.key, r.tmp, r.size)
+			r.tmp = nil
+			r.release()
+		}
+	}
+	return
+}
+
+func (r *cacheReader) discard() {
+	r.tmp.Close()
+	os.Remove(r.tmp.Name())
+	r.tmp = nil
+	r.release()
+}
+
+func (r *cacheReader) release() {
+	if r.unlock != nil {
+		r.unlock()
+		r.unlock = nil
+	}
+}
+
+func (r *cacheReader) Close() error {
+	if r.tmp != nil {
+		r.discard()
+	}
+	return r.ReadCloser.Close()
+}
+
+func (f CacheFS) WriteIf(ctx context.Context, key string, data []byte, etag string) error {
+	f.invalidate(key)
+	return writeIf(ctx, f.fs, key, data, etag)
+}
+
+func (f CacheFS) openCached(key string) (io.ReadCloser, bool) {
+	if p, ok := f.fresh(key); ok {
+		if file, err := os.Open(p); err == nil {
+			f.state.hits.Add(1)
+			return file, true
+		}
+	}
+	return nil, false
+}
+
+func (f CacheFS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if file, ok := f.openCached(key); ok {
+		return file, nil
+	}
+
+	reader := &cacheReader{fs: f, key: key}
+	if id, ok := cacheArchiveId(key); ok {
+		reader.id = id
+		reader.hash = instanceIdHashOf(id)
+		// Archive downloaded by another process is waited for. Locks of
+		// downloads longer than the stale lock age are broken, so the
+		// archive is downloaded twice then.
+		if err := os.MkdirAll(filepath.Dir(f.path(key)), 0755); err == nil {
+			unlock, err := lockFile(ctx, f.path(key))
+			if err != nil && ctx.Err() != nil {
+				return nil, err
+			}
+			reader.unlock = unlock
+		}
+		if file, ok := f.openCached(key); ok {
+			reader.release()
+			return file, nil
+		}
+	}
+
+	f.state.misses.Add(1)
+	body, err := f.fs.Open(ctx, key)
+	if err != nil {
+		reader.release()
+		return nil, err
+	}
+
+	tmp, err := f.tempFile(key)
+	if err != nil {
+		reader.release()
+		return body, nil
+	}
+	reader.ReadCloser = body
+	reader.tmp = tmp
+	return reader, nil
+}
+
+func (f CacheFS) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	f.invalidate(key)
+	return f.fs.Create(ctx, key)
+}
+
+func (f CacheFS) MakeDir(ctx context.Context, key string) error {
+	return f.fs.MakeDir(ctx, key)
+}
+
+func (f CacheFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	return f.fs.ListDir(ctx, key)
+}
+
+func (f CacheFS) Remove(ctx context.Context, key string) error {
+	f.invalidate(key)
+	return f.fs.Remove(ctx, key)
+}
+
+func (f CacheFS) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	return f.fs.Stat(ctx, key)
+}
+
+func (f CacheFS) RemoveAll(ctx context.Context, prefix string) error {
+	os.RemoveAll(f.path(prefix))
+	return removeAll(ctx, f.fs, prefix)
+}
+
+func (f CacheFS) Exists(ctx context.Context, key string) (bool, error) {
+	return f.fs.Exists(ctx, key)
+}
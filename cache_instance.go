@@ -0,0 +1,99 @@
+package shop
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// Subdirectory of the cache dir for extracted instances.
+	CacheExtractedDir = "extracted"
+)
+
+// Directory in the cache dir with the instance extracted from its archive.
+// Instances are extracted once and shared by all registries, other processes
+// extracting the same instance are waited for. Files in the directory must
+// not be modified, they are copied by Site.InstallDir.
+func ExtractCachedInstance(ctx context.Context, registry Registry, cache CacheConfig, instance Instance) (string, error) {
+	dir := filepath.Join(cache.Dir, CacheExtractedDir, instance.Id)
+	// Modification time of the directory is the last use for cache cleaning.
+	now := time.Now()
+	if os.Chtimes(dir, now, now) == nil {
+		return dir, nil
+	}
+
+	parent := filepath.Dir(dir)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	unlock, err := lockFile(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	if _, err = os.Stat(dir); err == nil {
+		return dir, nil
+	}
+
+	tmp, err := os.MkdirTemp(parent, "."+instance.Id+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(registry.DownloadPackageInstance(ctx, instance, writer))
+	}()
+	defer reader.Close()
+
+	// Rest of the archive is read, so hash mismatches are reported.
+	err = ExtractArchive(reader, tmp, ExtractOptions{})
+	if err == nil {
+		_, err = io.Copy(io.Discard, reader)
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0755)
+	}
+	if err == nil {
+		err = os.Rename(tmp, dir)
+	}
+	if err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Instance ready to be installed: extracted in the cache dir if the registry
+// has one, downloaded into a temporary file otherwise.
+type localInstance struct {
+	dir     string
+	archive *os.File
+}
+
+func fetchInstance(ctx context.Context, registry Registry, instance Instance) (localInstance, error) {
+	if cache := registry.GetConfig().Cache; cache != nil && cache.Dir != "" {
+		dir, err := ExtractCachedInstance(ctx, registry, *cache, instance)
+		return localInstance{dir: dir}, err
+	}
+
+	archive, err := DownloadPackageInstanceFile(ctx, registry, instance)
+	return localInstance{archive: archive}, err
+}
+
+func (l localInstance) Close() error {
+	if l.archive == nil {
+		return nil
+	}
+	return l.archive.Close()
+}
+
+func (s Site) installLocal(ctx context.Context, instance Instance, local localInstance) (*SitePackage, error) {
+	if local.dir != "" {
+		return s.InstallDir(ctx, instance, local.dir)
+	}
+	return s.Install(ctx, instance, local.archive)
+}
@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+const (
+	RoleHelp = "Role is one of " + shop.RoleReader + ", " + shop.RoleWriter + " or " + shop.RoleOwner +
+		", empty prefix (/) is the whole registry"
+)
+
+type ACLCommand struct {
+	*PackageCommand
+}
+
+func NewACLCommand(args *GlobalArguments) *cobra.Command {
+	c := &ACLCommand{
+		PackageCommand: &PackageCommand{
+			Arguments: args,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "acl [-r registry]",
+		Short: "Manage access of principals to package prefixes.",
+		Long: "Manage role bindings of principals (like user:alice or group:dev) to package prefixes.\n" +
+			"They are stored in the root repository of the registry.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return c.LoadConfig()
+		},
+	}
+
+	cmd.AddCommand(
+		NewACLGrantCommand(c),
+		NewACLRevokeCommand(c),
+		NewACLListCommand(c),
+	)
+
+	cmd.PersistentFlags().StringVarP(&c.RegistryName, "registry", "r", "", "Registry name.")
+
+	return cmd
+}
+
+func (c *ACLCommand) update(ctx context.Context, prefix, principal, role string, grant bool) error {
+	binding, err := shop.NewRoleBinding(prefix, principal, role)
+	if err != nil {
+		return err
+	}
+
+	registryClient, err := shop.NewRegistry(ctx, c.Cfg.Registry(c.RegistryName))
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	_, err = registryClient.UpdateACL(ctx, func(acl *shop.ACL) error {
+		if grant {
+			acl.Grant(binding)
+		} else if !acl.Revoke(binding) {
+			return fmt.Errorf("%w: %s %s on %s", shop.ErrNoRoleBinding, binding.Principal, binding.Role, prefixText(binding.Prefix))
+		}
+		return nil
+	})
+	return err
+}
+
+type ACLGrantCommand struct {
+	*ACLCommand
+}
+
+func NewACLGrantCommand(parent *ACLCommand) *cobra.Command {
+	c := &ACLGrantCommand{
+		ACLCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "grant prefix principal role",
+		Short: "Give the principal a role on packages under the prefix.",
+		Long:  "Give the principal a role on packages under the prefix.\n" + RoleHelp + ".",
+		Args:  cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.update(cmd.Context(), args[0], args[1], args[2], true)
+		},
+	}
+
+	return cmd
+}
+
+type ACLRevokeCommand struct {
+	*ACLCommand
+}
+
+func NewACLRevokeCommand(parent *ACLCommand) *cobra.Command {
+	c := &ACLRevokeCommand{
+		ACLCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:     "revoke prefix principal role",
+		Aliases: []string{"rm"},
+		Short:   "Take the role on packages under the prefix from the principal.",
+		Long:    "Take the role on packages under the prefix from the principal.\n" + RoleHelp + ".",
+		Args:    cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.update(cmd.Context(), args[0], args[1], args[2], false)
+		},
+	}
+
+	return cmd
+}
+
+type ACLListCommand struct {
+	*ACLCommand
+}
+
+func NewACLListCommand(parent *ACLCommand) *cobra.Command {
+	c := &ACLListCommand{
+		ACLCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:     "list [prefix]",
+		Aliases: []string{"ls"},
+		Short:   "List role bindings.",
+		Long:    "List all role bindings, or ones applying to the prefix, including inherited from parent prefixes.",
+		Args:    cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args)
+		},
+	}
+
+	return cmd
+}
+
+func (c *ACLListCommand) Run(ctx context.Context, args []string) error {
+	registryClient, err := shop.NewRegistry(ctx, c.Cfg.Registry(c.RegistryName))
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	acl, err := registryClient.GetACL(ctx)
+	if err != nil {
+		return err
+	}
+
+	bindings := acl.Bindings
+	if len(args) > 0 {
+		bindings = acl.Effective(args[0])
+	}
+
+	output := make([]ACLOutputItem, 0, len(bindings))
+	for _, binding := range bindings {
+		output = append(output, ACLOutputItem{binding})
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type ACLOutputItem struct {
+	shop.RoleBinding
+}
+
+func (i ACLOutputItem) IntoText() ([]byte, error) {
+	return []byte(prefixText(i.Prefix) + "\t" + i.Principal + "\t" + i.Role), nil
+}
+
+func prefixText(prefix string) string {
+	if prefix == "" {
+		return "/"
+	}
+	return prefix
+}
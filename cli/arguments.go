@@ -1,10 +1,25 @@
 package cli
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
 	"github.com/alex-ac/shop"
 	"github.com/spf13/cobra"
 )
 
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+var (
+	ErrUnknownLogFormat = errors.New("Unknown log format")
+)
+
 func NewRootCommand() *cobra.Command {
 	rootCmd := &cobra.Command{
 		Use:   "shop",
@@ -13,6 +28,8 @@ func NewRootCommand() *cobra.Command {
 
 Shop is a package registry/deployment system for development tools.`,
 		SilenceUsage: true,
+		// Printed by Run, in the output format.
+		SilenceErrors: true,
 	}
 
 	return rootCmd
@@ -21,16 +38,44 @@ Shop is a package registry/deployment system for development tools.`,
 type GlobalArguments struct {
 	Config       string
 	OutputFormat OutputFormat
+	Metrics      string
+	DryRun       bool
+	Verbose      int
+	LogFormat    string
+	LogFile      string
+	Jobs         int
+	AllowYanked  bool
+	// Platform package name templates are expanded for, the host's by default.
+	OS   string
+	Arch string
+
+	plan    *shop.DryRunPlan
+	logFile *os.File
+	logSet  bool
+	// Registry used by the command, reported in errors.
+	registry string
 }
 
 var DefaultGlobalArguments = GlobalArguments{
 	OutputFormat: DefaultOutputFormat,
+	LogFormat:    LogFormatText,
 }
 
 func (a *GlobalArguments) Setup(cmd *cobra.Command) {
 	cmd.PersistentFlags().StringVarP(&a.Config, "config", "f", a.Config, "Path to the config file to use.")
 	cmd.MarkPersistentFlagFilename("config", "toml")
 	cmd.PersistentFlags().VarP(TextVar{&a.OutputFormat}, "output-format", "o", "Output format.")
+	cmd.PersistentFlags().StringVar(&a.Metrics, "metrics", a.Metrics, "Write repository metrics in Prometheus text format into the file on exit (- for stderr).")
+	cmd.PersistentFlags().BoolVar(&a.DryRun, "dry-run", a.DryRun, "Don't change registries and sites, print repository writes which would be made into stderr.")
+	cmd.PersistentFlags().CountVarP(&a.Verbose, "verbose", "v", "Log failed repository operations, -vv logs every operation.")
+	cmd.PersistentFlags().StringVar(&a.LogFormat, "log-format", a.LogFormat, "Log format: text or json.")
+	cmd.PersistentFlags().StringVar(&a.LogFile, "log-file", a.LogFile, "Append logs to the file instead of stderr.")
+	cmd.MarkPersistentFlagFilename("log-file")
+	cmd.PersistentFlags().IntVarP(&a.Jobs, "jobs", "j", a.Jobs, "Parallel operations of batch commands and ranges of downloaded archives (default depends on the registry backend).")
+	cmd.PersistentFlags().BoolVar(&a.AllowYanked, "allow-yanked", a.AllowYanked, "Resolve versions to yanked instances and instances of deprecated packages.")
+	cmd.PersistentFlags().StringVar(&a.OS, "os", a.OS, "OS substituted for ${os} in package names (default: host OS).")
+	cmd.PersistentFlags().StringVar(&a.Arch, "arch", a.Arch, "Architecture substituted for ${arch} in package names (default: host architecture).")
+	cmd.RegisterFlagCompletionFunc("log-format", cobra.FixedCompletions([]string{LogFormatText, LogFormatJSON}, cobra.ShellCompDirectiveNoFileComp))
 	cmd.RegisterFlagCompletionFunc("output-format", func(cmd *cobra.Command, args []string, toComplete string) (variants []string, directive cobra.ShellCompDirective) {
 		for format, _ := range AllOutputFormats {
 			variants = append(variants, string(format))
@@ -39,6 +84,22 @@ func (a *GlobalArguments) Setup(cmd *cobra.Command) {
 	})
 }
 
+func (a *GlobalArguments) Platform() shop.Platform {
+	platform := shop.CurrentPlatform()
+	if a.OS != "" {
+		platform.OS = a.OS
+	}
+	if a.Arch != "" {
+		platform.Arch = a.Arch
+	}
+	return platform
+}
+
+// Package name from the command line with the platform substituted.
+func (a *GlobalArguments) ExpandPackageName(name string) (string, error) {
+	return a.Platform().Expand(name)
+}
+
 func (a *GlobalArguments) ResolveConfig() (err error) {
 	if a.Config == "" {
 		a.Config, err = shop.FindConfigFile()
@@ -48,15 +109,78 @@ func (a *GlobalArguments) ResolveConfig() (err error) {
 }
 
 func (a *GlobalArguments) LoadConfig() (cfg shop.Config, err error) {
-	err = a.ResolveConfig()
+	err = a.SetupLogging()
+
+	if err == nil {
+		err = a.ResolveConfig()
+	}
 
 	if err == nil {
 		cfg, err = shop.LoadConfig(a.Config)
 	}
+	if err == nil {
+		cfg.Jobs = a.Jobs
+		cfg.AllowYanked = a.AllowYanked
+	}
+	if err == nil && a.DryRun {
+		if a.plan == nil {
+			a.plan = shop.NewDryRunPlan()
+		}
+		cfg.DryRun = a.plan
+	}
 
 	return
 }
 
+// Set the default slog logger according to the flags. Repository operations
+// are logged at debug level when they succeed and at warning level when they
+// fail, so -v shows failures and -vv shows everything.
+func (a *GlobalArguments) SetupLogging() error {
+	if a.logSet {
+		return nil
+	}
+
+	level := slog.LevelError
+	switch {
+	case a.Verbose >= 2:
+		level = slog.LevelDebug
+	case a.Verbose == 1:
+		level = slog.LevelWarn
+	}
+
+	var output io.Writer = os.Stderr
+	if a.LogFile != "" {
+		file, err := os.OpenFile(a.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		a.logFile = file
+		output = file
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch a.LogFormat {
+	case LogFormatText:
+		handler = slog.NewTextHandler(output, options)
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(output, options)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownLogFormat, a.LogFormat)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	a.logSet = true
+	return nil
+}
+
+func (a *GlobalArguments) CloseLog() error {
+	if a.logFile == nil {
+		return nil
+	}
+	return a.logFile.Close()
+}
+
 func (a *GlobalArguments) SaveConfig(cfg shop.Config) (err error) {
 	err = a.ResolveConfig()
 
@@ -66,3 +190,52 @@ func (a *GlobalArguments) SaveConfig(cfg shop.Config) (err error) {
 
 	return
 }
+
+func (a *GlobalArguments) WriteMetrics() error {
+	if a.Metrics == "" {
+		return nil
+	}
+	if a.Metrics == "-" {
+		return shop.WriteRepositoryMetrics(os.Stderr)
+	}
+
+	file, err := os.Create(a.Metrics)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err = shop.WriteRepositoryMetrics(file); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Print repository writes recorded in dry run mode.
+func (a *GlobalArguments) WritePlan() error {
+	if a.plan == nil {
+		return nil
+	}
+
+	mutations := a.plan.Mutations()
+	output := make([]PlanOutputItem, 0, len(mutations))
+	for _, mutation := range mutations {
+		output = append(output, PlanOutputItem{mutation})
+	}
+	return a.OutputFormat.CreateEncoder(os.Stderr).Encode(output)
+}
+
+type PlanOutputItem struct {
+	shop.Mutation
+}
+
+func (i PlanOutputItem) IntoText() ([]byte, error) {
+	text := "would " + i.Op + " " + i.URL + " " + i.Key
+	switch {
+	case i.Source != "":
+		text += " from " + i.Source
+	case i.Op == shop.MutationWrite:
+		text += fmt.Sprintf(" (%d bytes)", i.Size)
+	}
+	return []byte(text), nil
+}
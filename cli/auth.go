@@ -0,0 +1,258 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ErrUnknownCredentialType = errors.New("Unknown credential type")
+	ErrEmptyCredential       = errors.New("Credential is empty")
+)
+
+type AuthCommand struct {
+	Arguments *GlobalArguments
+	Cfg       shop.Config
+}
+
+func NewAuthCommand(args *GlobalArguments) *cobra.Command {
+	c := &AuthCommand{
+		Arguments: args,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "auth",
+		Short: "Manage registry credentials.",
+		Long: "Manage registry credentials. They are stored in " + shop.CredentialsFileName + " next to the config file,\n" +
+			"readable only by the user, and are used for all repositories of the registry.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			c.Cfg, err = c.Arguments.LoadConfig()
+			return
+		},
+	}
+
+	cmd.AddCommand(
+		NewAuthLoginCommand(c),
+		NewAuthLogoutCommand(c),
+		NewAuthStatusCommand(c),
+	)
+
+	return cmd
+}
+
+func (c *AuthCommand) registry(name string) (shop.RegistryConfig, error) {
+	registryCfg, ok := c.Cfg.Registries[name]
+	if !ok {
+		return registryCfg, fmt.Errorf("%w: %s", ErrRegistryDoesNotExist, name)
+	}
+	return registryCfg, nil
+}
+
+func (c *AuthCommand) status(name string) AuthStatusOutputItem {
+	output := AuthStatusOutputItem{Registry: name}
+	if credential, ok := c.Cfg.Credentials.Registries[name]; ok && credential.Kind() != "" {
+		output.Type = credential.Kind()
+		output.Source = shop.CredentialsFileName
+		output.Identity = credential.AccessKeyId + credential.User
+		return output
+	}
+
+	// Secrets which are still in plaintext in the config.
+	repo := c.Cfg.Registries[name].RootRepo
+	switch {
+	case repo.S3 != nil && repo.S3.AccessKeyId != "":
+		output.Type = shop.CredentialS3
+		output.Identity = repo.S3.AccessKeyId
+	case repo.HTTP != nil && repo.HTTP.User != "":
+		output.Type = shop.CredentialBasic
+		output.Identity = repo.HTTP.User
+	case repo.HTTP != nil && repo.HTTP.Token != "":
+		output.Type = shop.CredentialToken
+	default:
+		return output
+	}
+	output.Source = "config"
+	return output
+}
+
+type AuthLoginCommand struct {
+	*AuthCommand
+
+	Type string
+}
+
+func NewAuthLoginCommand(parent *AuthCommand) *cobra.Command {
+	c := &AuthLoginCommand{
+		AuthCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "login [-t s3|basic|token] registry",
+		Short: "Store credentials for the registry.",
+		Long: "Ask for credentials of the registry and store them in " + shop.CredentialsFileName + ".\n" +
+			"Secrets left in the registry config are removed from it.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0])
+		},
+		ValidArgsFunction: CompleteRegistryFlag,
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.Type, "type", "t", "", "Credential type: s3, basic or token (default: s3 for s3:// registries, basic otherwise).")
+
+	return cmd
+}
+
+func (c *AuthLoginCommand) Run(ctx context.Context, name string) error {
+	registryCfg, err := c.registry(name)
+	if err != nil {
+		return err
+	}
+
+	kind := c.Type
+	if kind == "" {
+		kind = shop.CredentialBasic
+		if u, err := url.Parse(registryCfg.RootRepo.URL); err == nil && u.Scheme == "s3" {
+			kind = shop.CredentialS3
+		}
+	}
+
+	credential := shop.Credential{}
+	switch kind {
+	case shop.CredentialS3:
+		if credential.AccessKeyId, err = prompt("Access key id:", false); err == nil {
+			credential.SecretAccessKey, err = prompt("Secret access key:", true)
+		}
+	case shop.CredentialBasic:
+		if credential.User, err = prompt("User:", false); err == nil {
+			credential.Password, err = prompt("Password:", true)
+		}
+	case shop.CredentialToken:
+		credential.Token, err = prompt("Token:", true)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownCredentialType, kind)
+	}
+	if err != nil {
+		return err
+	}
+	if credential.Kind() == "" {
+		return ErrEmptyCredential
+	}
+
+	if c.Cfg.Credentials.Registries == nil {
+		c.Cfg.Credentials.Registries = map[string]shop.Credential{}
+	}
+	c.Cfg.Credentials.Registries[name] = credential
+	if err = shop.SaveCredentials(c.Cfg.Credentials, c.Arguments.Config); err != nil {
+		return err
+	}
+
+	if err = c.Cfg.ClearRegistrySecrets(name); err != nil {
+		return err
+	}
+	if err = c.Arguments.SaveConfig(c.Cfg); err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(c.status(name))
+}
+
+type AuthLogoutCommand struct {
+	*AuthCommand
+}
+
+func NewAuthLogoutCommand(parent *AuthCommand) *cobra.Command {
+	c := &AuthLogoutCommand{
+		AuthCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:               "logout registry",
+		Short:             "Remove stored credentials of the registry.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: CompleteRegistryFlag,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0])
+		},
+	}
+
+	return cmd
+}
+
+func (c *AuthLogoutCommand) Run(ctx context.Context, name string) error {
+	if _, ok := c.Cfg.Credentials.Registries[name]; !ok {
+		return fmt.Errorf("%w: %s", shop.ErrNoCredentials, name)
+	}
+
+	delete(c.Cfg.Credentials.Registries, name)
+	return shop.SaveCredentials(c.Cfg.Credentials, c.Arguments.Config)
+}
+
+type AuthStatusCommand struct {
+	*AuthCommand
+}
+
+func NewAuthStatusCommand(parent *AuthCommand) *cobra.Command {
+	c := &AuthStatusCommand{
+		AuthCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:               "status [registry]",
+		Short:             "Show where credentials of registries come from.",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: CompleteRegistryFlag,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args)
+		},
+	}
+
+	return cmd
+}
+
+func (c *AuthStatusCommand) Run(ctx context.Context, names []string) error {
+	if len(names) == 0 {
+		for name := range c.Cfg.Registries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	output := make([]AuthStatusOutputItem, 0, len(names))
+	for _, name := range names {
+		if _, err := c.registry(name); err != nil {
+			return err
+		}
+		output = append(output, c.status(name))
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+// Secrets are never printed, only the key id or user name.
+type AuthStatusOutputItem struct {
+	Registry string `json:"registry"`
+	Type     string `json:"type,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Identity string `json:"identity,omitempty"`
+}
+
+func (i AuthStatusOutputItem) IntoText() ([]byte, error) {
+	if i.Type == "" {
+		return []byte(i.Registry + "\tnot logged in"), nil
+	}
+	text := fmt.Sprintf("%s\t%s", i.Registry, i.Type)
+	if i.Identity != "" {
+		text += " " + i.Identity
+	}
+	return []byte(text + " (" + i.Source + ")"), nil
+}
@@ -0,0 +1,230 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+type ExportCommand struct {
+	*PackageCommand
+
+	Prefix string
+}
+
+func NewExportCommand(args *GlobalArguments) *cobra.Command {
+	c := &ExportCommand{
+		PackageCommand: &PackageCommand{
+			Arguments: args,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "export [-r registry] [-p prefix] out" + shop.BundleExtension,
+		Short: "Write packages into a bundle file.",
+		Long: "Write packages with their instances, refs, tags and archives into a single bundle file,\n" +
+			"which could be imported into another registry with shop import.",
+		Args: cobra.ExactArgs(1),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return c.LoadConfig()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0])
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.RegistryName, "registry", "r", "", "Registry name.")
+	cmd.PersistentFlags().StringVarP(&c.Prefix, "prefix", "p", "", "Only export packages under the prefix.")
+
+	return cmd
+}
+
+func (c *ExportCommand) Run(ctx context.Context, out string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	var changes []shop.SyncChange
+	err = writeBundleFile(out, func(w io.Writer) (err error) {
+		changes, err = shop.ExportBundle(ctx, registryClient, c.Prefix, w)
+		return
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(syncOutput(changes))
+}
+
+// Bundle is written next to the destination and renamed, so partial bundles
+// are never left behind.
+func writeBundleFile(out string, write func(w io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(out), filepath.Base(out)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), out)
+	}
+	return err
+}
+
+type ImportCommand struct {
+	*PackageCommand
+}
+
+func NewImportCommand(args *GlobalArguments) *cobra.Command {
+	c := &ImportCommand{
+		PackageCommand: &PackageCommand{
+			Arguments: args,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "import [-r registry] [--dry-run] in" + shop.BundleExtension,
+		Short: "Copy packages from a bundle file into the registry.",
+		Long: "Copy packages from a bundle file made by shop export into the registry. Only missing or updated\n" +
+			"objects are copied. Packages which are not in the registry yet are created in its root repository.",
+		Args: cobra.ExactArgs(1),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return c.LoadConfig()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0])
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.RegistryName, "registry", "r", "", "Registry name.")
+
+	return cmd
+}
+
+func (c *ImportCommand) Run(ctx context.Context, in string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	file, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, changes, err := shop.ImportBundle(ctx, file, registryClient, c.Arguments.DryRun)
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	if encodeErr := encoder.Encode(syncOutput(changes)); err == nil {
+		err = encodeErr
+	}
+	return err
+}
+
+type BundleCommand struct {
+	*PackageCommand
+}
+
+func NewBundleCommand(args *GlobalArguments) *cobra.Command {
+	c := &BundleCommand{
+		PackageCommand: &PackageCommand{
+			Arguments: args,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Make bundles for offline installs.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return c.LoadConfig()
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.RegistryName, "registry", "r", "", "Registry name.")
+
+	cmd.AddCommand(
+		NewBundleCreateCommand(c),
+	)
+
+	return cmd
+}
+
+type BundleCreateCommand struct {
+	*BundleCommand
+
+	EnsureFile string
+}
+
+func NewBundleCreateCommand(parent *BundleCommand) *cobra.Command {
+	c := &BundleCreateCommand{
+		BundleCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "create [-r registry] -e ensure_file out" + shop.BundleExtension,
+		Short: "Write instances resolved from the ensure file into a bundle file.",
+		Long: "Resolve versions from the ensure file and write the instances with their archives, tags and refs\n" +
+			"into a single bundle file, so the ensure file could be installed from it with \"shop ensure --from-bundle\"\n" +
+			"on machines without access to the registry. Package name templates are expanded for --os and --arch.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0])
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.EnsureFile, "ensure-file", "e", "", "Ensure file.")
+	cmd.MarkPersistentFlagRequired("ensure-file")
+
+	return cmd
+}
+
+func (c *BundleCreateCommand) Run(ctx context.Context, out string) error {
+	file, err := shop.LoadEnsureFile(c.EnsureFile)
+	if err != nil {
+		return err
+	}
+	if file, err = file.Expand(c.Arguments.Platform()); err != nil {
+		return fmt.Errorf("%s: %w", c.EnsureFile, err)
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	var changes []shop.SyncChange
+	err = writeBundleFile(out, func(w io.Writer) (err error) {
+		_, changes, err = shop.CreateEnsureBundle(ctx, registryClient, *file, w)
+		return
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(syncOutput(changes))
+}
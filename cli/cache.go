@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ErrCacheIsNotSet = errors.New("Cache dir is not set in the config")
+)
+
+type CacheCommand struct {
+	Arguments *GlobalArguments
+	Dir       string
+}
+
+func NewCacheCommand(args *GlobalArguments) *cobra.Command {
+	c := &CacheCommand{
+		Arguments: args,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the local cache.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := c.Arguments.LoadConfig()
+			if err != nil {
+				return err
+			}
+			if cfg.Cache == "" {
+				return ErrCacheIsNotSet
+			}
+			c.Dir = cfg.Cache
+			return nil
+		},
+	}
+
+	cmd.AddCommand(
+		NewCacheStatsCommand(c),
+		NewCacheCleanCommand(c),
+		NewCacheVerifyCommand(c),
+	)
+
+	return cmd
+}
+
+type CacheStatsCommand struct {
+	*CacheCommand
+}
+
+func NewCacheStatsCommand(parent *CacheCommand) *cobra.Command {
+	c := &CacheStatsCommand{
+		CacheCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Print size, entry count and hit rate of the cache.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context())
+		},
+	}
+
+	return cmd
+}
+
+func (c *CacheStatsCommand) Run(ctx context.Context) error {
+	stats, err := shop.GetCacheStats(c.Dir)
+	if err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(CacheStatsOutput{
+		CacheStats: stats,
+		HitRate:    stats.HitRate(),
+	})
+}
+
+type CacheStatsOutput struct {
+	shop.CacheStats
+	HitRate float64 `json:"hit_rate"`
+}
+
+func (o CacheStatsOutput) IntoText() ([]byte, error) {
+	return []byte(fmt.Sprintf("dir\t%s\nsize\t%d\nentries\t%d\nhits\t%d\nmisses\t%d\nhit rate\t%.1f%%",
+		o.Dir, o.Size, o.Entries, o.Hits, o.Misses, o.HitRate*100)), nil
+}
+
+type CacheCleanCommand struct {
+	*CacheCommand
+
+	MaxSize   int64
+	OlderThan time.Duration
+}
+
+func NewCacheCleanCommand(parent *CacheCommand) *cobra.Command {
+	c := &CacheCleanCommand{
+		CacheCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "clean [--max-size bytes] [--older-than duration]",
+		Short: "Remove cached objects.",
+		Long: "Remove cached objects not used for --older-than, then the oldest ones until the cache fits into --max-size.\n" +
+			"Without flags everything is removed.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context())
+		},
+	}
+
+	cmd.PersistentFlags().Int64Var(&c.MaxSize, "max-size", -1, "Size in bytes to trim the cache to.")
+	cmd.PersistentFlags().DurationVar(&c.OlderThan, "older-than", 0, "Remove objects not used for this long.")
+
+	return cmd
+}
+
+func (c *CacheCleanCommand) Run(ctx context.Context) error {
+	maxSize := c.MaxSize
+	if maxSize < 0 && c.OlderThan == 0 {
+		maxSize = 0
+	}
+
+	result, err := shop.CleanCache(c.Dir, maxSize, c.OlderThan)
+	if err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(CacheCleanOutput{result})
+}
+
+type CacheCleanOutput struct {
+	shop.CacheCleanResult
+}
+
+func (o CacheCleanOutput) IntoText() ([]byte, error) {
+	return []byte(fmt.Sprintf("Removed %d objects, freed %d bytes, %d bytes left.", o.Removed, o.Freed, o.Size)), nil
+}
+
+type CacheVerifyCommand struct {
+	*CacheCommand
+}
+
+func NewCacheVerifyCommand(parent *CacheCommand) *cobra.Command {
+	c := &CacheVerifyCommand{
+		CacheCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Remove corrupted cache entries.",
+		Long: "Remove cached archives with content not matching their id, unreadable metadata\n" +
+			"and temporary files of downloads interrupted long ago. Prints removed entries.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context())
+		},
+	}
+
+	return cmd
+}
+
+func (c *CacheVerifyCommand) Run(ctx context.Context) error {
+	removed, err := shop.VerifyCache(c.Dir)
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	if encodeErr := encoder.Encode(removed); err == nil {
+		err = encodeErr
+	}
+	return err
+}
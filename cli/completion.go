@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	ShellBash       = "bash"
+	ShellZsh        = "zsh"
+	ShellFish       = "fish"
+	ShellPowerShell = "powershell"
+)
+
+var (
+	ErrUnsupportedShell = errors.New("Unsupported shell")
+)
+
+type CompletionCommand struct {
+	Arguments *GlobalArguments
+
+	NoDescriptions bool
+}
+
+func NewCompletionCommand(args *GlobalArguments) *cobra.Command {
+	c := &CompletionCommand{
+		Arguments: args,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "completion {bash|zsh|fish|powershell}",
+		Short: "Generate shell completion script.",
+		Long: `Generate shell completion script into stdout.
+
+Bash (requires bash-completion):
+  shop completion bash > /etc/bash_completion.d/shop
+Zsh:
+  shop completion zsh > "${fpath[1]}/_shop"
+Fish:
+  shop completion fish > ~/.config/fish/completions/shop.fish
+PowerShell:
+  shop completion powershell | Out-String | Invoke-Expression`,
+		ValidArgs: []string{ShellBash, ShellZsh, ShellFish, ShellPowerShell},
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Root(), args[0], os.Stdout)
+		},
+	}
+
+	cmd.PersistentFlags().BoolVar(&c.NoDescriptions, "no-descriptions", false, "Don't include descriptions of completions.")
+
+	return cmd
+}
+
+func (c *CompletionCommand) Run(root *cobra.Command, shell string, w io.Writer) error {
+	switch shell {
+	case ShellBash:
+		return root.GenBashCompletionV2(w, !c.NoDescriptions)
+	case ShellZsh:
+		if c.NoDescriptions {
+			return root.GenZshCompletionNoDesc(w)
+		}
+		return root.GenZshCompletion(w)
+	case ShellFish:
+		return root.GenFishCompletion(w, !c.NoDescriptions)
+	case ShellPowerShell:
+		if c.NoDescriptions {
+			return root.GenPowerShellCompletion(w)
+		}
+		return root.GenPowerShellCompletionWithDesc(w)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedShell, shell)
+	}
+}
@@ -0,0 +1,269 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+
+	"github.com/alex-ac/shop"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// Used when neither $VISUAL nor $EDITOR is set.
+	DefaultEditor = "vi"
+)
+
+type ConfigCommand struct {
+	Arguments *GlobalArguments
+}
+
+func NewConfigCommand(args *GlobalArguments) *cobra.Command {
+	c := &ConfigCommand{
+		Arguments: args,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Read and change the config file.",
+		Long: "Read and change the config file. Keys are dotted paths of the config file tables,\n" +
+			"like registry.default.root_repository.url.",
+	}
+
+	cmd.AddCommand(
+		NewConfigGetCommand(c),
+		NewConfigSetCommand(c),
+		NewConfigEditCommand(c),
+	)
+
+	return cmd
+}
+
+type ConfigGetCommand struct {
+	*ConfigCommand
+}
+
+func NewConfigGetCommand(parent *ConfigCommand) *cobra.Command {
+	c := &ConfigGetCommand{
+		ConfigCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "get key",
+		Short: "Print the config value.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0])
+		},
+	}
+
+	return cmd
+}
+
+func (c *ConfigGetCommand) Run(ctx context.Context, key string) error {
+	cfg, err := c.Arguments.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	value, err := cfg.Get(key)
+	if err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(ConfigGetOutput{Key: key, Value: value})
+}
+
+type ConfigGetOutput struct {
+	Key   string `json:"key"`
+	Value any    `json:"value"`
+}
+
+// Tables use key names from the config file.
+func (o ConfigGetOutput) MarshalJSON() ([]byte, error) {
+	value := o.Value
+	switch v := reflect.ValueOf(value); v.Kind() {
+	case reflect.Pointer, reflect.Struct, reflect.Map:
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			value = nil
+			break
+		}
+		if _, ok := value.(encoding.TextMarshaler); ok {
+			break
+		}
+
+		data, err := toml.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		table := map[string]any{}
+		if err = toml.Unmarshal(data, &table); err != nil {
+			return nil, err
+		}
+		value = table
+	}
+
+	return json.Marshal(struct {
+		Key   string `json:"key"`
+		Value any    `json:"value"`
+	}{o.Key, value})
+}
+
+// Scalars are printed as is, tables in TOML.
+func (o ConfigGetOutput) IntoText() ([]byte, error) {
+	if marshaler, ok := o.Value.(encoding.TextMarshaler); ok {
+		return marshaler.MarshalText()
+	}
+
+	switch v := reflect.ValueOf(o.Value); v.Kind() {
+	case reflect.Struct, reflect.Map:
+		data, err := toml.Marshal(o.Value)
+		return bytes.TrimRight(data, "\n"), err
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return ConfigGetOutput{Key: o.Key, Value: v.Elem().Interface()}.IntoText()
+	default:
+		return []byte(fmt.Sprint(o.Value)), nil
+	}
+}
+
+type ConfigSetCommand struct {
+	*ConfigCommand
+}
+
+func NewConfigSetCommand(parent *ConfigCommand) *cobra.Command {
+	c := &ConfigSetCommand{
+		ConfigCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "set key value",
+		Short: "Change the config value.",
+		Long:  "Change the config value. Value is parsed according to the key type, lists are comma separated.",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	return cmd
+}
+
+func (c *ConfigSetCommand) Run(ctx context.Context, key, value string) error {
+	cfg, err := c.Arguments.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	if err = cfg.Set(key, value); err != nil {
+		return err
+	}
+
+	return c.Arguments.SaveConfig(cfg)
+}
+
+type ConfigEditCommand struct {
+	*ConfigCommand
+}
+
+func NewConfigEditCommand(parent *ConfigCommand) *cobra.Command {
+	c := &ConfigEditCommand{
+		ConfigCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "edit",
+		Short: "Open the config file in $VISUAL or $EDITOR.",
+		Long: "Open a copy of the config file in $VISUAL or $EDITOR (default: " + DefaultEditor + ").\n" +
+			"The config is replaced only if the edited copy is valid.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context())
+		},
+	}
+
+	return cmd
+}
+
+func (c *ConfigEditCommand) Run(ctx context.Context) error {
+	if err := c.Arguments.ResolveConfig(); err != nil {
+		return err
+	}
+	path := c.Arguments.Config
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Copy is kept next to the config, so it could be renamed into place.
+	file, err := os.CreateTemp(filepath.Dir(path), ".config-*.toml")
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+	defer os.Remove(tmp)
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	for {
+		if err = runEditor(ctx, tmp); err != nil {
+			return err
+		}
+
+		_, err = shop.LoadConfig(tmp)
+		if err == nil {
+			break
+		}
+
+		fmt.Fprintln(os.Stderr, err)
+		ok, promptErr := confirm("Edit again?")
+		if promptErr != nil {
+			return promptErr
+		}
+		if !ok {
+			return err
+		}
+	}
+
+	if err = os.Chmod(tmp, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func runEditor(ctx context.Context, path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = DefaultEditor
+	}
+
+	// Editor could have arguments, like "code --wait".
+	cmd := exec.CommandContext(ctx, "sh", "-c", editor+` "$1"`, "sh", path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+)
+
+const (
+	ManSection = "1"
+)
+
+func NewDocsCommand(args *GlobalArguments) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "docs",
+		Short: "Generate documentation.",
+	}
+
+	cmd.AddCommand(
+		NewDocsManCommand(args),
+	)
+
+	return cmd
+}
+
+type DocsManCommand struct {
+	Arguments *GlobalArguments
+}
+
+func NewDocsManCommand(args *GlobalArguments) *cobra.Command {
+	c := &DocsManCommand{
+		Arguments: args,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "man dir",
+		Short: "Generate man pages for every command into the directory.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), cmd.Root(), args[0])
+		},
+	}
+
+	return cmd
+}
+
+func (c *DocsManCommand) Run(ctx context.Context, root *cobra.Command, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	header := &doc.GenManHeader{
+		Section: ManSection,
+		Source:  fmt.Sprintf("shop %s", GetVersionInfo().Version),
+		Manual:  "Shop Manual",
+	}
+	root.DisableAutoGenTag = true
+	return doc.GenManTree(root, header, dir)
+}
@@ -0,0 +1,215 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ErrDoctorProblems = errors.New("Problems found")
+)
+
+type DoctorCommand struct {
+	Arguments *GlobalArguments
+
+	RegistryName string
+	Probe        bool
+}
+
+func NewDoctorCommand(args *GlobalArguments) *cobra.Command {
+	c := &DoctorCommand{
+		Arguments: args,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "doctor [-r registry] [--probe]",
+		Short: "Check config, registries and cache for problems.",
+		Long: "Check that config parses, manifests of configured registries and their repositories could be read\n" +
+			"and the cache directory is writable. With --probe an object is written into writable repositories and\n" +
+			"deleted, to check permissions and clock skew. Fails if there are errors.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context())
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.RegistryName, "registry", "r", "", "Only check this registry.")
+	cmd.PersistentFlags().BoolVar(&c.Probe, "probe", false, "Write and delete a probe object in writable repositories.")
+	cmd.RegisterFlagCompletionFunc("registry", CompleteRegistryFlag)
+
+	return cmd
+}
+
+func (c *DoctorCommand) Run(ctx context.Context) error {
+	var diagnostics []shop.Diagnostic
+	cfg, err := c.Arguments.LoadConfig()
+	if err != nil {
+		diagnostics = append(diagnostics, shop.Diagnostic{
+			Check:   "config",
+			Target:  c.Arguments.Config,
+			Status:  shop.DiagnosticError,
+			Message: err.Error(),
+			Hint:    "Fix the config with shop config edit.",
+		})
+	} else {
+		diagnostics = append(diagnostics, shop.Diagnostic{
+			Check:   "config",
+			Target:  c.Arguments.Config,
+			Status:  shop.DiagnosticOk,
+			Message: fmt.Sprintf("%d registries", len(cfg.Registries)),
+		})
+		diagnostics = append(diagnostics, c.checkCache(cfg)...)
+		diagnostics = append(diagnostics, c.checkRegistries(ctx, cfg)...)
+	}
+
+	output := make([]DoctorOutputItem, 0, len(diagnostics))
+	errorsCount := 0
+	for _, diagnostic := range diagnostics {
+		output = append(output, DoctorOutputItem{diagnostic})
+		if diagnostic.Status == shop.DiagnosticError {
+			errorsCount++
+		}
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	if err = encoder.Encode(output); err != nil {
+		return err
+	}
+	if errorsCount > 0 {
+		return fmt.Errorf("%w: %d", ErrDoctorProblems, errorsCount)
+	}
+	return nil
+}
+
+func (c *DoctorCommand) checkCache(cfg shop.Config) []shop.Diagnostic {
+	if cfg.Cache == "" {
+		return nil
+	}
+
+	diagnostic := shop.Diagnostic{
+		Check:  "cache",
+		Target: cfg.Cache,
+		Status: shop.DiagnosticError,
+		Hint:   "Fix permissions of the cache directory or change it with shop config set cache.",
+	}
+	if err := os.MkdirAll(cfg.Cache, 0755); err != nil {
+		diagnostic.Message = err.Error()
+		return []shop.Diagnostic{diagnostic}
+	}
+	file, err := os.CreateTemp(cfg.Cache, ".doctor-*")
+	if err != nil {
+		diagnostic.Message = err.Error()
+		return []shop.Diagnostic{diagnostic}
+	}
+	file.Close()
+	os.Remove(file.Name())
+
+	var size int64
+	err = filepath.WalkDir(cfg.Cache, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err == nil {
+			size += info.Size()
+		}
+		return err
+	})
+	if err != nil {
+		diagnostic.Message = err.Error()
+		return []shop.Diagnostic{diagnostic}
+	}
+
+	maxSize := cfg.CacheMaxSize
+	if maxSize == 0 {
+		maxSize = shop.DefaultCacheMaxSize
+	}
+	diagnostic.Status = shop.DiagnosticOk
+	diagnostic.Message = fmt.Sprintf("%d of %d bytes used", size, maxSize)
+	diagnostic.Hint = ""
+	if size > maxSize {
+		diagnostic.Status = shop.DiagnosticWarning
+		diagnostic.Hint = "Cache is trimmed on the next write, or could be removed."
+	}
+	return []shop.Diagnostic{diagnostic}
+}
+
+func (c *DoctorCommand) checkRegistries(ctx context.Context, cfg shop.Config) []shop.Diagnostic {
+	names := []string{c.RegistryName}
+	if c.RegistryName == "" {
+		names = names[:0]
+		for name := range cfg.Registries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	var diagnostics []shop.Diagnostic
+	for _, name := range names {
+		registryConfig, ok := cfg.Registries[name]
+		if !ok {
+			diagnostics = append(diagnostics, shop.Diagnostic{
+				Check:   "registry",
+				Target:  name,
+				Status:  shop.DiagnosticError,
+				Message: ErrRegistryDoesNotExist.Error(),
+				Hint:    "Add it with shop registry add.",
+			})
+			continue
+		}
+
+		if s3 := registryConfig.RootRepo.S3; s3 != nil && s3.SecretAccessKey != "" {
+			diagnostics = append(diagnostics, shop.Diagnostic{
+				Check:   "credentials",
+				Target:  name,
+				Status:  shop.DiagnosticWarning,
+				Message: "secret access key is stored in the config",
+				Hint:    "Move it into " + shop.CredentialsFileName + " with shop auth login " + name + ".",
+			})
+		}
+		if http := registryConfig.RootRepo.HTTP; http != nil && (http.Password != "" || http.Token != "") {
+			diagnostics = append(diagnostics, shop.Diagnostic{
+				Check:   "credentials",
+				Target:  name,
+				Status:  shop.DiagnosticWarning,
+				Message: "password or token is stored in the config",
+				Hint:    "Move it into " + shop.CredentialsFileName + " with shop auth login " + name + ".",
+			})
+		}
+
+		registryClient, err := shop.NewRegistry(ctx, cfg.Registry(name))
+		if err != nil {
+			diagnostics = append(diagnostics, shop.Diagnostic{
+				Check:   "registry",
+				Target:  name,
+				Status:  shop.DiagnosticError,
+				Message: err.Error(),
+				Hint:    "Check the registry url, network access and credentials (shop auth login " + name + ").",
+			})
+			continue
+		}
+		defer registryClient.Close()
+		diagnostics = append(diagnostics, registryClient.Diagnose(ctx, c.Probe)...)
+	}
+	return diagnostics
+}
+
+type DoctorOutputItem struct {
+	shop.Diagnostic
+}
+
+func (i DoctorOutputItem) IntoText() ([]byte, error) {
+	text := fmt.Sprintf("[%s] %s %s: %s", i.Status, i.Check, i.Target, i.Message)
+	if i.Hint != "" {
+		text += "\n  " + i.Hint
+	}
+	return []byte(text), nil
+}
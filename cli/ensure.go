@@ -0,0 +1,336 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ErrEnsureRootIsNotSet = errors.New("Site root is not set")
+	ErrMissingVariants    = errors.New("Packages are missing for some platforms")
+)
+
+type EnsureCommand struct {
+	*PackageCommand
+
+	EnsureFile string
+	LockFile   string
+	Locked     bool
+	FromBundle string
+
+	RejectUnsafeSymlinks bool
+}
+
+func NewEnsureCommand(args *GlobalArguments) *cobra.Command {
+	c := &EnsureCommand{
+		PackageCommand: &PackageCommand{
+			Arguments: args,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "ensure [-r registry | --from-bundle bundle] -e ensure_file [--locked] [root]",
+		Short: "Install packages listed in the ensure file and remove the rest.",
+		Long: "Install packages listed in the ensure file into the root and remove installed packages which are not listed.\n" +
+			"Ensure file has one \"package [version]\" per line, " + shop.EnsureComment + " comments and optional \"" + shop.EnsureRootDirective + " dir\"\n" +
+			"with the root relative to the file, used when root argument is omitted. With --locked packages are installed\n" +
+			"exactly as pinned by the lockfile from \"ensure resolve\", which fails if the registry or repos of the packages\n" +
+			"changed since. Instance ids in the lockfile are hashes of the archives, checked on download, so they pin\n" +
+			"the content. With --from-bundle packages are installed from the bundle made by \"bundle create\" instead\n" +
+			"of the registry, without network access. ${os}, ${arch} and ${platform} (${os}-${arch}) in package names\n" +
+			"and versions are replaced with the host platform, or --os and --arch.\n" +
+			SymlinkPolicyHelp + ".\n" +
+			VersionHelp + " (default: " + shop.DefaultVersion + ").",
+		Args: cobra.MaximumNArgs(1),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			if c.FromBundle != "" {
+				// Registries aren't used, so they don't need to be configured.
+				c.Cfg, err = c.Arguments.LoadConfig()
+				return
+			}
+			return c.LoadConfig()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := ""
+			if len(args) > 0 {
+				root = args[0]
+			}
+			return c.Run(cmd.Context(), root)
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.RegistryName, "registry", "r", "", "Registry name.")
+	addSymlinkPolicyFlags(cmd, &c.RejectUnsafeSymlinks)
+	cmd.PersistentFlags().StringVarP(&c.EnsureFile, "ensure-file", "e", "", "Ensure file.")
+	cmd.PersistentFlags().StringVarP(&c.LockFile, "lockfile", "l", "", "Lockfile (default: ensure file with "+shop.EnsureLockExtension+" extension).")
+	cmd.MarkPersistentFlagRequired("ensure-file")
+	cmd.Flags().BoolVar(&c.Locked, "locked", false, "Install instances pinned by the lockfile, fail if it is stale.")
+	cmd.Flags().StringVar(&c.FromBundle, "from-bundle", "", "Install from the bundle file instead of the registry.")
+	cmd.MarkFlagsMutuallyExclusive("from-bundle", "registry")
+
+	cmd.AddCommand(
+		NewEnsureResolveCommand(c),
+		NewEnsureCheckCommand(c),
+	)
+
+	return cmd
+}
+
+func (c *EnsureCommand) Run(ctx context.Context, root string) error {
+	file, err := c.loadEnsureFile()
+	if err != nil {
+		return err
+	}
+	if root == "" {
+		root = file.Root
+	}
+	var lock *shop.EnsureLockFile
+	if c.Locked {
+		if lock, err = shop.LoadEnsureLockFile(c.lockFile()); err != nil {
+			return err
+		}
+		if file, err = lock.Pin(*file); err != nil {
+			return err
+		}
+	}
+	if root == "" {
+		return fmt.Errorf("%w: %s", ErrEnsureRootIsNotSet, c.EnsureFile)
+	}
+
+	var registryClient shop.Registry
+	if c.FromBundle != "" {
+		bundle, err := c.openBundle(ctx)
+		if err != nil {
+			return err
+		}
+		defer bundle.Close()
+		registryClient = bundle.Registry
+	} else {
+		registryConfig := c.Cfg.Registry(c.RegistryName)
+
+		registryClient, err = shop.NewRegistry(ctx, registryConfig)
+		if err != nil {
+			return err
+		}
+		defer registryClient.Close()
+		if lock != nil {
+			if err = lock.CheckRegistry(ctx, registryClient); err != nil {
+				return fmt.Errorf("%s: %w", c.lockFile(), err)
+			}
+		}
+	}
+
+	site := shop.NewSite(root)
+	site.RejectUnsafeSymlinks = c.RejectUnsafeSymlinks || c.Cfg.RejectUnsafeSymlinks
+	changes, err := site.Ensure(ctx, registryClient, *file, 0, c.Arguments.DryRun)
+
+	output := make([]EnsureOutputItem, 0, len(changes))
+	for _, change := range changes {
+		output = append(output, EnsureOutputItem{change})
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	if encodeErr := encoder.Encode(output); err == nil {
+		err = encodeErr
+	}
+	return err
+}
+
+func (c *EnsureCommand) openBundle(ctx context.Context) (*shop.Bundle, error) {
+	file, err := os.Open(c.FromBundle)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return shop.OpenBundle(ctx, file)
+}
+
+// Ensure file expanded for the platform.
+func (c *EnsureCommand) loadEnsureFile() (*shop.EnsureFile, error) {
+	file, err := shop.LoadEnsureFile(c.EnsureFile)
+	if err != nil {
+		return nil, err
+	}
+	if file, err = file.Expand(c.Arguments.Platform()); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.EnsureFile, err)
+	}
+	return file, nil
+}
+
+func (c *EnsureCommand) lockFile() string {
+	if c.LockFile != "" {
+		return c.LockFile
+	}
+	return c.EnsureFile + shop.EnsureLockExtension
+}
+
+type EnsureResolveCommand struct {
+	*EnsureCommand
+}
+
+func NewEnsureResolveCommand(parent *EnsureCommand) *cobra.Command {
+	c := &EnsureResolveCommand{
+		EnsureCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "resolve [-r registry] -e ensure_file [-l lockfile]",
+		Short: "Pin versions from the ensure file to instances in the lockfile.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context())
+		},
+	}
+
+	return cmd
+}
+
+func (c *EnsureResolveCommand) Run(ctx context.Context) error {
+	file, err := c.loadEnsureFile()
+	if err != nil {
+		return err
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	lock, err := file.Resolve(ctx, registryClient)
+	if err != nil {
+		return err
+	}
+	if err = shop.SaveEnsureLockFile(*lock, c.lockFile()); err != nil {
+		return err
+	}
+
+	output := make([]EnsureResolveOutputItem, 0, len(lock.Packages))
+	for _, pkg := range lock.Packages {
+		output = append(output, EnsureResolveOutputItem{pkg})
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type EnsureCheckCommand struct {
+	*EnsureCommand
+
+	Platforms []string
+}
+
+func NewEnsureCheckCommand(parent *EnsureCommand) *cobra.Command {
+	c := &EnsureCheckCommand{
+		EnsureCommand: parent,
+	}
+
+	var defaultPlatforms []string
+	for _, platform := range shop.DefaultPlatforms {
+		defaultPlatforms = append(defaultPlatforms, platform.String())
+	}
+
+	cmd := &cobra.Command{
+		Use:   "check [-r registry] -e ensure_file [-p os-arch...]",
+		Short: "Check that packages of the ensure file exist for every platform.",
+		Long: "Expand package name templates of the ensure file for each platform and resolve the versions.\n" +
+			"Fails if some of them are not found.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context())
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&c.Platforms, "platform", "p", defaultPlatforms, "Platforms to check.")
+
+	return cmd
+}
+
+func (c *EnsureCheckCommand) Run(ctx context.Context) error {
+	var platforms []shop.Platform
+	for _, s := range c.Platforms {
+		platform, err := shop.ParsePlatform(s)
+		if err != nil {
+			return err
+		}
+		platforms = append(platforms, platform)
+	}
+
+	file, err := shop.LoadEnsureFile(c.EnsureFile)
+	if err != nil {
+		return err
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	variants, err := shop.CheckPlatformVariants(ctx, registryClient, *file, platforms)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.EnsureFile, err)
+	}
+
+	output := make([]EnsureCheckOutputItem, 0, len(variants))
+	missing := 0
+	for _, variant := range variants {
+		output = append(output, EnsureCheckOutputItem{variant})
+		if variant.Id == "" {
+			missing++
+		}
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	if err = encoder.Encode(output); err != nil {
+		return err
+	}
+	if missing > 0 {
+		return fmt.Errorf("%w: %d", ErrMissingVariants, missing)
+	}
+	return nil
+}
+
+type EnsureCheckOutputItem struct {
+	shop.PlatformVariant
+}
+
+func (i EnsureCheckOutputItem) IntoText() ([]byte, error) {
+	if i.Id == "" {
+		return []byte(fmt.Sprintf("%s\t%s@%s: missing", i.Platform, i.Package, i.Version)), nil
+	}
+	return []byte(fmt.Sprintf("%s\t%s@%s: %s", i.Platform, i.Package, i.Version, i.Id)), nil
+}
+
+type EnsureResolveOutputItem struct {
+	shop.EnsureLockPackage
+}
+
+func (i EnsureResolveOutputItem) IntoText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%s@%s: %s", i.Package, i.Version, i.Id)), nil
+}
+
+type EnsureOutputItem struct {
+	shop.EnsureChange
+}
+
+func (i EnsureOutputItem) IntoText() ([]byte, error) {
+	switch i.Action {
+	case shop.EnsureUpgrade:
+		return []byte(fmt.Sprintf("%s %s %s -> %s", i.Action, i.Package, i.PreviousId, i.Id)), nil
+	case shop.EnsureRemove:
+		return []byte(fmt.Sprintf("%s %s %s", i.Action, i.Package, i.PreviousId)), nil
+	default:
+		return []byte(fmt.Sprintf("%s %s %s", i.Action, i.Package, i.Id)), nil
+	}
+}
@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/alex-ac/shop"
+	"github.com/hashicorp/go-multierror"
+)
+
+// Error printed into stderr instead of the message with "-o json".
+type ErrorOutput struct {
+	Code     string         `json:"code"`
+	Message  string         `json:"message"`
+	Details  map[string]any `json:"details,omitempty"`
+	Key      string         `json:"key,omitempty"`
+	Registry string         `json:"registry,omitempty"`
+}
+
+// Stable codes of errors, the first one the error matches is used. Specific
+// errors go before the generic ones they could wrap.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{shop.ErrVersionNotFound, "version_not_found"},
+	{shop.ErrReferenceNotSet, "reference_not_set"},
+	{shop.ErrAmbiguousVersion, "ambiguous_version"},
+	{shop.ErrInstanceYanked, "instance_yanked"},
+	{shop.ErrPackageDeprecated, "package_deprecated"},
+	{shop.ErrPackageMoved, "package_moved"},
+	{shop.ErrPackageExists, "package_exists"},
+	{shop.ErrInstanceExists, "instance_exists"},
+	{shop.ErrArchiveShared, "archive_shared"},
+	{shop.ErrInvalidPackageName, "invalid_package_name"},
+	{shop.ErrInvalidInstanceId, "invalid_instance_id"},
+	{shop.ErrUnknownIdAlgorithm, "unknown_id_algorithm"},
+	{shop.ErrUnknownCompression, "unknown_compression"},
+	{shop.ErrNoArchiveURL, "no_archive_url"},
+	{shop.ErrInvalidDeltaBase, "invalid_delta_base"},
+	{shop.ErrInvalidReferenceName, "invalid_reference_name"},
+	{shop.ErrInvalidTagName, "invalid_tag_name"},
+	{shop.ErrInvalidTagValue, "invalid_tag_value"},
+	{shop.ErrInvalidSemVerRange, "invalid_version_range"},
+	{shop.ErrHashMismatch, "hash_mismatch"},
+	{shop.ErrInvalidArchive, "invalid_archive"},
+	{shop.ErrFileNotInArchive, "file_not_in_archive"},
+	{shop.ErrUnsafeSymlink, "unsafe_symlink"},
+	{shop.ErrSpecialMode, "special_mode"},
+	{shop.ErrInvalidIgnorePattern, "invalid_ignore_pattern"},
+	{shop.ErrUnknownRepo, "unknown_repo"},
+	{shop.ErrRepoInUse, "repo_in_use"},
+	{shop.ErrRegistryAdminIsNotAllowed, "admin_not_allowed"},
+	{shop.ErrRepoAdminIsNotAllowed, "admin_not_allowed"},
+	{shop.ErrRegistryWriteIsNotAllowed, "write_not_allowed"},
+	{shop.ErrRepoWriteIsNotAllowed, "write_not_allowed"},
+	{shop.ErrDependencyConflict, "dependency_conflict"},
+	{shop.ErrNoIDToken, "no_identity_token"},
+	{shop.ErrInstanceNotSigned, "not_signed"},
+	{shop.ErrInvalidSignature, "invalid_signature"},
+	{shop.ErrUntrustedSigner, "untrusted_signer"},
+	{shop.ErrNoTrustedRoots, "no_trusted_roots"},
+	{shop.ErrInvalidSigningKey, "invalid_signing_key"},
+	{shop.ErrSigningKeyExists, "signing_key_exists"},
+	{shop.ErrUnknownSigningKey, "unknown_signing_key"},
+	{shop.ErrSignaturePolicy, "signature_policy"},
+	{shop.ErrInvalidSBOM, "invalid_sbom"},
+	{shop.ErrNoSBOM, "no_sbom"},
+	{shop.ErrInvalidProvenance, "invalid_provenance"},
+	{shop.ErrNoProvenance, "no_provenance"},
+	{shop.ErrInvalidEnsureFile, "invalid_ensure_file"},
+	{shop.ErrStaleEnsureLock, "stale_ensure_lock"},
+	{shop.ErrRegistryDrift, "registry_drift"},
+	{shop.ErrInvalidTemplate, "invalid_template"},
+	{shop.ErrInvalidPlatform, "invalid_platform"},
+	{shop.ErrSiteFileConflict, "site_file_conflict"},
+	{shop.ErrSiteSymlinkPath, "site_symlink_path"},
+	{shop.ErrInvalidBundle, "invalid_bundle"},
+	{shop.ErrNoCredentials, "no_credentials"},
+	{shop.ErrUnimplemented, "unimplemented"},
+	{ErrRegistryDoesNotExist, "unknown_registry"},
+	{ErrNotConfirmed, "not_confirmed"},
+	{ErrRegistryProblems, "registry_problems"},
+	{ErrDoctorProblems, "doctor_problems"},
+	{ErrSiteModified, "site_modified"},
+	{ErrMissingVariants, "missing_variants"},
+	{shop.ErrConditionFailed, "conflict"},
+	{os.ErrNotExist, "not_found"},
+	{os.ErrExist, "already_exists"},
+	{os.ErrPermission, "permission_denied"},
+	{context.Canceled, "canceled"},
+	{context.DeadlineExceeded, "timeout"},
+	{flag.ErrHelp, "usage"},
+}
+
+func ErrorCode(err error) string {
+	for _, entry := range errorCodes {
+		if errors.Is(err, entry.err) {
+			return entry.code
+		}
+	}
+	return "error"
+}
+
+func NewErrorOutput(err error, registry string) ErrorOutput {
+	output := ErrorOutput{
+		Code:     ErrorCode(err),
+		Message:  err.Error(),
+		Registry: registry,
+		Details:  map[string]any{},
+	}
+
+	var repoErr *shop.RepositoryError
+	if errors.As(err, &repoErr) {
+		output.Key = repoErr.Key
+		output.Details["repo"] = repoErr.Repo
+		output.Details["op"] = repoErr.Op
+	}
+	var statusErr shop.HTTPStatusError
+	if errors.As(err, &statusErr) {
+		output.Details["method"] = statusErr.Method
+		output.Details["url"] = statusErr.URL
+		output.Details["status_code"] = statusErr.StatusCode
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		output.Details["path"] = pathErr.Path
+	}
+	var multiErr *multierror.Error
+	if errors.As(err, &multiErr) && len(multiErr.Errors) > 1 {
+		errs := make([]string, 0, len(multiErr.Errors))
+		for _, err := range multiErr.Errors {
+			errs = append(errs, err.Error())
+		}
+		output.Details["errors"] = errs
+	}
+
+	if len(output.Details) == 0 {
+		output.Details = nil
+	}
+	return output
+}
+
+// Report the failure of the command, as an ErrorOutput object with "-o json".
+func (a *GlobalArguments) PrintError(w io.Writer, err error) {
+	if a.OutputFormat != JSONOutputFormat {
+		fmt.Fprintln(w, "Error:", err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(NewErrorOutput(err, a.registry))
+}
@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+const (
+	DefaultInstallVersion = shop.DefaultVersion
+
+	SymlinkPolicyHelp = "Symlinks to absolute paths or outside of the instance are rejected before they are created with\n" +
+		"--reject-unsafe-symlinks, or reject_unsafe_symlinks in config"
+)
+
+type InstallCommand struct {
+	*PackageCommand
+
+	RejectUnsafeSymlinks bool
+}
+
+func addSymlinkPolicyFlags(cmd *cobra.Command, reject *bool) {
+	cmd.PersistentFlags().BoolVar(reject, "reject-unsafe-symlinks", false, "Fail on symlinks to absolute paths or outside of the instance.")
+}
+
+func NewInstallCommand(args *GlobalArguments) *cobra.Command {
+	c := &InstallCommand{
+		PackageCommand: &PackageCommand{
+			Arguments: args,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "install [-r registry] package_name[@version] root",
+		Short: "Install package instance into a directory.",
+		Long: "Install package instance into a directory. Installed files are recorded in " + shop.SiteStateDir + "/ under the root,\n" +
+			"so installing another version upgrades the package in place and removes files it no longer has.\n" +
+			"Packages the instance depends on are installed along with it.\n" +
+			SymlinkPolicyHelp + ".\n" +
+			VersionHelp + " (default: " + DefaultInstallVersion + ").",
+		Args: cobra.ExactArgs(2),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return c.LoadConfig()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.RegistryName, "registry", "r", "", "Registry name.")
+	addSymlinkPolicyFlags(cmd, &c.RejectUnsafeSymlinks)
+
+	return cmd
+}
+
+func (c *InstallCommand) Run(ctx context.Context, spec, root string) error {
+	spec, err := c.Arguments.ExpandPackageName(spec)
+	if err != nil {
+		return err
+	}
+	name, version, ok := strings.Cut(spec, "@")
+	if !ok {
+		version = DefaultInstallVersion
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	resolved, err := shop.ResolveDependencies(ctx, registryClient, []shop.EnsurePackage{{Package: name, Version: version}})
+	if err != nil {
+		return err
+	}
+
+	// Dependencies go first, so the package is not installed without them.
+	site := shop.NewSite(root)
+	site.RejectUnsafeSymlinks = c.RejectUnsafeSymlinks || c.Cfg.RejectUnsafeSymlinks
+	output := InstallOutput{}
+	for i := len(resolved) - 1; i >= 0; i-- {
+		instance := resolved[i].Instance
+		instance.Package = resolved[i].Package
+		installed, err := site.InstallFrom(ctx, registryClient, instance)
+		if err != nil {
+			return fmt.Errorf("%s@%s: %w", instance.Package, instance.Id, err)
+		}
+		if i == 0 {
+			output.SitePackage = installed
+		} else {
+			output.Dependencies = append(output.Dependencies, installed)
+		}
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type InstallOutput struct {
+	*shop.SitePackage
+	Dependencies []*shop.SitePackage `json:"dependencies,omitempty"`
+}
+
+func (o InstallOutput) IntoText() ([]byte, error) {
+	var text strings.Builder
+	for _, pkg := range o.Dependencies {
+		fmt.Fprintf(&text, "%s@%s: %d files\n", pkg.Package, pkg.Id, len(pkg.Files))
+	}
+	fmt.Fprintf(&text, "%s@%s: %d files", o.Package, o.Id, len(o.Files))
+	return []byte(text.String()), nil
+}
@@ -0,0 +1,185 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+type KeyCommand struct {
+	*PackageCommand
+}
+
+func NewKeyCommand(args *GlobalArguments) *cobra.Command {
+	c := &KeyCommand{
+		PackageCommand: &PackageCommand{
+			Arguments: args,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "key [-r registry]",
+		Short: "Manage keys trusted to sign instances.",
+		Long: "Manage minisign and GPG public keys, which make detached signatures of instance archives.\n" +
+			"They are listed in the registry manifest, signatures by them are verified on download.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return c.LoadConfig()
+		},
+	}
+
+	cmd.AddCommand(
+		NewKeyAddCommand(c),
+		NewKeyRemoveCommand(c),
+		NewKeyListCommand(c),
+	)
+
+	cmd.PersistentFlags().StringVarP(&c.RegistryName, "registry", "r", "", "Registry name.")
+
+	return cmd
+}
+
+func (c *KeyCommand) update(ctx context.Context, update func(*shop.RegistryManifest) error) error {
+	registryClient, err := shop.NewRegistry(ctx, c.Cfg.Registry(c.RegistryName))
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	manifest, err := registryClient.GetManifest(ctx)
+	if err != nil {
+		return err
+	}
+	if err = update(manifest); err != nil {
+		return err
+	}
+	return registryClient.PutManifest(ctx, *manifest)
+}
+
+type KeyAddCommand struct {
+	*KeyCommand
+
+	Comment string
+}
+
+func NewKeyAddCommand(parent *KeyCommand) *cobra.Command {
+	c := &KeyAddCommand{
+		KeyCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "add [--comment text] file",
+		Short: "Trust the public key.",
+		Long: "Add the minisign public key (as minisign -G makes) or the GPG public key (as gpg --export makes) to the registry.\n" +
+			"GPG keys have to be RSA, DSA or ECDSA ones.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0])
+		},
+	}
+
+	cmd.PersistentFlags().StringVar(&c.Comment, "comment", "", "Description of the key (default: GPG user id).")
+
+	return cmd
+}
+
+func (c *KeyAddCommand) Run(ctx context.Context, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	key, err := shop.ParseSigningKey(data)
+	if err != nil {
+		return err
+	}
+	if c.Comment != "" {
+		key.Comment = c.Comment
+	}
+
+	if err = c.update(ctx, func(manifest *shop.RegistryManifest) error {
+		return manifest.AddSigningKey(*key)
+	}); err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(KeyOutputItem{*key})
+}
+
+type KeyRemoveCommand struct {
+	*KeyCommand
+}
+
+func NewKeyRemoveCommand(parent *KeyCommand) *cobra.Command {
+	c := &KeyRemoveCommand{
+		KeyCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:     "rm id",
+		Aliases: []string{"remove"},
+		Short:   "Stop trusting the key.",
+		Long:    "Remove the key from the registry. Signatures made with it are kept, but not verified anymore.",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.update(cmd.Context(), func(manifest *shop.RegistryManifest) error {
+				return manifest.RemoveSigningKey(args[0])
+			})
+		},
+	}
+
+	return cmd
+}
+
+type KeyListCommand struct {
+	*KeyCommand
+}
+
+func NewKeyListCommand(parent *KeyCommand) *cobra.Command {
+	c := &KeyListCommand{
+		KeyCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List trusted keys.",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context())
+		},
+	}
+
+	return cmd
+}
+
+func (c *KeyListCommand) Run(ctx context.Context) error {
+	registryClient, err := shop.NewRegistry(ctx, c.Cfg.Registry(c.RegistryName))
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	manifest, err := registryClient.GetManifest(ctx)
+	if err != nil {
+		return err
+	}
+
+	output := make([]KeyOutputItem, 0, len(manifest.SigningKeys))
+	for _, key := range manifest.SigningKeys {
+		output = append(output, KeyOutputItem{key})
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type KeyOutputItem struct {
+	shop.SigningKey
+}
+
+func (i KeyOutputItem) IntoText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%s\t%s\t%s", i.Format, i.Id, i.Comment)), nil
+}
@@ -6,14 +6,31 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/alex-ac/shop"
 	"github.com/spf13/cobra"
 )
 
+const (
+	VersionHelp = "Version is an instance id, ref:name or tag:key=value attached to a single instance,\n" +
+		"or just name and key:value. version:range (or just a range like ^1.4, ~1.4.2 or >=1.2,<2) resolves to\n" +
+		"the newest instance with a " + shop.VersionTagKey + ":MAJOR.MINOR.PATCH tag in the range, latest does so too if there is no such ref"
+)
+
 var (
 	ErrRegistryDoesNotExist = errors.New("Registry does not exist")
+	ErrNotConfirmed         = errors.New("Not confirmed")
+	ErrRegistryProblems     = errors.New("Registry has problems")
+	ErrUploadSource         = errors.New("Exactly one of dir, --file or --stdin must be given")
+	ErrRawUploadOfDir       = errors.New("--raw requires --file or --stdin")
+	ErrFilterWithoutDir     = errors.New("--include and --exclude require a dir")
+	ErrNotRegularFile       = errors.New("Not a regular file")
+	ErrArchiveExtension     = errors.New("Output extension does not match the archive compression")
+	ErrDeltaBaseCompression = errors.New("--delta-base requires the delta compression and can't be used with --raw")
 )
 
 type PackageCommand struct {
@@ -30,24 +47,8 @@ func NewPackageCommand(args *GlobalArguments) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "package [-r registry]",
 		Short: "Manage packages.",
-		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
-			c.Cfg, err = c.Arguments.LoadConfig()
-			if err != nil {
-				return
-			}
-
-			if c.RegistryName == "" {
-				c.RegistryName = c.Cfg.DefaultRegistry
-			}
-
-			if c.RegistryName == "" {
-				c.RegistryName = shop.DefaultRegistryName
-			}
-
-			if _, ok := c.Cfg.Registries[c.RegistryName]; !ok {
-				err = fmt.Errorf("%w: %s", ErrRegistryDoesNotExist, c.RegistryName)
-			}
-			return
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return c.LoadConfig()
 		},
 	}
 
@@ -55,6 +56,30 @@ func NewPackageCommand(args *GlobalArguments) *cobra.Command {
 		NewPackageListCommand(c),
 		NewPackageAddCommand(c),
 		NewPackageUploadCommand(c),
+		NewPackageURLCommand(c),
+		NewPackageDownloadCommand(c),
+		NewPackageCatCommand(c),
+		NewPackageFilesCommand(c),
+		NewPackageInstancesCommand(c),
+		NewPackageInstanceCommand(c),
+		NewPackageDeleteCommand(c),
+		NewPackageMoveCommand(c),
+		NewPackageYankCommand(c),
+		NewPackageResolveCommand(c),
+		NewPackageDepsCommand(c),
+		NewPackageVerifyCommand(c),
+		NewPackageSignCommand(c),
+		NewPackageSBOMCommand(c),
+		NewPackageProvenanceCommand(c),
+		NewPackageDiffCommand(c),
+		NewPackageTagCommand(c),
+		NewPackageTagsCommand(c),
+		NewPackageRefCommand(c),
+		NewPackagePromoteCommand(c),
+		NewPackagePruneCommand(c),
+		NewPackageRetentionCommand(c),
+		NewPackageCompressionCommand(c),
+		NewPackageWatchCommand(c),
 	)
 
 	cmd.PersistentFlags().StringVarP(&c.RegistryName, "registry", "r", "", "Registry name.")
@@ -62,6 +87,28 @@ func NewPackageCommand(args *GlobalArguments) *cobra.Command {
 	return cmd
 }
 
+// Load config and pick the registry.
+func (c *PackageCommand) LoadConfig() (err error) {
+	c.Cfg, err = c.Arguments.LoadConfig()
+	if err != nil {
+		return
+	}
+
+	if c.RegistryName == "" {
+		c.RegistryName = c.Cfg.DefaultRegistry
+	}
+
+	if c.RegistryName == "" {
+		c.RegistryName = shop.DefaultRegistryName
+	}
+	c.Arguments.registry = c.RegistryName
+
+	if _, ok := c.Cfg.Registries[c.RegistryName]; !ok {
+		err = fmt.Errorf("%w: %s", ErrRegistryDoesNotExist, c.RegistryName)
+	}
+	return
+}
+
 type PackageListCommand struct {
 	*PackageCommand
 }
@@ -88,12 +135,13 @@ func NewPackageListCommand(parent *PackageCommand) *cobra.Command {
 }
 
 func (c *PackageListCommand) Run(ctx context.Context, prefix string) error {
-	registryConfig := c.Cfg.Registries[c.RegistryName]
+	registryConfig := c.Cfg.Registry(c.RegistryName)
 
 	registryClient, err := shop.NewRegistry(ctx, registryConfig)
 	if err != nil {
 		return err
 	}
+	defer registryClient.Close()
 
 	var output []PackageListOutputItem
 	cursor := registryClient.ListPackages(ctx, prefix)
@@ -128,6 +176,18 @@ func (i PackageListOutputItem) IntoText() (text []byte, err error) {
 			text = append(text, "\trepo="...)
 			text = append(text, i.Package.Repo...)
 		}
+		if i.Package.Compression != "" {
+			text = append(text, "\tcompression="...)
+			text = append(text, i.Package.Compression...)
+		}
+		if i.Package.MovedTo != "" {
+			text = append(text, "\tmoved_to="...)
+			text = append(text, i.Package.MovedTo...)
+		}
+		if i.Package.Deprecated != nil {
+			text = append(text, "\tdeprecated: "...)
+			text = append(text, i.Package.Deprecated.String()...)
+		}
 	} else {
 		text = append(text, i.Prefix...)
 		text = append(text, "/"...)
@@ -140,6 +200,7 @@ type PackageAddCommand struct {
 
 	Description string
 	Repo        string
+	Compression string
 }
 
 func NewPackageAddCommand(parent *PackageCommand) *cobra.Command {
@@ -148,7 +209,7 @@ func NewPackageAddCommand(parent *PackageCommand) *cobra.Command {
 	}
 
 	cmd := &cobra.Command{
-		Use:   "add [-d description] [-R repo] package_name",
+		Use:   "add [-d description] [-R repo] [--compression gzip|zstd|none|zip] package_name",
 		Short: "Add Package into registry",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -158,17 +219,23 @@ func NewPackageAddCommand(parent *PackageCommand) *cobra.Command {
 
 	cmd.PersistentFlags().StringVarP(&c.Description, "description", "d", "", "Package description text.")
 	cmd.PersistentFlags().StringVarP(&c.Repo, "repo", "R", "", "Repo to use for package data")
+	cmd.PersistentFlags().StringVar(&c.Compression, "compression", "", "Compression of archives uploaded into the package (default gzip).")
 
 	return cmd
 }
 
 func (c *PackageAddCommand) Run(ctx context.Context, name string) error {
-	registryConfig := c.Cfg.Registries[c.RegistryName]
+	if !shop.IsValidCompression(c.Compression) {
+		return fmt.Errorf("%w: %s", shop.ErrUnknownCompression, c.Compression)
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
 
 	registryClient, err := shop.NewRegistry(ctx, registryConfig)
 	if err != nil {
 		return err
 	}
+	defer registryClient.Close()
 
 	if c.Repo != "" {
 		registryManifest, err := registryClient.GetManifest(ctx)
@@ -185,6 +252,7 @@ func (c *PackageAddCommand) Run(ctx context.Context, name string) error {
 	if err != nil {
 		return err
 	}
+	pkg.Compression = c.Compression
 
 	return registryClient.PutPackage(ctx, pkg)
 }
@@ -213,6 +281,9 @@ func (m TagsMap) Set(v string) error {
 		if !shop.IsValidTagValue(value) {
 			return fmt.Errorf("%s is not a valid tag value", value)
 		}
+		if key == shop.VersionTagKey && !shop.IsValidSemVer(value) {
+			return fmt.Errorf("%s is not a valid semantic version", value)
+		}
 
 		if oldValue, ok := m[key]; ok && oldValue != value {
 			return fmt.Errorf("Conflicting values for tag %s: %s vs %s", key, oldValue, value)
@@ -227,6 +298,38 @@ func (m TagsMap) Type() string {
 	return "tag:value"
 }
 
+// Point in time given either as a date, RFC 3339 time, or a duration
+// before now (e.g. 7d or 12h).
+type TimeFlag struct {
+	time.Time
+}
+
+func (t TimeFlag) String() string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func (t *TimeFlag) Set(v string) error {
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if parsed, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	ago, err := shop.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("%s is neither a date, time nor duration", v)
+	}
+	t.Time = time.Now().Add(-ago)
+	return nil
+}
+
+func (t TimeFlag) Type() string {
+	return "time"
+}
+
 type RefSet map[string]struct{}
 
 func (s RefSet) String() string {
@@ -256,9 +359,32 @@ func (s RefSet) Set(v string) error {
 type PackageUploadCommand struct {
 	*PackageCommand
 
-	Tags TagsMap
-	Refs RefSet
-	Dir  string
+	Tags  TagsMap
+	Refs  RefSet
+	Dir   string
+	File  string
+	Stdin bool
+	Raw   bool
+	Name  string
+
+	Depends     []string
+	IfNotExists bool
+	Sign        bool
+	SignKey     string
+	SBOM        string
+
+	Provenance   bool
+	BuilderId    string
+	SourceRepo   string
+	SourceRef    string
+	SourceCommit string
+	Inputs       []string
+	Compression  string
+	DeltaBase    string
+
+	AllowSpecialModes bool
+	Include           []string
+	Exclude           []string
 }
 
 func NewPackageUploadCommand(parent *PackageCommand) *cobra.Command {
@@ -269,29 +395,154 @@ func NewPackageUploadCommand(parent *PackageCommand) *cobra.Command {
 	}
 
 	cmd := &cobra.Command{
-		Use:   "upload [-t tag:value...] [-R ref] package_name dir",
+		Use:   "upload [-t tag:value...] [-R ref] [-d package_name[@version]...] [--if-not-exists] package_name {dir | --file path | --stdin} [--raw]",
 		Short: "Upload new instance for package.",
-		Args:  cobra.ExactArgs(2),
+		Long: "Upload new instance for package, made of the dir, or a single file read from --file or --stdin.\n" +
+			"With --raw the file is a ready tar archive (gzip, zstd or not compressed) or zip archive, which is uploaded as is.\n" +
+			"Archives are compressed with --compression, or as set for the package by \"package compression\".\n" +
+			"The instance records it, so downloads pick the right decompressor. Zip archives keep modes of files too.\n" +
+			"With the delta compression only files which --delta-base does not have are stored, the latest instance of the package\n" +
+			"which is not a delta is the base by default, deltas can't be bases. The first instance of the package is stored file by file.\n" +
+			"Files with any executable bit get mode 0755, other files 0644. Setuid, setgid and sticky bits fail the upload,\n" +
+			"unless --allow-special-modes is given. They are kept in the archive, but never set on extraction.\n" +
+			"Files matching patterns of " + shop.ShopIgnoreFile + " at the root of the dir (gitignore syntax) or --exclude are not uploaded.\n" +
+			"With --include only files matching its patterns, or in directories matching them, are uploaded, e.g. --include 'bin/' --include '*.so'.\n" +
+			"With --if-not-exists an instance with the same content is not uploaded again, only tags and refs are applied.\n" +
+			"Large archives are uploaded in parts. With the cache set, an interrupted upload of an archive or a file to B2\n" +
+			"continues from the parts uploaded so far when the same content is uploaded again within a week. Deltas are\n" +
+			"uploaded from the start, as their content depends on the base.\n" +
+			"Packages from --depends are installed along with the instance, their versions are resolved at install time.\n" +
+			"With --sign (or sign_on_upload in the registry sigstore settings) new instances are signed as by \"package sign\",\n" +
+			"with --sign-key they are signed as by \"package sign --key\".\n" +
+			"SPDX or CycloneDX document from --sbom is attached to new instances, \"package sbom\" prints it.\n" +
+			"With --provenance SLSA provenance of new instances is stored, \"package provenance\" verifies it.\n" +
+			"Builder and source are detected in GitHub Actions and GitLab CI jobs, flags override them.",
+		Args: cobra.RangeArgs(1, 2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return c.Run(cmd.Context(), args[0], args[1])
+			return c.Run(cmd.Context(), args[0], args[1:])
 		},
 	}
 
 	cmd.PersistentFlags().VarP(c.Tags, "tag", "t", "Attach tag(s) to the instance.")
 	cmd.PersistentFlags().VarP(c.Refs, "ref", "R", "Update reference to point to the instance.")
+	cmd.PersistentFlags().StringVar(&c.File, "file", "", "Upload the single file instead of a dir.")
+	cmd.PersistentFlags().BoolVar(&c.Stdin, "stdin", false, "Upload the single file read from stdin instead of a dir.")
+	cmd.PersistentFlags().BoolVar(&c.Raw, "raw", false, "The file is an instance archive to upload as is.")
+	cmd.PersistentFlags().StringVar(&c.Name, "name", "", "Name of the file read from stdin in the instance (default: last element of the package name).")
+	cmd.PersistentFlags().StringArrayVarP(&c.Depends, "depends", "d", nil, "Package (package_name[@version]) the instance depends on.")
+	cmd.PersistentFlags().BoolVar(&c.IfNotExists, "if-not-exists", false, "Skip the upload if the instance exists already.")
+	cmd.PersistentFlags().BoolVar(&c.Sign, "sign", false, "Sign the instance with a sigstore certificate for the OIDC identity.")
+	cmd.PersistentFlags().StringVar(&c.SignKey, "sign-key", "", "Sign the instance with the local minisign or GPG secret key.")
+	cmd.PersistentFlags().StringVar(&c.SBOM, "sbom", "", "Attach the SBOM file (SPDX or CycloneDX) to the instance.")
+	cmd.PersistentFlags().BoolVar(&c.Provenance, "provenance", false, "Store SLSA provenance of the instance.")
+	cmd.PersistentFlags().StringVar(&c.BuilderId, "builder-id", "", "Builder id in the provenance (default: CI workflow).")
+	cmd.PersistentFlags().StringVar(&c.SourceRepo, "source-repo", "", "Source repository URI in the provenance, e.g. git+https://github.com/org/repo.")
+	cmd.PersistentFlags().StringVar(&c.SourceRef, "source-ref", "", "Source ref in the provenance, e.g. refs/tags/v1.0.")
+	cmd.PersistentFlags().StringVar(&c.SourceCommit, "source-commit", "", "Source commit in the provenance.")
+	cmd.PersistentFlags().StringArrayVar(&c.Inputs, "input", nil, "Build input (uri[@algorithm:hex]) in the provenance.")
+	cmd.PersistentFlags().StringArrayVar(&c.Include, "include", nil, "Upload only files matching the pattern (gitignore syntax), unless they are excluded.")
+	cmd.PersistentFlags().StringArrayVar(&c.Exclude, "exclude", nil, "Leave out files matching the pattern (gitignore syntax), after ones from "+shop.ShopIgnoreFile+".")
+	cmd.PersistentFlags().BoolVar(&c.AllowSpecialModes, "allow-special-modes", false, "Keep setuid, setgid and sticky bits of files in the archive.")
+	cmd.PersistentFlags().StringVar(&c.Compression, "compression", "", "Compression of the archive: gzip, zstd, none, zip, files to store it file by file, or delta (default: compression of the package, or gzip).")
+	cmd.PersistentFlags().StringVar(&c.DeltaBase, "delta-base", "", "Version of the package to store the archive as a delta against, implies --compression delta.")
 
 	return cmd
 }
 
-func (c *PackageUploadCommand) Run(ctx context.Context, name, dir string) error {
-	registryConfig := c.Cfg.Registries[c.RegistryName]
+func (c *PackageUploadCommand) Run(ctx context.Context, name string, dirs []string) error {
+	name, err := c.Arguments.ExpandPackageName(name)
+	if err != nil {
+		return err
+	}
+
+	sources := len(dirs)
+	if c.File != "" {
+		sources++
+	}
+	if c.Stdin {
+		sources++
+	}
+	switch {
+	case sources != 1:
+		return ErrUploadSource
+	case c.Raw && len(dirs) > 0:
+		return ErrRawUploadOfDir
+	case (len(c.Include) > 0 || len(c.Exclude) > 0) && len(dirs) == 0:
+		return ErrFilterWithoutDir
+	case !shop.IsValidCompression(c.Compression):
+		return fmt.Errorf("%w: %s", shop.ErrUnknownCompression, c.Compression)
+	case c.DeltaBase != "" && (c.Raw || (c.Compression != "" && c.Compression != shop.CompressionDelta)):
+		return ErrDeltaBaseCompression
+	}
+
+	var deps []shop.Dependency
+	for _, spec := range c.Depends {
+		dep, err := shop.ParseDependency(spec)
+		if err != nil {
+			return err
+		}
+		deps = append(deps, dep)
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+	sign := c.Sign || (registryConfig.Sigstore != nil && registryConfig.Sigstore.SignOnUpload)
+	// Token is checked before the upload, which is not signed without it.
+	var token string
+	if sign {
+		if token, err = shop.SigstoreIDToken(ctx); err != nil {
+			return err
+		}
+	}
+	var signKey shop.SecretSigningKey
+	if c.SignKey != "" {
+		if signKey, err = readSecretSigningKey(c.SignKey); err != nil {
+			return err
+		}
+	}
+	var sbom []byte
+	if c.SBOM != "" {
+		if sbom, err = os.ReadFile(c.SBOM); err != nil {
+			return err
+		}
+		if _, err = shop.DetectSBOM(sbom); err != nil {
+			return fmt.Errorf("%w: %s", err, c.SBOM)
+		}
+	}
+	var provenance *shop.ProvenanceOptions
+	if c.Provenance {
+		if provenance, err = c.provenanceOptions(); err != nil {
+			return err
+		}
+	}
 
 	registryClient, err := shop.NewRegistry(ctx, registryConfig)
 	if err != nil {
 		return err
 	}
+	defer registryClient.Close()
+
+	compression := c.Compression
+	if c.DeltaBase != "" {
+		compression = shop.CompressionDelta
+	}
+	if compression == "" && !c.Raw {
+		pkg, err := registryClient.GetPackage(ctx, name)
+		if err != nil {
+			return err
+		}
+		compression = pkg.Compression
+	}
+	var deltaBase *shop.Instance
+	if compression == shop.CompressionDelta {
+		if deltaBase, err = c.deltaBase(ctx, registryClient, name); err != nil {
+			return err
+		}
+		if deltaBase == nil {
+			compression = shop.CompressionFiles
+		}
+	}
 
-	file, err := os.CreateTemp("", fmt.Sprintf("%s_*%s", strings.Replace(name, "/", "-", -1), shop.RegistryCASArchiveExtension))
+	file, err := os.CreateTemp("", fmt.Sprintf("%s_*%s", strings.Replace(name, "/", "-", -1), shop.ArchiveExtension(compression)))
 	if err != nil {
 		return err
 	}
@@ -302,7 +553,11 @@ func (c *PackageUploadCommand) Run(ctx context.Context, name, dir string) error
 		return err
 	}
 
-	id, err := shop.MakeArchive(file, os.DirFS(dir))
+	id, compression, err := c.makeArchive(file, name, dirs, shop.ArchiveOptions{
+		IdAlgorithm:       registryConfig.IdAlgorithm,
+		Compression:       compression,
+		AllowSpecialModes: c.AllowSpecialModes,
+	})
 	if err != nil {
 		return err
 	}
@@ -312,18 +567,75 @@ func (c *PackageUploadCommand) Run(ctx context.Context, name, dir string) error
 		return err
 	}
 
-	instance, err := registryClient.UploadPackageInstance(ctx, name, id, file)
-	if err != nil {
-		return err
+	uploadOpts := shop.UploadOptions{
+		IfNotExists: c.IfNotExists,
+		Compression: compression,
 	}
-
-	err = registryClient.PutPackageInstanceInfo(ctx, *instance)
-	if err != nil {
+	switch {
+	case deltaBase == nil:
+	case deltaBase.Id == id:
+		// Same content as the base, which is uploaded again as it's stored.
+		uploadOpts.Compression, uploadOpts.DeltaBase = deltaBase.Compression, deltaBase.DeltaBase
+	default:
+		uploadOpts.DeltaBase = deltaBase.Id
+	}
+	instance, err := registryClient.UploadPackageInstance(ctx, name, id, file, uploadOpts)
+	switch {
+	case errors.Is(err, shop.ErrInstanceExists):
+		fmt.Printf("%s:\n  %s (exists)\n", name, instance.Id)
+	case err != nil:
 		return err
+	default:
+		instance.Dependencies = deps
+		if sbom != nil {
+			if instance.SBOM, err = shop.PutSBOM(ctx, registryClient, *instance, sbom); err != nil {
+				return err
+			}
+		}
+		// Provenance goes first too, so policies requiring it never see the
+		// instance without one.
+		if provenance != nil {
+			if _, err = file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			digest, err := shop.ArchiveDigest(file)
+			if err != nil {
+				return err
+			}
+			if _, err = shop.PutProvenance(ctx, registryClient, *instance, digest, *provenance); err != nil {
+				return err
+			}
+		}
+		if err = c.putInstance(ctx, registryClient, *instance, file); err != nil {
+			return err
+		}
+		fmt.Printf("%s:\n  %s\n", name, instance.Id)
+		if instance.SBOM != nil {
+			fmt.Printf("  (%s SBOM)\n", instance.SBOM.Format)
+		}
+		if provenance != nil {
+			fmt.Printf("  (provenance by %s)\n", provenance.BuilderId)
+		}
+		if sign {
+			if _, err = file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			if _, err = shop.SignPackageInstance(ctx, registryClient, *instance, file, token); err != nil {
+				return err
+			}
+			fmt.Println("  (signed)")
+		}
+		if signKey != nil {
+			if _, err = file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			if _, err = shop.SignPackageInstanceDetached(ctx, registryClient, *instance, file, signKey); err != nil {
+				return err
+			}
+			fmt.Printf("  (signed by %s)\n", signKey.Public())
+		}
 	}
 
-	fmt.Printf("%s:\n  %s\n", name, instance.Id)
-
 	for key, value := range c.Tags {
 		var tag shop.Tag
 		tag, err = shop.NewTag(name, key, value, instance.Id)
@@ -348,8 +660,876 @@ func (c *PackageUploadCommand) Run(ctx context.Context, name, dir string) error
 		if err != nil {
 			return err
 		}
-		fmt.Printf("  %s\n", ref)
+		fmt.Printf("  %s\n", ref.Name)
 	}
 
 	return nil
 }
+
+func (c *PackageUploadCommand) putInstance(ctx context.Context, registryClient shop.Registry, instance shop.Instance, file *os.File) error {
+	err := registryClient.PutPackageInstanceInfo(ctx, instance)
+	if err != nil {
+		return err
+	}
+
+	// File manifest lets files and diff skip downloading the archive.
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	files, err := shop.ListArchive(file)
+	if err != nil {
+		return err
+	}
+	return registryClient.PutPackageInstanceFiles(ctx, instance, files)
+}
+
+// Detected options overridden by flags, checked before the upload.
+// Instance from --delta-base, or the latest uploaded one which is not a delta
+// itself. Nil if the package has no such instances yet.
+func (c *PackageUploadCommand) deltaBase(ctx context.Context, registryClient shop.Registry, name string) (*shop.Instance, error) {
+	if c.DeltaBase != "" {
+		return registryClient.ResolveVersion(ctx, name, c.DeltaBase)
+	}
+
+	instances, err := shop.CollectCursor(ctx, registryClient.ListPackageInstances(ctx, name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var latest *shop.Instance
+	for i, instance := range instances {
+		if instance.Compression == shop.CompressionDelta {
+			continue
+		}
+		if latest == nil || instance.UploadedAt.After(latest.UploadedAt.Time) {
+			latest = &instances[i]
+		}
+	}
+	return latest, nil
+}
+
+func (c *PackageUploadCommand) provenanceOptions() (*shop.ProvenanceOptions, error) {
+	opts := shop.DetectProvenanceOptions()
+	if c.BuilderId != "" {
+		opts.BuilderId = c.BuilderId
+	}
+	if c.SourceRepo != "" {
+		opts.SourceRepo = c.SourceRepo
+	}
+	if c.SourceRef != "" {
+		opts.SourceRef = c.SourceRef
+	}
+	if c.SourceCommit != "" {
+		opts.SourceCommit = c.SourceCommit
+	}
+	for _, spec := range c.Inputs {
+		input, err := shop.ParseProvenanceInput(spec)
+		if err != nil {
+			return nil, err
+		}
+		opts.Inputs = append(opts.Inputs, input)
+	}
+	if opts.BuilderId == "" {
+		return nil, fmt.Errorf("%w: no --builder-id and not in a CI job", shop.ErrInvalidProvenance)
+	}
+	return &opts, nil
+}
+
+// Archive of the upload source and its compression, which is detected for
+// raw archives.
+func (c *PackageUploadCommand) makeArchive(dst io.Writer, name string, dirs []string, opts shop.ArchiveOptions) (string, string, error) {
+	if len(dirs) > 0 {
+		fsys := shop.DirFS(dirs[0])
+		var err error
+		if opts.Ignore, err = shop.LoadIgnoreRules(fsys); err != nil {
+			return "", "", err
+		}
+		for _, pattern := range c.Exclude {
+			if err = opts.Ignore.Add(pattern); err != nil {
+				return "", "", err
+			}
+		}
+		for _, pattern := range c.Include {
+			if err = opts.Include.Add(pattern); err != nil {
+				return "", "", err
+			}
+		}
+		id, err := shop.MakeArchive(dst, fsys, opts)
+		return id, opts.Compression, err
+	}
+
+	src := os.Stdin
+	if c.File != "" {
+		file, err := os.Open(c.File)
+		if err != nil {
+			return "", "", err
+		}
+		defer file.Close()
+		src = file
+	}
+	if c.Raw {
+		return shop.CopyArchive(dst, src, opts)
+	}
+
+	if c.File != "" {
+		info, err := src.Stat()
+		if err != nil {
+			return "", "", err
+		}
+		if !info.Mode().IsRegular() {
+			return "", "", fmt.Errorf("%w: %s", ErrNotRegularFile, c.File)
+		}
+		id, err := shop.MakeFileArchive(dst, src, shop.ArchiveFile{
+			Path: filepath.Base(c.File),
+			Size: info.Size(),
+			Mode: info.Mode(),
+		}, info.ModTime(), opts)
+		return id, opts.Compression, err
+	}
+
+	// Size of the file is written before its content, so stdin is read
+	// into a temporary file first.
+	spool, err := os.CreateTemp("", "shop-stdin-*")
+	if err != nil {
+		return "", "", err
+	}
+	defer spool.Close()
+	if err = os.Remove(spool.Name()); err != nil {
+		return "", "", err
+	}
+	size, err := io.Copy(spool, src)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err = spool.Seek(0, io.SeekStart); err != nil {
+		return "", "", err
+	}
+
+	fileName := c.Name
+	if fileName == "" {
+		fileName = path.Base(name)
+	}
+	id, err := shop.MakeFileArchive(dst, spool, shop.ArchiveFile{
+		Path: fileName,
+		Size: size,
+		Mode: 0644,
+	}, time.Now(), opts)
+	return id, opts.Compression, err
+}
+
+type PackageURLCommand struct {
+	*PackageCommand
+
+	TTL time.Duration
+}
+
+func NewPackageURLCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageURLCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "url [--ttl duration] package_name version",
+		Short: "Print time-limited download link for package instance.",
+		Long: "Print time-limited download link for package instance, which could be used without registry credentials.\n" +
+			"Only repositories which sign links support it (b2), others fail with unimplemented.\n" +
+			VersionHelp + ".",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	cmd.PersistentFlags().DurationVar(&c.TTL, "ttl", time.Hour, "Time until the link expires.")
+
+	return cmd
+}
+
+func (c *PackageURLCommand) Run(ctx context.Context, name, version string) error {
+	name, err := c.Arguments.ExpandPackageName(name)
+	if err != nil {
+		return err
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	instance, err := registryClient.ResolveVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+
+	url, err := registryClient.GetPackageInstanceURL(ctx, *instance, c.TTL)
+	if err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(PackageURLOutput{
+		Package:   instance.Package,
+		Id:        instance.Id,
+		URL:       url,
+		ExpiresAt: shop.UnixTimestamp{Time: time.Now().Add(c.TTL)},
+	})
+}
+
+type PackageURLOutput struct {
+	Package   string             `json:"package"`
+	Id        string             `json:"id"`
+	URL       string             `json:"url"`
+	ExpiresAt shop.UnixTimestamp `json:"expires_at"`
+}
+
+func (o PackageURLOutput) IntoText() ([]byte, error) {
+	return []byte(o.URL), nil
+}
+
+type PackageDownloadCommand struct {
+	*PackageCommand
+
+	Out                  string
+	RejectUnsafeSymlinks bool
+}
+
+func NewPackageDownloadCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageDownloadCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "download [-O dir|file] package_name version",
+		Short: "Download package instance.",
+		Long: "Download package instance and extract it into a directory, or save the archive when the output path ends with\n" +
+			"the extension of its compression (.tgz, .tar.zst, .tar or .zip).\n" +
+			"The archive is written into a " + shop.PartialDownloadSuffix + " file first, and moved into place once its hash is verified.\n" +
+			"Running the command again after a failure continues the download from where it stopped.\n" +
+			"Large archives are downloaded in --jobs parallel ranges when the backend reads ranges, and neither cache nor\n" +
+			"mirrors are set.\n" +
+			SymlinkPolicyHelp + ".\n" +
+			VersionHelp + ".",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.Out, "out", "O", "", "Output directory or archive file (default is the last component of package name).")
+	addSymlinkPolicyFlags(cmd, &c.RejectUnsafeSymlinks)
+
+	return cmd
+}
+
+func (c *PackageDownloadCommand) Run(ctx context.Context, name, version string) error {
+	name, err := c.Arguments.ExpandPackageName(name)
+	if err != nil {
+		return err
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	instance, err := registryClient.ResolveVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+
+	out := c.Out
+	if out == "" {
+		out = path.Base(name)
+	}
+
+	if _, ok := shop.ArchiveNameCompression(out); ok {
+		if !strings.HasSuffix(out, shop.ArchiveExtension(instance.Compression)) {
+			return fmt.Errorf("%w: %s, the archive is %s", ErrArchiveExtension, out, shop.ArchiveExtension(instance.Compression))
+		}
+		err = c.save(ctx, registryClient, *instance, out)
+	} else {
+		err = c.extract(ctx, registryClient, *instance, out)
+	}
+	if err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(PackageDownloadOutput{
+		Package: instance.Package,
+		Id:      instance.Id,
+		Path:    out,
+	})
+}
+
+func (c *PackageDownloadCommand) save(ctx context.Context, registryClient shop.Registry, instance shop.Instance, out string) error {
+	return shop.DownloadPackageInstanceToFile(ctx, registryClient, instance, out)
+}
+
+// Archive is downloaded next to the directory, so an interrupted download
+// continues the next time, and removed once extracted.
+func (c *PackageDownloadCommand) extract(ctx context.Context, registryClient shop.Registry, instance shop.Instance, out string) error {
+	archive := filepath.Join(filepath.Dir(out), "."+filepath.Base(out)+shop.ArchiveExtension(instance.Compression))
+	if err := shop.DownloadPackageInstanceToFile(ctx, registryClient, instance, archive); err != nil {
+		return err
+	}
+	defer os.Remove(archive)
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return shop.ExtractArchive(file, out, shop.ExtractOptions{
+		RejectUnsafeSymlinks: c.RejectUnsafeSymlinks || c.Cfg.RejectUnsafeSymlinks,
+	})
+}
+
+type PackageDownloadOutput struct {
+	Package string `json:"package"`
+	Id      string `json:"id"`
+	Path    string `json:"path"`
+}
+
+func (o PackageDownloadOutput) IntoText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%s@%s -> %s", o.Package, o.Id, o.Path)), nil
+}
+
+type PackageInstancesCommand struct {
+	*PackageCommand
+
+	Tags  TagsMap
+	Since TimeFlag
+	Until TimeFlag
+	Limit int
+}
+
+func NewPackageInstancesCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageInstancesCommand{
+		PackageCommand: parent,
+		Tags:           TagsMap{},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "instances [-t tag:value...] [--since time] [--until time] [--limit n] package_name",
+		Short: "List package instances with their refs and tags, newest first.",
+		Long: "List package instances with their refs and tags, newest first. Only instances with all the --tag tags,\n" +
+			"uploaded since and before --until are listed. Times are dates, RFC 3339 times or durations before now\n" +
+			"(e.g. 7d or 12h).",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0])
+		},
+	}
+
+	cmd.PersistentFlags().VarP(c.Tags, "tag", "t", "Only list instances with the tag(s).")
+	cmd.PersistentFlags().Var(&c.Since, "since", "Only list instances uploaded since the time.")
+	cmd.PersistentFlags().Var(&c.Until, "until", "Only list instances uploaded before the time.")
+	cmd.PersistentFlags().IntVar(&c.Limit, "limit", 0, "List at most this many newest instances.")
+
+	return cmd
+}
+
+func (c *PackageInstancesCommand) Run(ctx context.Context, name string) error {
+	name, err := c.Arguments.ExpandPackageName(name)
+	if err != nil {
+		return err
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	if _, err = registryClient.GetPackage(ctx, name); err != nil {
+		return err
+	}
+
+	instances, err := shop.FindPackageInstances(ctx, registryClient, name, shop.InstanceFilter{
+		Tags:  c.Tags,
+		Since: c.Since.Time,
+		Until: c.Until.Time,
+		Limit: c.Limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	refs, err := shop.CollectCursor(ctx, registryClient.ListPackageReferences(ctx, name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	instanceRefs := map[string][]string{}
+	for _, ref := range refs {
+		instanceRefs[ref.Id] = append(instanceRefs[ref.Id], ref.Name)
+	}
+
+	output := make([]PackageInstancesOutputItem, 0, len(instances))
+	for _, instance := range instances {
+		tags, err := shop.CollectCursor(ctx, registryClient.ListPackageInstanceTags(ctx, instance))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		item := PackageInstancesOutputItem{
+			Id:         instance.Id,
+			UploadedAt: instance.UploadedAt,
+			Refs:       instanceRefs[instance.Id],
+			Tags:       []string{},
+			Yanked:     instance.Yanked,
+		}
+		if item.Refs == nil {
+			item.Refs = []string{}
+		}
+		for _, tag := range tags {
+			item.Tags = append(item.Tags, tag.Key+":"+tag.Value)
+		}
+		output = append(output, item)
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type PackageInstancesOutputItem struct {
+	Id         string             `json:"id"`
+	UploadedAt shop.UnixTimestamp `json:"uploaded_at"`
+	Refs       []string           `json:"refs"`
+	Tags       []string           `json:"tags"`
+	Yanked     *shop.Deprecation  `json:"yanked,omitempty"`
+}
+
+func (i PackageInstancesOutputItem) IntoText() ([]byte, error) {
+	text := fmt.Sprintf("%s\t%s", i.Id, i.UploadedAt.Format(time.RFC3339))
+	for _, ref := range i.Refs {
+		text += "\t" + ref
+	}
+	for _, tag := range i.Tags {
+		text += "\t" + tag
+	}
+	if i.Yanked != nil {
+		text += "\tyanked: " + i.Yanked.String()
+	}
+	return []byte(text), nil
+}
+
+type PackageDeleteCommand struct {
+	*PackageCommand
+
+	Yes     bool
+	KeepCAS bool
+}
+
+func NewPackageDeleteCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageDeleteCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:     "delete [-y|--force] [--keep-cas] package_name",
+		Aliases: []string{"rm"},
+		Short:   "Delete package with all its instances, refs and tags.",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0])
+		},
+	}
+
+	addYesFlags(cmd, &c.Yes)
+	cmd.PersistentFlags().BoolVar(&c.KeepCAS, "keep-cas", false, "Keep instance archives in CAS. Archives identical instances of other packages share are always kept.")
+
+	return cmd
+}
+
+func (c *PackageDeleteCommand) Run(ctx context.Context, name string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	if _, err = registryClient.GetPackage(ctx, name); err != nil {
+		return err
+	}
+
+	instances, err := shop.CollectCursor(ctx, registryClient.ListPackageInstances(ctx, name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	var summary strings.Builder
+	for _, instance := range instances {
+		fmt.Fprintf(&summary, "%s@%s\n", instance.Package, instance.Id)
+	}
+	err = confirmDestruction(c.Yes, summary.String(), fmt.Sprintf("Delete package %s with %d instances?", name, len(instances)))
+	if err != nil {
+		return err
+	}
+
+	return registryClient.DeletePackage(ctx, name, c.KeepCAS)
+}
+
+type PackageResolveCommand struct {
+	*PackageCommand
+}
+
+func NewPackageResolveCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageResolveCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "resolve package_name version",
+		Short: "Print instance id for the version.",
+		Long:  "Print instance id for the version, which could be used to pin it.\n" + VersionHelp + ".",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	return cmd
+}
+
+func (c *PackageResolveCommand) Run(ctx context.Context, name, version string) error {
+	name, err := c.Arguments.ExpandPackageName(name)
+	if err != nil {
+		return err
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	instance, err := registryClient.ResolveVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(PackageResolveOutput{
+		Package: instance.Package,
+		Version: version,
+		Id:      instance.Id,
+	})
+}
+
+type PackageResolveOutput struct {
+	Package string `json:"package"`
+	Version string `json:"version"`
+	Id      string `json:"id"`
+}
+
+func (o PackageResolveOutput) IntoText() ([]byte, error) {
+	return []byte(o.Id), nil
+}
+
+type PackageVerifyCommand struct {
+	*PackageCommand
+
+	Signature bool
+}
+
+func NewPackageVerifyCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageVerifyCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "verify [--signature] package_name version",
+		Short: "Check the instance archive.",
+		Long: "Download the instance archive, check that its hash matches the instance id and that it could be extracted.\n" +
+			"With --signature also check that the archive is signed by an identity allowed by the registry sigstore settings,\n" +
+			"or by a key added with \"key add\".\n" +
+			"Fails if the archive is broken.\n" + VersionHelp + ".",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	cmd.PersistentFlags().BoolVar(&c.Signature, "signature", false, "Verify the sigstore or detached signatures of the archive.")
+
+	return cmd
+}
+
+func (c *PackageVerifyCommand) Run(ctx context.Context, name, version string) error {
+	name, err := c.Arguments.ExpandPackageName(name)
+	if err != nil {
+		return err
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	instance, err := registryClient.ResolveVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+
+	if err = shop.VerifyPackageInstance(ctx, registryClient, *instance); err != nil {
+		return err
+	}
+
+	output := PackageVerifyOutput{
+		Package: instance.Package,
+		Version: version,
+		Id:      instance.Id,
+	}
+	if c.Signature {
+		if err = c.verifySignatures(ctx, registryClient, *instance, &output); err != nil {
+			return err
+		}
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+// Sigstore bundle and detached signatures are checked, either is enough, but
+// invalid ones fail.
+func (c *PackageVerifyCommand) verifySignatures(ctx context.Context, registryClient shop.Registry, instance shop.Instance, output *PackageVerifyOutput) error {
+	signer, err := shop.VerifyPackageInstanceSignature(ctx, registryClient, instance)
+	if err != nil && !errors.Is(err, shop.ErrInstanceNotSigned) {
+		return err
+	}
+	keys, keysErr := shop.VerifyPackageInstanceDetachedSignatures(ctx, registryClient, instance)
+	if keysErr != nil && !errors.Is(keysErr, shop.ErrInstanceNotSigned) {
+		return keysErr
+	}
+	if signer == nil && len(keys) == 0 {
+		return err
+	}
+
+	output.Signer = signer
+	for _, key := range keys {
+		output.Keys = append(output.Keys, key.String())
+	}
+	return nil
+}
+
+type PackageVerifyOutput struct {
+	Package string       `json:"package"`
+	Version string       `json:"version"`
+	Id      string       `json:"id"`
+	Signer  *shop.Signer `json:"signer,omitempty"`
+	Keys    []string     `json:"keys,omitempty"`
+}
+
+func (o PackageVerifyOutput) IntoText() ([]byte, error) {
+	var signers []string
+	if o.Signer != nil {
+		signers = append(signers, o.Signer.String())
+	}
+	signers = append(signers, o.Keys...)
+	if len(signers) > 0 {
+		return []byte(o.Package + "@" + o.Id + ": ok, signed by " + strings.Join(signers, ", ")), nil
+	}
+	return []byte(o.Package + "@" + o.Id + ": ok"), nil
+}
+
+type PackageDiffCommand struct {
+	*PackageCommand
+}
+
+func NewPackageDiffCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageDiffCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "diff package_name version_a version_b",
+		Short: "Show files changed between two instances.",
+		Long:  "Show files added, removed or changed between two instances, with their sizes.\n" + VersionHelp + ".",
+		Args:  cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1], args[2])
+		},
+	}
+
+	return cmd
+}
+
+func (c *PackageDiffCommand) Run(ctx context.Context, name, versionA, versionB string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	from, err := registryClient.ResolveVersion(ctx, name, versionA)
+	if err != nil {
+		return err
+	}
+	to, err := registryClient.ResolveVersion(ctx, name, versionB)
+	if err != nil {
+		return err
+	}
+
+	changes, err := shop.DiffPackageInstances(ctx, registryClient, *from, *to)
+	if err != nil {
+		return err
+	}
+
+	output := make([]PackageDiffOutputItem, 0, len(changes))
+	for _, change := range changes {
+		output = append(output, PackageDiffOutputItem{change})
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type PackageDiffOutputItem struct {
+	shop.FileChange
+}
+
+func (i PackageDiffOutputItem) IntoText() ([]byte, error) {
+	switch i.Change {
+	case shop.FileAdded:
+		return []byte(fmt.Sprintf("+ %s\t%d", i.Path, i.NewSize)), nil
+	case shop.FileRemoved:
+		return []byte(fmt.Sprintf("- %s\t%d", i.Path, i.OldSize)), nil
+	default:
+		text := fmt.Sprintf("~ %s\t%d -> %d", i.Path, i.OldSize, i.NewSize)
+		if i.OldMode != i.NewMode {
+			text += fmt.Sprintf("\t%s -> %s", i.OldMode, i.NewMode)
+		}
+		return []byte(text), nil
+	}
+}
+
+type PackageCatCommand struct {
+	*PackageCommand
+}
+
+func NewPackageCatCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageCatCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "cat package_name version path",
+		Short: "Print a file from the instance.",
+		Long: "Stream a file from the instance archive to stdout, without extracting other files.\n" +
+			"Fails after the file is printed if the archive doesn't match the instance id.\n" + VersionHelp + ".",
+		Args: cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1], args[2])
+		},
+	}
+
+	return cmd
+}
+
+func (c *PackageCatCommand) Run(ctx context.Context, name, version, file string) error {
+	name, err := c.Arguments.ExpandPackageName(name)
+	if err != nil {
+		return err
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	instance, err := registryClient.ResolveVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+
+	return shop.CatPackageInstanceFile(ctx, registryClient, *instance, file, os.Stdout)
+}
+
+type PackageFilesCommand struct {
+	*PackageCommand
+}
+
+func NewPackageFilesCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageFilesCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "files package_name version",
+		Short: "List files of the instance.",
+		Long: "Print modes, sizes and paths of files in the instance archive. The file manifest stored on upload\n" +
+			"is used when the instance has one, otherwise the archive is read.\n" + VersionHelp + ".",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	return cmd
+}
+
+func (c *PackageFilesCommand) Run(ctx context.Context, name, version string) error {
+	name, err := c.Arguments.ExpandPackageName(name)
+	if err != nil {
+		return err
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	instance, err := registryClient.ResolveVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+
+	files, err := shop.ListPackageInstanceFiles(ctx, registryClient, *instance)
+	if err != nil {
+		return err
+	}
+
+	output := make([]PackageFilesOutputItem, 0, len(files))
+	for _, file := range files {
+		output = append(output, PackageFilesOutputItem{file})
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type PackageFilesOutputItem struct {
+	shop.ArchiveFile
+}
+
+func (i PackageFilesOutputItem) IntoText() ([]byte, error) {
+	if i.Target != "" {
+		return []byte(fmt.Sprintf("%s\t%d\t%s -> %s", i.Mode, i.Size, i.Path, i.Target)), nil
+	}
+	return []byte(fmt.Sprintf("%s\t%d\t%s", i.Mode, i.Size, i.Path)), nil
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+type PackageCompressionCommand struct {
+	*PackageCommand
+}
+
+func NewPackageCompressionCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageCompressionCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "compression package_name [gzip|zstd|none|zip|files|delta]",
+		Short: "Show or change the compression of archives uploaded into the package.",
+		Long: "Print the compression stored in the package manifest, or change it. Uploads use it unless --compression is given,\n" +
+			"existing instances keep theirs. Zip archives suit tools which can't read tar ones, e.g. on Windows.\n" +
+			"With files every file is stored in CAS by its hash, so uploads skip files other instances have.\n" +
+			"With delta new instances only store files missing from the latest instance of the package which is not a delta.",
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1:])
+		},
+	}
+
+	return cmd
+}
+
+func (c *PackageCompressionCommand) Run(ctx context.Context, name string, compression []string) error {
+	name, err := c.Arguments.ExpandPackageName(name)
+	if err != nil {
+		return err
+	}
+
+	registryClient, err := shop.NewRegistry(ctx, c.Cfg.Registry(c.RegistryName))
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	pkg, err := registryClient.GetPackage(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if len(compression) > 0 {
+		if !shop.IsValidCompression(compression[0]) {
+			return fmt.Errorf("%w: %s", shop.ErrUnknownCompression, compression[0])
+		}
+		pkg.Compression = compression[0]
+		if err = registryClient.PutPackage(ctx, *pkg); err != nil {
+			return err
+		}
+	}
+
+	output := PackageCompressionOutput{Package: pkg.Name, Compression: pkg.Compression}
+	if output.Compression == "" {
+		output.Compression = shop.DefaultCompression
+	}
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type PackageCompressionOutput struct {
+	Package     string `json:"package"`
+	Compression string `json:"compression"`
+}
+
+func (o PackageCompressionOutput) IntoText() ([]byte, error) {
+	return []byte(o.Compression), nil
+}
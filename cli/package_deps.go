@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+type PackageDepsCommand struct {
+	*PackageCommand
+}
+
+func NewPackageDepsCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageDepsCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "deps package_name [version]",
+		Short: "Print instances installed along with the version.",
+		Long: "Resolve the version and packages it depends on, recursively, and print the instances which would be installed.\n" +
+			"Fails if dependencies require different instances of a package.\n" +
+			VersionHelp + " (default: " + DefaultInstallVersion + ").",
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			version := DefaultInstallVersion
+			if len(args) > 1 {
+				version = args[1]
+			}
+			return c.Run(cmd.Context(), args[0], version)
+		},
+	}
+
+	return cmd
+}
+
+func (c *PackageDepsCommand) Run(ctx context.Context, name, version string) error {
+	name, err := c.Arguments.ExpandPackageName(name)
+	if err != nil {
+		return err
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	resolved, err := shop.ResolveDependencies(ctx, registryClient, []shop.EnsurePackage{{Package: name, Version: version}})
+	if err != nil {
+		return err
+	}
+
+	output := make([]PackageDepsOutputItem, 0, len(resolved))
+	for _, pkg := range resolved {
+		output = append(output, PackageDepsOutputItem{
+			Package:    pkg.Package,
+			Version:    pkg.Version,
+			Id:         pkg.Instance.Id,
+			RequiredBy: pkg.RequiredBy,
+		})
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type PackageDepsOutputItem struct {
+	Package    string   `json:"package"`
+	Version    string   `json:"version"`
+	Id         string   `json:"id"`
+	RequiredBy []string `json:"required_by,omitempty"`
+}
+
+func (i PackageDepsOutputItem) IntoText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%s@%s\t%s\t%s", i.Package, i.Version, i.Id, strings.Join(i.RequiredBy, ","))), nil
+}
@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+func NewPackageInstanceCommand(parent *PackageCommand) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "instance",
+		Short: "Manage package instances.",
+	}
+
+	cmd.AddCommand(
+		NewPackageInstanceRemoveCommand(parent),
+		NewPackageInstanceTagsCommand(parent),
+	)
+
+	return cmd
+}
+
+type PackageInstanceRemoveCommand struct {
+	*PackageCommand
+
+	Yes     bool
+	KeepCAS bool
+}
+
+func NewPackageInstanceRemoveCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageInstanceRemoveCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "rm [-y|--force] [--keep-cas] package_name version",
+		Short: "Delete package instance with its tags and refs.",
+		Long: "Delete package instance with its tags and refs pointing to it. Its archive is deleted from CAS\n" +
+			"unless instances of other packages in the same repository share it.\n" + VersionHelp + ".",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	addYesFlags(cmd, &c.Yes)
+	cmd.PersistentFlags().BoolVar(&c.KeepCAS, "keep-cas", false, "Keep the instance archive in CAS.")
+
+	return cmd
+}
+
+func (c *PackageInstanceRemoveCommand) Run(ctx context.Context, name, version string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	instance, err := registryClient.ResolveVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+
+	if !c.Yes {
+		refs, err := shop.CollectCursor(ctx, registryClient.ListPackageReferences(ctx, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		var summary strings.Builder
+		for _, ref := range refs {
+			if ref.Id == instance.Id {
+				fmt.Fprintf(&summary, "ref %s\n", ref.Name)
+			}
+		}
+		err = confirmDestruction(false, summary.String(), fmt.Sprintf("Delete instance %s@%s?", instance.Package, instance.Id))
+		if err != nil {
+			return err
+		}
+	}
+
+	deletion, err := registryClient.DeletePackageInstance(ctx, *instance, c.KeepCAS)
+	if err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(PackageInstanceRemoveOutput{*deletion})
+}
+
+type PackageInstanceRemoveOutput struct {
+	shop.InstanceDeletion
+}
+
+func (o PackageInstanceRemoveOutput) IntoText() ([]byte, error) {
+	text := "Deleted " + o.Package + "@" + o.Id
+	if len(o.Refs) > 0 {
+		text += " with refs " + strings.Join(o.Refs, ", ")
+	}
+	if o.ArchiveDeleted {
+		text += " and its archive"
+	}
+	return []byte(text + "."), nil
+}
+
+type PackageInstanceTagsCommand struct {
+	*PackageCommand
+}
+
+func NewPackageInstanceTagsCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageInstanceTagsCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "tags package_name version",
+		Short: "List tags attached to package instance.",
+		Long:  "List key:value tags attached to package instance.\n" + VersionHelp + ".",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	return cmd
+}
+
+func (c *PackageInstanceTagsCommand) Run(ctx context.Context, name, version string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	instance, err := registryClient.ResolveVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+
+	tags, err := shop.CollectCursor(ctx, registryClient.ListPackageInstanceTags(ctx, *instance))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	output := make([]PackageInstanceTagsOutputItem, 0, len(tags))
+	for _, tag := range tags {
+		output = append(output, PackageInstanceTagsOutputItem{
+			Key:       tag.Key,
+			Value:     tag.Value,
+			UpdatedAt: tag.UpdatedAt,
+		})
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type PackageInstanceTagsOutputItem struct {
+	Key       string             `json:"key"`
+	Value     string             `json:"value"`
+	UpdatedAt shop.UnixTimestamp `json:"updated_at"`
+}
+
+func (i PackageInstanceTagsOutputItem) IntoText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%s:%s\t%s", i.Key, i.Value, i.UpdatedAt.Format(time.RFC3339))), nil
+}
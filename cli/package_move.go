@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"context"
+	"os"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+type PackageMoveCommand struct {
+	*PackageCommand
+
+	Alias bool
+}
+
+func NewPackageMoveCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageMoveCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:     "move [--alias] old_name new_name",
+		Aliases: []string{"mv"},
+		Short:   "Rename package with all its instances, refs and tags.",
+		Long: "Copy package manifest, instances, refs and tags to the new name and delete the old package.\n" +
+			"Archives are not copied. With --alias the old name is kept as an alias, so versions of the old\n" +
+			"package (e.g. in ensure files) resolve to the instances of the new one until the alias is deleted.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	cmd.PersistentFlags().BoolVar(&c.Alias, "alias", false, "Leave an alias at the old name.")
+
+	return cmd
+}
+
+func (c *PackageMoveCommand) Run(ctx context.Context, from, to string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	changes, err := shop.MovePackage(ctx, registryClient, from, to, c.Alias)
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	if encodeErr := encoder.Encode(syncOutput(changes)); err == nil {
+		err = encodeErr
+	}
+	return err
+}
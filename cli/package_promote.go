@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+type PackagePromoteCommand struct {
+	*PackageCommand
+
+	To   string
+	Tags []string
+	Refs []string
+}
+
+func NewPackagePromoteCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackagePromoteCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "promote [-r registry] [--tag key]... [--ref name]... [--dry-run] package_name version --to registry",
+		Short: "Copy package instance into another registry.",
+		Long: "Copy package instance with its archive, tags and refs into another registry, keeping its id.\n" +
+			"By default all tags of the instance and refs pointing to it are copied.\n" + VersionHelp + ".",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	cmd.PersistentFlags().StringVar(&c.To, "to", "", "Destination registry name.")
+	cmd.PersistentFlags().StringSliceVarP(&c.Tags, "tag", "t", nil, "Copy only tags with the key.")
+	cmd.PersistentFlags().StringSliceVar(&c.Refs, "ref", nil, "Point the ref to the instance in the destination registry, instead of copying refs.")
+	_ = cmd.MarkPersistentFlagRequired("to")
+	_ = cmd.RegisterFlagCompletionFunc("to", CompleteRegistryFlag)
+
+	return cmd
+}
+
+func (c *PackagePromoteCommand) Run(ctx context.Context, name, version string) error {
+	if _, ok := c.Cfg.Registries[c.To]; !ok {
+		return fmt.Errorf("%w: %s", ErrRegistryDoesNotExist, c.To)
+	}
+
+	srcRegistry, err := shop.NewRegistry(ctx, c.Cfg.Registry(c.RegistryName))
+	if err != nil {
+		return err
+	}
+	defer srcRegistry.Close()
+	dstRegistry, err := shop.NewRegistry(ctx, c.Cfg.Registry(c.To))
+	if err != nil {
+		return err
+	}
+	defer dstRegistry.Close()
+
+	instance, err := srcRegistry.ResolveVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+
+	changes, err := shop.CopyInstance(ctx, srcRegistry, dstRegistry, *instance, shop.CopyInstanceOptions{
+		Tags:   c.Tags,
+		Refs:   c.Refs,
+		DryRun: c.Arguments.DryRun,
+	})
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	if encodeErr := encoder.Encode(syncOutput(changes)); err == nil {
+		err = encodeErr
+	}
+	return err
+}
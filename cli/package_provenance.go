@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+type PackageProvenanceCommand struct {
+	*PackageCommand
+}
+
+func NewPackageProvenanceCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageProvenanceCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "provenance package_name version",
+		Short: "Verify and print SLSA provenance of the instance.",
+		Long: "Verify that the provenance stored on upload with --provenance is about the instance archive, and print it.\n" +
+			"With --output json the in-toto statement is printed as is. Fails if the instance has none.\n" +
+			"Signature policies of the registry (require_provenance, builders, source_repos) enforce provenance on install.\n" +
+			VersionHelp + ".",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	return cmd
+}
+
+func (c *PackageProvenanceCommand) Run(ctx context.Context, name, version string) error {
+	name, err := c.Arguments.ExpandPackageName(name)
+	if err != nil {
+		return err
+	}
+
+	registryClient, err := shop.NewRegistry(ctx, c.Cfg.Registry(c.RegistryName))
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	instance, err := registryClient.ResolveVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+
+	statement, err := shop.VerifyPackageInstanceProvenance(ctx, registryClient, *instance)
+	if err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(PackageProvenanceOutput{statement})
+}
+
+type PackageProvenanceOutput struct {
+	*shop.InTotoStatement
+}
+
+func (o PackageProvenanceOutput) IntoText() ([]byte, error) {
+	lines := []string{fmt.Sprintf("builder: %s", o.BuilderId())}
+	if repo := o.SourceRepo(); repo != "" {
+		lines = append(lines, fmt.Sprintf("source: %s", repo))
+	}
+	if ref := o.Predicate.BuildDefinition.ExternalParameters.Ref; ref != "" {
+		lines = append(lines, fmt.Sprintf("ref: %s", ref))
+	}
+	if commit := o.SourceCommit(); commit != "" {
+		lines = append(lines, fmt.Sprintf("commit: %s", commit))
+	}
+	for _, input := range o.Predicate.BuildDefinition.ResolvedDependencies {
+		if _, ok := input.Digest["gitCommit"]; ok {
+			continue
+		}
+		line := fmt.Sprintf("input: %s", input.URI)
+		for algorithm, digest := range input.Digest {
+			line += fmt.Sprintf(" %s:%s", algorithm, digest)
+		}
+		lines = append(lines, line)
+	}
+	if id := o.Predicate.RunDetails.Metadata.InvocationId; id != "" {
+		lines = append(lines, fmt.Sprintf("invocation: %s", id))
+	}
+	lines = append(lines, fmt.Sprintf("finished: %s", o.Predicate.RunDetails.Metadata.FinishedOn.Format(time.RFC3339)))
+	return []byte(strings.Join(lines, "\n")), nil
+}
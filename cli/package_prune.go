@@ -0,0 +1,241 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+// Retention policy flags shared by prune and retention commands. Only flags
+// set on the command line override the policy stored in the package manifest.
+type RetentionFlags struct {
+	Keep      int
+	KeepRefs  bool
+	OlderThan shop.Duration
+}
+
+func (f *RetentionFlags) Setup(cmd *cobra.Command) {
+	cmd.PersistentFlags().IntVar(&f.Keep, "keep", 0, "Keep this many newest instances.")
+	cmd.PersistentFlags().BoolVar(&f.KeepRefs, "keep-refs", false, "Keep instances pointed to by refs.")
+	cmd.PersistentFlags().Var(TextVar{&f.OlderThan}, "older-than", "Keep instances uploaded less than this ago (e.g. 90d or 12h).")
+}
+
+func (f *RetentionFlags) Changed(cmd *cobra.Command) bool {
+	return cmd.Flags().Changed("keep") || cmd.Flags().Changed("keep-refs") || cmd.Flags().Changed("older-than")
+}
+
+func (f *RetentionFlags) Apply(cmd *cobra.Command, policy shop.RetentionPolicy) shop.RetentionPolicy {
+	if cmd.Flags().Changed("keep") {
+		policy.Keep = f.Keep
+	}
+	if cmd.Flags().Changed("keep-refs") {
+		policy.KeepRefs = f.KeepRefs
+	}
+	if cmd.Flags().Changed("older-than") {
+		policy.OlderThan = f.OlderThan
+	}
+	return policy
+}
+
+type PackagePruneCommand struct {
+	*PackageCommand
+	RetentionFlags
+
+	Yes bool
+}
+
+func NewPackagePruneCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackagePruneCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "prune [-y|--force] [--keep n] [--keep-refs] [--older-than duration] package_name",
+		Short: "Delete old package instances.",
+		Long: "Delete package instances which are not kept by the retention policy, along with refs pointing to them.\n" +
+			"Instances are kept if they are among --keep newest ones, uploaded less than --older-than ago, or\n" +
+			"pointed to by refs with --keep-refs. Flags override the policy stored in the package manifest\n" +
+			"(see \"package retention\"). Instances to delete are listed for confirmation, use --dry-run to only\n" +
+			"list them.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), cmd, args[0])
+		},
+	}
+
+	c.RetentionFlags.Setup(cmd)
+	addYesFlags(cmd, &c.Yes)
+
+	return cmd
+}
+
+func (c *PackagePruneCommand) Run(ctx context.Context, cmd *cobra.Command, name string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	pkg, err := registryClient.GetPackage(ctx, name)
+	if err != nil {
+		return err
+	}
+	var policy shop.RetentionPolicy
+	if pkg.Retention != nil {
+		policy = *pkg.Retention
+	}
+	policy = c.Apply(cmd, policy)
+
+	if !c.Arguments.DryRun && !c.Yes {
+		if err = c.confirm(ctx, registryClient, name, policy); err != nil {
+			return err
+		}
+	}
+
+	deletions, err := shop.PrunePackage(ctx, registryClient, name, policy, c.Arguments.DryRun)
+	if deletions == nil && err != nil {
+		return err
+	}
+
+	output := PackagePruneOutput{
+		DryRun:    c.Arguments.DryRun,
+		Instances: make([]PackagePruneOutputItem, 0, len(deletions)),
+	}
+	for _, deletion := range deletions {
+		output.Instances = append(output.Instances, PackagePruneOutputItem{deletion})
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	if encodeErr := encoder.Encode(output); err == nil {
+		err = encodeErr
+	}
+	return err
+}
+
+func (c *PackagePruneCommand) confirm(ctx context.Context, registryClient shop.Registry, name string, policy shop.RetentionPolicy) error {
+	deletions, err := shop.PrunePackage(ctx, registryClient, name, policy, true)
+	if err != nil {
+		return err
+	}
+	if len(deletions) == 0 {
+		return nil
+	}
+
+	output := PackagePruneOutput{DryRun: true}
+	for _, deletion := range deletions {
+		output.Instances = append(output.Instances, PackagePruneOutputItem{deletion})
+	}
+	summary, err := output.IntoText()
+	if err != nil {
+		return err
+	}
+	return confirmDestruction(false, string(summary), "Continue?")
+}
+
+type PackagePruneOutput struct {
+	DryRun    bool                     `json:"dry_run"`
+	Instances []PackagePruneOutputItem `json:"instances"`
+}
+
+type PackagePruneOutputItem struct {
+	shop.InstanceDeletion
+}
+
+func (o PackagePruneOutput) IntoText() ([]byte, error) {
+	var b strings.Builder
+	for _, instance := range o.Instances {
+		fmt.Fprintf(&b, "%s@%s", instance.Package, instance.Id)
+		for _, ref := range instance.Refs {
+			fmt.Fprintf(&b, "\t%s", ref)
+		}
+		b.WriteString("\n")
+	}
+
+	verb := "Deleted"
+	if o.DryRun {
+		verb = "Would delete"
+	}
+	fmt.Fprintf(&b, "%s %d instances", verb, len(o.Instances))
+	return []byte(b.String()), nil
+}
+
+type PackageRetentionCommand struct {
+	*PackageCommand
+	RetentionFlags
+
+	Clear bool
+}
+
+func NewPackageRetentionCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageRetentionCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "retention [--keep n] [--keep-refs] [--older-than duration] [--clear] package_name",
+		Short: "Show or change the retention policy used by prune.",
+		Long: "Print the retention policy stored in the package manifest. Flags change it, --clear removes it,\n" +
+			"so prune works only with policies given on its command line.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), cmd, args[0])
+		},
+	}
+
+	c.RetentionFlags.Setup(cmd)
+	cmd.PersistentFlags().BoolVar(&c.Clear, "clear", false, "Remove the retention policy.")
+	cmd.MarkFlagsMutuallyExclusive("clear", "keep")
+	cmd.MarkFlagsMutuallyExclusive("clear", "keep-refs")
+	cmd.MarkFlagsMutuallyExclusive("clear", "older-than")
+
+	return cmd
+}
+
+func (c *PackageRetentionCommand) Run(ctx context.Context, cmd *cobra.Command, name string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	pkg, err := registryClient.GetPackage(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	var policy shop.RetentionPolicy
+	if pkg.Retention != nil {
+		policy = *pkg.Retention
+	}
+	if c.Clear || c.Changed(cmd) {
+		if c.Clear {
+			policy = shop.RetentionPolicy{}
+			pkg.Retention = nil
+		} else {
+			policy = c.Apply(cmd, policy)
+			pkg.Retention = &policy
+		}
+		if err = registryClient.PutPackage(ctx, *pkg); err != nil {
+			return err
+		}
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(PackageRetentionOutput{policy})
+}
+
+type PackageRetentionOutput struct {
+	shop.RetentionPolicy
+}
+
+func (o PackageRetentionOutput) IntoText() ([]byte, error) {
+	return []byte(fmt.Sprintf("keep\t%d\nkeep refs\t%t\nolder than\t%s", o.Keep, o.KeepRefs, o.OlderThan.Duration)), nil
+}
@@ -0,0 +1,242 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+func NewPackageRefCommand(parent *PackageCommand) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ref",
+		Short: "Manage package references.",
+	}
+
+	cmd.AddCommand(
+		NewPackageRefSetCommand(parent),
+		NewPackageRefListCommand(parent),
+		NewPackageRefRemoveCommand(parent),
+		NewPackageRefHistoryCommand(parent),
+	)
+
+	return cmd
+}
+
+type PackageRefSetCommand struct {
+	*PackageCommand
+}
+
+func NewPackageRefSetCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageRefSetCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "set package_name ref version",
+		Short: "Point reference to package instance.",
+		Long:  "Point reference to package instance.\n" + VersionHelp + ".",
+		Args:  cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1], args[2])
+		},
+	}
+
+	return cmd
+}
+
+func (c *PackageRefSetCommand) Run(ctx context.Context, name, refName, version string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	instance, err := registryClient.ResolveVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+
+	ref, err := shop.NewReference(name, refName, instance.Id)
+	if err != nil {
+		return err
+	}
+
+	if err = registryClient.PutPackageReference(ctx, ref); err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(PackageRefOutputItem{ref})
+}
+
+type PackageRefListCommand struct {
+	*PackageCommand
+}
+
+func NewPackageRefListCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageRefListCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "ls package_name",
+		Short: "List package references.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0])
+		},
+	}
+
+	return cmd
+}
+
+func (c *PackageRefListCommand) Run(ctx context.Context, name string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	if _, err = registryClient.GetPackage(ctx, name); err != nil {
+		return err
+	}
+
+	refs, err := shop.CollectCursor(ctx, registryClient.ListPackageReferences(ctx, name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	output := make([]PackageRefOutputItem, 0, len(refs))
+	for _, ref := range refs {
+		output = append(output, PackageRefOutputItem{ref})
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type PackageRefRemoveCommand struct {
+	*PackageCommand
+}
+
+func NewPackageRefRemoveCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageRefRemoveCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "rm package_name ref",
+		Short: "Delete package reference.",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	return cmd
+}
+
+func (c *PackageRefRemoveCommand) Run(ctx context.Context, name, refName string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	ref, err := registryClient.GetPackageReference(ctx, name, refName)
+	if err != nil {
+		return err
+	}
+
+	return registryClient.DeletePackageReference(ctx, *ref)
+}
+
+type PackageRefHistoryCommand struct {
+	*PackageCommand
+
+	At TimeFlag
+}
+
+func NewPackageRefHistoryCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageRefHistoryCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "history [--at time] package_name ref",
+		Short: "List instances the reference pointed to.",
+		Long: "List instances the reference pointed to, newest first, along with when it was set and moved away.\n" +
+			fmt.Sprintf("Only the last %d moves are kept. ", shop.ReferenceHistoryLimit) +
+			"With --at only the instance it pointed to at the time is printed.\n" +
+			"Times are dates, RFC 3339 times or durations before now (e.g. 7d or 12h).",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	cmd.PersistentFlags().Var(&c.At, "at", "Print the instance the reference pointed to at the time.")
+
+	return cmd
+}
+
+func (c *PackageRefHistoryCommand) Run(ctx context.Context, name, refName string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	if !c.At.IsZero() {
+		ref, err := shop.ReferenceAt(ctx, registryClient, name, refName, c.At.Time)
+		if err != nil {
+			return err
+		}
+		return encoder.Encode(PackageRefOutputItem{*ref})
+	}
+
+	entries, err := shop.ListReferenceHistory(ctx, registryClient, name, refName)
+	if err != nil {
+		return err
+	}
+
+	output := make([]PackageRefHistoryOutputItem, 0, len(entries))
+	for _, entry := range entries {
+		output = append(output, PackageRefHistoryOutputItem{entry})
+	}
+	return encoder.Encode(output)
+}
+
+type PackageRefHistoryOutputItem struct {
+	shop.ReferenceHistoryEntry
+}
+
+func (i PackageRefHistoryOutputItem) IntoText() ([]byte, error) {
+	replacedAt := "-"
+	if i.ReplacedAt != nil {
+		replacedAt = i.ReplacedAt.Format(time.RFC3339)
+	}
+	return []byte(fmt.Sprintf("%s\t%s\t%s", i.Id, i.UpdatedAt.Format(time.RFC3339), replacedAt)), nil
+}
+
+type PackageRefOutputItem struct {
+	shop.Reference
+}
+
+func (i PackageRefOutputItem) IntoText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%s\t%s\t%s", i.Name, i.Id, i.UpdatedAt.Format(time.RFC3339))), nil
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+type PackageSBOMCommand struct {
+	*PackageCommand
+
+	Out string
+}
+
+func NewPackageSBOMCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageSBOMCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "sbom [-O file] package_name version",
+		Short: "Print the SBOM attached to the instance.",
+		Long: "Print the SPDX or CycloneDX document attached to the instance on upload with --sbom, as it was uploaded.\n" +
+			"Fails if the instance has none.\n" + VersionHelp + ".",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.Out, "out", "O", "", "Write the SBOM into the file instead of stdout.")
+
+	return cmd
+}
+
+func (c *PackageSBOMCommand) Run(ctx context.Context, name, version string) error {
+	name, err := c.Arguments.ExpandPackageName(name)
+	if err != nil {
+		return err
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	instance, err := registryClient.ResolveVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+
+	if c.Out == "" {
+		return shop.GetSBOM(ctx, registryClient, *instance, os.Stdout)
+	}
+
+	// Written next to the destination and renamed once verified.
+	file, err := os.CreateTemp(filepath.Dir(c.Out), filepath.Base(c.Out)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err = shop.GetSBOM(ctx, registryClient, *instance, file); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), c.Out)
+}
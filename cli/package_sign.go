@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+type PackageSignCommand struct {
+	*PackageCommand
+
+	IDToken string
+	Key     string
+}
+
+func NewPackageSignCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageSignCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "sign [--identity-token token | --key file] package_name version",
+		Short: "Sign the instance archive with a sigstore certificate or a local key.",
+		Long: "Sign the instance archive with a short-lived certificate issued for the OIDC identity (e.g. the CI workflow)\n" +
+			"and store the sigstore bundle next to the archive. The token is read from " + shop.SigstoreIDTokenEnv + " or requested\n" +
+			"from the GitHub Actions runner by default.\n" +
+			"With --key the archive is signed with the minisign or GPG secret key instead, and the detached signature is stored\n" +
+			"with the instance. It's verified on download if the key is added to the registry with \"key add\".\n" +
+			"Passphrase of the key is read from " + shop.SigningKeyPassphraseEnv + " or asked on the terminal.\n" + VersionHelp + ".",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	cmd.PersistentFlags().StringVar(&c.IDToken, "identity-token", "", "OIDC identity token.")
+	cmd.PersistentFlags().StringVar(&c.Key, "key", "", "Minisign or GPG secret key file.")
+	cmd.MarkFlagsMutuallyExclusive("identity-token", "key")
+
+	return cmd
+}
+
+func (c *PackageSignCommand) Run(ctx context.Context, name, version string) error {
+	name, err := c.Arguments.ExpandPackageName(name)
+	if err != nil {
+		return err
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	instance, err := registryClient.ResolveVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+
+	if c.Key != "" {
+		return c.signDetached(ctx, registryClient, *instance)
+	}
+
+	token := c.IDToken
+	if token == "" {
+		if token, err = shop.SigstoreIDToken(ctx); err != nil {
+			return err
+		}
+	}
+
+	bundle, err := shop.SignPackageInstance(ctx, registryClient, *instance, nil, token)
+	if err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(newPackageSignOutput(*instance, *bundle))
+}
+
+func (c *PackageSignCommand) signDetached(ctx context.Context, registryClient shop.Registry, instance shop.Instance) error {
+	key, err := readSecretSigningKey(c.Key)
+	if err != nil {
+		return err
+	}
+
+	signature, err := shop.SignPackageInstanceDetached(ctx, registryClient, instance, nil, key)
+	if err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(PackageSignOutput{
+		Package: instance.Package,
+		Id:      instance.Id,
+		Key:     shop.SigningKey{Format: signature.Format, Id: signature.KeyId}.String(),
+	})
+}
+
+// Secret key from the file, decrypted with the passphrase from the
+// environment or the terminal.
+func readSecretSigningKey(path string) (shop.SecretSigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return shop.ReadSecretSigningKey(data, func() ([]byte, error) {
+		if passphrase, ok := os.LookupEnv(shop.SigningKeyPassphraseEnv); ok {
+			return []byte(passphrase), nil
+		}
+		passphrase, err := prompt("Passphrase for "+path+":", true)
+		return []byte(passphrase), err
+	})
+}
+
+type PackageSignOutput struct {
+	Package  string `json:"package"`
+	Id       string `json:"id"`
+	LogIndex string `json:"log_index,omitempty"`
+	Key      string `json:"key,omitempty"`
+}
+
+func newPackageSignOutput(instance shop.Instance, bundle shop.SigstoreBundle) PackageSignOutput {
+	output := PackageSignOutput{Package: instance.Package, Id: instance.Id}
+	if entries := bundle.VerificationMaterial.TlogEntries; len(entries) > 0 {
+		output.LogIndex = entries[0].LogIndex
+	}
+	return output
+}
+
+func (o PackageSignOutput) IntoText() ([]byte, error) {
+	if o.Key != "" {
+		return []byte(fmt.Sprintf("%s@%s: signed by %s", o.Package, o.Id, o.Key)), nil
+	}
+	if o.LogIndex == "" {
+		return []byte(fmt.Sprintf("%s@%s: signed", o.Package, o.Id)), nil
+	}
+	return []byte(fmt.Sprintf("%s@%s: signed, log index %s", o.Package, o.Id, o.LogIndex)), nil
+}
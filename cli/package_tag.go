@@ -0,0 +1,253 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+func NewPackageTagCommand(parent *PackageCommand) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tag",
+		Short: "Manage tags of package instances.",
+	}
+
+	cmd.AddCommand(
+		NewPackageTagAddCommand(parent),
+		NewPackageTagRemoveCommand(parent),
+	)
+
+	return cmd
+}
+
+type PackageTagAddCommand struct {
+	*PackageCommand
+}
+
+func NewPackageTagAddCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageTagAddCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "add package_name version tag:value...",
+		Short: "Attach tags to package instance.",
+		Long:  "Attach tags to package instance.\n" + VersionHelp + ".",
+		Args:  cobra.MinimumNArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tags, err := parseTags(args[2:])
+			if err != nil {
+				return err
+			}
+			return c.Run(cmd.Context(), args[0], args[1], tags)
+		},
+	}
+
+	return cmd
+}
+
+func (c *PackageTagAddCommand) Run(ctx context.Context, name, version string, tags TagsMap) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	instance, err := registryClient.ResolveVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+
+	var output []PackageTagOutputItem
+	for key, value := range tags {
+		tag, err := shop.NewTag(name, key, value, instance.Id)
+		if err != nil {
+			return err
+		}
+		if err = registryClient.PutPackageInstanceTag(ctx, tag); err != nil {
+			return err
+		}
+		output = append(output, PackageTagOutputItem{tag})
+	}
+
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].Key < output[j].Key
+	})
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type PackageTagRemoveCommand struct {
+	*PackageCommand
+}
+
+func NewPackageTagRemoveCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageTagRemoveCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "rm package_name version tag:value...",
+		Short: "Detach tags from package instance.",
+		Long:  "Detach tags from package instance.\n" + VersionHelp + ".",
+		Args:  cobra.MinimumNArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tags, err := parseTags(args[2:])
+			if err != nil {
+				return err
+			}
+			return c.Run(cmd.Context(), args[0], args[1], tags)
+		},
+	}
+
+	return cmd
+}
+
+func (c *PackageTagRemoveCommand) Run(ctx context.Context, name, version string, tags TagsMap) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	instance, err := registryClient.ResolveVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+
+	var output []PackageTagOutputItem
+	for key, value := range tags {
+		tag, err := shop.NewTag(name, key, value, instance.Id)
+		if err != nil {
+			return err
+		}
+		if err = registryClient.DeletePackageInstanceTag(ctx, tag); err != nil {
+			return err
+		}
+		output = append(output, PackageTagOutputItem{tag})
+	}
+
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].Key < output[j].Key
+	})
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type PackageTagsCommand struct {
+	*PackageCommand
+}
+
+func NewPackageTagsCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageTagsCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "tags package_name [tag]",
+		Short: "List tags of package with their values and instances.",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key := ""
+			if len(args) > 1 {
+				key = args[1]
+			}
+			return c.Run(cmd.Context(), args[0], key)
+		},
+	}
+
+	return cmd
+}
+
+func (c *PackageTagsCommand) Run(ctx context.Context, name, key string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	if _, err = registryClient.GetPackage(ctx, name); err != nil {
+		return err
+	}
+
+	keys := []shop.PackageTag{{Package: name, Key: key}}
+	if key == "" {
+		keys, err = shop.CollectCursor(ctx, registryClient.ListPackageTags(ctx, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	output := []PackageTagsOutputItem{}
+	for _, tag := range keys {
+		values, err := shop.CollectCursor(ctx, registryClient.ListPackageTagValues(ctx, tag))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		for _, value := range values {
+			tags, err := shop.CollectCursor(ctx, registryClient.ListPackageInstancesByTag(ctx, value))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			// Values stay behind when their last instance is detached.
+			if len(tags) == 0 {
+				continue
+			}
+
+			item := PackageTagsOutputItem{
+				Key:   value.Key,
+				Value: value.Value,
+			}
+			for _, tag := range tags {
+				item.Instances = append(item.Instances, tag.Id)
+			}
+			output = append(output, item)
+		}
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type PackageTagsOutputItem struct {
+	Key       string   `json:"key"`
+	Value     string   `json:"value"`
+	Instances []string `json:"instances"`
+}
+
+func (i PackageTagsOutputItem) IntoText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%s:%s\t%s", i.Key, i.Value, strings.Join(i.Instances, " "))), nil
+}
+
+func parseTags(args []string) (TagsMap, error) {
+	tags := TagsMap{}
+	for _, arg := range args {
+		if err := tags.Set(arg); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+type PackageTagOutputItem struct {
+	shop.Tag
+}
+
+func (i PackageTagOutputItem) IntoText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%s@%s\t%s:%s", i.Package, i.Id, i.Key, i.Value)), nil
+}
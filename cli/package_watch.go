@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+const (
+	DefaultWatchInterval = 30 * time.Second
+)
+
+var (
+	ErrInvalidWatchInterval = errors.New("Watch interval must be positive")
+)
+
+type PackageWatchCommand struct {
+	*PackageCommand
+
+	Ref      string
+	Interval shop.Duration
+	Exec     string
+}
+
+func NewPackageWatchCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageWatchCommand{
+		PackageCommand: parent,
+		Interval:       shop.Duration{Duration: DefaultWatchInterval},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "watch [-r registry] --ref name [--interval duration] [--exec command] package_name",
+		Short: "Report when the package ref moves to another instance.",
+		Long: "Poll the ref and print the instance it points to, once on start and then every time it moves.\n" +
+			"With --exec the command is run by sh on every move, with SHOP_REGISTRY, SHOP_PACKAGE, SHOP_REF,\n" +
+			"SHOP_INSTANCE and SHOP_PREVIOUS_INSTANCE in its environment. Failed commands are retried on the next\n" +
+			"poll. Runs until interrupted.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0])
+		},
+	}
+
+	cmd.PersistentFlags().StringVar(&c.Ref, "ref", "", "Ref to watch.")
+	cmd.PersistentFlags().Var(TextVar{&c.Interval}, "interval", "Time between polls (e.g. 30s or 5m).")
+	cmd.PersistentFlags().StringVar(&c.Exec, "exec", "", "Command to run when the ref moves.")
+	_ = cmd.MarkPersistentFlagRequired("ref")
+
+	return cmd
+}
+
+func (c *PackageWatchCommand) Run(ctx context.Context, name string) error {
+	if c.Interval.Duration <= 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidWatchInterval, c.Interval.Duration)
+	}
+
+	// Cached refs would hide moves for the cache TTL.
+	cfg := c.Cfg
+	cfg.Cache = ""
+
+	registryConfig := cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return shop.WatchReference(ctx, registryClient, name, c.Ref, c.Interval.Duration, func(ctx context.Context, change shop.ReferenceChange) error {
+		if err := encoder.Encode(PackageWatchOutputItem{change}); err != nil {
+			return err
+		}
+		// Text encoder doesn't end single items with a newline, json does.
+		if c.Arguments.OutputFormat == TextOutputFormat {
+			fmt.Println()
+		}
+		if c.Exec == "" {
+			return nil
+		}
+		return c.runHook(ctx, change)
+	})
+}
+
+func (c *PackageWatchCommand) runHook(ctx context.Context, change shop.ReferenceChange) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Exec)
+	cmd.Env = append(os.Environ(),
+		"SHOP_REGISTRY="+c.RegistryName,
+		"SHOP_PACKAGE="+change.Package,
+		"SHOP_REF="+change.Ref,
+		"SHOP_INSTANCE="+change.Id,
+		"SHOP_PREVIOUS_INSTANCE="+change.Previous,
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+type PackageWatchOutputItem struct {
+	shop.ReferenceChange
+}
+
+func (i PackageWatchOutputItem) IntoText() ([]byte, error) {
+	text := fmt.Sprintf("%s@%s\t%s", i.Package, i.Ref, i.Id)
+	if i.Previous != "" {
+		text += "\t" + i.Previous
+	}
+	return []byte(text), nil
+}
@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+type PackageYankCommand struct {
+	*PackageCommand
+
+	Reason string
+	Undo   bool
+}
+
+func NewPackageYankCommand(parent *PackageCommand) *cobra.Command {
+	c := &PackageYankCommand{
+		PackageCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "yank [--reason text] [--undo] package_name [version]",
+		Short: "Yank package instance or deprecate the whole package.",
+		Long: "Mark the instance as yanked, or the package as deprecated when version is omitted. Versions are not\n" +
+			"resolved to yanked instances and instances of deprecated packages, unless --allow-yanked is given,\n" +
+			"but they could still be installed and downloaded by exact id. --undo removes the mark.\n" +
+			VersionHelp + ".",
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				return c.RunPackage(cmd.Context(), args[0])
+			}
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	cmd.PersistentFlags().StringVar(&c.Reason, "reason", "", "Why the instance is yanked, shown by failed resolutions.")
+	cmd.PersistentFlags().BoolVar(&c.Undo, "undo", false, "Remove the yanked or deprecated mark.")
+	cmd.MarkFlagsMutuallyExclusive("reason", "undo")
+
+	return cmd
+}
+
+func (c *PackageYankCommand) deprecation() *shop.Deprecation {
+	if c.Undo {
+		return nil
+	}
+	return shop.NewDeprecation(c.Reason)
+}
+
+func (c *PackageYankCommand) Run(ctx context.Context, name, version string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+	// Yanked instances have to be resolved to be unyanked.
+	registryConfig.AllowYanked = true
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	instance, err := registryClient.ResolveVersion(ctx, name, version)
+	if err != nil {
+		return err
+	}
+	instance.Yanked = c.deprecation()
+	if err = registryClient.PutPackageInstanceInfo(ctx, *instance); err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(PackageYankOutput{
+		Package: instance.Package,
+		Id:      instance.Id,
+		Yanked:  instance.Yanked,
+	})
+}
+
+func (c *PackageYankCommand) RunPackage(ctx context.Context, name string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	pkg, err := registryClient.GetPackage(ctx, name)
+	if err != nil {
+		return err
+	}
+	pkg.Deprecated = c.deprecation()
+	if err = registryClient.PutPackage(ctx, *pkg); err != nil {
+		return err
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(PackageYankOutput{
+		Package: pkg.Name,
+		Yanked:  pkg.Deprecated,
+	})
+}
+
+type PackageYankOutput struct {
+	Package string            `json:"package"`
+	Id      string            `json:"id,omitempty"`
+	Yanked  *shop.Deprecation `json:"yanked"`
+}
+
+func (o PackageYankOutput) IntoText() ([]byte, error) {
+	name, state := o.Package, "deprecated"
+	if o.Id != "" {
+		name, state = o.Package+"@"+o.Id, "yanked"
+	}
+	if o.Yanked == nil {
+		return []byte(fmt.Sprintf("%s is not %s", name, state)), nil
+	}
+	return []byte(fmt.Sprintf("%s is %s: %s", name, state, o.Yanked)), nil
+}
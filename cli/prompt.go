@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// Shared, so answers piped into stdin are not lost in buffers between
+// prompts.
+var stdin = bufio.NewReader(os.Stdin)
+
+// Ask a yes/no question on the terminal.
+func confirm(question string) (bool, error) {
+	answer, err := prompt(question+" [y/N]", false)
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// Flags of destructive commands, which skip confirmation in scripts.
+func addYesFlags(cmd *cobra.Command, yes *bool) {
+	cmd.PersistentFlags().BoolVarP(yes, "yes", "y", false, "Don't ask for confirmation.")
+	cmd.PersistentFlags().BoolVar(yes, "force", false, "Same as --yes.")
+}
+
+// Show what is about to be destroyed on stderr and ask whether to go on,
+// unless yes is set. Refusal, including closed stdin, is ErrNotConfirmed.
+func confirmDestruction(yes bool, summary, question string) error {
+	if yes {
+		return nil
+	}
+	if summary != "" {
+		fmt.Fprintln(os.Stderr, strings.TrimRight(summary, "\n"))
+	}
+
+	ok, err := confirm(question)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: use --yes to skip confirmation", ErrNotConfirmed)
+	}
+	return nil
+}
+
+// Read a line from stdin after the question on stderr. Secrets are not
+// echoed when stdin is a terminal.
+func prompt(question string, secret bool) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s ", question)
+
+	if fd := int(os.Stdin.Fd()); secret && term.IsTerminal(fd) {
+		answer, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return strings.TrimSpace(string(answer)), err
+	}
+
+	answer, err := stdin.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimSpace(answer), nil
+}
@@ -3,7 +3,11 @@ package cli
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/alex-ac/shop"
 	"github.com/spf13/cobra"
@@ -20,6 +24,12 @@ func NewRegistryCommand(args *GlobalArguments) *cobra.Command {
 		NewRegistryAddCommand(args),
 		NewRegistryListCommand(args),
 		NewRegistryDeleteCommand(args),
+		NewRegistrySetDefaultCommand(args),
+		NewRegistryShowCommand(args),
+		NewRegistryGCCommand(args),
+		NewRegistryFsckCommand(args),
+		NewRegistrySyncCommand(args),
+		NewRegistryStatsCommand(args),
 	)
 
 	return cmd
@@ -72,6 +82,7 @@ func (c *RegistryAddCommand) Run(ctx context.Context, url string) error {
 	if err != nil {
 		return err
 	}
+	defer registryClient.Close()
 
 	registryManifest, err := registryClient.GetManifest(ctx)
 	if err != nil {
@@ -173,6 +184,9 @@ func (c *RegistryListCommand) Run(ctx context.Context) error {
 	for name, registry := range cfg.Registries {
 		output = append(output, RegistryListOutputItemFromRegistry(registry, name, name == cfg.DefaultRegistry))
 	}
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].Name < output[j].Name
+	})
 
 	return encoder.Encode(output)
 }
@@ -213,6 +227,464 @@ func (c *RegistryDeleteCommand) Run(ctx context.Context, name string) error {
 	return c.Arguments.SaveConfig(cfg)
 }
 
+type RegistrySetDefaultCommand struct {
+	Arguments *GlobalArguments
+}
+
+func NewRegistrySetDefaultCommand(args *GlobalArguments) *cobra.Command {
+	c := &RegistrySetDefaultCommand{
+		Arguments: args,
+	}
+
+	cmd := &cobra.Command{
+		Use:               "set-default name",
+		Short:             "Use registry by default.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: CompleteRegistryFlag,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0])
+		},
+	}
+
+	return cmd
+}
+
+func (c *RegistrySetDefaultCommand) Run(ctx context.Context, name string) error {
+	cfg, err := c.Arguments.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	if err = cfg.SetDefaultRegistry(name); err != nil {
+		return err
+	}
+
+	return c.Arguments.SaveConfig(cfg)
+}
+
+type RegistryShowCommand struct {
+	Arguments *GlobalArguments
+}
+
+func NewRegistryShowCommand(args *GlobalArguments) *cobra.Command {
+	c := &RegistryShowCommand{
+		Arguments: args,
+	}
+
+	cmd := &cobra.Command{
+		Use:               "show [name]",
+		Short:             "Show registry manifest along with local configuration.",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: CompleteRegistryFlag,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := ""
+			if len(args) > 0 {
+				name = args[0]
+			}
+			return c.Run(cmd.Context(), name)
+		},
+	}
+
+	return cmd
+}
+
+func (c *RegistryShowCommand) Run(ctx context.Context, name string) error {
+	cfg, err := c.Arguments.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	if cfg.DefaultRegistry == "" {
+		cfg.DefaultRegistry = shop.DefaultRegistryName
+	}
+	if name == "" {
+		name = cfg.DefaultRegistry
+	}
+
+	registryConfig, ok := cfg.Registries[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", shop.ErrRegistryConfigNotExists, name)
+	}
+
+	registryClient, err := shop.NewRegistry(ctx, cfg.Registry(name))
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	manifest, err := registryClient.GetManifest(ctx)
+	if err != nil {
+		return err
+	}
+
+	output := RegistryShowOutput{
+		RegistryListOutputItem: RegistryListOutputItemFromRegistry(registryConfig, name, name == cfg.DefaultRegistry),
+		Manifest:               *manifest,
+		Repos:                  []RegistryShowRepoOutput{},
+	}
+	for repoName, repoManifest := range manifest.Repos {
+		output.Repos = append(output.Repos, RegistryShowRepoOutput{
+			Name:       repoName,
+			URL:        repoManifest.URL,
+			ConfigURL:  registryConfig.Repos[repoName].URL,
+			Configured: registryConfig.Repos[repoName].URL != "",
+		})
+	}
+	sort.Slice(output.Repos, func(i, j int) bool {
+		return output.Repos[i].Name < output.Repos[j].Name
+	})
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type RegistryShowOutput struct {
+	RegistryListOutputItem
+	Manifest shop.RegistryManifest    `json:"manifest"`
+	Repos    []RegistryShowRepoOutput `json:"repos"`
+}
+
+type RegistryShowRepoOutput struct {
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	ConfigURL  string `json:"config_url,omitempty"`
+	Configured bool   `json:"configured"`
+}
+
+func (o RegistryShowOutput) IntoText() ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "name: %s\n", o.Name)
+	fmt.Fprintf(&b, "default: %t\n", o.IsDefault)
+	fmt.Fprintf(&b, "admin: %t\n", o.Admin)
+	fmt.Fprintf(&b, "write: %t\n", o.Write)
+	fmt.Fprintf(&b, "url: %s\n", o.URL)
+	fmt.Fprintf(&b, "manifest:\n")
+	fmt.Fprintf(&b, "  name: %s\n", o.Manifest.Name)
+	fmt.Fprintf(&b, "  api_version: %s\n", o.Manifest.ApiVersion)
+	fmt.Fprintf(&b, "  updated_at: %s\n", o.Manifest.UpdatedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "  root_repo: %s %s", o.Manifest.RootRepo.Name, o.Manifest.RootRepo.URL)
+	if o.Manifest.RootRepo.URL != o.URL {
+		fmt.Fprintf(&b, " (config: %s)", o.URL)
+	}
+	fmt.Fprintf(&b, "\n  repos:")
+	for _, repo := range o.Repos {
+		fmt.Fprintf(&b, "\n    %s %s", repo.Name, repo.URL)
+		if repo.Configured && repo.ConfigURL != repo.URL {
+			fmt.Fprintf(&b, " (config: %s)", repo.ConfigURL)
+		}
+	}
+	return []byte(b.String()), nil
+}
+
+type RegistryGCCommand struct {
+	*PackageCommand
+
+	MinAge time.Duration
+	Yes    bool
+}
+
+func NewRegistryGCCommand(args *GlobalArguments) *cobra.Command {
+	c := &RegistryGCCommand{
+		PackageCommand: &PackageCommand{
+			Arguments: args,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "gc [-r registry] [--dry-run] [-y|--force] [--min-age duration]",
+		Short: "Delete CAS archives which don't belong to any package instance.",
+		Long: "Delete CAS archives which don't belong to any package instance. Archives to delete are listed\n" +
+			"for confirmation, use --dry-run to only list them.",
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return c.LoadConfig()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context())
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.RegistryName, "registry", "r", "", "Registry name.")
+	cmd.PersistentFlags().DurationVar(&c.MinAge, "min-age", shop.DefaultGCMinAge, "Keep archives younger than this, they could belong to uploads in progress.")
+	addYesFlags(cmd, &c.Yes)
+
+	return cmd
+}
+
+func (c *RegistryGCCommand) Run(ctx context.Context) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	if !c.Arguments.DryRun && !c.Yes {
+		garbage, err := registryClient.CollectGarbage(ctx, c.MinAge, true)
+		if err != nil {
+			return err
+		}
+		if len(garbage) > 0 {
+			summary, err := gcOutput(garbage, true).IntoText()
+			if err != nil {
+				return err
+			}
+			if err = confirmDestruction(false, string(summary), "Continue?"); err != nil {
+				return err
+			}
+		}
+	}
+
+	garbage, err := registryClient.CollectGarbage(ctx, c.MinAge, c.Arguments.DryRun)
+	if err != nil {
+		return err
+	}
+
+	output := gcOutput(garbage, c.Arguments.DryRun)
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+func gcOutput(garbage []shop.ArchiveInfo, dryRun bool) RegistryGCOutput {
+	output := RegistryGCOutput{
+		DryRun:   dryRun,
+		Archives: make([]RegistryGCOutputItem, 0, len(garbage)),
+	}
+	for _, archive := range garbage {
+		output.Archives = append(output.Archives, RegistryGCOutputItem{archive})
+		output.Size += archive.Size
+	}
+	return output
+}
+
+type RegistryGCOutput struct {
+	DryRun   bool                   `json:"dry_run"`
+	Archives []RegistryGCOutputItem `json:"archives"`
+	Size     int64                  `json:"size"`
+}
+
+type RegistryGCOutputItem struct {
+	shop.ArchiveInfo
+}
+
+func (o RegistryGCOutput) IntoText() ([]byte, error) {
+	var b strings.Builder
+	for _, archive := range o.Archives {
+		repo := archive.Repo
+		if repo == "" {
+			repo = "root"
+		}
+		id := archive.Id
+		if archive.File {
+			id = "file:" + id
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%d\n", repo, id, archive.Size)
+	}
+
+	verb := "Deleted"
+	if o.DryRun {
+		verb = "Would delete"
+	}
+	fmt.Fprintf(&b, "%s %d archives, %d bytes", verb, len(o.Archives), o.Size)
+	return []byte(b.String()), nil
+}
+
+type RegistryFsckCommand struct {
+	*PackageCommand
+
+	Repair bool
+}
+
+func NewRegistryFsckCommand(args *GlobalArguments) *cobra.Command {
+	c := &RegistryFsckCommand{
+		PackageCommand: &PackageCommand{
+			Arguments: args,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "fsck [-r registry] [--repair]",
+		Short: "Check consistency of registry metadata.",
+		Long: "Check consistency of registry metadata: manifests parse, instances have archives in CAS,\n" +
+			"tags are in both indexes and refs point to existing instances. Fails if there are problems left.",
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return c.LoadConfig()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context())
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.RegistryName, "registry", "r", "", "Registry name.")
+	cmd.PersistentFlags().BoolVar(&c.Repair, "repair", false, "Restore missing tag index entries, delete tags and refs of missing instances.")
+
+	return cmd
+}
+
+func (c *RegistryFsckCommand) Run(ctx context.Context) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	problems, err := registryClient.CheckIntegrity(ctx, c.Repair)
+	if err != nil {
+		return err
+	}
+
+	output := make([]RegistryFsckOutputItem, 0, len(problems))
+	left := 0
+	for _, problem := range problems {
+		output = append(output, RegistryFsckOutputItem{problem})
+		if !problem.Fixed {
+			left++
+		}
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	if err = encoder.Encode(output); err != nil {
+		return err
+	}
+	if left > 0 {
+		return fmt.Errorf("%w: %d", ErrRegistryProblems, left)
+	}
+	return nil
+}
+
+type RegistryFsckOutputItem struct {
+	shop.Problem
+}
+
+func (i RegistryFsckOutputItem) IntoText() ([]byte, error) {
+	text := i.Kind
+	if i.Package != "" {
+		text += " " + i.Package
+	}
+	if i.Object != "" {
+		text += " " + i.Object
+	}
+	text += ": " + i.Message
+	switch {
+	case i.Fixed:
+		text += " [fixed]"
+	case i.Fixable:
+		text += " [fixable]"
+	}
+	return []byte(text), nil
+}
+
+type RegistrySyncCommand struct {
+	Arguments *GlobalArguments
+	Cfg       shop.Config
+
+	Prefix string
+}
+
+func NewRegistrySyncCommand(args *GlobalArguments) *cobra.Command {
+	c := &RegistrySyncCommand{
+		Arguments: args,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "sync [--prefix prefix] [--dry-run] src dst",
+		Short: "Copy packages, instances, refs and tags from one registry to another.",
+		Long: "Copy packages, instances, refs and tags from one registry to another. Registries are names\n" +
+			"from config or repository urls. Only missing or updated objects are copied, nothing is deleted.",
+		Args: cobra.ExactArgs(2),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			c.Cfg, err = c.Arguments.LoadConfig()
+			return
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+		ValidArgsFunction: CompleteRegistryFlag,
+	}
+
+	cmd.PersistentFlags().StringVar(&c.Prefix, "prefix", "", "Only copy packages under the prefix.")
+
+	return cmd
+}
+
+// Registry from config by name, or registry in the repository by url.
+func (c *RegistrySyncCommand) registryConfig(name string, write bool) (shop.RegistryConfig, error) {
+	if !strings.Contains(name, "://") {
+		if _, ok := c.Cfg.Registries[name]; !ok {
+			return shop.RegistryConfig{}, fmt.Errorf("%w: %s", ErrRegistryDoesNotExist, name)
+		}
+		return c.Cfg.Registry(name), nil
+	}
+
+	registryConfig := c.Cfg.Registry("")
+	registryConfig.URL = name
+	registryConfig.RootRepo = shop.RepositoryConfig{
+		URL:   name,
+		Admin: write,
+		Write: write,
+	}
+	registryConfig.Admin = write
+	registryConfig.Write = write
+	return registryConfig, nil
+}
+
+func (c *RegistrySyncCommand) Run(ctx context.Context, src, dst string) error {
+	srcConfig, err := c.registryConfig(src, false)
+	if err != nil {
+		return err
+	}
+	dstConfig, err := c.registryConfig(dst, true)
+	if err != nil {
+		return err
+	}
+
+	srcRegistry, err := shop.NewRegistry(ctx, srcConfig)
+	if err != nil {
+		return err
+	}
+	defer srcRegistry.Close()
+	dstRegistry, err := shop.NewRegistry(ctx, dstConfig)
+	if err != nil {
+		return err
+	}
+	defer dstRegistry.Close()
+
+	changes, err := shop.SyncRegistries(ctx, srcRegistry, dstRegistry, shop.SyncOptions{
+		Prefix: c.Prefix,
+		DryRun: c.Arguments.DryRun,
+	})
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	if encodeErr := encoder.Encode(syncOutput(changes)); err == nil {
+		err = encodeErr
+	}
+	return err
+}
+
+type RegistrySyncOutputItem struct {
+	shop.SyncChange
+}
+
+func syncOutput(changes []shop.SyncChange) []RegistrySyncOutputItem {
+	output := make([]RegistrySyncOutputItem, 0, len(changes))
+	for _, change := range changes {
+		output = append(output, RegistrySyncOutputItem{change})
+	}
+	return output
+}
+
+func (i RegistrySyncOutputItem) IntoText() ([]byte, error) {
+	text := i.Kind + " " + i.Package
+	if i.Object != "" {
+		text += " " + i.Object
+	}
+	return []byte(text), nil
+}
+
 func CompleteRegistryFlag(cmd *cobra.Command, argv []string, toComplete string) (variants []string, directive cobra.ShellCompDirective) {
 	_ = cmd.ParseFlags(argv)
 	args := DefaultGlobalArguments
@@ -278,6 +750,7 @@ func (c *RegistryInitCommand) Run(ctx context.Context, url string) error {
 	if err != nil {
 		return err
 	}
+	defer repo.Close()
 
 	repoManifest, err := repo.GetManifest(ctx)
 	if err != nil {
@@ -300,6 +773,7 @@ func (c *RegistryInitCommand) Run(ctx context.Context, url string) error {
 	if err != nil {
 		return err
 	}
+	defer registry.Close()
 
 	err = registry.Initialize(ctx, c.ManifestName)
 	if err != nil {
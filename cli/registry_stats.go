@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+const (
+	DefaultStatsTop = 10
+)
+
+type RegistryStatsCommand struct {
+	*PackageCommand
+
+	Top   int
+	Since string
+	Save  string
+}
+
+func NewRegistryStatsCommand(args *GlobalArguments) *cobra.Command {
+	c := &RegistryStatsCommand{
+		PackageCommand: &PackageCommand{
+			Arguments: args,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "stats [-r registry] [--top n] [--since snapshot] [--save snapshot]",
+		Short: "Summarize packages, instances and storage used by the registry.",
+		Long: "Count packages and instances, sizes of CAS archives in every repo and the largest packages.\n" +
+			"Stats could be saved into a snapshot file with --save, and growth since a snapshot is shown with --since.",
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return c.LoadConfig()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context())
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.RegistryName, "registry", "r", "", "Registry name.")
+	cmd.PersistentFlags().IntVar(&c.Top, "top", DefaultStatsTop, "Number of largest packages to show.")
+	cmd.PersistentFlags().StringVar(&c.Since, "since", "", "Show growth since the snapshot file.")
+	cmd.PersistentFlags().StringVar(&c.Save, "save", "", "Save stats into the snapshot file.")
+	cmd.MarkPersistentFlagFilename("since", "json")
+	cmd.MarkPersistentFlagFilename("save", "json")
+
+	return cmd
+}
+
+func (c *RegistryStatsCommand) Run(ctx context.Context) error {
+	var snapshot *shop.RegistryStats
+	if c.Since != "" {
+		var err error
+		if snapshot, err = shop.LoadRegistryStats(c.Since); err != nil {
+			return err
+		}
+	}
+
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	stats, err := registryClient.CollectStats(ctx)
+	if err != nil {
+		return err
+	}
+	if c.Save != "" {
+		if err = shop.SaveRegistryStats(*stats, c.Save); err != nil {
+			return err
+		}
+	}
+
+	output := RegistryStatsOutput{
+		Packages:  stats.Packages,
+		Instances: stats.Instances,
+		Size:      stats.Size,
+		Repos:     stats.Repos,
+		Largest:   stats.Largest(c.Top),
+	}
+	if snapshot != nil {
+		growth := stats.GrowthSince(*snapshot)
+		output.Growth = &growth
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type RegistryStatsOutput struct {
+	Packages  int                 `json:"packages"`
+	Instances int                 `json:"instances"`
+	Size      int64               `json:"size"`
+	Repos     []shop.RepoStats    `json:"repos"`
+	Largest   []shop.PackageStats `json:"largest"`
+	Growth    *shop.StatsGrowth   `json:"growth,omitempty"`
+}
+
+func (o RegistryStatsOutput) IntoText() ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "packages\t%d\ninstances\t%d\nsize\t%d\n", o.Packages, o.Instances, o.Size)
+	for _, repo := range o.Repos {
+		fmt.Fprintf(&b, "repo %s\t%d archives\t%d bytes\n", repoText(repo.Repo), repo.Archives, repo.Size)
+	}
+	for _, pkg := range o.Largest {
+		fmt.Fprintf(&b, "package %s\t%d instances\t%d bytes\n", pkg.Name, pkg.Instances, pkg.Size)
+	}
+	if o.Growth != nil {
+		fmt.Fprintf(&b, "growth since %s\t%+d packages\t%+d instances\t%+d bytes\n",
+			o.Growth.Since.Format(time.RFC3339), o.Growth.Packages, o.Growth.Instances, o.Growth.Size)
+		for _, repo := range o.Growth.Repos {
+			fmt.Fprintf(&b, "growth repo %s\t%+d archives\t%+d bytes\n", repoText(repo.Repo), repo.Archives, repo.Size)
+		}
+	}
+	return []byte(strings.TrimSuffix(b.String(), "\n")), nil
+}
+
+func repoText(repo string) string {
+	if repo == "" {
+		return "root"
+	}
+	return repo
+}
@@ -3,6 +3,8 @@ package cli
 import (
 	"context"
 	"net/url"
+	"os"
+	"strings"
 
 	"github.com/alex-ac/shop"
 	"github.com/spf13/cobra"
@@ -25,6 +27,8 @@ func NewRepoCommand(args *GlobalArguments) *cobra.Command {
 	cmd.AddCommand(
 		NewRepoAddCommand(c),
 		NewRepoInitCommand(c),
+		NewRepoIndexCommand(c),
+		NewRepoRemoveCommand(c),
 	)
 
 	return cmd
@@ -64,6 +68,78 @@ func NewRepoAddCommand(parent *RepoCommand) *cobra.Command {
 	return cmd
 }
 
+type RepoRemoveCommand struct {
+	*PackageCommand
+
+	Force bool
+}
+
+func NewRepoRemoveCommand(parent *RepoCommand) *cobra.Command {
+	c := &RepoRemoveCommand{
+		PackageCommand: &PackageCommand{
+			Arguments: parent.Arguments,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:     "rm [-r registry] [--force] name",
+		Aliases: []string{"remove"},
+		Short:   "Remove repository from the registry.",
+		Long: "Remove repository from the registry manifest. Fails if packages store archives in it,\n" +
+			"unless --force is set: then archives are copied into the root repository and packages are moved there.",
+		Args: cobra.ExactArgs(1),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return c.LoadConfig()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0])
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.RegistryName, "registry", "r", "", "Registry name.")
+	cmd.PersistentFlags().BoolVar(&c.Force, "force", false, "Move packages using the repository to the root repository.")
+
+	return cmd
+}
+
+func (c *RepoRemoveCommand) Run(ctx context.Context, name string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	moved, err := registryClient.RemoveRepository(ctx, name, c.Force)
+	if err != nil {
+		return err
+	}
+
+	output := RepoRemoveOutput{
+		Repo:  name,
+		Moved: moved,
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type RepoRemoveOutput struct {
+	Repo string `json:"repo"`
+	// Packages moved to the root repository.
+	Moved []string `json:"moved"`
+}
+
+func (o RepoRemoveOutput) IntoText() ([]byte, error) {
+	var b strings.Builder
+	for _, pkg := range o.Moved {
+		b.WriteString(pkg + " moved to root repository\n")
+	}
+	b.WriteString("Removed " + o.Repo)
+	return []byte(b.String()), nil
+}
+
 type RepoInitCommand struct {
 	*RepoCommand
 	Name        string
@@ -121,6 +197,50 @@ func (c *RepoInitCommand) Run(ctx context.Context, u string) error {
 	if err != nil {
 		return err
 	}
+	defer repo.Close()
 
 	return repo.PutManifest(ctx, manifest)
 }
+
+type RepoIndexCommand struct {
+	*RepoCommand
+	Prefix string
+}
+
+func NewRepoIndexCommand(parent *RepoCommand) *cobra.Command {
+	c := &RepoIndexCommand{
+		RepoCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "index [-p prefix] url",
+		Short: "Generate index.json listings for serving repository over plain http.",
+		Long: "Generate index.json listings for serving repository over plain http, in the prefix and all prefixes under it.\n" +
+			"Listings are snapshots: objects written into the repository afterwards are not listed over http until\n" +
+			"the index is generated again, so run it after uploads, e.g. as the last step of publishing. shop doctor\n" +
+			"shows when the listings of http repositories were generated.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0])
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.Prefix, "prefix", "p", "/", "Prefix to generate listings for.")
+
+	return cmd
+}
+
+func (c *RepoIndexCommand) Run(ctx context.Context, u string) error {
+	config := shop.RepositoryConfig{
+		URL:   u,
+		Write: true,
+	}
+
+	repo, err := shop.NewRepository(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	return shop.GenerateRepositoryIndex(ctx, repo, c.Prefix)
+}
@@ -10,6 +10,9 @@ import (
 	"reflect"
 	"strings"
 	"syscall"
+
+	"github.com/alex-ac/shop"
+	"github.com/hashicorp/go-multierror"
 )
 
 func CliContext() (context.Context, func()) {
@@ -62,10 +65,32 @@ func Run(ctx context.Context, args []string, extras ...any) error {
 		NewRegistryCommand(&arguments),
 		NewPackageCommand(&arguments),
 		NewRepoCommand(&arguments),
+		NewInstallCommand(&arguments),
+		NewSearchCommand(&arguments),
+		NewEnsureCommand(&arguments),
+		NewSiteCommand(&arguments),
+		NewAuthCommand(&arguments),
+		NewConfigCommand(&arguments),
+		NewVersionCommand(&arguments),
+		NewDoctorCommand(&arguments),
+		NewExportCommand(&arguments),
+		NewImportCommand(&arguments),
+		NewBundleCommand(&arguments),
+		NewACLCommand(&arguments),
+		NewKeyCommand(&arguments),
+		NewCacheCommand(&arguments),
+		NewServeCommand(&arguments),
+		NewCompletionCommand(&arguments),
+		NewDocsCommand(&arguments),
 	)
 
 	rootCmd.SetArgs(args[1:])
-	return rootCmd.ExecuteContext(ctx)
+	err := rootCmd.ExecuteContext(ctx)
+	if err != nil {
+		arguments.PrintError(rootCmd.ErrOrStderr(), err)
+	}
+	shop.FlushCacheStats()
+	return multierror.Append(err, arguments.WritePlan(), arguments.WriteMetrics(), arguments.CloseLog()).ErrorOrNil()
 }
 
 func ErrorToExitCode(err error) int {
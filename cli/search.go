@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"context"
+	"os"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+type SearchCommand struct {
+	*PackageCommand
+}
+
+func NewSearchCommand(args *GlobalArguments) *cobra.Command {
+	c := &SearchCommand{
+		PackageCommand: &PackageCommand{
+			Arguments: args,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "search [-r registry] query",
+		Short: "Search packages by name and description.",
+		Long: "Search packages by name and description. Query with *, ? or [...] is a glob matched against\n" +
+			"the whole package name (e.g. compilers/clang*), or its last component if there are no slashes (e.g. clang*).\n" +
+			"Otherwise it's a case-insensitive substring of the name or the description.",
+		Args: cobra.ExactArgs(1),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return c.LoadConfig()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0])
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.RegistryName, "registry", "r", "", "Registry name.")
+
+	return cmd
+}
+
+func (c *SearchCommand) Run(ctx context.Context, query string) error {
+	registryConfig := c.Cfg.Registry(c.RegistryName)
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	packages, err := shop.SearchPackages(ctx, registryClient, query)
+	if err != nil {
+		return err
+	}
+
+	output := make([]PackageListOutputItem, 0, len(packages))
+	for i := range packages {
+		output = append(output, PackageListOutputItem{&shop.PackageOrPrefix{Package: &packages[i]}})
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+const (
+	DefaultServeListen = ":8080"
+	// Time given to requests in progress on shutdown.
+	ServeShutdownTimeout = 10 * time.Second
+)
+
+type ServeCommand struct {
+	*PackageCommand
+
+	Listen   string
+	Proxy    bool
+	CacheDir string
+}
+
+func NewServeCommand(args *GlobalArguments) *cobra.Command {
+	c := &ServeCommand{
+		PackageCommand: &PackageCommand{
+			Arguments: args,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "serve [-r registry] [--listen addr] [--proxy [--cache-dir dir]]",
+		Short: "Serve the registry read-only over plain http.",
+		Long: "Serve the registry read-only over plain http, so it could be added with an http:// url by machines\n" +
+			"without access to its storage. With --proxy objects and metadata are cached in the local cache dir,\n" +
+			"so every archive is fetched from the storage once.",
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return c.LoadConfig()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context())
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&c.RegistryName, "registry", "r", "", "Registry name.")
+	cmd.PersistentFlags().StringVar(&c.Listen, "listen", DefaultServeListen, "Address to listen on.")
+	cmd.PersistentFlags().BoolVar(&c.Proxy, "proxy", false, "Cache served objects locally.")
+	cmd.PersistentFlags().StringVar(&c.CacheDir, "cache-dir", "", "Cache dir used with --proxy (default: cache dir from the config).")
+	cmd.MarkPersistentFlagDirname("cache-dir")
+
+	return cmd
+}
+
+func (c *ServeCommand) Run(ctx context.Context) error {
+	cfg := c.Cfg
+	if c.Proxy {
+		if c.CacheDir != "" {
+			cfg.Cache = c.CacheDir
+		}
+		if cfg.Cache == "" {
+			return ErrCacheIsNotSet
+		}
+	} else {
+		cfg.Cache = ""
+	}
+
+	registryConfig := cfg.Registry(c.RegistryName)
+	registryConfig.Admin = false
+	registryConfig.Write = false
+
+	registryClient, err := shop.NewRegistry(ctx, registryConfig)
+	if err != nil {
+		return err
+	}
+	defer registryClient.Close()
+
+	proxy, err := shop.NewRegistryProxy(registryClient)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", c.Listen)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Serving registry %s on http://%s\n", c.RegistryName, listener.Addr())
+
+	server := &http.Server{
+		Handler:           proxy,
+		ReadHeaderTimeout: time.Minute,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ServeShutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,203 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ErrSiteModified = errors.New("Installed files were changed")
+)
+
+type SiteCommand struct {
+	Arguments *GlobalArguments
+}
+
+func NewSiteCommand(args *GlobalArguments) *cobra.Command {
+	c := &SiteCommand{
+		Arguments: args,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "site",
+		Short: "Manage packages installed into a root.",
+	}
+
+	cmd.AddCommand(
+		NewSiteListCommand(c),
+		NewSiteStatusCommand(c),
+		NewSiteUninstallCommand(c),
+	)
+
+	return cmd
+}
+
+type SiteListCommand struct {
+	*SiteCommand
+}
+
+func NewSiteListCommand(parent *SiteCommand) *cobra.Command {
+	c := &SiteListCommand{
+		SiteCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "ls root",
+		Short: "List packages installed into the root.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0])
+		},
+	}
+
+	return cmd
+}
+
+func (c *SiteListCommand) Run(ctx context.Context, root string) error {
+	installed, err := shop.NewSite(root).List()
+	if err != nil {
+		return err
+	}
+
+	output := make([]SiteListOutputItem, 0, len(installed))
+	for _, pkg := range installed {
+		output = append(output, SiteListOutputItem{pkg})
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(output)
+}
+
+type SiteListOutputItem struct {
+	shop.SitePackage
+}
+
+func (i SiteListOutputItem) IntoText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%s\t%s\t%d files\t%s", i.Package, i.Id, len(i.Files), i.InstalledAt.Format(time.RFC3339))), nil
+}
+
+type SiteStatusCommand struct {
+	*SiteCommand
+}
+
+func NewSiteStatusCommand(parent *SiteCommand) *cobra.Command {
+	c := &SiteStatusCommand{
+		SiteCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "status root [package_name...]",
+		Short: "List installed files changed since installation.",
+		Long: "List files of installed packages (all by default) which were modified or removed since the\n" +
+			"package was installed. Fails if there are any.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1:])
+		},
+	}
+
+	return cmd
+}
+
+func (c *SiteStatusCommand) Run(ctx context.Context, root string, names []string) error {
+	site := shop.NewSite(root)
+	if len(names) == 0 {
+		installed, err := site.List()
+		if err != nil {
+			return err
+		}
+		for _, pkg := range installed {
+			names = append(names, pkg.Package)
+		}
+	}
+
+	output := []SiteStatusOutputItem{}
+	for _, name := range names {
+		changes, err := site.Check(name)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			output = append(output, SiteStatusOutputItem{change})
+		}
+	}
+
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	if err := encoder.Encode(output); err != nil {
+		return err
+	}
+	if len(output) > 0 {
+		return fmt.Errorf("%w: %d", ErrSiteModified, len(output))
+	}
+	return nil
+}
+
+type SiteStatusOutputItem struct {
+	shop.SiteFileChange
+}
+
+func (i SiteStatusOutputItem) IntoText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%s\t%s\t%s", i.Kind, i.Path, i.Package)), nil
+}
+
+type SiteUninstallCommand struct {
+	*SiteCommand
+
+	Yes bool
+}
+
+func NewSiteUninstallCommand(parent *SiteCommand) *cobra.Command {
+	c := &SiteUninstallCommand{
+		SiteCommand: parent,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "uninstall [-y|--force] root package_name",
+		Short: "Remove files of the installed package from the root.",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context(), args[0], args[1])
+		},
+	}
+
+	addYesFlags(cmd, &c.Yes)
+
+	return cmd
+}
+
+func (c *SiteUninstallCommand) Run(ctx context.Context, root, name string) error {
+	site := shop.NewSite(root)
+	installed, err := site.Installed(name)
+	if err != nil {
+		return err
+	}
+
+	if !c.Yes && !c.Arguments.DryRun {
+		changes, err := site.Check(name)
+		if err != nil {
+			return err
+		}
+		var summary strings.Builder
+		for _, change := range changes {
+			if change.Kind == shop.SiteFileModified {
+				fmt.Fprintf(&summary, "modified\t%s\n", change.Path)
+			}
+		}
+		err = confirmDestruction(false, summary.String(), fmt.Sprintf("Remove %d files of %s@%s?", len(installed.Files), installed.Package, installed.Id))
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.Arguments.DryRun {
+		return nil
+	}
+	return site.Uninstall(ctx, name)
+}
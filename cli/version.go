@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/alex-ac/shop"
+	"github.com/spf13/cobra"
+)
+
+// Build metadata, set with
+// -ldflags "-X github.com/alex-ac/shop/cli.Version=v1.0.0 -X ...". Values
+// which are not set are taken from the build info embedded by go build.
+var (
+	Version   string
+	Commit    string
+	BuildDate string
+)
+
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+	// Version of registry metadata written by the binary.
+	ApiVersion string `json:"api_version"`
+}
+
+func GetVersionInfo() VersionInfo {
+	info := VersionInfo{
+		Version:    Version,
+		Commit:     Commit,
+		BuildDate:  BuildDate,
+		GoVersion:  runtime.Version(),
+		Platform:   runtime.GOOS + "/" + runtime.GOARCH,
+		ApiVersion: shop.LatestVersion,
+	}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "" {
+		info.Version = build.Main.Version
+	}
+
+	modified := false
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			// Commit time is the closest to build date go build records.
+			if info.BuildDate == "" {
+				info.BuildDate = setting.Value
+			}
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if modified && Commit == "" && info.Commit != "" {
+		info.Commit += "-dirty"
+	}
+	return info
+}
+
+func (i VersionInfo) IntoText() ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "shop %s\n", i.Version)
+	if i.Commit != "" {
+		fmt.Fprintf(&b, "commit: %s\n", i.Commit)
+	}
+	if i.BuildDate != "" {
+		fmt.Fprintf(&b, "built: %s\n", i.BuildDate)
+	}
+	fmt.Fprintf(&b, "go: %s %s\n", i.GoVersion, i.Platform)
+	fmt.Fprintf(&b, "api version: %s", i.ApiVersion)
+	return []byte(b.String()), nil
+}
+
+type VersionCommand struct {
+	Arguments *GlobalArguments
+}
+
+func NewVersionCommand(args *GlobalArguments) *cobra.Command {
+	c := &VersionCommand{
+		Arguments: args,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print version of the binary and registry API it writes.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.Run(cmd.Context())
+		},
+	}
+
+	return cmd
+}
+
+func (c *VersionCommand) Run(ctx context.Context) error {
+	encoder := c.Arguments.OutputFormat.CreateEncoder(os.Stdout)
+	return encoder.Encode(GetVersionInfo())
+}
@@ -0,0 +1,170 @@
+package shop
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionNone = "none"
+	// Zip archive with deflated files, instead of a tar one.
+	CompressionZip = "zip"
+	// Instances uploaded before the compression was recorded are gzipped.
+	DefaultCompression = CompressionGzip
+)
+
+var (
+	ErrUnknownCompression = errors.New("Unknown archive compression")
+)
+
+// CAS archives are named by the instance id and the extension of their
+// compression.
+var compressionExtensions = map[string]string{
+	CompressionGzip: RegistryCASArchiveExtension,
+	CompressionZstd: ".tar.zst",
+	CompressionNone: ".tar",
+	CompressionZip:  ".zip",
+}
+
+var (
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	// Local file header, or the end of the central directory of empty zip
+	// archives.
+	zipMagic      = []byte("PK\x03\x04")
+	emptyZipMagic = []byte("PK\x05\x06")
+)
+
+// CAS objects of instances which are not stored as their archive. Archives
+// of these instances are uncompressed tars made from the objects.
+var storedExtensions = map[string]string{
+	CompressionFiles: ".files.json",
+	CompressionDelta: ".delta",
+}
+
+func IsValidCompression(compression string) bool {
+	_, ok := compressionExtensions[compression]
+	_, stored := storedExtensions[compression]
+	return compression == "" || ok || stored
+}
+
+// Extension of archives with the compression, empty one is the default.
+func ArchiveExtension(compression string) string {
+	if compression == "" {
+		compression = DefaultCompression
+	}
+	if _, ok := storedExtensions[compression]; ok {
+		compression = CompressionNone
+	}
+	return compressionExtensions[compression]
+}
+
+func InstanceCASKey(id, compression string) string {
+	if extension, ok := storedExtensions[compression]; ok {
+		return filepath.Join(RegistryCASPrefix, id+extension)
+	}
+	return filepath.Join(RegistryCASPrefix, id+ArchiveExtension(compression))
+}
+
+func (i Instance) CASKey() string {
+	return InstanceCASKey(i.Id, i.Compression)
+}
+
+// Instance id and compression of the CAS archive name.
+func ParseCASArchiveName(name string) (id, compression string, ok bool) {
+	for compression, extension := range compressionExtensions {
+		// .tar is a suffix of .tar.zst too, so the id is checked.
+		if id, ok := strings.CutSuffix(name, extension); ok && IsValidInstanceId(id) {
+			return id, compression, true
+		}
+	}
+	return "", "", false
+}
+
+// Instance id and compression of the CAS object name, either an archive or
+// an object the archive is made from.
+func parseCASName(name string) (id, compression string, ok bool) {
+	for compression, extension := range storedExtensions {
+		if id, ok := strings.CutSuffix(name, extension); ok && IsValidInstanceId(id) {
+			return id, compression, true
+		}
+	}
+	return ParseCASArchiveName(name)
+}
+
+// Compression of the archive file by its extension, false if it has none of
+// the archive extensions.
+func ArchiveNameCompression(name string) (string, bool) {
+	for compression, extension := range compressionExtensions {
+		if strings.HasSuffix(name, extension) {
+			return compression, true
+		}
+	}
+	return "", false
+}
+
+// Compression of the archive given its first bytes. Anything other than
+// gzip, zstd and zip is taken for a plain tar.
+func DetectCompression(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return CompressionZstd
+	case bytes.HasPrefix(header, zipMagic) || bytes.HasPrefix(header, emptyZipMagic):
+		return CompressionZip
+	default:
+		return CompressionNone
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func newCompressor(dst io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "", CompressionGzip:
+		return gzip.NewWriter(dst), nil
+	case CompressionZstd:
+		return zstd.NewWriter(dst)
+	case CompressionNone:
+		return nopWriteCloser{dst}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCompression, compression)
+	}
+}
+
+// Decompressor of the archive, picked by its first bytes.
+func newDecompressor(src io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(src)
+	header, err := buffered.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	switch DetectCompression(header) {
+	case CompressionGzip:
+		return gzip.NewReader(buffered)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return io.NopCloser(buffered), nil
+	}
+}
@@ -0,0 +1,50 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Optional RepositoryFS capability for atomic compare-and-swap writes. Data
+// is written only if the current object ETag (as returned by Stat) matches,
+// empty etag means the object must not exist. Fails with ErrConditionFailed
+// otherwise.
+type ConditionalWriteFS interface {
+	WriteIf(ctx context.Context, key string, data []byte, etag string) error
+}
+
+func writeIf(ctx context.Context, fs RepositoryFS, key string, data []byte, etag string) error {
+	if cw, ok := findCapability[ConditionalWriteFS](fs); ok {
+		return cw.WriteIf(ctx, key, data, etag)
+	}
+
+	if etag == "" {
+		w, err := fs.Create(ctx, key)
+		if err == nil {
+			if _, err = w.Write(data); err != nil {
+				if aborter, ok := w.(Aborter); ok {
+					aborter.Abort()
+				}
+				return err
+			}
+			err = w.Close()
+		}
+		if errors.Is(err, os.ErrExist) {
+			err = fmt.Errorf("%w: %s", ErrConditionFailed, key)
+		}
+		return err
+	}
+
+	// The backend can't check the condition atomically, so a concurrent
+	// write between Stat and Write is not detected.
+	info, err := fs.Stat(ctx, key)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && info.ETag != etag) {
+		return fmt.Errorf("%w: %s", ErrConditionFailed, key)
+	}
+	if err != nil {
+		return err
+	}
+	return fs.Write(ctx, key, data)
+}
@@ -24,7 +24,7 @@ type ConfigLoadError struct {
 }
 
 func (e ConfigLoadError) Error() string {
-	return fmt.Sprintf("Can't load config (%s): %v", e.error.Error())
+	return fmt.Sprintf("Can't load config (%s): %v", e.Path, e.error.Error())
 }
 
 func NewConfigLoadError(err error, path string) error {
@@ -40,7 +40,7 @@ type ConfigSaveError struct {
 }
 
 func (e ConfigSaveError) Error() string {
-	return fmt.Sprintf("Can't save config (%s): %v", e.error.Error())
+	return fmt.Sprintf("Can't save config (%s): %v", e.Path, e.error.Error())
 }
 
 func NewConfigSaveError(err error, path string) error {
@@ -57,10 +57,43 @@ func wrapConfigError(err *error, path *string, constructor func(error, string) e
 }
 
 type Config struct {
-	DefaultRegistry string `toml:"default_registry,omitempty" comment:"Default registry to use."`
-	Cache           string `toml:"cache,omitempty" comment:"Path to the local file cache."`
+	DefaultRegistry string   `toml:"default_registry,omitempty" comment:"Default registry to use."`
+	Cache           string   `toml:"cache,omitempty" comment:"Path to the local cache of repository objects, archives and extracted instances."`
+	CacheTTL        Duration `toml:"cache_ttl,omitempty" comment:"How long cached repository metadata is used without refetching (default: 5m)."`
+	CacheMaxSize    int64    `toml:"cache_max_size,omitempty" comment:"Maximum size of the local cache in bytes (default: 1GiB)."`
+
+	RejectUnsafeSymlinks bool `toml:"reject_unsafe_symlinks,omitempty" comment:"Fail to install or extract instances with symlinks to absolute paths or outside of the instance."`
 
 	Registries map[string]RegistryConfig `toml:"registry,omitempty"`
+
+	// Loaded from the credentials file.
+	Credentials Credentials `toml:"-"`
+	// Writes to registries are recorded into the plan instead of being made.
+	DryRun *DryRunPlan `toml:"-"`
+	// Parallel operations of batch commands, backend defaults if zero.
+	Jobs int `toml:"-"`
+	// Resolve versions to yanked instances and instances of deprecated
+	// packages.
+	AllowYanked bool `toml:"-"`
+}
+
+// Registry configuration with local settings applied.
+func (c Config) Registry(name string) RegistryConfig {
+	registryCfg := c.Registries[name]
+	if credential, ok := c.Credentials.Registries[name]; ok {
+		registryCfg.Credential = &credential
+	}
+	if c.Cache != "" {
+		registryCfg.Cache = &CacheConfig{
+			Dir:     c.Cache,
+			TTL:     c.CacheTTL.Duration,
+			MaxSize: c.CacheMaxSize,
+		}
+	}
+	registryCfg.DryRun = c.DryRun
+	registryCfg.Jobs = c.Jobs
+	registryCfg.AllowYanked = c.AllowYanked
+	return registryCfg
 }
 
 func (c *Config) AddRegistry(name string, registryCfg RegistryConfig) error {
@@ -89,6 +122,15 @@ func (c *Config) UpdateRegistry(name string, registryCfg RegistryConfig) error {
 	return nil
 }
 
+func (c *Config) SetDefaultRegistry(name string) error {
+	if _, ok := c.Registries[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrRegistryConfigNotExists, name)
+	}
+
+	c.DefaultRegistry = name
+	return nil
+}
+
 type RegistryConfig struct {
 	URL      string                      `toml:"url" comment:"Manifest url."`
 	RootRepo RepositoryConfig            `toml:"root_repository" comment:"Main repository settings."`
@@ -97,19 +139,103 @@ type RegistryConfig struct {
 	// Local tool configuration
 	Admin bool `toml:"admin,omitempty" comment:"Enable admin commands for this registry."`
 	Write bool `toml:"write,omitempty" comment:"Enable write commands for this registry."`
+
+	Headers map[string]string `toml:"headers,omitempty" comment:"Extra headers sent with every http request to the registry repositories."`
+
+	IdAlgorithm string `toml:"id_algorithm,omitempty" comment:"Hash of new instance ids: sha256 (default) or sha1, the only one clients before sha256 ids understand."`
+
+	Sigstore *SigstoreConfig `toml:"sigstore,omitempty" comment:"Keyless signing of instances and verification of their signatures."`
+	// The policy with the longest prefix matching the package applies.
+	SignaturePolicies []SignaturePolicy `toml:"signature_policy,omitempty" comment:"Signatures and provenance required of instances on download, install and ensure, by package prefix."`
+
+	Cache *CacheConfig `toml:"-"`
+	// Set from the credentials file.
+	Credential  *Credential `toml:"-"`
+	DryRun      *DryRunPlan `toml:"-"`
+	Jobs        int         `toml:"-"`
+	AllowYanked bool        `toml:"-"`
+}
+
+type SigstoreConfig struct {
+	FulcioURL           string `toml:"fulcio_url,omitempty" comment:"Certificate authority issuing signing certificates (default: public sigstore instance)."`
+	RekorURL            string `toml:"rekor_url,omitempty" comment:"Transparency log signatures are recorded in (default: public sigstore instance)."`
+	SkipTransparencyLog bool   `toml:"skip_tlog,omitempty" comment:"Don't record signatures in the transparency log."`
+	SignOnUpload        bool   `toml:"sign_on_upload,omitempty" comment:"Sign every uploaded instance."`
+
+	TrustedRoots   string             `toml:"trusted_roots,omitempty" comment:"PEM file with root and intermediate certificates of the certificate authority."`
+	RekorPublicKey string             `toml:"rekor_public_key,omitempty" comment:"PEM file with the transparency log key, signatures must be logged if set."`
+	Identities     []SigstoreIdentity `toml:"identities,omitempty" comment:"Signers allowed to sign instances, e.g. CI workflows."`
+}
+
+// Signer identity allowed by the policy. Subject is matched exactly or by
+// SubjectRegexp, which is anchored.
+type SigstoreIdentity struct {
+	Issuer        string `toml:"issuer" comment:"OIDC issuer, e.g. https://token.actions.githubusercontent.com."`
+	Subject       string `toml:"subject,omitempty" comment:"Email or workflow URI from the certificate."`
+	SubjectRegexp string `toml:"subject_regexp,omitempty" comment:"Regular expression matching the whole subject."`
+}
+
+// Rules checked when instance archives are downloaded. Signatures count if
+// they are made by allowed identities or keys, ones by other signers are
+// violations of the identities or keys rule. Provenance by other builders or
+// of other sources violates the builders or source_repos rule.
+type SignaturePolicy struct {
+	Prefix        string             `toml:"prefix,omitempty" comment:"Packages the policy applies to (default: all)."`
+	RequireSigned bool               `toml:"require_signed,omitempty" comment:"Fail on instances without a signature by an allowed signer."`
+	Identities    []SigstoreIdentity `toml:"identities,omitempty" comment:"Sigstore signers allowed (default: identities from the sigstore settings)."`
+	Keys          []string           `toml:"keys,omitempty" comment:"Ids of registry signing keys allowed (default: all keys of the registry)."`
+	GracePeriod   Duration           `toml:"grace_period,omitempty" comment:"Instances uploaded this recently don't have to be signed yet, e.g. while a release job signs them."`
+
+	RequireProvenance bool     `toml:"require_provenance,omitempty" comment:"Fail on instances without SLSA provenance of their archive."`
+	Builders          []string `toml:"builders,omitempty" comment:"Builder ids allowed in provenance, a trailing * matches any suffix (default: all)."`
+	SourceRepos       []string `toml:"source_repos,omitempty" comment:"Source repositories allowed in provenance, a trailing * matches any suffix (default: all)."`
 }
 
 type RepositoryConfig struct {
 	URL   string `toml:"url" comment:"Repository URL"`
 	Admin bool   `toml:"admin,omitempty" comment:"Enable admin access for this repository."`
 	Write bool   `toml:"write,omitempty" comment:"Enable write access for this repository."`
+
+	Mirrors        []string `toml:"mirrors,omitempty" comment:"Read-only copies of the repository used when it is unavailable."`
+	MirrorStrategy string   `toml:"mirror_strategy,omitempty" comment:"How mirrors are used for reads: failover (in order) or race (fastest wins)."`
+
+	CompressMetadata bool `toml:"compress_metadata,omitempty" comment:"Store JSON metadata gzip-compressed (not readable by older shop versions)."`
+
+	MultipartThreshold   int64 `toml:"multipart_threshold,omitempty" comment:"Upload objects larger than this in parts (bytes)."`
+	MultipartPartSize    int64 `toml:"multipart_part_size,omitempty" comment:"Size of a single part of multipart upload (bytes)."`
+	MultipartConcurrency int   `toml:"multipart_concurrency,omitempty" comment:"Number of parts uploaded in parallel."`
+
+	RetryMaxAttempts int      `toml:"retry_max_attempts,omitempty" comment:"Attempts for operations failed with transient errors (default: 3, 1 disables retries)."`
+	RetryBaseDelay   Duration `toml:"retry_base_delay,omitempty" comment:"Delay before the first retry, doubled on every next one (default: 200ms)."`
+
+	RateLimit      float64 `toml:"rate_limit,omitempty" comment:"Maximum operations per second (default: unlimited)."`
+	RateLimitBurst int     `toml:"rate_limit_burst,omitempty" comment:"Operations allowed at once above the rate limit (default: rate_limit)."`
+
+	TLS         *TLSConfig               `toml:"tls,omitempty" comment:"TLS settings of remote backends."`
+	HTTP        *HTTPAccessConfig        `toml:"http,omitempty" comment:"HTTP and WebDAV access settings."`
+	File        *FileAccessConfig        `toml:"file,omitempty" comment:"Local file repository settings."`
+	SSH         *SSHAccessConfig         `toml:"ssh,omitempty" comment:"SSH access settings."`
+	Artifactory *ArtifactoryAccessConfig `toml:"artifactory,omitempty" comment:"Artifactory access settings."`
+	B2          *B2AccessConfig          `toml:"b2,omitempty" comment:"Backblaze B2 access settings."`
+	SMB         *SMBAccessConfig         `toml:"smb,omitempty" comment:"SMB file share access settings."`
+	S3          *S3AccessConfig          `toml:"s3,omitempty" comment:"S3 (or compatible storage) access settings."`
+	FTP         *FTPAccessConfig         `toml:"ftp,omitempty" comment:"FTP access settings."`
+	Git         *GitAccessConfig         `toml:"git,omitempty" comment:"Git metadata repository settings."`
+
+	// Set from the tool configuration.
+	Cache *CacheConfig `toml:"-"`
+	// Set from the registry configuration.
+	Headers map[string]string `toml:"-"`
+	DryRun  *DryRunPlan       `toml:"-"`
+	Jobs    int               `toml:"-"`
 }
 
 type S3AccessConfig struct {
 	// S3 Bucket settings.
-	EndpointURL string `toml:"endpoint_url,omitempty" comment:"S3 Endpoint url."`
-	Region      string `toml:"region" comment:"AWS region."`
-	Bucket      string `toml:"bucket" comment:"S3 Bucket name."`
+	EndpointURL string `toml:"endpoint_url,omitempty" comment:"S3 Endpoint url, for MinIO, Ceph and other compatible storages."`
+	Region      string `toml:"region,omitempty" comment:"AWS region."`
+	Bucket      string `toml:"bucket,omitempty" comment:"S3 Bucket name (default: host of the repository url)."`
+	PathStyle   bool   `toml:"path_style,omitempty" comment:"Put bucket name into the path instead of the host name."`
 
 	// S3 Auth information.
 	AWSProfile      string `toml:"aws_profile,omitempty" comment:"AWS profile name."`
@@ -118,9 +244,63 @@ type S3AccessConfig struct {
 }
 
 type HTTPAccessConfig struct {
-	User       string `toml:"user,omitempty"`
-	Password   string `toml:"password,omitempty"`
-	ClientCert string `toml:"client_cert,omitempty" comment:"Client Certificate in PEM format."`
+	User       string `toml:"user,omitempty" comment:"Basic auth user name."`
+	Password   string `toml:"password,omitempty" comment:"Basic auth password."`
+	Token      string `toml:"token,omitempty" comment:"Bearer token."`
+	ClientCert string `toml:"client_cert,omitempty" comment:"Path to the client certificate in PEM format."`
+	ClientKey  string `toml:"client_key,omitempty" comment:"Path to the client certificate key in PEM format (default: client_cert)."`
+
+	Headers map[string]string `toml:"headers,omitempty" comment:"Extra headers sent with every request."`
+}
+
+type TLSConfig struct {
+	CAFile             string `toml:"ca_file,omitempty" comment:"Path to the CA bundle in PEM format used instead of the system one."`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify,omitempty" comment:"Do not verify server certificates. Only for isolated networks."`
+	MinVersion         string `toml:"min_version,omitempty" comment:"Minimum TLS version: 1.0, 1.1, 1.2 or 1.3 (default: 1.2)."`
+}
+
+type FileAccessConfig struct {
+	VerifyHash bool     `toml:"verify_hash,omitempty" comment:"Verify hash of CAS archives before moving them into place."`
+	Dedup      string   `toml:"dedup,omitempty" comment:"Share CAS archives with other local repositories: hardlink or reflink."`
+	DedupRoots []string `toml:"dedup_roots,omitempty" comment:"Other local repository paths to look for existing CAS archives."`
+}
+
+type SSHAccessConfig struct {
+	User           string `toml:"user,omitempty" comment:"SSH user name."`
+	IdentityFile   string `toml:"identity_file,omitempty" comment:"Path to the private key file."`
+	KnownHostsFile string `toml:"known_hosts_file,omitempty" comment:"Path to the known_hosts file."`
+}
+
+type ArtifactoryAccessConfig struct {
+	ContextPath string `toml:"context_path,omitempty" comment:"Artifactory context path (default: artifactory)."`
+	APIKey      string `toml:"api_key,omitempty" comment:"Artifactory API key."`
+	Token       string `toml:"token,omitempty" comment:"Artifactory access token."`
+}
+
+type B2AccessConfig struct {
+	KeyId          string `toml:"key_id" comment:"B2 application key id."`
+	ApplicationKey string `toml:"application_key" comment:"B2 application key."`
+	PartSize       int64  `toml:"part_size,omitempty" comment:"Large file part size in bytes (default: recommended by B2)."`
+}
+
+type SMBAccessConfig struct {
+	User     string `toml:"user,omitempty" comment:"User name, could be in DOMAIN\\user form."`
+	Password string `toml:"password,omitempty" comment:"Password (default: $SHOP_SMB_PASSWORD)."`
+	Domain   string `toml:"domain,omitempty" comment:"Windows domain of the user."`
+	Auth     string `toml:"auth,omitempty" comment:"Authentication method: ntlm (default). Kerberos is not supported yet."`
+}
+
+type FTPAccessConfig struct {
+	User        string `toml:"user,omitempty" comment:"User name (default: anonymous)."`
+	Password    string `toml:"password,omitempty" comment:"Password (default: $SHOP_FTP_PASSWORD)."`
+	ImplicitTLS bool   `toml:"implicit_tls,omitempty" comment:"Use implicit TLS for ftps:// instead of AUTH TLS."`
+}
+
+type GitAccessConfig struct {
+	BlobURL     string `toml:"blob_url" comment:"Repository url for CAS archives, which are not stored in git."`
+	Branch      string `toml:"branch,omitempty" comment:"Branch with the metadata (default: main)."`
+	AuthorName  string `toml:"author_name,omitempty" comment:"Commit author name (default: from git config)."`
+	AuthorEmail string `toml:"author_email,omitempty" comment:"Commit author email (default: from git config)."`
 }
 
 // Find location of the config file. Should be
@@ -152,7 +332,11 @@ func LoadConfig(path string) (cfg Config, err error) {
 
 	decoder := toml.NewDecoder(file)
 	decoder.DisallowUnknownFields()
-	err = decoder.Decode(&cfg)
+	if err = decoder.Decode(&cfg); err != nil {
+		return
+	}
+
+	cfg.Credentials, err = LoadCredentials(path)
 	return
 }
 
@@ -0,0 +1,153 @@
+package shop
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknownConfigKey   = errors.New("Unknown config key")
+	ErrInvalidConfigValue = errors.New("Invalid config value")
+)
+
+// Value at the dotted key, like registry.default.root_repository.url, as
+// it's named in the config file. Keys which are not set have zero values.
+func (c Config) Get(key string) (any, error) {
+	value, err := getConfigValue(reflect.ValueOf(c), strings.Split(key, "."))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, key)
+	}
+	return value.Interface(), nil
+}
+
+// Parse the value according to the type of the dotted key and set it.
+// Lists are comma separated.
+func (c *Config) Set(key, value string) error {
+	err := setConfigValue(reflect.ValueOf(c).Elem(), strings.Split(key, "."), value)
+	switch {
+	case errors.Is(err, ErrUnknownConfigKey):
+		return fmt.Errorf("%w: %s", ErrUnknownConfigKey, key)
+	case err != nil:
+		return fmt.Errorf("%w: %s = %q: %v", ErrInvalidConfigValue, key, value, err)
+	default:
+		return nil
+	}
+}
+
+// Struct field by the name from its toml tag.
+func configField(v reflect.Value, name string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		tag, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("toml"), ",")
+		if tag != "" && tag != "-" && tag == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func getConfigValue(v reflect.Value, parts []string) (reflect.Value, error) {
+	if len(parts) == 0 {
+		return v, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v = reflect.New(v.Type().Elem())
+		}
+		return getConfigValue(v.Elem(), parts)
+	case reflect.Struct:
+		if _, ok := v.Interface().(encoding.TextMarshaler); ok {
+			break
+		}
+		field, ok := configField(v, parts[0])
+		if ok {
+			return getConfigValue(field, parts[1:])
+		}
+	case reflect.Map:
+		elem := v.MapIndex(reflect.ValueOf(parts[0]))
+		if !elem.IsValid() {
+			elem = reflect.Zero(v.Type().Elem())
+		}
+		return getConfigValue(elem, parts[1:])
+	}
+	return reflect.Value{}, ErrUnknownConfigKey
+}
+
+func setConfigValue(v reflect.Value, parts []string, value string) error {
+	if len(parts) == 0 {
+		return parseConfigValue(v, value)
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setConfigValue(v.Elem(), parts, value)
+	case reflect.Struct:
+		if _, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			break
+		}
+		field, ok := configField(v, parts[0])
+		if ok {
+			return setConfigValue(field, parts[1:], value)
+		}
+	case reflect.Map:
+		// Map elements are not addressable, so they are updated in a copy.
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		key := reflect.ValueOf(parts[0])
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if existing := v.MapIndex(key); existing.IsValid() {
+			elem.Set(existing)
+		}
+		if err := setConfigValue(elem, parts[1:], value); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+		return nil
+	}
+	return ErrUnknownConfigKey
+}
+
+func parseConfigValue(v reflect.Value, value string) (err error) {
+	if unmarshaler, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(value))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(value)
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(value, 10, v.Type().Bits())
+		v.SetInt(i)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(value, v.Type().Bits())
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return ErrUnknownConfigKey
+		}
+		var items []string
+		if value != "" {
+			items = strings.Split(value, ",")
+		}
+		v.Set(reflect.ValueOf(items).Convert(v.Type()))
+	default:
+		// Tables could be set only field by field.
+		return ErrUnknownConfigKey
+	}
+	return
+}
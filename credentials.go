@@ -0,0 +1,168 @@
+package shop
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+const (
+	// Credentials are kept next to the config file, readable only by the
+	// user.
+	CredentialsFileName = "credentials.toml"
+
+	CredentialS3    = "s3"
+	CredentialBasic = "basic"
+	CredentialToken = "token"
+)
+
+var (
+	ErrNoCredentials = errors.New("No credentials stored for registry")
+)
+
+// Secrets of the registry, applied to all of its repositories.
+type Credential struct {
+	AccessKeyId     string `toml:"access_key_id,omitempty" comment:"AWS Access Key ID."`
+	SecretAccessKey string `toml:"secret_access_key,omitempty" comment:"AWS Secret Access Key."`
+	User            string `toml:"user,omitempty" comment:"Basic auth user name."`
+	Password        string `toml:"password,omitempty" comment:"Basic auth password."`
+	Token           string `toml:"token,omitempty" comment:"Bearer token."`
+}
+
+// One of CredentialS3, CredentialBasic or CredentialToken, empty if
+// nothing is set.
+func (c Credential) Kind() string {
+	switch {
+	case c.AccessKeyId != "" || c.SecretAccessKey != "":
+		return CredentialS3
+	case c.User != "" || c.Password != "":
+		return CredentialBasic
+	case c.Token != "":
+		return CredentialToken
+	default:
+		return ""
+	}
+}
+
+// Repository config with the credential applied over secrets from the
+// config.
+func (c Credential) apply(cfg RepositoryConfig) RepositoryConfig {
+	switch c.Kind() {
+	case CredentialS3:
+		s3 := S3AccessConfig{}
+		if cfg.S3 != nil {
+			s3 = *cfg.S3
+		}
+		s3.AWSProfile = ""
+		s3.AccessKeyId = c.AccessKeyId
+		s3.SecretAccessKey = c.SecretAccessKey
+		cfg.S3 = &s3
+	case CredentialBasic, CredentialToken:
+		http := HTTPAccessConfig{}
+		if cfg.HTTP != nil {
+			http = *cfg.HTTP
+		}
+		http.User = c.User
+		http.Password = c.Password
+		http.Token = c.Token
+		cfg.HTTP = &http
+	}
+	return cfg
+}
+
+type Credentials struct {
+	Registries map[string]Credential `toml:"registry,omitempty"`
+}
+
+func CredentialsFile(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), CredentialsFileName)
+}
+
+// Load credentials stored next to the config. Returns empty credentials if
+// file does not exist.
+func LoadCredentials(configPath string) (creds Credentials, err error) {
+	path := CredentialsFile(configPath)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	decoder := toml.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&creds); err != nil {
+		err = fmt.Errorf("%s: %w", path, err)
+	}
+	return
+}
+
+func SaveCredentials(creds Credentials, configPath string) (err error) {
+	path := CredentialsFile(configPath)
+	defer wrapConfigError(&err, &path, NewConfigSaveError)
+
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+
+	err = toml.NewEncoder(file).Encode(&creds)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return
+}
+
+// Remove plaintext secrets from the registry config, after they were moved
+// into the credentials file.
+func (c *Config) ClearRegistrySecrets(name string) error {
+	registryCfg, ok := c.Registries[name]
+	if !ok {
+		return ErrRegistryConfigNotExists
+	}
+
+	registryCfg.RootRepo = clearRepositorySecrets(registryCfg.RootRepo)
+	repos := map[string]RepositoryConfig{}
+	for key, repoCfg := range registryCfg.Repos {
+		repos[key] = clearRepositorySecrets(repoCfg)
+	}
+	if registryCfg.Repos != nil {
+		registryCfg.Repos = repos
+	}
+	c.Registries[name] = registryCfg
+	return nil
+}
+
+func clearRepositorySecrets(cfg RepositoryConfig) RepositoryConfig {
+	if cfg.S3 != nil {
+		s3 := *cfg.S3
+		s3.AccessKeyId = ""
+		s3.SecretAccessKey = ""
+		cfg.S3 = &s3
+	}
+	if cfg.HTTP != nil {
+		http := *cfg.HTTP
+		http.User = ""
+		http.Password = ""
+		http.Token = ""
+		cfg.HTTP = &http
+	}
+	return cfg
+}
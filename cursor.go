@@ -20,6 +20,10 @@ func (c ErrorCursor[T]) GetNext(context.Context) (*T, error) {
 	return nil, c
 }
 
+func (c ErrorCursor[T]) Unwrap() error {
+	return c.error
+}
+
 type SliceCursor[T any] struct {
 	items []T
 }
@@ -35,3 +39,15 @@ func (c *SliceCursor[T]) GetNext(context.Context) (item *T, err error) {
 	}
 	return
 }
+
+// Read all remaining items of the cursor.
+func CollectCursor[T any](ctx context.Context, cursor Cursor[T]) (items []T, err error) {
+	for {
+		var item *T
+		item, err = cursor.GetNext(ctx)
+		if err != nil || item == nil {
+			return
+		}
+		items = append(items, *item)
+	}
+}
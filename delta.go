@@ -0,0 +1,501 @@
+package shop
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// Instance stored as the files its base instance, another instance of
+	// the package, does not have. Its archive is made like one of instances
+	// stored file by file.
+	CompressionDelta = "delta"
+
+	// First entry of delta objects, other ones are content of files named
+	// by SHA-256, in the order of the manifest.
+	deltaManifestName = "manifest.json"
+)
+
+var (
+	ErrInvalidDeltaBase = errors.New("Invalid delta base instance")
+)
+
+type DeltaManifest struct {
+	Base            string `json:"base"`
+	BaseCompression string `json:"base_compression,omitempty"`
+	// All files of the archive, content of ones the base has is not stored.
+	Files []FileManifestEntry `json:"files"`
+}
+
+func (m DeltaManifest) base(pkg string) Instance {
+	return Instance{Package: pkg, Id: m.Base, Compression: m.BaseCompression}
+}
+
+// Content of files kept in an anonymous temporary file, by SHA-256.
+type fileSpool struct {
+	file  *os.File
+	size  int64
+	files map[string]spooledFile
+}
+
+type spooledFile struct {
+	offset, size int64
+}
+
+func newFileSpool() (*fileSpool, error) {
+	file, err := os.CreateTemp("", "shop-spool-*")
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Remove(file.Name()); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileSpool{file: file, files: map[string]spooledFile{}}, nil
+}
+
+// Read the content from src and keep it, unless the spool has it already or
+// keep says it's not needed. Returns its SHA-256 and whether it was kept.
+func (s *fileSpool) add(src io.Reader, keep func(hash string) bool) (string, bool, error) {
+	if _, err := s.file.Seek(s.size, io.SeekStart); err != nil {
+		return "", false, err
+	}
+	sum := sha256.New()
+	size, err := io.Copy(io.MultiWriter(s.file, sum), src)
+	if err != nil {
+		return "", false, err
+	}
+	hash := hex.EncodeToString(sum.Sum(nil))
+	if _, ok := s.files[hash]; ok || !keep(hash) {
+		return hash, false, nil
+	}
+	s.files[hash] = spooledFile{s.size, size}
+	s.size += size
+	return hash, true, nil
+}
+
+func keepAll(string) bool {
+	return true
+}
+
+func (s *fileSpool) open(hash string) (io.Reader, bool) {
+	file, ok := s.files[hash]
+	if !ok {
+		return nil, false
+	}
+	return io.NewSectionReader(s.file, file.offset, file.size), true
+}
+
+func (s *fileSpool) Close() error {
+	return s.file.Close()
+}
+
+// Write the archive of the instance as it's stored in the repo, without
+// checking it against the id.
+func writeStoredArchive(ctx context.Context, repo Repository, instance Instance, dst io.Writer) error {
+	switch instance.Compression {
+	case CompressionFiles:
+		return downloadFileManifest(ctx, repo, instance, dst)
+	case CompressionDelta:
+		return downloadDelta(ctx, repo, instance, dst)
+	default:
+		body, err := repo.Get(ctx, instance.CASKey())
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		_, err = io.Copy(dst, body)
+		return err
+	}
+}
+
+// Copy CAS objects of the instance missing in dst, along with files and
+// delta bases they refer to.
+func copyCASObjects(ctx context.Context, src, dst Repository, instance Instance) error {
+	switch instance.Compression {
+	case CompressionFiles:
+		return copyFileManifest(ctx, src, dst, instance.Id)
+	case CompressionDelta:
+		manifest, delta, err := openDelta(ctx, src, instance)
+		if err != nil {
+			return err
+		}
+		delta.Close()
+		if err = copyCASObjects(ctx, src, dst, manifest.base(instance.Package)); err != nil {
+			return err
+		}
+	}
+	return copyMissingObject(ctx, src, dst, instance.CASKey())
+}
+
+// Whether the instance is a delta stored against the id, or the delta base
+// is in turn.
+func isDeltaBase(ctx context.Context, repo Repository, instance Instance, id string) (bool, error) {
+	for instance.Compression == CompressionDelta {
+		manifest, delta, err := openDelta(ctx, repo, instance)
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		delta.Close()
+		if manifest.Base == id {
+			return true, nil
+		}
+		instance = manifest.base(instance.Package)
+	}
+	return false, nil
+}
+
+// Add bases of deltas in the CAS entries which ids refer to, and their bases
+// in turn, to ids. Bases are kept even if their instances are deleted.
+func addDeltaBases(ctx context.Context, repo Repository, entries []Entry, ids map[string]struct{}) error {
+	deltas := map[string]bool{}
+	for _, entry := range entries {
+		if id, compression, ok := parseCASName(entry.Key); ok && !entry.IsPrefix && compression == CompressionDelta {
+			deltas[id] = false
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for id, checked := range deltas {
+			if _, ok := ids[id]; !ok || checked {
+				continue
+			}
+			deltas[id], changed = true, true
+			manifest, delta, err := openDelta(ctx, repo, Instance{Id: id, Compression: CompressionDelta})
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			delta.Close()
+			ids[manifest.Base] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// Spool files of the instance archive which have one of the hashes.
+func spoolArchiveFiles(ctx context.Context, repo Repository, instance Instance, spool *fileSpool, hashes map[string]bool) error {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeStoredArchive(ctx, repo, instance, writer))
+	}()
+	defer reader.Close()
+
+	archive, err := openArchive(reader)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		_, _, err = spool.add(archive, func(hash string) bool {
+			return hashes[hash]
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Hashes of files in the archive of the instance. Instances not stored file
+// by file are read whole.
+func archiveFileHashes(ctx context.Context, repo Repository, instance Instance) (map[string]bool, error) {
+	hashes := map[string]bool{}
+	switch instance.Compression {
+	case CompressionFiles:
+		list, err := fileManifestHashes(ctx, repo, instance.Id)
+		for _, hash := range list {
+			hashes[hash] = true
+		}
+		return hashes, err
+	case CompressionDelta:
+		manifest, body, err := openDelta(ctx, repo, instance)
+		if err != nil {
+			return nil, err
+		}
+		body.Close()
+		for _, entry := range manifest.Files {
+			if entry.Mode.IsRegular() {
+				hashes[entry.SHA256] = true
+			}
+		}
+		return hashes, nil
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeStoredArchive(ctx, repo, instance, writer))
+	}()
+	defer reader.Close()
+	files, err := listArchiveSHA256(reader)
+	for _, hash := range files {
+		hashes[hash] = true
+	}
+	return hashes, err
+}
+
+func listArchiveSHA256(src io.Reader) ([]string, error) {
+	archive, err := openArchive(src)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	var hashes []string
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return hashes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		sum := sha256.New()
+		if _, err = io.Copy(sum, archive); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hex.EncodeToString(sum.Sum(nil)))
+	}
+}
+
+// Store the archive as the delta against the base: content of files the base
+// does not have is kept, along with the manifest of all files. The archive is
+// made again from the manifest while it's read, and has to match the id.
+func uploadDelta(ctx context.Context, repo Repository, instance, base Instance, src io.Reader) error {
+	baseHashes, err := archiveFileHashes(ctx, repo, base)
+	if err != nil {
+		return fmt.Errorf("%w: %s@%s: %w", ErrInvalidDeltaBase, base.Package, base.Id, err)
+	}
+
+	archive, err := openArchive(src)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	defer archive.Close()
+	spool, err := newFileSpool()
+	if err != nil {
+		return err
+	}
+	defer spool.Close()
+
+	h := instanceIdHashOf(instance.Id)
+	made := tar.NewWriter(h)
+	manifest := DeltaManifest{Base: base.Id, BaseCompression: base.Compression, Files: []FileManifestEntry{}}
+	var stored []string
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		name, err := checkArchiveEntry(header)
+		if err != nil {
+			return err
+		}
+
+		entry := FileManifestEntry{Path: name, Mode: header.FileInfo().Mode(), Target: header.Linkname}
+		if header.Typeflag == tar.TypeReg {
+			entry.Size = header.Size
+		}
+		if err = made.WriteHeader(entry.tarHeader()); err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeReg {
+			var added bool
+			entry.SHA256, added, err = spool.add(io.TeeReader(archive, made), func(hash string) bool {
+				return !baseHashes[hash]
+			})
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+			}
+			if added {
+				stored = append(stored, entry.SHA256)
+			}
+		}
+		manifest.Files = append(manifest.Files, entry)
+	}
+	if err = made.Close(); err != nil {
+		return err
+	}
+	if id := h.Id(); id != instance.Id {
+		return fmt.Errorf("%w: %s@%s: archive made from the manifest is %s", ErrHashMismatch, instance.Package, instance.Id, id)
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeDelta(writer, manifest, spool, stored))
+	}()
+	defer reader.Close()
+	return repo.Put(ctx, instance.CASKey(), reader)
+}
+
+func writeDelta(dst io.Writer, manifest DeltaManifest, spool *fileSpool, stored []string) error {
+	compressor, err := newCompressor(dst, CompressionZstd)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	delta := tar.NewWriter(compressor)
+	err = delta.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: deltaManifestName, Size: int64(len(data)), Mode: 0644})
+	if err == nil {
+		_, err = delta.Write(data)
+	}
+	for _, hash := range stored {
+		if err != nil {
+			return err
+		}
+		content, _ := spool.open(hash)
+		err = delta.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: hash, Size: spool.files[hash].size, Mode: 0644})
+		if err == nil {
+			_, err = io.Copy(delta, content)
+		}
+	}
+	if err == nil {
+		err = delta.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return compressor.Close()
+}
+
+// Manifest of the delta, and the delta positioned at the first file.
+func openDelta(ctx context.Context, repo Repository, instance Instance) (*DeltaManifest, *tarArchiveReader, error) {
+	body, err := repo.Get(ctx, instance.CASKey())
+	if err != nil {
+		return nil, nil, err
+	}
+	decompressor, err := newDecompressor(body)
+	if err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+	delta := &tarArchiveReader{tar.NewReader(decompressor), closeBoth{decompressor, body}}
+
+	manifest := &DeltaManifest{}
+	header, err := delta.Next()
+	if err == nil && header.Name != deltaManifestName {
+		err = fmt.Errorf("first entry is %s", header.Name)
+	}
+	if err == nil {
+		err = json.NewDecoder(delta).Decode(manifest)
+	}
+	if err == nil && (!IsValidInstanceId(manifest.Base) || manifest.Base == instance.Id) {
+		err = fmt.Errorf("%w: %q", ErrInvalidDeltaBase, manifest.Base)
+	}
+	if err != nil {
+		delta.Close()
+		return nil, nil, fmt.Errorf("%w: %s@%s: %w", ErrInvalidArchive, instance.Package, instance.Id, err)
+	}
+	return manifest, delta, nil
+}
+
+type closeBoth struct {
+	io.ReadCloser
+	other io.Closer
+}
+
+func (c closeBoth) Close() error {
+	err := c.ReadCloser.Close()
+	if otherErr := c.other.Close(); err == nil {
+		err = otherErr
+	}
+	return err
+}
+
+// Write the archive made from the delta manifest into dst, with content of
+// files taken from the delta or the archive of its base. Content is checked
+// against hashes of the manifest.
+func downloadDelta(ctx context.Context, repo Repository, instance Instance, dst io.Writer) error {
+	manifest, delta, err := openDelta(ctx, repo, instance)
+	if err != nil {
+		return err
+	}
+	defer delta.Close()
+
+	spool, err := newFileSpool()
+	if err != nil {
+		return err
+	}
+	defer spool.Close()
+	needed := map[string]bool{}
+	for _, entry := range manifest.Files {
+		if entry.Mode.IsRegular() {
+			needed[entry.SHA256] = true
+		}
+	}
+	if err = spoolArchiveFiles(ctx, repo, manifest.base(instance.Package), spool, needed); err != nil {
+		return fmt.Errorf("%w: %s@%s: %w", ErrInvalidDeltaBase, instance.Package, manifest.Base, err)
+	}
+
+	archive := tar.NewWriter(dst)
+	for _, entry := range manifest.Files {
+		if err = archive.WriteHeader(entry.tarHeader()); err != nil {
+			return err
+		}
+		if !entry.Mode.IsRegular() {
+			continue
+		}
+		content, ok := spool.open(entry.SHA256)
+		if !ok {
+			err = spoolDeltaFile(delta, spool, entry)
+			content, _ = spool.open(entry.SHA256)
+		}
+		if err == nil {
+			_, err = io.Copy(archive, content)
+		}
+		if err != nil {
+			return fmt.Errorf("%s@%s: %s: %w", instance.Package, instance.Id, entry.Path, err)
+		}
+	}
+	return archive.Close()
+}
+
+// Content of files is stored in the delta once, files with the same content
+// later on are taken from the spool.
+func spoolDeltaFile(delta *tarArchiveReader, spool *fileSpool, entry FileManifestEntry) error {
+	header, err := delta.Next()
+	if err == io.EOF {
+		return fmt.Errorf("%w: content of the file is not in the delta", ErrInvalidArchive)
+	}
+	if err != nil {
+		return err
+	}
+	if header.Name != entry.SHA256 || header.Size != entry.Size {
+		return fmt.Errorf("%w: delta has %s instead of the file", ErrInvalidArchive, header.Name)
+	}
+	hash, _, err := spool.add(delta, keepAll)
+	if err == nil && hash != entry.SHA256 {
+		err = fmt.Errorf("%w: content of the file is not %s", ErrHashMismatch, entry.SHA256)
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+package shop
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+	"testing"
+)
+
+func TestDelta(t *testing.T) {
+	tests := []struct {
+		name            string
+		base            testTree
+		baseCompression string
+		tree            testTree
+		// Content of files stored in the delta.
+		stored []string
+	}{
+		{
+			name:   "added file",
+			base:   testTree{"a": "a"},
+			tree:   testTree{"a": "a", "b": "b"},
+			stored: []string{"b"},
+		},
+		{
+			name:   "changed and removed files",
+			base:   testTree{"a": "a", "b": "b"},
+			tree:   testTree{"a": "changed"},
+			stored: []string{"changed"},
+		},
+		{
+			name: "moved file",
+			base: testTree{"a": "a"},
+			tree: testTree{"d/a": "a"},
+		},
+		{
+			name:   "same content twice",
+			base:   testTree{"a": "a"},
+			tree:   testTree{"x": "new", "y": "new", "z": "a"},
+			stored: []string{"new"},
+		},
+		{
+			name: "symlink",
+			base: testTree{"a": "a"},
+			tree: testTree{"a": "a", "l": "->a"},
+		},
+		{
+			name:            "base not stored file by file",
+			base:            testTree{"a": "a"},
+			baseCompression: CompressionGzip,
+			tree:            testTree{"a": "a", "b": "b"},
+			stored:          []string{"b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			registry := newTestRegistry(t)
+			baseCompression := test.baseCompression
+			if baseCompression == "" {
+				baseCompression = CompressionFiles
+			}
+			base, err := uploadTestInstance(t, registry, test.base, baseCompression, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			instance, err := uploadTestInstance(t, registry, test.tree, CompressionDelta, base)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var archive bytes.Buffer
+			if err = registry.DownloadPackageInstance(ctx, *instance, &archive); err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			if err = ExtractArchive(&archive, dir, ExtractOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			checkTree(t, dir, test.tree)
+
+			repo, err := registry.packageRepository(ctx, "test/pkg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			manifest, delta, err := openDelta(ctx, repo, *instance)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer delta.Close()
+			if manifest.Base != base.Id || manifest.BaseCompression != baseCompression {
+				t.Errorf("got delta against %s (%s), want %s (%s)", manifest.Base, manifest.BaseCompression, base.Id, baseCompression)
+			}
+			var got, want []string
+			for {
+				header, err := delta.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, header.Name)
+			}
+			for _, content := range test.stored {
+				sum := sha256.Sum256([]byte(content))
+				want = append(want, hex.EncodeToString(sum[:]))
+			}
+			sort.Strings(got)
+			sort.Strings(want)
+			if len(got) != len(want) {
+				t.Fatalf("got stored files %v, want %v", got, want)
+			}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Errorf("got stored files %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package shop
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Package required by an instance, resolved the same way as packages of
+// ensure files.
+type Dependency struct {
+	Package string `json:"package"`
+	Version string `json:"version"`
+}
+
+// Parse "package[@version]", version is DefaultVersion if omitted.
+func ParseDependency(spec string) (Dependency, error) {
+	name, version, ok := strings.Cut(spec, "@")
+	if !ok {
+		version = DefaultVersion
+	}
+	if !IsValidPackageName(name) {
+		return Dependency{}, fmt.Errorf("%w: %s", ErrInvalidPackageName, name)
+	}
+	if version == "" {
+		return Dependency{}, fmt.Errorf("%w: %s", ErrVersionNotFound, spec)
+	}
+	return Dependency{Package: name, Version: version}, nil
+}
+
+func (d Dependency) String() string {
+	return d.Package + "@" + d.Version
+}
+
+// Instance of a package from the closure of ResolveDependencies.
+type ResolvedPackage struct {
+	// Name the package was required by, which differs from the instance
+	// package for moved packages.
+	Package  string   `json:"package"`
+	Version  string   `json:"version"`
+	Instance Instance `json:"instance"`
+	// Packages which depend on this one.
+	RequiredBy []string `json:"required_by,omitempty"`
+}
+
+// Resolve the packages and everything they depend on. Requested packages
+// come first, in the same order. Every package is installed once, so
+// requirements which resolve a package to different instances fail with
+// ErrDependencyConflict.
+func ResolveDependencies(ctx context.Context, registry Registry, packages []EnsurePackage) ([]ResolvedPackage, error) {
+	var result []ResolvedPackage
+	index := map[string]int{}
+
+	type requirement struct {
+		Dependency
+		by string
+	}
+	queue := make([]requirement, 0, len(packages))
+	for _, pkg := range packages {
+		queue = append(queue, requirement{Dependency: Dependency{Package: pkg.Package, Version: pkg.Version}})
+	}
+
+	for len(queue) > 0 {
+		req := queue[0]
+		queue = queue[1:]
+
+		i, seen := index[req.Package]
+		if seen && result[i].Version == req.Version {
+			addRequiredBy(&result[i], req.by)
+			continue
+		}
+
+		instance, err := registry.ResolveVersion(ctx, req.Package, req.Version)
+		if err != nil {
+			if req.by != "" {
+				err = fmt.Errorf("%s: %w", req.by, err)
+			}
+			return nil, err
+		}
+		if seen {
+			if result[i].Instance.Id != instance.Id {
+				return nil, fmt.Errorf("%w: %s resolves to %s for %s and to %s for %s", ErrDependencyConflict,
+					req.Package, result[i].Instance.Id, describeRequirement(result[i]), instance.Id, describeBy(req.by))
+			}
+			addRequiredBy(&result[i], req.by)
+			continue
+		}
+
+		index[req.Package] = len(result)
+		resolved := ResolvedPackage{Package: req.Package, Version: req.Version, Instance: *instance}
+		addRequiredBy(&resolved, req.by)
+		result = append(result, resolved)
+		for _, dep := range instance.Dependencies {
+			queue = append(queue, requirement{Dependency: dep, by: req.Package})
+		}
+	}
+	return result, nil
+}
+
+func addRequiredBy(pkg *ResolvedPackage, by string) {
+	if by == "" {
+		return
+	}
+	for _, other := range pkg.RequiredBy {
+		if other == by {
+			return
+		}
+	}
+	pkg.RequiredBy = append(pkg.RequiredBy, by)
+}
+
+func describeRequirement(pkg ResolvedPackage) string {
+	if len(pkg.RequiredBy) == 0 {
+		return describeBy("")
+	}
+	return strings.Join(pkg.RequiredBy, ", ")
+}
+
+func describeBy(by string) string {
+	if by == "" {
+		return "the request"
+	}
+	return by
+}
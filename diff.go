@@ -0,0 +1,82 @@
+package shop
+
+import (
+	"context"
+	"io/fs"
+	"sort"
+)
+
+const (
+	FileAdded   = "added"
+	FileRemoved = "removed"
+	FileChanged = "changed"
+)
+
+// Difference of a file between two instances. Sizes and modes of missing
+// files are zero.
+type FileChange struct {
+	Change  string      `json:"change"`
+	Path    string      `json:"path"`
+	OldSize int64       `json:"old_size"`
+	NewSize int64       `json:"new_size"`
+	OldMode fs.FileMode `json:"old_mode"`
+	NewMode fs.FileMode `json:"new_mode"`
+}
+
+// Compare files of two archive listings, directories are skipped. File is
+// changed if its content or mode differs. Changes are sorted by path.
+func DiffArchives(from, to []ArchiveFile) []FileChange {
+	oldFiles := map[string]ArchiveFile{}
+	for _, file := range from {
+		if !file.Mode.IsDir() {
+			oldFiles[file.Path] = file
+		}
+	}
+
+	changes := []FileChange{}
+	for _, file := range to {
+		if file.Mode.IsDir() {
+			continue
+		}
+		change := FileChange{
+			Change:  FileAdded,
+			Path:    file.Path,
+			NewSize: file.Size,
+			NewMode: file.Mode,
+		}
+		if oldFile, ok := oldFiles[file.Path]; ok {
+			delete(oldFiles, file.Path)
+			if oldFile.Hash == file.Hash && oldFile.Mode == file.Mode && oldFile.Target == file.Target {
+				continue
+			}
+			change.Change = FileChanged
+			change.OldSize = oldFile.Size
+			change.OldMode = oldFile.Mode
+		}
+		changes = append(changes, change)
+	}
+	for _, file := range oldFiles {
+		changes = append(changes, FileChange{
+			Change:  FileRemoved,
+			Path:    file.Path,
+			OldSize: file.Size,
+			OldMode: file.Mode,
+		})
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// List files of both instances and compare them.
+func DiffPackageInstances(ctx context.Context, registry Registry, from, to Instance) ([]FileChange, error) {
+	fromFiles, err := ListPackageInstanceFiles(ctx, registry, from)
+	if err != nil {
+		return nil, err
+	}
+	toFiles, err := ListPackageInstanceFiles(ctx, registry, to)
+	if err != nil {
+		return nil, err
+	}
+	return DiffArchives(fromFiles, toFiles), nil
+}
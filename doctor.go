@@ -0,0 +1,180 @@
+package shop
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	DiagnosticOk      = "ok"
+	DiagnosticWarning = "warning"
+	DiagnosticError   = "error"
+
+	// Object written and deleted by the write probe.
+	DiagnosticProbeKey = ".shop-doctor-probe"
+	// Larger clock skew breaks signed urls and cache TTLs.
+	DiagnosticMaxClockSkew = time.Minute
+)
+
+// Result of a single check made by shop doctor.
+type Diagnostic struct {
+	Check   string `json:"check"`
+	Target  string `json:"target"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	// What could be done about the problem.
+	Hint string `json:"hint,omitempty"`
+}
+
+// Check that manifests of the registry and its repositories could be read.
+// With probe an object is written into every writable repository and
+// deleted, to check permissions and clock skew against the storage.
+func (c *RegistryImpl) Diagnose(ctx context.Context, probe bool) []Diagnostic {
+	var result []Diagnostic
+
+	manifest, err := c.GetManifest(ctx)
+	if err != nil {
+		return append(result, Diagnostic{
+			Check:   "manifest",
+			Target:  c.cfg.URL,
+			Status:  DiagnosticError,
+			Message: err.Error(),
+			Hint:    "Check the registry url and credentials (shop auth login).",
+		})
+	}
+	result = append(result, Diagnostic{
+		Check:   "manifest",
+		Target:  c.cfg.URL,
+		Status:  DiagnosticOk,
+		Message: fmt.Sprintf("registry %s, api version %s", manifest.Name, manifest.ApiVersion),
+	})
+	if manifest.ApiVersion != "" && manifest.ApiVersion != LatestVersion {
+		result = append(result, Diagnostic{
+			Check:   "api version",
+			Target:  c.cfg.URL,
+			Status:  DiagnosticWarning,
+			Message: fmt.Sprintf("registry has api version %s, shop writes %s", manifest.ApiVersion, LatestVersion),
+			Hint:    "Use shop version matching the registry.",
+		})
+	}
+
+	names := []string{""}
+	for name := range c.repositories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		repo := c.rootRepository
+		if name != "" {
+			repo = c.repositories[name]
+		}
+		result = append(result, diagnoseRepository(ctx, repo, probe)...)
+	}
+	return result
+}
+
+// Http repositories are listed by index.json files, which are not updated by
+// writes through other urls.
+func diagnoseRepositoryIndex(ctx context.Context, repo Repository) Diagnostic {
+	diagnostic := Diagnostic{
+		Check:  "index",
+		Target: repo.GetConfig().URL,
+		Hint:   "Regenerate listings with shop repo index on the writable url of the repository after writes.",
+	}
+	var index RepositoryIndex
+	if err := repo.GetJSON(ctx, RepositoryIndexKey, &index); err != nil {
+		diagnostic.Status = DiagnosticWarning
+		diagnostic.Message = err.Error()
+		return diagnostic
+	}
+	diagnostic.Status = DiagnosticOk
+	diagnostic.Message = fmt.Sprintf("listings generated at %s, objects written since are not listed", index.UpdatedAt.Format(time.RFC3339))
+	return diagnostic
+}
+
+func diagnoseRepository(ctx context.Context, repo Repository, probe bool) []Diagnostic {
+	cfg := repo.GetConfig()
+
+	repoManifest, err := repo.GetManifest(ctx)
+	if err != nil {
+		return []Diagnostic{{
+			Check:   "repository",
+			Target:  cfg.URL,
+			Status:  DiagnosticError,
+			Message: err.Error(),
+			Hint:    "Check the repository url, network access and credentials.",
+		}}
+	}
+
+	result := []Diagnostic{{
+		Check:   "repository",
+		Target:  cfg.URL,
+		Status:  DiagnosticOk,
+		Message: "repository " + repoManifest.Name,
+	}}
+	if repoManifest.URL != cfg.URL {
+		result = append(result, Diagnostic{
+			Check:   "repository",
+			Target:  cfg.URL,
+			Status:  DiagnosticWarning,
+			Message: "manifest has url " + repoManifest.URL,
+			Hint:    "Repository was moved or is accessed through a mirror.",
+		})
+	}
+	if u, err := url.Parse(cfg.URL); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		result = append(result, diagnoseRepositoryIndex(ctx, repo))
+	}
+
+	if !probe || !cfg.Write {
+		return result
+	}
+	return append(result, probeRepository(ctx, repo))
+}
+
+func probeRepository(ctx context.Context, repo Repository) Diagnostic {
+	cfg := repo.GetConfig()
+	diagnostic := Diagnostic{
+		Check:  "write",
+		Target: cfg.URL,
+		Status: DiagnosticError,
+		Hint:   "Check write permissions of the credentials, or disable write in the config.",
+	}
+
+	start := time.Now()
+	data := []byte(strconv.FormatInt(start.UnixNano(), 10))
+	if err := repo.Put(ctx, DiagnosticProbeKey, bytes.NewReader(data)); err != nil {
+		diagnostic.Message = err.Error()
+		return diagnostic
+	}
+	info, statErr := repo.Stat(ctx, DiagnosticProbeKey)
+	end := time.Now()
+	if err := repo.Delete(ctx, DiagnosticProbeKey); err != nil {
+		diagnostic.Message = fmt.Sprintf("probe was written, but not deleted: %v", err)
+		return diagnostic
+	}
+
+	diagnostic.Status = DiagnosticOk
+	diagnostic.Message = "probe was written and deleted"
+	diagnostic.Hint = ""
+	if statErr != nil || info.ModTime.IsZero() {
+		return diagnostic
+	}
+
+	// Storage time is compared with the middle of the request.
+	skew := info.ModTime.Sub(start.Add(end.Sub(start) / 2))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > DiagnosticMaxClockSkew+end.Sub(start) {
+		diagnostic.Status = DiagnosticWarning
+		diagnostic.Message = fmt.Sprintf("clock differs from the storage by %s", skew.Round(time.Second))
+		diagnostic.Hint = "Synchronize the system clock (NTP)."
+	}
+	return diagnostic
+}
@@ -0,0 +1,282 @@
+package shop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// Suffix of archives being downloaded. They are kept when the download
+	// fails, so the next one continues from where it stopped.
+	PartialDownloadSuffix = ".partial"
+	// Progress of partial downloads is recorded after this many bytes.
+	partialSyncInterval = 16 << 20
+	// Size of ranges large archives are downloaded in parallel by.
+	DownloadChunkSize = 16 << 20
+)
+
+// Progress of the partial download, recorded next to the partial file. Only
+// bytes synced to disk are counted, the rest is downloaded again.
+type partialDownload struct {
+	Package     string `json:"package"`
+	Id          string `json:"id"`
+	Compression string `json:"compression"`
+	Size        int64  `json:"size"`
+}
+
+// Download the instance archive into the file at path. It's written into the
+// partial file next to it, and renamed once verified. A partial file left by
+// an interrupted download of the same instance is continued.
+func DownloadPackageInstanceToFile(ctx context.Context, registry Registry, instance Instance, path string) error {
+	partial := path + PartialDownloadSuffix
+	progress := partial + ".json"
+
+	offset := readPartialProgress(progress, instance)
+	err := downloadPartial(ctx, registry, instance, partial, progress, offset)
+	if offset > 0 && (errors.Is(err, ErrHashMismatch) || errors.Is(err, ErrInvalidRange)) {
+		// Partial file is not the start of the archive.
+		err = downloadPartial(ctx, registry, instance, partial, progress, 0)
+	}
+	if errors.Is(err, ErrHashMismatch) {
+		os.Remove(partial)
+		os.Remove(progress)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = os.Rename(partial, path); err != nil {
+		return err
+	}
+	os.Remove(progress)
+	return nil
+}
+
+// Bytes of the partial file which could be kept, none if it's a download of
+// another instance.
+func readPartialProgress(progress string, instance Instance) int64 {
+	data, err := os.ReadFile(progress)
+	if err != nil {
+		return 0
+	}
+	var record partialDownload
+	if json.Unmarshal(data, &record) != nil || record.Package != instance.Package ||
+		record.Id != instance.Id || record.Compression != instance.Compression || record.Size < 0 {
+		return 0
+	}
+	return record.Size
+}
+
+func savePartialProgress(progress string, record partialDownload) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	tmp := progress + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, progress)
+}
+
+func downloadPartial(ctx context.Context, registry Registry, instance Instance, partial, progress string, offset int64) error {
+	file, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err != nil {
+		return err
+	} else if info.Size() < offset {
+		offset = 0
+	}
+	if err = file.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	w := &partialWriter{
+		file:     file,
+		progress: progress,
+		record: partialDownload{
+			Package:     instance.Package,
+			Id:          instance.Id,
+			Compression: instance.Compression,
+			Size:        offset,
+		},
+	}
+	if err = savePartialProgress(progress, w.record); err != nil {
+		return err
+	}
+
+	err = registry.DownloadPackageInstanceFrom(ctx, instance, io.NewSectionReader(file, 0, offset), w)
+	if syncErr := w.sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Writer of the partial file which records progress as it goes.
+type partialWriter struct {
+	file     *os.File
+	progress string
+	record   partialDownload
+	unsynced int64
+}
+
+func (w *partialWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.unsynced += int64(n)
+	if err == nil && w.unsynced >= partialSyncInterval {
+		err = w.sync()
+	}
+	return n, err
+}
+
+func (w *partialWriter) sync() error {
+	if w.unsynced == 0 {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.record.Size += w.unsynced
+	w.unsynced = 0
+	return savePartialProgress(w.progress, w.record)
+}
+
+// Drops the first skip bytes written into it.
+type skipWriter struct {
+	w    io.Writer
+	skip int64
+}
+
+func (w *skipWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if w.skip >= int64(n) {
+		w.skip -= int64(n)
+		return n, nil
+	}
+	_, err := w.w.Write(p[w.skip:])
+	w.skip = 0
+	return n, err
+}
+
+type parallelRangesRepository interface {
+	parallelRanges() bool
+}
+
+// Copy the CAS archive of the instance from offset. Large archives are read
+// in DownloadChunkSize ranges, as many at once as there are jobs, when the
+// repository reads ranges on its own.
+func copyArchiveFrom(ctx context.Context, repo Repository, instance Instance, offset int64, dst io.Writer) error {
+	key := instance.CASKey()
+	jobs := repositoryJobs(repo.GetConfig())
+	parallel, ok := repo.(parallelRangesRepository)
+	ranged := ok && jobs > 1 && parallel.parallelRanges()
+
+	size := int64(-1)
+	if offset > 0 || ranged {
+		info, err := repo.Stat(ctx, key)
+		if err != nil {
+			return err
+		}
+		if offset > info.Size {
+			return fmt.Errorf("%w: %s: offset %d, size %d", ErrInvalidRange, key, offset, info.Size)
+		}
+		size = info.Size
+	}
+	if ranged && size-offset > DownloadChunkSize {
+		return copyRanges(ctx, repo, key, offset, size, jobs, dst)
+	}
+
+	var body io.ReadCloser
+	var err error
+	if offset == 0 {
+		// Whole archives are read through the cache and mirrors, ranges
+		// only from the backend.
+		body, err = repo.Get(ctx, key)
+	} else {
+		body, err = repo.GetRange(ctx, key, offset, -1)
+	}
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.Copy(dst, body)
+	return err
+}
+
+type downloadedChunk struct {
+	data []byte
+	err  error
+}
+
+// Read the object from offset to size in chunks, jobs of them at once, and
+// write them into dst in order. Chunks are held in memory until they are
+// written, so there are at most jobs of them.
+func copyRanges(ctx context.Context, repo Repository, key string, offset, size int64, jobs int, dst io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunks := make([]chan downloadedChunk, (size-offset+DownloadChunkSize-1)/DownloadChunkSize)
+	for i := range chunks {
+		chunks[i] = make(chan downloadedChunk, 1)
+	}
+	slots := make(chan struct{}, jobs)
+	go func() {
+		for i := range chunks {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func() {
+				start := offset + int64(i)*DownloadChunkSize
+				data, err := readRange(ctx, repo, key, start, min(DownloadChunkSize, size-start))
+				chunks[i] <- downloadedChunk{data, err}
+			}()
+		}
+	}()
+
+	for _, chunk := range chunks {
+		var result downloadedChunk
+		select {
+		case result = <-chunk:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if result.err != nil {
+			return result.err
+		}
+		if _, err := dst.Write(result.data); err != nil {
+			return err
+		}
+		<-slots
+	}
+	return nil
+}
+
+func readRange(ctx context.Context, repo Repository, key string, offset, length int64) ([]byte, error) {
+	body, err := repo.GetRange(ctx, key, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data := make([]byte, length)
+	if _, err = io.ReadFull(body, data); err != nil {
+		return nil, fmt.Errorf("%s: range %d-%d: %w", key, offset, offset+length-1, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,164 @@
+package shop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadPackageInstanceToFile(t *testing.T) {
+	small := testTree{"a": strings.Repeat("a", 1000), "d/b": "b"}
+	// Archive downloaded in parallel ranges.
+	large := testTree{"a": strings.Repeat("a", 2*DownloadChunkSize+1000)}
+	junk := func(archive []byte) []byte {
+		return bytes.Repeat([]byte{'x'}, len(archive)/2)
+	}
+	half := func(archive []byte) []byte {
+		return archive[:len(archive)/2]
+	}
+
+	tests := []struct {
+		name        string
+		tree        testTree
+		compression string
+		// Partial file left by the previous download, and its progress
+		// record.
+		partial  func(archive []byte) []byte
+		progress func(instance Instance, partial []byte) partialDownload
+		corrupt  bool
+		err      error
+	}{
+		{
+			name:        "fresh",
+			tree:        small,
+			compression: CompressionGzip,
+		},
+		{
+			name:        "continued",
+			tree:        small,
+			compression: CompressionGzip,
+			partial:     half,
+		},
+		{
+			name:        "continued archive made from manifest",
+			tree:        small,
+			compression: CompressionFiles,
+			partial:     half,
+		},
+		{
+			name:        "continued in parallel ranges",
+			tree:        large,
+			compression: CompressionNone,
+			partial: func(archive []byte) []byte {
+				return archive[:DownloadChunkSize/2]
+			},
+		},
+		{
+			name:        "partial is not the start of the archive",
+			tree:        small,
+			compression: CompressionGzip,
+			partial:     junk,
+		},
+		{
+			name:        "partial is longer than the archive",
+			tree:        small,
+			compression: CompressionGzip,
+			partial: func(archive []byte) []byte {
+				return append(append([]byte{}, archive...), 'x')
+			},
+		},
+		{
+			name:        "partial of another instance",
+			tree:        small,
+			compression: CompressionGzip,
+			partial:     junk,
+			progress: func(instance Instance, partial []byte) partialDownload {
+				return partialDownload{Package: instance.Package, Id: "sha256-" + strings.Repeat("0", 64), Compression: instance.Compression, Size: int64(len(partial))}
+			},
+		},
+		{
+			name:        "progress is ahead of partial",
+			tree:        small,
+			compression: CompressionGzip,
+			partial:     half,
+			progress: func(instance Instance, partial []byte) partialDownload {
+				return partialDownload{Package: instance.Package, Id: instance.Id, Compression: instance.Compression, Size: int64(len(partial)) + 1}
+			},
+		},
+		{
+			name:        "corrupted archive",
+			tree:        small,
+			compression: CompressionGzip,
+			partial:     half,
+			corrupt:     true,
+			err:         ErrHashMismatch,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			registry := newTestRegistry(t)
+			instance, err := uploadTestInstance(t, registry, test.tree, test.compression, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var archive bytes.Buffer
+			if err = registry.DownloadPackageInstance(ctx, *instance, &archive); err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(t.TempDir(), "archive")
+			partial, progress := path+PartialDownloadSuffix, path+PartialDownloadSuffix+".json"
+			if test.partial != nil {
+				data := test.partial(archive.Bytes())
+				record := partialDownload{Package: instance.Package, Id: instance.Id, Compression: instance.Compression, Size: int64(len(data))}
+				if test.progress != nil {
+					record = test.progress(*instance, data)
+				}
+				if err = os.WriteFile(partial, data, 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err = savePartialProgress(progress, record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.corrupt {
+				repo, err := registry.packageRepository(ctx, instance.Package)
+				if err == nil {
+					err = repo.Delete(ctx, instance.CASKey())
+				}
+				if err == nil {
+					err = repo.Put(ctx, instance.CASKey(), bytes.NewReader(junk(archive.Bytes())))
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = DownloadPackageInstanceToFile(ctx, registry, *instance, path)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			for _, p := range []string{partial, progress} {
+				if _, err := os.Stat(p); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("%s is left after the download: %v", filepath.Base(p), err)
+				}
+			}
+			if test.err != nil {
+				return
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, archive.Bytes()) {
+				t.Errorf("got %d bytes of the archive, want %d", len(data), archive.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package shop
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	MutationWrite  = "write"
+	MutationRemove = "remove"
+	MutationCopy   = "copy"
+)
+
+// Repository change which DryRunFS recorded instead of making it.
+type Mutation struct {
+	Op  string `json:"op"`
+	URL string `json:"url"`
+	Key string `json:"key"`
+	// Size of the written object.
+	Size int64 `json:"size,omitempty"`
+	// Copied object.
+	Source string `json:"source,omitempty"`
+}
+
+// Mutations recorded by all DryRunFS sharing the plan, in order.
+type DryRunPlan struct {
+	lock      sync.Mutex
+	mutations []Mutation
+}
+
+func NewDryRunPlan() *DryRunPlan {
+	return &DryRunPlan{}
+}
+
+func (p *DryRunPlan) record(mutation Mutation) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.mutations = append(p.mutations, mutation)
+}
+
+func (p *DryRunPlan) Mutations() []Mutation {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]Mutation{}, p.mutations...)
+}
+
+// RepositoryFS wrapper which passes reads through and records writes into
+// the plan without making them. Reads don't see recorded writes. Write
+// capabilities of the wrapped backends are overridden, so they are never
+// reached through Unwrap.
+type DryRunFS struct {
+	fs   RepositoryFS
+	url  string
+	plan *DryRunPlan
+}
+
+func NewDryRunFS(fs RepositoryFS, cfg RepositoryConfig) RepositoryFS {
+	rawURL := cfg.URL
+	if u, err := url.Parse(rawURL); err == nil {
+		rawURL = u.Redacted()
+	}
+	return DryRunFS{
+		fs:   fs,
+		url:  rawURL,
+		plan: cfg.DryRun,
+	}
+}
+
+func (f DryRunFS) Unwrap() RepositoryFS {
+	return f.fs
+}
+
+func (f DryRunFS) record(op, key string, size int64) {
+	f.plan.record(Mutation{Op: op, URL: f.url, Key: key, Size: size})
+}
+
+func (f DryRunFS) Read(ctx context.Context, key string) ([]byte, error) {
+	return f.fs.Read(ctx, key)
+}
+
+func (f DryRunFS) Write(ctx context.Context, key string, data []byte) error {
+	f.record(MutationWrite, key, int64(len(data)))
+	return nil
+}
+
+func (f DryRunFS) WriteIf(ctx context.Context, key string, data []byte, etag string) error {
+	f.record(MutationWrite, key, int64(len(data)))
+	return nil
+}
+
+func (f DryRunFS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return f.fs.Open(ctx, key)
+}
+
+// Counts written bytes and records the write on Close.
+type dryRunWriter struct {
+	fs      DryRunFS
+	key     string
+	size    int64
+	aborted bool
+}
+
+func (w *dryRunWriter) Write(data []byte) (int, error) {
+	w.size += int64(len(data))
+	return len(data), nil
+}
+
+func (w *dryRunWriter) Abort() error {
+	w.aborted = true
+	return nil
+}
+
+func (w *dryRunWriter) Close() error {
+	if !w.aborted {
+		w.fs.record(MutationWrite, w.key, w.size)
+	}
+	return nil
+}
+
+func (f DryRunFS) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	return &dryRunWriter{fs: f, key: key}, nil
+}
+
+type dryRunUpload struct {
+	lock   sync.Mutex
+	writer dryRunWriter
+}
+
+func (u *dryRunUpload) UploadPart(ctx context.Context, number int, data []byte) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	_, err := u.writer.Write(data)
+	return err
+}
+
+func (u *dryRunUpload) Complete(ctx context.Context) error {
+	return u.writer.Close()
+}
+
+func (u *dryRunUpload) Abort(ctx context.Context) error {
+	return u.writer.Abort()
+}
+
+func (f DryRunFS) CreateMultipart(ctx context.Context, key string) (MultipartUpload, error) {
+	return &dryRunUpload{writer: dryRunWriter{fs: f, key: key}}, nil
+}
+
+func (f DryRunFS) MinPartSize() int64 {
+	if fs, ok := findCapability[MultipartFS](f.fs); ok {
+		return fs.MinPartSize()
+	}
+	return 0
+}
+
+// Prefixes don't exist on their own in most backends, so they are not
+// recorded.
+func (f DryRunFS) MakeDir(ctx context.Context, key string) error {
+	return nil
+}
+
+func (f DryRunFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	return f.fs.ListDir(ctx, key)
+}
+
+func (f DryRunFS) Remove(ctx context.Context, key string) error {
+	f.record(MutationRemove, key, 0)
+	return nil
+}
+
+func (f DryRunFS) RemoveAll(ctx context.Context, prefix string) error {
+	f.record(MutationRemove, strings.TrimSuffix(prefix, "/")+"/", 0)
+	return nil
+}
+
+func (f DryRunFS) Copy(ctx context.Context, src, dst string) error {
+	f.plan.record(Mutation{Op: MutationCopy, URL: f.url, Key: dst, Source: src})
+	return nil
+}
+
+func (f DryRunFS) Exists(ctx context.Context, key string) (bool, error) {
+	return f.fs.Exists(ctx, key)
+}
+
+func (f DryRunFS) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	return f.fs.Stat(ctx, key)
+}
@@ -0,0 +1,49 @@
+package shop
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidDuration = errors.New("Invalid duration")
+)
+
+// Like time.ParseDuration, but also accepts a leading number of days, e.g.
+// "90d" or "1d12h".
+func ParseDuration(text string) (time.Duration, error) {
+	days, rest, ok := strings.Cut(text, "d")
+	if !ok {
+		return time.ParseDuration(text)
+	}
+	n, err := strconv.ParseUint(days, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidDuration, text)
+	}
+	duration := time.Duration(n) * 24 * time.Hour
+	if rest != "" {
+		d, err := time.ParseDuration(rest)
+		if err != nil {
+			return 0, err
+		}
+		duration += d
+	}
+	return duration, nil
+}
+
+// time.Duration stored in config files as a string like "1m30s".
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) (err error) {
+	d.Duration, err = ParseDuration(string(text))
+	return
+}
@@ -0,0 +1,440 @@
+package shop
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Version used for packages listed without one.
+	DefaultVersion = "latest"
+
+	// Ensure file directive with the site root, relative to the file.
+	EnsureRootDirective = "$Root"
+	EnsureComment       = "#"
+	// Lockfile is stored next to the ensure file by default.
+	EnsureLockExtension = ".lock"
+	// Registry fingerprints in lockfiles are prefixed with the algorithm.
+	EnsureLockHashSHA256 = "sha256"
+
+	EnsureInstall = "install"
+	EnsureUpgrade = "upgrade"
+	EnsureRemove  = "remove"
+)
+
+var (
+	ErrInvalidEnsureFile = errors.New("Invalid ensure file")
+	ErrStaleEnsureLock   = errors.New("Lockfile does not match ensure file")
+	ErrRegistryDrift     = errors.New("Registry changed since the lockfile was resolved")
+)
+
+type EnsurePackage struct {
+	Package string `json:"package"`
+	Version string `json:"version"`
+}
+
+// List of packages which should be installed into the site root, one
+// "package [version]" per line. Package names and versions may be templates,
+// which are expanded for the host platform by Expand:
+//
+//	# Toolchain.
+//	$Root toolchain
+//	compilers/clang-17 latest
+//	tools/lld tag:os=linux
+//	tools/cmake/${platform} latest
+type EnsureFile struct {
+	Root     string
+	Packages []EnsurePackage
+	// Packages include all dependencies already, as in files pinned by a
+	// lockfile, so dependencies are not resolved again.
+	Closed bool
+}
+
+// Listed packages with their dependencies, unless the file is closed.
+func (f EnsureFile) ResolvePackages(ctx context.Context, registry Registry) ([]ResolvedPackage, error) {
+	if !f.Closed {
+		return ResolveDependencies(ctx, registry, f.Packages)
+	}
+
+	result := make([]ResolvedPackage, 0, len(f.Packages))
+	for _, pkg := range f.Packages {
+		instance, err := registry.ResolveVersion(ctx, pkg.Package, pkg.Version)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, ResolvedPackage{Package: pkg.Package, Version: pkg.Version, Instance: *instance})
+	}
+	return result, nil
+}
+
+func ParseEnsureFile(r io.Reader) (*EnsureFile, error) {
+	file := &EnsureFile{}
+	seen := map[string]int{}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, EnsureComment) {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if strings.HasPrefix(fields[0], "$") {
+			if fields[0] != EnsureRootDirective || len(fields) != 2 {
+				return nil, fmt.Errorf("%w: line %d: %s", ErrInvalidEnsureFile, n, line)
+			}
+			file.Root = fields[1]
+			continue
+		}
+
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%w: line %d: %s", ErrInvalidEnsureFile, n, line)
+		}
+		pkg := EnsurePackage{Package: fields[0], Version: DefaultVersion}
+		if len(fields) == 2 {
+			pkg.Version = fields[1]
+		}
+		if _, err := CurrentPlatform().Expand(pkg.Package + " " + pkg.Version); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidEnsureFile, n, err)
+		}
+		if !IsValidPackageNameTemplate(pkg.Package) {
+			return nil, fmt.Errorf("%w: line %d: %w: %s", ErrInvalidEnsureFile, n, ErrInvalidPackageName, pkg.Package)
+		}
+		if previous, ok := seen[pkg.Package]; ok {
+			return nil, fmt.Errorf("%w: line %d: %s is already listed on line %d", ErrInvalidEnsureFile, n, pkg.Package, previous)
+		}
+		seen[pkg.Package] = n
+		file.Packages = append(file.Packages, pkg)
+	}
+	return file, scanner.Err()
+}
+
+// Parse the ensure file, with root relative to its directory.
+func LoadEnsureFile(path string) (*EnsureFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	file, err := ParseEnsureFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if file.Root != "" && !filepath.IsAbs(file.Root) {
+		file.Root = filepath.Join(filepath.Dir(path), filepath.FromSlash(file.Root))
+	}
+	return file, nil
+}
+
+// Package version pinned by the lockfile.
+type EnsureLockPackage struct {
+	Package string `json:"package"`
+	// Version from the ensure file, or from the dependency declaration.
+	Version string `json:"version"`
+	// Hash of the archive, checked on download, so it pins the content.
+	// Lockfiles written before it was relied on have the hash key too, which
+	// is ignored.
+	Id string `json:"id"`
+	// Repo with the archive, empty for the root repo.
+	Repo string `json:"repo,omitempty"`
+	// Packages depending on this one, if it's not listed in the ensure file.
+	RequiredBy []string `json:"required_by,omitempty"`
+}
+
+// Ensure file with versions resolved into instances, so installs from it
+// are reproducible.
+type EnsureLockFile struct {
+	ApiVersion string `json:"api_version"`
+	// Registry the versions were resolved in and RegistryFingerprint of its
+	// manifest. Empty in lockfiles made by older versions.
+	Registry    string              `json:"registry,omitempty"`
+	Fingerprint string              `json:"fingerprint,omitempty"`
+	Packages    []EnsureLockPackage `json:"packages"`
+}
+
+// Hash of the registry name and its repos, which changes when repos are
+// added, removed or moved to other URLs.
+func RegistryFingerprint(manifest RegistryManifest) (string, error) {
+	type repo struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	}
+	fingerprint := struct {
+		Name  string          `json:"name"`
+		Root  repo            `json:"root"`
+		Repos map[string]repo `json:"repos"`
+	}{
+		Name:  manifest.Name,
+		Root:  repo{Name: manifest.RootRepo.Name, URL: manifest.RootRepo.URL},
+		Repos: map[string]repo{},
+	}
+	for name, r := range manifest.Repos {
+		fingerprint.Repos[name] = repo{Name: r.Name, URL: r.URL}
+	}
+
+	data, err := json.Marshal(fingerprint)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return EnsureLockHashSHA256 + ":" + hex.EncodeToString(sum[:]), nil
+}
+
+// Resolve versions of all packages and their dependencies into instances.
+func (f EnsureFile) Resolve(ctx context.Context, registry Registry) (*EnsureLockFile, error) {
+	manifest, err := registry.GetManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := RegistryFingerprint(*manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved, err := f.ResolvePackages(ctx, registry)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &EnsureLockFile{
+		ApiVersion:  LatestVersion,
+		Registry:    manifest.Name,
+		Fingerprint: fingerprint,
+		Packages:    make([]EnsureLockPackage, 0, len(resolved)),
+	}
+	for i, pkg := range resolved {
+		manifest, err := registry.GetPackage(ctx, pkg.Instance.Package)
+		if err != nil {
+			return nil, err
+		}
+		locked := EnsureLockPackage{
+			Package: pkg.Package,
+			Version: pkg.Version,
+			Id:      pkg.Instance.Id,
+			Repo:    manifest.Repo,
+		}
+		if i >= len(f.Packages) {
+			locked.RequiredBy = pkg.RequiredBy
+		}
+		lock.Packages = append(lock.Packages, locked)
+	}
+	return lock, nil
+}
+
+// Closed ensure file with versions replaced by instance ids from the
+// lockfile, including the locked dependencies. Fails with ErrStaleEnsureLock
+// if the ensure file was changed after the lockfile was generated.
+func (l EnsureLockFile) Pin(file EnsureFile) (*EnsureFile, error) {
+	locked := map[string]EnsureLockPackage{}
+	var deps []EnsureLockPackage
+	for _, pkg := range l.Packages {
+		if len(pkg.RequiredBy) > 0 {
+			deps = append(deps, pkg)
+		} else {
+			locked[pkg.Package] = pkg
+		}
+	}
+	if len(locked) != len(file.Packages) {
+		return nil, fmt.Errorf("%w: lockfile has %d packages, ensure file has %d", ErrStaleEnsureLock, len(locked), len(file.Packages))
+	}
+
+	pinned := &EnsureFile{
+		Root:     file.Root,
+		Packages: make([]EnsurePackage, 0, len(l.Packages)),
+		Closed:   true,
+	}
+	listed := make([]EnsureLockPackage, 0, len(l.Packages))
+	for _, pkg := range file.Packages {
+		lock, ok := locked[pkg.Package]
+		if !ok || lock.Version != pkg.Version {
+			return nil, fmt.Errorf("%w: %s@%s", ErrStaleEnsureLock, pkg.Package, pkg.Version)
+		}
+		listed = append(listed, lock)
+	}
+	for _, lock := range append(listed, deps...) {
+		if !IsValidInstanceId(lock.Id) {
+			return nil, fmt.Errorf("%w: %s@%s", ErrInvalidInstanceId, lock.Package, lock.Id)
+		}
+		pinned.Packages = append(pinned.Packages, EnsurePackage{Package: lock.Package, Version: lock.Id})
+	}
+	return pinned, nil
+}
+
+// Fail with ErrRegistryDrift if the registry manifest or repos of the locked
+// packages changed since the lockfile was resolved, so the instances could
+// come from other places.
+func (l EnsureLockFile) CheckRegistry(ctx context.Context, registry Registry) error {
+	if l.Fingerprint == "" {
+		return nil
+	}
+
+	manifest, err := registry.GetManifest(ctx)
+	if err != nil {
+		return err
+	}
+	fingerprint, err := RegistryFingerprint(*manifest)
+	if err != nil {
+		return err
+	}
+	if fingerprint != l.Fingerprint {
+		return fmt.Errorf("%w: %s has fingerprint %s, lockfile has %s of %s", ErrRegistryDrift, manifest.Name, fingerprint, l.Fingerprint, l.Registry)
+	}
+
+	for _, locked := range l.Packages {
+		instance, err := registry.ResolveVersion(ctx, locked.Package, locked.Id)
+		if err != nil {
+			return err
+		}
+		pkg, err := registry.GetPackage(ctx, instance.Package)
+		if err != nil {
+			return err
+		}
+		if pkg.Repo != locked.Repo {
+			return fmt.Errorf("%w: %s is in repo %q, lockfile has %q", ErrRegistryDrift, locked.Package, pkg.Repo, locked.Repo)
+		}
+	}
+	return nil
+}
+
+func LoadEnsureLockFile(path string) (*EnsureLockFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &EnsureLockFile{}
+	if err = json.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return lock, nil
+}
+
+func SaveEnsureLockFile(lock EnsureLockFile, path string) error {
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Package change made by Site.Ensure.
+type EnsureChange struct {
+	Action     string `json:"action"`
+	Package    string `json:"package"`
+	Id         string `json:"id,omitempty"`
+	PreviousId string `json:"previous_id,omitempty"`
+}
+
+// Bring the site in line with the ensure file: install listed packages and
+// their dependencies which are missing or resolve to other instances, and
+// uninstall packages which are neither listed nor required. Archives are downloaded (or extracted into the cache dir of the
+// registry) in parallel, registry.Jobs() at once if jobs is zero, before
+// anything is changed. With dryRun only the changes are returned.
+func (s Site) Ensure(ctx context.Context, registry Registry, file EnsureFile, jobs int, dryRun bool) ([]EnsureChange, error) {
+	if jobs < 1 {
+		jobs = registry.Jobs()
+	}
+
+	installed, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	previous := map[string]string{}
+	for _, pkg := range installed {
+		previous[pkg.Package] = pkg.Id
+	}
+
+	resolved, err := file.ResolvePackages(ctx, registry)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []EnsureChange
+	var instances []Instance
+	// Names from the ensure file or dependencies, instances of moved
+	// packages are installed under their old names.
+	var names []string
+	listed := map[string]bool{}
+	for _, pkg := range resolved {
+		listed[pkg.Package] = true
+		instance := pkg.Instance
+
+		id, ok := previous[pkg.Package]
+		switch {
+		case !ok:
+			changes = append(changes, EnsureChange{Action: EnsureInstall, Package: pkg.Package, Id: instance.Id})
+		case id != instance.Id:
+			changes = append(changes, EnsureChange{Action: EnsureUpgrade, Package: pkg.Package, Id: instance.Id, PreviousId: id})
+		default:
+			continue
+		}
+		instances = append(instances, instance)
+		names = append(names, pkg.Package)
+	}
+
+	if dryRun {
+		for _, pkg := range installed {
+			if !listed[pkg.Package] {
+				changes = append(changes, EnsureChange{Action: EnsureRemove, Package: pkg.Package, PreviousId: pkg.Id})
+			}
+		}
+		return changes, nil
+	}
+
+	locals, err := fetchInstances(ctx, registry, instances, jobs)
+	defer func() {
+		for _, local := range locals {
+			local.Close()
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, instance := range instances {
+		instance.Package = names[i]
+		if _, err = s.installLocal(ctx, instance, locals[i]); err != nil {
+			return changes[:i], fmt.Errorf("%s@%s: %w", instance.Package, instance.Id, err)
+		}
+	}
+
+	for _, pkg := range installed {
+		if listed[pkg.Package] {
+			continue
+		}
+		if err = s.Uninstall(ctx, pkg.Package); err != nil {
+			return changes, fmt.Errorf("%s: %w", pkg.Package, err)
+		}
+		changes = append(changes, EnsureChange{Action: EnsureRemove, Package: pkg.Package, PreviousId: pkg.Id})
+	}
+	return changes, nil
+}
+
+func fetchInstances(ctx context.Context, registry Registry, instances []Instance, jobs int) ([]localInstance, error) {
+	locals := make([]localInstance, len(instances))
+	err := runJobs(ctx, jobs, len(instances), func(ctx context.Context, i int) error {
+		instance := instances[i]
+		local, err := fetchInstance(ctx, registry, instance)
+		if err != nil {
+			return fmt.Errorf("%s@%s: %w", instance.Package, instance.Id, err)
+		}
+		locals[i] = local
+		return nil
+	})
+	return locals, err
+}
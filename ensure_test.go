@@ -0,0 +1,49 @@
+package shop
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadEnsureLockFile(t *testing.T) {
+	id := "sha256-" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{
+			name: "current",
+			data: `{"api_version":"v1","packages":[{"package":"a","version":"latest","id":"` + id + `"}]}`,
+		},
+		{
+			name: "with archive hash",
+			data: `{"api_version":"v1","packages":[{"package":"a","version":"latest","id":"` + id + `","hash":"sha256:00"}]}`,
+		},
+		{
+			name:    "invalid",
+			data:    `{"packages":`,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "shop.ensure.lock")
+			if err := os.WriteFile(path, []byte(test.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			lock, err := LoadEnsureLockFile(path)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(lock.Packages) != 1 || lock.Packages[0].Id != id {
+				t.Errorf("got packages %+v, want a@%s", lock.Packages, id)
+			}
+		})
+	}
+}
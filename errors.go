@@ -2,6 +2,9 @@ package shop
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
+	"os"
 )
 
 var (
@@ -9,9 +12,81 @@ var (
 	ErrRegistryAdminIsNotAllowed = errors.New("Admin action on the registry is not enabled in configuration")
 	ErrRegistryWriteIsNotAllowed = errors.New("Write action on the registry is not enabled in configuration")
 	ErrUnknownRepo               = errors.New("Registry does not have repo")
+	ErrRepoInUse                 = errors.New("Repo is used by packages")
 	ErrInvalidPackageName        = errors.New("Invalid package name")
 	ErrInvalidInstanceId         = errors.New("Invalid instance id")
 	ErrInvalidReferenceName      = errors.New("Invalid reference name")
 	ErrInvalidTagName            = errors.New("Invalid tag name")
 	ErrInvalidTagValue           = errors.New("Invalid tag value")
+	ErrHashMismatch              = errors.New("Content hash does not match instance id")
+	ErrInvalidArchive            = errors.New("Invalid instance archive")
+	ErrFileNotInArchive          = errors.New("File is not in instance archive")
+	ErrUploadAborted             = errors.New("Upload aborted")
+	ErrConditionFailed           = errors.New("Object was changed concurrently")
+	ErrInvalidURLTTL             = errors.New("Invalid download link ttl")
+	ErrVersionNotFound           = errors.New("Version not found")
+	ErrAmbiguousVersion          = errors.New("Version matches multiple instances")
+	ErrPackageExists             = errors.New("Package already exists")
+	ErrPackageMoved              = errors.New("Package was moved")
+	ErrInstanceYanked            = errors.New("Instance is yanked")
+	ErrInstanceExists            = errors.New("Instance already exists")
+	ErrPackageDeprecated         = errors.New("Package is deprecated")
+	ErrDependencyConflict        = errors.New("Dependencies require different instances of a package")
+	ErrArchiveShared             = errors.New("Archive is shared with other instances")
 )
+
+type HTTPStatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Status)
+}
+
+func (e HTTPStatusError) Is(target error) bool {
+	switch target {
+	case os.ErrNotExist:
+		return e.StatusCode == http.StatusNotFound
+	case os.ErrExist, ErrConditionFailed:
+		return e.StatusCode == http.StatusPreconditionFailed
+	case os.ErrPermission:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	default:
+		return false
+	}
+}
+
+func (e HTTPStatusError) Transient() bool {
+	return e.StatusCode == http.StatusRequestTimeout ||
+		e.StatusCode == http.StatusTooManyRequests ||
+		(e.StatusCode >= 500 && e.StatusCode != http.StatusNotImplemented)
+}
+
+// Failed repository operation. Message is the one of Err, the rest is for
+// tools which report where the error happened.
+type RepositoryError struct {
+	Repo string
+	Op   string
+	Key  string
+	Err  error
+}
+
+func (e *RepositoryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RepositoryError) Unwrap() error {
+	return e.Err
+}
+
+func NewHTTPStatusError(resp *http.Response) error {
+	return HTTPStatusError{
+		Method:     resp.Request.Method,
+		URL:        resp.Request.URL.Redacted(),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+	}
+}
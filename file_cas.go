@@ -0,0 +1,348 @@
+package shop
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Instance stored file by file: the CAS object is a FileManifest and
+	// the content of files is under RegistryCASFilesPrefix. Its archive is
+	// an uncompressed tar made from the manifest.
+	CompressionFiles = "files"
+
+	// Content of files of instances stored file by file, named by SHA-256.
+	RegistryCASFilesPrefix = "/cas/files/"
+)
+
+var (
+	ErrNoArchiveURL = errors.New("Instance is not stored as an archive, it has no URL")
+)
+
+// Entries of the archive in its order. Archive is the same whichever way the
+// instance is stored, so it has the same id.
+type FileManifest struct {
+	Files []FileManifestEntry `json:"files"`
+}
+
+type FileManifestEntry struct {
+	Path string      `json:"path"`
+	Mode fs.FileMode `json:"mode"`
+	Size int64       `json:"size,omitempty"`
+	// SHA-256 of the content of regular files.
+	SHA256 string `json:"sha256,omitempty"`
+	Target string `json:"target,omitempty"`
+}
+
+func casFileKey(sha256 string) string {
+	return RegistryCASFilesPrefix + sha256
+}
+
+func isValidSHA256(hash string) bool {
+	decoded, err := hex.DecodeString(hash)
+	return err == nil && len(decoded) == sha256.Size && hash == strings.ToLower(hash)
+}
+
+// Header of the entry in archives of instances stored file by file. Nothing
+// but the manifest goes into it, so the archive could be made again.
+func (e FileManifestEntry) tarHeader() *tar.Header {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     e.Path,
+		Size:     e.Size,
+		Mode:     tarMode(e.Mode),
+		ModTime:  time.Unix(0, 0),
+	}
+	switch {
+	case e.Mode.IsDir():
+		header.Typeflag, header.Name, header.Size = tar.TypeDir, e.Path+"/", 0
+	case e.Mode&fs.ModeSymlink != 0:
+		header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, e.Target, 0
+	}
+	return header
+}
+
+// Archive writer of MakeArchive for instances stored file by file.
+type fileManifestArchiveWriter struct {
+	*tar.Writer
+}
+
+func (w fileManifestArchiveWriter) WriteEntry(name string, info fs.FileInfo, target string, src io.Reader) error {
+	entry := FileManifestEntry{Path: name, Mode: info.Mode(), Target: target}
+	if info.Mode().IsRegular() {
+		entry.Size = info.Size()
+	}
+	return w.write(entry, src)
+}
+
+func (w fileManifestArchiveWriter) WriteFile(file ArchiveFile, modTime time.Time, src io.Reader) error {
+	return w.write(FileManifestEntry{Path: file.Path, Mode: file.Mode, Size: file.Size}, src)
+}
+
+func (w fileManifestArchiveWriter) write(entry FileManifestEntry, src io.Reader) error {
+	err := w.WriteHeader(entry.tarHeader())
+	if err == nil && src != nil {
+		_, err = io.Copy(w.Writer, src)
+	}
+	return err
+}
+
+// Store the archive file by file: files missing in CAS are uploaded, then the
+// manifest. The archive is made again from the manifest while it's read, and
+// has to match the id.
+func uploadFileManifest(ctx context.Context, repo Repository, instance Instance, src io.Reader) error {
+	archive, err := openArchive(src)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	defer archive.Close()
+
+	// Content is hashed before it's known whether it has to be uploaded.
+	spool, err := os.CreateTemp("", "shop-file-*")
+	if err != nil {
+		return err
+	}
+	defer spool.Close()
+	if err = os.Remove(spool.Name()); err != nil {
+		return err
+	}
+	if err = repo.EnsurePrefix(ctx, RegistryCASFilesPrefix); err != nil {
+		return err
+	}
+
+	h := instanceIdHashOf(instance.Id)
+	made := tar.NewWriter(h)
+	manifest := FileManifest{Files: []FileManifestEntry{}}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		name, err := checkArchiveEntry(header)
+		if err != nil {
+			return err
+		}
+
+		entry := FileManifestEntry{Path: name, Mode: header.FileInfo().Mode(), Target: header.Linkname}
+		if header.Typeflag != tar.TypeReg {
+			manifest.Files = append(manifest.Files, entry)
+			if err = made.WriteHeader(entry.tarHeader()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		entry.Size = header.Size
+		if err = made.WriteHeader(entry.tarHeader()); err != nil {
+			return err
+		}
+		if _, err = spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err = spool.Truncate(0); err != nil {
+			return err
+		}
+		sum := sha256.New()
+		if _, err = io.Copy(io.MultiWriter(spool, sum, made), archive); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		entry.SHA256 = hex.EncodeToString(sum.Sum(nil))
+		manifest.Files = append(manifest.Files, entry)
+
+		ok, err := repo.ResourceExists(ctx, casFileKey(entry.SHA256))
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		if _, err = spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err = repo.Put(ctx, casFileKey(entry.SHA256), io.LimitReader(spool, entry.Size)); err != nil {
+			return err
+		}
+	}
+	if err = made.Close(); err != nil {
+		return err
+	}
+	if id := h.Id(); id != instance.Id {
+		return fmt.Errorf("%w: %s@%s: archive made from the manifest is %s", ErrHashMismatch, instance.Package, instance.Id, id)
+	}
+
+	return repo.PutJSON(ctx, instance.CASKey(), manifest)
+}
+
+// Write the archive made from the manifest of the instance into dst, checking
+// the content of files against their hashes.
+func downloadFileManifest(ctx context.Context, repo Repository, instance Instance, dst io.Writer) error {
+	var manifest FileManifest
+	if err := repo.GetJSON(ctx, instance.CASKey(), &manifest); err != nil {
+		return err
+	}
+
+	archive := tar.NewWriter(dst)
+	for _, entry := range manifest.Files {
+		if err := archive.WriteHeader(entry.tarHeader()); err != nil {
+			return err
+		}
+		if !entry.Mode.IsRegular() {
+			continue
+		}
+		if err := copyCASFile(ctx, repo, entry, archive); err != nil {
+			return fmt.Errorf("%s@%s: %s: %w", instance.Package, instance.Id, entry.Path, err)
+		}
+	}
+	return archive.Close()
+}
+
+func copyCASFile(ctx context.Context, repo Repository, entry FileManifestEntry, dst io.Writer) error {
+	if !isValidSHA256(entry.SHA256) {
+		return fmt.Errorf("%w: invalid file hash %q", ErrInvalidArchive, entry.SHA256)
+	}
+	body, err := repo.Get(ctx, casFileKey(entry.SHA256))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	sum := sha256.New()
+	n, err := io.Copy(io.MultiWriter(dst, sum), io.LimitReader(body, entry.Size))
+	if err != nil {
+		return err
+	}
+	if n != entry.Size || hex.EncodeToString(sum.Sum(nil)) != entry.SHA256 {
+		return fmt.Errorf("%w: content of the file is not %s", ErrHashMismatch, entry.SHA256)
+	}
+	return nil
+}
+
+// Hashes of files the manifest of the instance refers to.
+func fileManifestHashes(ctx context.Context, repo Repository, id string) ([]string, error) {
+	var manifest FileManifest
+	if err := repo.GetJSON(ctx, InstanceCASKey(id, CompressionFiles), &manifest); err != nil {
+		return nil, err
+	}
+	var hashes []string
+	for _, entry := range manifest.Files {
+		if !entry.Mode.IsRegular() {
+			continue
+		}
+		if !isValidSHA256(entry.SHA256) {
+			return nil, fmt.Errorf("%w: %s: invalid file hash %q", ErrInvalidArchive, id, entry.SHA256)
+		}
+		hashes = append(hashes, entry.SHA256)
+	}
+	return hashes, nil
+}
+
+// Copy the manifest of the instance and files missing in dst.
+func copyFileManifest(ctx context.Context, src, dst Repository, id string) error {
+	hashes, err := fileManifestHashes(ctx, src, id)
+	if err != nil {
+		return err
+	}
+	if err = dst.EnsurePrefix(ctx, RegistryCASFilesPrefix); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if err = copyMissingObject(ctx, src, dst, casFileKey(hash)); err != nil {
+			return err
+		}
+	}
+	return copyMissingObject(ctx, src, dst, InstanceCASKey(id, CompressionFiles))
+}
+
+func copyMissingObject(ctx context.Context, src, dst Repository, key string) error {
+	ok, err := dst.ResourceExists(ctx, key)
+	if err != nil || ok {
+		return err
+	}
+	body, err := src.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path.Base(key), err)
+	}
+	defer body.Close()
+	return dst.Put(ctx, key, body)
+}
+
+// Files in CAS which none of the manifests refer to and are older than
+// minAge, deleted unless dryRun is set.
+func collectCASFiles(ctx context.Context, repo Repository, manifests []string, minAge time.Duration, dryRun bool) ([]ArchiveInfo, error) {
+	entries, err := CollectCursor(ctx, repo.List(ctx, RegistryCASFilesPrefix))
+	if errors.Is(err, os.ErrNotExist) || len(entries) == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := map[string]bool{}
+	for _, id := range manifests {
+		hashes, err := fileManifestHashes(ctx, repo, id)
+		if errors.Is(err, os.ErrNotExist) {
+			// Deleted since listing.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range hashes {
+			referenced[hash] = true
+		}
+	}
+
+	var candidates []string
+	for _, entry := range entries {
+		if !entry.IsPrefix && isValidSHA256(entry.Key) && !referenced[entry.Key] {
+			candidates = append(candidates, entry.Key)
+		}
+	}
+
+	var lock sync.Mutex
+	var garbage []ArchiveInfo
+	err = runJobs(ctx, repositoryJobs(repo.GetConfig()), len(candidates), func(ctx context.Context, i int) error {
+		key := casFileKey(candidates[i])
+		info, err := repo.Stat(ctx, key)
+		if err != nil {
+			return err
+		}
+		if time.Since(info.ModTime) < minAge {
+			return nil
+		}
+		if !dryRun {
+			if err = repo.Delete(ctx, key); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		garbage = append(garbage, ArchiveInfo{
+			Id:      candidates[i],
+			File:    true,
+			Size:    info.Size,
+			ModTime: UnixTimestamp{info.ModTime},
+		})
+		return nil
+	})
+	sort.Slice(garbage, func(i, j int) bool {
+		return garbage[i].Id < garbage[j].Id
+	})
+	return garbage, err
+}
@@ -0,0 +1,105 @@
+package shop
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFileManifest(t *testing.T) {
+	tests := []struct {
+		name string
+		tree testTree
+		// Content of files stored in CAS.
+		files []string
+		// Change the stored file with the content before the download.
+		corrupt func(ctx context.Context, repo Repository, key string) error
+		err     error
+	}{
+		{
+			name:  "files",
+			tree:  testTree{"a": "a", "d/b": "b", "l": "->a"},
+			files: []string{"a", "b"},
+		},
+		{
+			name:  "same content twice",
+			tree:  testTree{"a": "x", "d/b": "x"},
+			files: []string{"x"},
+		},
+		{
+			name:  "changed file",
+			tree:  testTree{"a": "a"},
+			files: []string{"a"},
+			corrupt: func(ctx context.Context, repo Repository, key string) error {
+				if err := repo.Delete(ctx, key); err != nil {
+					return err
+				}
+				return repo.Put(ctx, key, strings.NewReader("b"))
+			},
+			err: ErrHashMismatch,
+		},
+		{
+			name:  "missing file",
+			tree:  testTree{"a": "a"},
+			files: []string{"a"},
+			corrupt: func(ctx context.Context, repo Repository, key string) error {
+				return repo.Delete(ctx, key)
+			},
+			err: os.ErrNotExist,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			registry := newTestRegistry(t)
+			instance, err := uploadTestInstance(t, registry, test.tree, CompressionFiles, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo, err := registry.packageRepository(ctx, "test/pkg")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := CollectCursor(ctx, repo.List(ctx, RegistryCASFilesPrefix))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(test.files) {
+				t.Errorf("got %d files in CAS, want %d", len(entries), len(test.files))
+			}
+			for _, content := range test.files {
+				sum := sha256.Sum256([]byte(content))
+				key := casFileKey(hex.EncodeToString(sum[:]))
+				if ok, err := repo.ResourceExists(ctx, key); err != nil || !ok {
+					t.Fatalf("%s is not in CAS: %v", content, err)
+				}
+				if test.corrupt != nil {
+					if err = test.corrupt(ctx, repo, key); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			var archive bytes.Buffer
+			err = registry.DownloadPackageInstance(ctx, *instance, &archive)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if test.err != nil {
+				return
+			}
+			dir := t.TempDir()
+			if err = ExtractArchive(&archive, dir, ExtractOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			checkTree(t, dir, test.tree)
+		})
+	}
+}
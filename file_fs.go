@@ -3,14 +3,24 @@ package shop
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 )
 
 func init() {
-	RepositoryFactories["file"] = NewFileFS
+	MustRegisterBackend(Backend{
+		Scheme:       "file",
+		Factory:      NewFileFS,
+		Capabilities: BackendCapabilities{Range: true, ConditionalWrite: true, Copy: true, RemoveAll: true},
+		Jobs:         8,
+	})
 }
 
 type FileFS struct {
@@ -24,26 +34,263 @@ func NewFileFS(ctx context.Context, cfg RepositoryConfig) (RepositoryFS, error)
 		return nil, err
 	}
 
-	return FileFS{
+	f := FileFS{
 		cfg:  cfg,
 		path: filepath.Join(u.Host, u.Path),
-	}, nil
+	}
+
+	if f.dedupEnabled() {
+		if err = validateFileDedupMode(cfg.File.Dedup); err != nil {
+			return nil, err
+		}
+		if root, err := filepath.Abs(f.path); err == nil {
+			fileFSDedupRoots.Store(root, struct{}{})
+		}
+	}
+
+	return f, nil
 }
 
 func (f FileFS) Read(ctx context.Context, path string) ([]byte, error) {
 	return os.ReadFile(filepath.Join(f.path, path))
 }
 
+// Writes take the lock of WriteIf, so they never land between its check and
+// its write.
 func (f FileFS) Write(ctx context.Context, path string, data []byte) error {
-	return os.WriteFile(filepath.Join(f.path, path), data, 0666)
+	unlock, err := lockFile(ctx, filepath.Join(f.path, path))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return f.write(path, data)
+}
+
+func (f FileFS) write(path string, data []byte) error {
+	w, err := f.newAtomicWriter(path, false)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Close()
+}
+
+const (
+	fileFSLockSuffix = ".lock"
+	// Lock files older than this are left by crashed processes.
+	fileFSStaleLockAge   = time.Minute
+	fileFSLockRetryDelay = 10 * time.Millisecond
+)
+
+// Take an exclusive lock file next to path, waiting while it's held by
+// someone else.
+func lockFile(ctx context.Context, path string) (unlock func(), err error) {
+	lock := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+fileFSLockSuffix)
+	for {
+		file, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > fileFSStaleLockAge {
+			os.Remove(lock)
+			continue
+		}
+
+		select {
+		case <-time.After(fileFSLockRetryDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (f FileFS) WriteIf(ctx context.Context, path string, data []byte, etag string) error {
+	if etag == "" {
+		w, err := f.newAtomicWriter(path, true)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(data); err != nil {
+			w.Abort()
+			return err
+		}
+		err = w.Close()
+		if errors.Is(err, os.ErrExist) {
+			err = fmt.Errorf("%w: %s", ErrConditionFailed, path)
+		}
+		return err
+	}
+
+	unlock, err := lockFile(ctx, filepath.Join(f.path, path))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	info, err := f.Stat(ctx, path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && info.ETag != etag) {
+		return fmt.Errorf("%w: %s", ErrConditionFailed, path)
+	}
+	if err != nil {
+		return err
+	}
+	return f.write(path, data)
 }
 
 func (f FileFS) Open(ctx context.Context, path string) (io.ReadCloser, error) {
 	return os.Open(filepath.Join(f.path, path))
 }
 
+func (f FileFS) OpenRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(f.path, path))
+	if err != nil {
+		return nil, err
+	}
+	return seekRange(file, offset, length)
+}
+
 func (f FileFS) Create(ctx context.Context, path string) (io.WriteCloser, error) {
-	return os.OpenFile(filepath.Join(f.path, path), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if _, err := os.Lstat(filepath.Join(f.path, path)); err == nil {
+		return nil, &os.PathError{Op: "create", Path: filepath.Join(f.path, path), Err: os.ErrExist}
+	}
+
+	if id, ok := casKeyInstanceId(path); ok && f.dedupEnabled() {
+		if src, ok := f.findDedupSource(path); ok {
+			return f.newDedupWriter(path, src, id), nil
+		}
+	}
+
+	return f.newAtomicWriter(path, true)
+}
+
+// Writer which writes into temporary file in the same directory and moves it
+// into place on Close, so readers never observe partially written files.
+type fileFSAtomicWriter struct {
+	file      *os.File
+	path      string
+	exclusive bool
+	hash      *InstanceIdHash
+	hashId    string
+}
+
+func (f FileFS) newAtomicWriter(path string, exclusive bool) (*fileFSAtomicWriter, error) {
+	target := filepath.Join(f.path, path)
+	file, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+
+	if err = file.Chmod(0644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	w := &fileFSAtomicWriter{
+		file:      file,
+		path:      target,
+		exclusive: exclusive,
+	}
+	if f.cfg.File != nil && f.cfg.File.VerifyHash {
+		if id, ok := casKeyInstanceId(path); ok {
+			w.hash = instanceIdHashOf(id)
+			w.hashId = id
+		}
+	}
+	return w, nil
+}
+
+func (w *fileFSAtomicWriter) Write(data []byte) (int, error) {
+	if w.hash != nil {
+		w.hash.Write(data)
+	}
+	return w.file.Write(data)
+}
+
+func (w *fileFSAtomicWriter) Abort() error {
+	w.file.Close()
+	return os.Remove(w.file.Name())
+}
+
+func (w *fileFSAtomicWriter) Close() (err error) {
+	tmp := w.file.Name()
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+
+	if err = w.file.Sync(); err != nil {
+		w.file.Close()
+		return
+	}
+	if err = w.file.Close(); err != nil {
+		return
+	}
+
+	if w.hash != nil {
+		if id := w.hash.Id(); id != w.hashId {
+			return fmt.Errorf("%w: %s: got %s", ErrHashMismatch, w.path, id)
+		}
+	}
+
+	if w.exclusive {
+		// Unlike rename, link fails if the target already exists.
+		if err = os.Link(tmp, w.path); err != nil {
+			return
+		}
+		os.Remove(tmp)
+	} else if err = os.Rename(tmp, w.path); err != nil {
+		return
+	}
+
+	return syncDir(filepath.Dir(w.path))
+}
+
+// Flush directory entries, so renamed file survives a crash.
+func syncDir(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Returns instance id if the key refers to a CAS archive.
+func casKeyInstanceId(key string) (string, bool) {
+	key = filepath.ToSlash(filepath.Clean("/" + key))
+	dir, name := filepath.ToSlash(filepath.Dir(key)), filepath.Base(key)
+	if dir+"/" != RegistryCASPrefix {
+		return "", false
+	}
+
+	id, _, ok := ParseCASArchiveName(name)
+	return id, ok
+}
+
+func (f FileFS) Copy(ctx context.Context, src, dst string) error {
+	// Objects are never modified in place, so they could share the inode.
+	srcPath := filepath.Join(f.path, src)
+	err := os.Link(srcPath, filepath.Join(f.path, dst))
+	if err == nil || errors.Is(err, os.ErrExist) || errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return f.copyFrom(srcPath, dst, true)
 }
 
 func (f FileFS) MakeDir(ctx context.Context, path string) error {
@@ -72,7 +319,21 @@ func (f FileFS) ListDir(ctx context.Context, path string) Cursor[Entry] {
 	return c
 }
 
-func (c *fileFSCursor) GetNext(context.Context) (ret *Entry, err error) {
+// Temporary and lock files of writes in progress are not listed.
+func isFileFSTempName(name string) bool {
+	return strings.HasPrefix(name, ".") && (strings.Contains(name, ".tmp-") || strings.HasSuffix(name, fileFSLockSuffix))
+}
+
+func (c *fileFSCursor) GetNext(ctx context.Context) (*Entry, error) {
+	for {
+		entry, err := c.next()
+		if err != nil || entry == nil || !isFileFSTempName(entry.Key) {
+			return entry, err
+		}
+	}
+}
+
+func (c *fileFSCursor) next() (ret *Entry, err error) {
 	if c.file == nil {
 		err = io.EOF
 		return
@@ -111,6 +372,10 @@ func (f FileFS) Remove(ctx context.Context, path string) error {
 	return os.Remove(filepath.Join(f.path, path))
 }
 
+func (f FileFS) RemoveAll(ctx context.Context, path string) error {
+	return os.RemoveAll(filepath.Join(f.path, path))
+}
+
 func (f FileFS) Exists(ctx context.Context, path string) (ok bool, err error) {
 	_, err = os.Stat(filepath.Join(f.path, path))
 	ok = err == nil
@@ -119,3 +384,24 @@ func (f FileFS) Exists(ctx context.Context, path string) (ok bool, err error) {
 	}
 	return
 }
+
+// Object info from local or sftp file info.
+func fileObjectInfo(key string, info fs.FileInfo) (ObjectInfo, error) {
+	if !info.Mode().IsRegular() {
+		return ObjectInfo{}, &fs.PathError{Op: "stat", Path: key, Err: fs.ErrNotExist}
+	}
+	return ObjectInfo{
+		Key:     key,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		ETag:    fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+	}, nil
+}
+
+func (f FileFS) Stat(ctx context.Context, path string) (ObjectInfo, error) {
+	info, err := os.Stat(filepath.Join(f.path, path))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return fileObjectInfo(path, info)
+}
@@ -0,0 +1,143 @@
+package shop
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	FileDedupHardlink = "hardlink"
+	FileDedupReflink  = "reflink"
+)
+
+var (
+	ErrUnknownDedupMode = errors.New("Unknown dedup mode")
+
+	// Roots of all the file repositories with dedup enabled opened by this
+	// process.
+	fileFSDedupRoots sync.Map
+)
+
+func validateFileDedupMode(mode string) error {
+	switch mode {
+	case "", FileDedupHardlink, FileDedupReflink:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownDedupMode, mode)
+	}
+}
+
+func (f FileFS) dedupEnabled() bool {
+	return f.cfg.File != nil && f.cfg.File.Dedup != ""
+}
+
+// Find the same CAS object in other local repositories.
+func (f FileFS) findDedupSource(key string) (string, bool) {
+	var roots []string
+	roots = append(roots, f.cfg.File.DedupRoots...)
+	fileFSDedupRoots.Range(func(root, _ any) bool {
+		roots = append(roots, root.(string))
+		return true
+	})
+
+	self, _ := filepath.Abs(f.path)
+	for _, root := range roots {
+		if abs, err := filepath.Abs(root); err != nil || abs == self {
+			continue
+		}
+
+		candidate := filepath.Join(root, key)
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// Writer for a CAS object which already exists in another local repository.
+// Data written into it is only used for hash verification, and the existing
+// object is linked into place on Close.
+type fileFSDedupWriter struct {
+	fs     FileFS
+	key    string
+	src    string
+	hash   *InstanceIdHash
+	hashId string
+}
+
+func (f FileFS) newDedupWriter(key, src, id string) *fileFSDedupWriter {
+	w := &fileFSDedupWriter{
+		fs:  f,
+		key: key,
+		src: src,
+	}
+	if f.cfg.File.VerifyHash {
+		w.hash = instanceIdHashOf(id)
+		w.hashId = id
+	}
+	return w
+}
+
+func (w *fileFSDedupWriter) Write(data []byte) (int, error) {
+	if w.hash != nil {
+		w.hash.Write(data)
+	}
+	return len(data), nil
+}
+
+func (w *fileFSDedupWriter) Abort() error {
+	return nil
+}
+
+func (w *fileFSDedupWriter) Close() error {
+	if w.hash != nil {
+		if id := w.hash.Id(); id != w.hashId {
+			return fmt.Errorf("%w: %s: got %s", ErrHashMismatch, w.key, id)
+		}
+	}
+	return w.fs.linkFrom(w.src, w.key)
+}
+
+// Place a copy of src at key, sharing storage with it when possible.
+func (f FileFS) linkFrom(src, key string) error {
+	dst := filepath.Join(f.path, key)
+	if f.cfg.File.Dedup == FileDedupHardlink {
+		err := os.Link(src, dst)
+		if err == nil || errors.Is(err, os.ErrExist) {
+			return err
+		}
+		// Most likely different filesystems, fallback to copy.
+	}
+
+	return f.copyFrom(src, key, f.cfg.File.Dedup == FileDedupReflink)
+}
+
+// Copy src file into key, cloning its blocks if reflink is set and the
+// filesystem supports it.
+func (f FileFS) copyFrom(src, key string, reflink bool) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	w, err := f.newAtomicWriter(key, true)
+	if err != nil {
+		return err
+	}
+	// Content is already verified, it's only copied here.
+	w.hash = nil
+
+	if !reflink || reflinkFile(source, w.file) != nil {
+		if _, err = io.Copy(w.file, source); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+
+	return w.Close()
+}
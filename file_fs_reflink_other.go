@@ -0,0 +1,11 @@
+//go:build !linux
+
+package shop
+
+import (
+	"os"
+)
+
+func reflinkFile(src, dst *os.File) error {
+	return ErrUnimplemented
+}
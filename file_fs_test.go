@@ -0,0 +1,69 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestFileFS(t *testing.T) (FileFS, string) {
+	t.Helper()
+	dir := t.TempDir()
+	fs, err := NewFileFS(context.Background(), RepositoryConfig{URL: "file://" + dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs.(FileFS), dir
+}
+
+func TestFileFSListDir(t *testing.T) {
+	fs, dir := newTestFileFS(t)
+	testTree{
+		"a":                "a",
+		".b":               "b",
+		".a.tmp-123":       "",
+		".a.lock":          "",
+		"d/.x.tmp-1":       "",
+		"d/x":              "x",
+		".index.json.lock": "",
+	}.writeInto(t, dir)
+
+	entries, err := CollectCursor(context.Background(), fs.ListDir(context.Background(), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, entry := range entries {
+		got[entry.Key] = entry.IsPrefix
+	}
+	want := map[string]bool{"a": false, ".b": false, "d": true}
+	if len(got) != len(want) {
+		t.Fatalf("got entries %v, want %v", got, want)
+	}
+	for key, isPrefix := range want {
+		if prefix, ok := got[key]; !ok || prefix != isPrefix {
+			t.Errorf("got entries %v, want %v", got, want)
+		}
+	}
+}
+
+func TestFileFSWriteTakesLock(t *testing.T) {
+	fs, dir := newTestFileFS(t)
+	unlock, err := lockFile(context.Background(), filepath.Join(dir, "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = fs.Write(ctx, "key", []byte("data")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v while the lock is held, want %v", err, context.DeadlineExceeded)
+	}
+
+	unlock()
+	if err = fs.Write(context.Background(), "key", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+}
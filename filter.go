@@ -0,0 +1,120 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"time"
+)
+
+// Conditions on package instances, all of which have to match. Zero value
+// matches every instance.
+type InstanceFilter struct {
+	// Tags attached to the instance, by key.
+	Tags map[string]string
+	// Bounds of the upload time, unbounded if zero. Until is exclusive.
+	Since time.Time
+	Until time.Time
+	// Newest instances kept after filtering, all if zero.
+	Limit int
+}
+
+func (f InstanceFilter) matches(instance Instance) bool {
+	switch {
+	case !f.Since.IsZero() && instance.UploadedAt.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !instance.UploadedAt.Before(f.Until):
+		return false
+	default:
+		return true
+	}
+}
+
+// Instances of the package matching the filter, newest first. With tags
+// only instances found in the tag index are read.
+func FindPackageInstances(ctx context.Context, registry Registry, name string, filter InstanceFilter) ([]Instance, error) {
+	var instances []Instance
+	if len(filter.Tags) == 0 {
+		var err error
+		instances, err = CollectCursor(ctx, registry.ListPackageInstances(ctx, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	} else {
+		ids, err := findTaggedInstanceIds(ctx, registry, name, filter.Tags)
+		if err != nil {
+			return nil, err
+		}
+
+		instances = make([]Instance, len(ids))
+		err = runJobs(ctx, registry.Jobs(), len(ids), func(ctx context.Context, i int) error {
+			instance, err := registry.GetPackageInstanceInfo(ctx, name, ids[i])
+			if err != nil {
+				return err
+			}
+			instances[i] = *instance
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := []Instance{}
+	for _, instance := range instances {
+		if filter.matches(instance) {
+			result = append(result, instance)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].UploadedAt.After(result[j].UploadedAt.Time)
+	})
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+	return result, nil
+}
+
+// Ids of instances with all the tags, from the intersection of their tag
+// index entries.
+func findTaggedInstanceIds(ctx context.Context, registry Registry, name string, tags map[string]string) ([]string, error) {
+	var ids []string
+	first := true
+	for key, value := range tags {
+		tagged, err := CollectCursor(ctx, registry.ListPackageInstancesByTag(ctx, PackageTagValue{
+			PackageTag: PackageTag{Package: name, Key: key},
+			Value:      value,
+		}))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		found := map[string]bool{}
+		for _, tag := range tagged {
+			found[tag.Id] = true
+		}
+		if first {
+			for _, tag := range tagged {
+				ids = append(ids, tag.Id)
+			}
+			first = false
+			continue
+		}
+
+		kept := ids[:0]
+		for _, id := range ids {
+			if found[id] {
+				kept = append(kept, id)
+			}
+		}
+		ids = kept
+		if len(ids) == 0 {
+			break
+		}
+	}
+	return ids, nil
+}
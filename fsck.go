@@ -0,0 +1,258 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	ProblemRegistry = "registry"
+	ProblemPackage  = "package"
+	ProblemInstance = "instance"
+	ProblemArchive  = "archive"
+	ProblemTag      = "tag"
+	ProblemRef      = "ref"
+)
+
+// Inconsistency found by CheckIntegrity.
+type Problem struct {
+	Kind    string `json:"kind"`
+	Package string `json:"package,omitempty"`
+	Object  string `json:"object,omitempty"`
+	Message string `json:"message"`
+	// Could be repaired without losing data.
+	Fixable bool `json:"fixable"`
+	Fixed   bool `json:"fixed"`
+}
+
+type registryChecker struct {
+	client   *RegistryImpl
+	repair   bool
+	problems []Problem
+}
+
+func (c *registryChecker) report(problem Problem, fix func() error) {
+	problem.Fixable = fix != nil
+	if c.repair && fix != nil {
+		if err := fix(); err != nil {
+			problem.Message += fmt.Sprintf(" (repair failed: %v)", err)
+		} else {
+			problem.Fixed = true
+		}
+	}
+	c.problems = append(c.problems, problem)
+}
+
+// Validate metadata of the whole registry: manifests parse, instances have
+// archives in CAS, tags are in both indexes, refs point to existing
+// instances. With repair, missing tag index entries are restored and tags
+// and refs of missing instances are deleted.
+func (c *RegistryImpl) CheckIntegrity(ctx context.Context, repair bool) ([]Problem, error) {
+	if repair && !c.cfg.Admin {
+		return nil, fmt.Errorf("%w: CheckIntegrity", ErrRegistryAdminIsNotAllowed)
+	}
+
+	checker := &registryChecker{
+		client: c,
+		repair: repair,
+	}
+
+	if _, err := c.GetManifest(ctx); err != nil {
+		checker.report(Problem{Kind: ProblemRegistry, Object: RegistryManifestKey, Message: err.Error()}, nil)
+		return checker.problems, nil
+	}
+
+	err := c.walkPackageNames(ctx, "", func(name string) error {
+		return checker.checkPackage(ctx, name)
+	})
+
+	problems := checker.problems
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Package != problems[j].Package {
+			return problems[i].Package < problems[j].Package
+		}
+		return problems[i].Object < problems[j].Object
+	})
+	return problems, err
+}
+
+func (c *registryChecker) checkPackage(ctx context.Context, name string) error {
+	pkg, err := c.client.GetPackage(ctx, name)
+	if err != nil {
+		c.report(Problem{Kind: ProblemPackage, Package: name, Message: err.Error()}, nil)
+		return nil
+	}
+	if pkg.Name != name {
+		c.report(Problem{Kind: ProblemPackage, Package: name, Message: fmt.Sprintf("manifest has name %s", pkg.Name)}, nil)
+	}
+
+	repo, err := c.client.packageRepository(ctx, name)
+	if err != nil {
+		c.report(Problem{Kind: ProblemPackage, Package: name, Message: err.Error()}, nil)
+	}
+
+	// Instances with broken manifests are still there for tags and refs.
+	instances := map[string]bool{}
+	prefix := filepath.Join(RegistryPackagesPrefix, name, RegistryPackageInstancesPrefix)
+	entries, err := CollectCursor(ctx, c.client.rootRepository.List(ctx, prefix))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsPrefix {
+			continue
+		}
+		if !IsValidInstanceId(entry.Key) {
+			c.report(Problem{Kind: ProblemInstance, Package: name, Object: entry.Key, Message: ErrInvalidInstanceId.Error()}, nil)
+			continue
+		}
+
+		instances[entry.Key] = true
+		instance, err := c.client.GetPackageInstanceInfo(ctx, name, entry.Key)
+		if err != nil {
+			c.report(Problem{Kind: ProblemInstance, Package: name, Object: entry.Key, Message: err.Error()}, nil)
+			continue
+		}
+		if instance.Id != entry.Key || instance.Package != name {
+			c.report(Problem{Kind: ProblemInstance, Package: name, Object: entry.Key, Message: fmt.Sprintf("manifest is for %s@%s", instance.Package, instance.Id)}, nil)
+		}
+
+		if repo != nil {
+			ok, err := repo.ResourceExists(ctx, instance.CASKey())
+			if err != nil {
+				return err
+			}
+			if !ok {
+				c.report(Problem{Kind: ProblemArchive, Package: name, Object: entry.Key, Message: "archive is missing in CAS"}, nil)
+			} else if instance.Compression == CompressionFiles {
+				if err = c.checkCASFiles(ctx, repo, name, instance.Id); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if err = c.checkTags(ctx, name, instances); err != nil {
+		return err
+	}
+	return c.checkRefs(ctx, name, instances)
+}
+
+// Files the manifest of the instance stored file by file refers to.
+func (c *registryChecker) checkCASFiles(ctx context.Context, repo Repository, name, id string) error {
+	hashes, err := fileManifestHashes(ctx, repo, id)
+	if err != nil {
+		c.report(Problem{Kind: ProblemArchive, Package: name, Object: id, Message: err.Error()}, nil)
+		return nil
+	}
+	missing := 0
+	for _, hash := range hashes {
+		ok, err := repo.ResourceExists(ctx, casFileKey(hash))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			missing++
+		}
+	}
+	if missing > 0 {
+		c.report(Problem{Kind: ProblemArchive, Package: name, Object: id, Message: fmt.Sprintf("%d files are missing in CAS", missing)}, nil)
+	}
+	return nil
+}
+
+// Every tag is stored twice: tags/<key>/<value>/<id> of the package and
+// instances/<id>/tags/<key>/<value>.
+func (c *registryChecker) checkTags(ctx context.Context, name string, instances map[string]bool) error {
+	byInstance := map[Tag]bool{}
+	for id := range instances {
+		tags, err := CollectCursor(ctx, c.client.ListPackageInstanceTags(ctx, Instance{Package: name, Id: id}))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			c.report(Problem{Kind: ProblemTag, Package: name, Object: id, Message: err.Error()}, nil)
+		}
+		for _, tag := range tags {
+			byInstance[tagIndexKey(tag)] = true
+		}
+	}
+
+	byValue := map[Tag]bool{}
+	keys, err := CollectCursor(ctx, c.client.ListPackageTags(ctx, name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, key := range keys {
+		values, err := CollectCursor(ctx, c.client.ListPackageTagValues(ctx, key))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for _, value := range values {
+			tags, err := CollectCursor(ctx, c.client.ListPackageInstancesByTag(ctx, value))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				c.report(Problem{Kind: ProblemTag, Package: name, Object: value.Key + ":" + value.Value, Message: err.Error()}, nil)
+			}
+			for _, tag := range tags {
+				byValue[tagIndexKey(tag)] = true
+			}
+		}
+	}
+
+	for tag := range byValue {
+		object := fmt.Sprintf("%s:%s@%s", tag.Key, tag.Value, tag.Id)
+		switch {
+		case !instances[tag.Id]:
+			c.report(Problem{Kind: ProblemTag, Package: name, Object: object, Message: "tag of missing instance"}, func() error {
+				key := filepath.Join(RegistryPackagesPrefix, name, RegistryPackageTagsPrefix, tag.Key, tag.Value, tag.Id)
+				return c.client.rootRepository.Delete(ctx, key)
+			})
+		case !byInstance[tag]:
+			c.report(Problem{Kind: ProblemTag, Package: name, Object: object, Message: "tag is missing in instance index"}, func() error {
+				return c.client.PutPackageInstanceTag(ctx, tag)
+			})
+		}
+	}
+	for tag := range byInstance {
+		if !byValue[tag] {
+			object := fmt.Sprintf("%s:%s@%s", tag.Key, tag.Value, tag.Id)
+			c.report(Problem{Kind: ProblemTag, Package: name, Object: object, Message: "tag is missing in package index"}, func() error {
+				return c.client.PutPackageInstanceTag(ctx, tag)
+			})
+		}
+	}
+	return nil
+}
+
+// Fields which identify the tag, so copies from both indexes compare equal.
+func tagIndexKey(tag Tag) Tag {
+	return Tag{Package: tag.Package, Key: tag.Key, Value: tag.Value, Id: tag.Id}
+}
+
+func (c *registryChecker) checkRefs(ctx context.Context, name string, instances map[string]bool) error {
+	prefix := filepath.Join(RegistryPackagesPrefix, name, RegistryPackageReferencesPrefix)
+	entries, err := CollectCursor(ctx, c.client.rootRepository.List(ctx, prefix))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsPrefix {
+			continue
+		}
+
+		ref, err := c.client.GetPackageReference(ctx, name, entry.Key)
+		switch {
+		case err != nil:
+			c.report(Problem{Kind: ProblemRef, Package: name, Object: entry.Key, Message: err.Error()}, nil)
+		case !IsValidRefName(ref.Name) || ref.Name != entry.Key:
+			c.report(Problem{Kind: ProblemRef, Package: name, Object: entry.Key, Message: fmt.Sprintf("%s: %s", ErrInvalidReferenceName, ref.Name)}, nil)
+		case !instances[ref.Id]:
+			c.report(Problem{Kind: ProblemRef, Package: name, Object: entry.Key, Message: fmt.Sprintf("points to missing instance %s", ref.Id)}, func() error {
+				return c.client.rootRepository.Delete(ctx, filepath.Join(prefix, entry.Key))
+			})
+		}
+	}
+	return nil
+}
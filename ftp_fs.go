@@ -0,0 +1,414 @@
+package shop
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/jlaffaye/ftp"
+)
+
+func init() {
+	MustRegisterBackend(Backend{
+		Scheme:       "ftp",
+		Factory:      NewFTPFS,
+		Capabilities: BackendCapabilities{Range: true, RemoveAll: true},
+		Jobs:         2,
+	})
+	MustRegisterBackend(Backend{
+		Scheme:       "ftps",
+		Factory:      NewFTPFS,
+		Capabilities: BackendCapabilities{Range: true, RemoveAll: true},
+		Jobs:         2,
+	})
+}
+
+const (
+	DefaultFTPPort = "21"
+
+	// Connections kept open between operations.
+	ftpMaxIdleConns = 4
+)
+
+// Repository on an FTP server. Transfers are always passive. ftps:// uses
+// explicit TLS (AUTH TLS), unless implicit TLS is configured.
+//
+// FTP connection runs a single command at a time, so every operation takes
+// its own connection from the pool.
+type FTPFS struct {
+	cfg  RepositoryConfig
+	pool *ftpPool
+	path string
+}
+
+type ftpPool struct {
+	dial func(ctx context.Context) (*ftp.ServerConn, error)
+	lock sync.Mutex
+	idle []*ftp.ServerConn
+}
+
+func NewFTPFS(ctx context.Context, cfg RepositoryConfig) (RepositoryFS, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	access := FTPAccessConfig{}
+	if cfg.FTP != nil {
+		access = *cfg.FTP
+	}
+	if u.User != nil && u.User.Username() != "" {
+		access.User = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			access.Password = password
+		}
+	}
+	if access.Password == "" {
+		access.Password = os.Getenv("SHOP_FTP_PASSWORD")
+	}
+	if access.User == "" {
+		access.User, access.Password = "anonymous", "anonymous"
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), DefaultFTPPort)
+	}
+
+	var options []ftp.DialOption
+	if u.Scheme == "ftps" {
+		tlsConfig, err := newTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		tlsConfig.ServerName = u.Hostname()
+
+		if access.ImplicitTLS {
+			options = append(options, ftp.DialWithTLS(tlsConfig))
+		} else {
+			options = append(options, ftp.DialWithExplicitTLS(tlsConfig))
+		}
+	}
+
+	pool := &ftpPool{
+		dial: func(ctx context.Context) (*ftp.ServerConn, error) {
+			conn, err := ftp.Dial(host, append(options, ftp.DialWithContext(ctx))...)
+			if err != nil {
+				return nil, err
+			}
+			if err = conn.Login(access.User, access.Password); err != nil {
+				conn.Quit()
+				return nil, err
+			}
+			return conn, nil
+		},
+	}
+
+	conn, err := pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.put(conn)
+
+	// Url path is relative to the login directory, but pooled connections
+	// could be left in other directories, so only absolute paths are used.
+	home, err := conn.CurrentDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return FTPFS{
+		cfg:  cfg,
+		pool: pool,
+		path: path.Join("/", home, u.Path),
+	}, nil
+}
+
+func (p *ftpPool) get(ctx context.Context) (*ftp.ServerConn, error) {
+	p.lock.Lock()
+	if n := len(p.idle); n > 0 {
+		conn := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.lock.Unlock()
+		return conn, nil
+	}
+	p.lock.Unlock()
+
+	return p.dial(ctx)
+}
+
+func (p *ftpPool) put(conn *ftp.ServerConn) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if len(p.idle) < ftpMaxIdleConns {
+		p.idle = append(p.idle, conn)
+	} else {
+		conn.Quit()
+	}
+}
+
+// Run op with a pooled connection. Connections which failed with anything
+// but a server reply are dropped.
+func (f FTPFS) do(ctx context.Context, op func(*ftp.ServerConn) error) error {
+	conn, err := f.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = op(conn)
+	f.release(conn, err)
+	return ftpError(err)
+}
+
+func (f FTPFS) release(conn *ftp.ServerConn, err error) {
+	var reply *textproto.Error
+	if err == nil || errors.As(err, &reply) {
+		f.pool.put(conn)
+	} else {
+		conn.Quit()
+	}
+}
+
+// FTP reports both missing files and denied access as 550, missing is far
+// more common for repository operations.
+func ftpError(err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code == ftp.StatusFileUnavailable {
+		return fmt.Errorf("%w: %w", os.ErrNotExist, err)
+	}
+	return err
+}
+
+func (f FTPFS) filePath(key string) string {
+	return path.Join(f.path, key)
+}
+
+func (f FTPFS) Read(ctx context.Context, key string) ([]byte, error) {
+	body, err := f.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func (f FTPFS) Write(ctx context.Context, key string, data []byte) error {
+	return f.do(ctx, func(conn *ftp.ServerConn) error {
+		return conn.Stor(f.filePath(key), bytes.NewReader(data))
+	})
+}
+
+type ftpResponse struct {
+	*ftp.Response
+	fs   FTPFS
+	conn *ftp.ServerConn
+	// Transfer is cut short by the client, so the server reports it as
+	// aborted.
+	partial bool
+}
+
+// Connection could be reused only after the transfer is finished.
+func (r ftpResponse) Close() error {
+	err := r.Response.Close()
+	if err == nil {
+		r.fs.pool.put(r.conn)
+		return nil
+	}
+
+	r.conn.Quit()
+	if r.partial {
+		return nil
+	}
+	return err
+}
+
+func (f FTPFS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return f.OpenRange(ctx, key, 0, -1)
+}
+
+func (f FTPFS) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	conn, err := f.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := conn.RetrFrom(f.filePath(key), uint64(offset))
+	if err != nil {
+		f.release(conn, err)
+		return nil, ftpError(err)
+	}
+	return limitRange(ftpResponse{resp, f, conn, length >= 0}, length), nil
+}
+
+type ftpUpload struct {
+	*io.PipeWriter
+	done chan error
+}
+
+func (u ftpUpload) Close() error {
+	u.PipeWriter.Close()
+	return <-u.done
+}
+
+func (u ftpUpload) Abort() error {
+	u.PipeWriter.CloseWithError(ErrUploadAborted)
+	<-u.done
+	return nil
+}
+
+func (f FTPFS) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	ok, err := f.Exists(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, fmt.Errorf("%s: %w", key, os.ErrExist)
+	}
+
+	reader, writer := io.Pipe()
+	upload := ftpUpload{
+		PipeWriter: writer,
+		done:       make(chan error, 1),
+	}
+
+	go func() {
+		err := f.do(ctx, func(conn *ftp.ServerConn) error {
+			err := conn.Stor(f.filePath(key), reader)
+			if errors.Is(err, ErrUploadAborted) {
+				// Don't leave partial file behind.
+				conn.Delete(f.filePath(key))
+			}
+			return err
+		})
+		reader.CloseWithError(err)
+		upload.done <- err
+	}()
+
+	return upload, nil
+}
+
+func (f FTPFS) MakeDir(ctx context.Context, key string) error {
+	return f.do(ctx, func(conn *ftp.ServerConn) error {
+		// MKD creates a single level and fails for existing directories.
+		dir := "/"
+		for _, part := range strings.Split(strings.Trim(f.filePath(key), "/"), "/") {
+			dir = path.Join(dir, part)
+			conn.MakeDir(dir)
+		}
+		return conn.ChangeDir(dir)
+	})
+}
+
+func (f FTPFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	var entries []Entry
+	err := f.do(ctx, func(conn *ftp.ServerConn) error {
+		dir := f.filePath(key)
+		// Listing of missing directory is empty on some servers.
+		if err := conn.ChangeDir(dir); err != nil {
+			return err
+		}
+
+		list, err := conn.List(dir)
+		if err != nil {
+			return err
+		}
+		for _, item := range list {
+			if item.Name == "." || item.Name == ".." {
+				continue
+			}
+			entries = append(entries, Entry{
+				Key:      item.Name,
+				IsPrefix: item.Type == ftp.EntryTypeFolder,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return NewErrorCursor[Entry](err)
+	}
+	return NewSliceCursor(entries)
+}
+
+func (f FTPFS) Remove(ctx context.Context, key string) error {
+	return f.do(ctx, func(conn *ftp.ServerConn) error {
+		err := conn.Delete(f.filePath(key))
+		if err != nil {
+			// Could be an empty directory.
+			if dirErr := conn.RemoveDir(f.filePath(key)); dirErr == nil {
+				return nil
+			}
+		}
+		return err
+	})
+}
+
+func (f FTPFS) RemoveAll(ctx context.Context, key string) error {
+	err := f.do(ctx, func(conn *ftp.ServerConn) error {
+		if err := conn.Delete(f.filePath(key)); err == nil {
+			return nil
+		}
+		return conn.RemoveDirRecur(f.filePath(key))
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Find the entry in the listing of its parent directory, which works for
+// both files and directories on every server.
+func (f FTPFS) entry(ctx context.Context, key string) (entry *ftp.Entry, err error) {
+	name := f.filePath(key)
+	err = f.do(ctx, func(conn *ftp.ServerConn) error {
+		list, err := conn.List(path.Dir(name))
+		if err != nil {
+			return err
+		}
+		for _, item := range list {
+			if item.Name == path.Base(name) {
+				entry = item
+				return nil
+			}
+		}
+		return &os.PathError{Op: "stat", Path: key, Err: os.ErrNotExist}
+	})
+	return
+}
+
+func (f FTPFS) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := f.entry(ctx, key)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (f FTPFS) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	entry, err := f.entry(ctx, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if entry.Type != ftp.EntryTypeFile {
+		return ObjectInfo{}, &os.PathError{Op: "stat", Path: key, Err: os.ErrNotExist}
+	}
+	return ObjectInfo{
+		Key:     key,
+		Size:    int64(entry.Size),
+		ModTime: entry.Time,
+		ETag:    fmt.Sprintf("%x-%x", entry.Time.UnixNano(), entry.Size),
+	}, nil
+}
@@ -0,0 +1,182 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// Archives are uploaded before instance metadata is written, so recent
+	// ones could belong to uploads in progress.
+	DefaultGCMinAge = time.Hour
+)
+
+// CAS archive in the root repository (empty Repo) or a secondary one.
+type ArchiveInfo struct {
+	Repo string `json:"repo"`
+	Id   string `json:"id"`
+	// Content of a file of instances stored file by file, Id is its
+	// SHA-256.
+	File    bool          `json:"file,omitempty"`
+	Size    int64         `json:"size"`
+	ModTime UnixTimestamp `json:"mod_time"`
+}
+
+// Ids of the package instances, without reading their metadata.
+func (c *RegistryImpl) listInstanceIds(ctx context.Context, name string) ([]string, error) {
+	prefix := filepath.Join(RegistryPackagesPrefix, name, RegistryPackageInstancesPrefix)
+	entries, err := CollectCursor(ctx, c.rootRepository.List(ctx, prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if entry.IsPrefix && IsValidInstanceId(entry.Key) {
+			ids = append(ids, entry.Key)
+		}
+	}
+	return ids, err
+}
+
+// Find CAS archives which no instance refers to and are older than minAge,
+// and delete them unless dryRun is set.
+func (c *RegistryImpl) CollectGarbage(ctx context.Context, minAge time.Duration, dryRun bool) ([]ArchiveInfo, error) {
+	if !dryRun && !c.cfg.Admin {
+		return nil, fmt.Errorf("%w: CollectGarbage", ErrRegistryAdminIsNotAllowed)
+	}
+
+	repos := map[string]Repository{"": c.rootRepository}
+	for name, repo := range c.repositories {
+		repos[name] = repo
+	}
+	// Keyed by url, repositories could share the storage.
+	referenced := map[string]map[string]struct{}{}
+	for _, repo := range repos {
+		referenced[repo.GetConfig().URL] = map[string]struct{}{}
+	}
+
+	var names []string
+	err := c.walkPackageNames(ctx, "", func(name string) error {
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var lock sync.Mutex
+	err = runJobs(ctx, c.Jobs(), len(names), func(ctx context.Context, i int) error {
+		pkg, err := c.GetPackage(ctx, names[i])
+		if err != nil {
+			return err
+		}
+		ids, err := c.listInstanceIds(ctx, names[i])
+		if err != nil {
+			return err
+		}
+
+		repo, ok := repos[pkg.Repo]
+		if !ok {
+			return fmt.Errorf("%w: %s: %s", ErrUnknownRepo, names[i], pkg.Repo)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		for _, id := range ids {
+			referenced[repo.GetConfig().URL][id] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var garbage []ArchiveInfo
+	for name, repo := range repos {
+		ids, ok := referenced[repo.GetConfig().URL]
+		if !ok {
+			continue
+		}
+		delete(referenced, repo.GetConfig().URL)
+
+		entries, err := CollectCursor(ctx, repo.List(ctx, RegistryCASPrefix))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		if err = addDeltaBases(ctx, repo, entries, ids); err != nil {
+			return nil, err
+		}
+
+		var candidates []Instance
+		for _, entry := range entries {
+			id, compression, ok := parseCASName(entry.Key)
+			if entry.IsPrefix || !ok {
+				continue
+			}
+			if _, ok := ids[id]; !ok {
+				candidates = append(candidates, Instance{Id: id, Compression: compression})
+			}
+		}
+
+		// Collected by index, so archives are reported in listing order.
+		collected := make([]*ArchiveInfo, len(candidates))
+		err = runJobs(ctx, repositoryJobs(repo.GetConfig()), len(candidates), func(ctx context.Context, i int) error {
+			id := candidates[i].Id
+			info, err := repo.Stat(ctx, candidates[i].CASKey())
+			if err != nil {
+				return err
+			}
+			if time.Since(info.ModTime) < minAge {
+				return nil
+			}
+
+			if !dryRun {
+				if err = deleteArchive(ctx, repo, id, candidates[i].Compression); err != nil {
+					return err
+				}
+			}
+			collected[i] = &ArchiveInfo{
+				Repo:    name,
+				Id:      id,
+				Size:    info.Size,
+				ModTime: UnixTimestamp{info.ModTime},
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		deleted := map[string]bool{}
+		for i, archive := range collected {
+			if archive != nil {
+				garbage = append(garbage, *archive)
+				deleted[candidates[i].CASKey()] = true
+			}
+		}
+
+		// Files are kept while any remaining manifest refers to them,
+		// including ones of uploads in progress.
+		var manifests []string
+		for _, entry := range entries {
+			id, compression, ok := parseCASName(entry.Key)
+			if !entry.IsPrefix && ok && compression == CompressionFiles && !deleted[InstanceCASKey(id, compression)] {
+				manifests = append(manifests, id)
+			}
+		}
+		files, err := collectCASFiles(ctx, repo, manifests, minAge, dryRun)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			file.Repo = name
+			garbage = append(garbage, file)
+		}
+	}
+	return garbage, nil
+}
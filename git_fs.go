@@ -0,0 +1,475 @@
+package shop
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	for _, scheme := range []string{"git+ssh", "git+https", "git+http", "git+file"} {
+		MustRegisterBackend(Backend{
+			Scheme:       scheme,
+			Factory:      NewGitFS,
+			Capabilities: BackendCapabilities{ConditionalWrite: true, RemoveAll: true, Range: true},
+			Jobs:         1,
+		})
+	}
+}
+
+const (
+	DefaultGitBranch = "main"
+	// Subdirectory of the cache dir for git clones.
+	CacheGitDir = "git"
+
+	// Pushes rejected because of concurrent updates are retried on top of
+	// the new remote state.
+	gitPushAttempts = 5
+)
+
+var (
+	ErrGitNoBlobURL = errors.New("Git repository needs blob_url for CAS archives")
+)
+
+// Repository which keeps metadata as files committed into a git repository,
+// so every change of manifests, refs and tags is recorded in its history.
+// CAS archives don't belong in git and are stored in the blob repository.
+//
+// Remote is mirrored into a local clone under the cache dir, which is
+// fetched again before reads once it's older than the cache ttl. Every write
+// is committed and pushed right away, rejected pushes are replayed on top of
+// the fetched branch, which also makes WriteIf atomic.
+type GitFS struct {
+	cfg    RepositoryConfig
+	remote string
+	branch string
+	dir    string
+	args   []string
+	blobs  RepositoryFS
+	ttl    time.Duration
+	state  *gitState
+}
+
+type gitState struct {
+	lock   sync.RWMutex
+	synced time.Time
+}
+
+func NewGitFS(ctx context.Context, cfg RepositoryConfig) (RepositoryFS, error) {
+	access := GitAccessConfig{}
+	if cfg.Git != nil {
+		access = *cfg.Git
+	}
+	if access.BlobURL == "" {
+		return nil, fmt.Errorf("%w: %s", ErrGitNoBlobURL, cfg.URL)
+	}
+	if access.Branch == "" {
+		access.Branch = DefaultGitBranch
+	}
+
+	blobCfg := cfg
+	blobCfg.URL = access.BlobURL
+	blobCfg.Git = nil
+	blobCfg.Mirrors = nil
+	blobs, err := newRepositoryFS(ctx, blobCfg)
+	if err != nil {
+		return nil, fmt.Errorf("blob repository: %w", err)
+	}
+
+	cacheDir := ""
+	if cfg.Cache != nil {
+		cacheDir = cfg.Cache.Dir
+	}
+	if cacheDir == "" {
+		if cacheDir, err = os.UserCacheDir(); err != nil {
+			return nil, err
+		}
+		cacheDir = filepath.Join(cacheDir, "shop")
+	}
+	sum := sha256.Sum256([]byte(cfg.URL))
+
+	f := GitFS{
+		cfg:    cfg,
+		remote: strings.TrimPrefix(cfg.URL, "git+"),
+		branch: access.Branch,
+		dir:    filepath.Join(cacheDir, CacheGitDir, hex.EncodeToString(sum[:16])),
+		blobs:  blobs,
+		ttl:    DefaultCacheTTL,
+		state:  &gitState{},
+	}
+	if cfg.Cache != nil && cfg.Cache.TTL != 0 {
+		f.ttl = cfg.Cache.TTL
+	}
+	if access.AuthorName != "" {
+		f.args = append(f.args, "-c", "user.name="+access.AuthorName)
+	}
+	if access.AuthorEmail != "" {
+		f.args = append(f.args, "-c", "user.email="+access.AuthorEmail)
+	}
+
+	if err = f.init(ctx); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f GitFS) git(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append(f.args, args...)...)
+	cmd.Dir = f.dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (f GitFS) init(ctx context.Context) error {
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(ctx, f.dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err = os.Stat(filepath.Join(f.dir, ".git")); errors.Is(err, os.ErrNotExist) {
+		for _, args := range [][]string{
+			{"init", "-q"},
+			{"remote", "add", "origin", f.remote},
+			{"symbolic-ref", "HEAD", "refs/heads/" + f.branch},
+		} {
+			if _, err = f.git(ctx, args...); err != nil {
+				return err
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+
+	return f.sync(ctx)
+}
+
+// Make the clone match the remote branch. Remote without the branch yet
+// leaves the local branch unborn, the first push creates it.
+func (f GitFS) sync(ctx context.Context) error {
+	f.state.lock.Lock()
+	defer f.state.lock.Unlock()
+
+	f.state.synced = time.Now()
+	if _, err := f.git(ctx, "fetch", "-q", "origin"); err != nil {
+		return err
+	}
+	if _, err := f.git(ctx, "rev-parse", "-q", "--verify", "refs/remotes/origin/"+f.branch); err != nil {
+		return nil
+	}
+	if _, err := f.git(ctx, "reset", "-q", "--hard", "origin/"+f.branch); err != nil {
+		return err
+	}
+	_, err := f.git(ctx, "clean", "-q", "-fd")
+	return err
+}
+
+// Sync the clone before reads, if it's older than the cache ttl.
+func (f GitFS) refresh(ctx context.Context) error {
+	f.state.lock.RLock()
+	fresh := time.Since(f.state.synced) < f.ttl
+	f.state.lock.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	unlock, err := lockFile(ctx, f.dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return f.sync(ctx)
+}
+
+// Apply change to the clone, commit and push it. Change reports whether
+// it modified anything, it's called again on the new remote state when
+// the push is rejected.
+func (f GitFS) update(ctx context.Context, message string, change func() (bool, error)) error {
+	unlock, err := lockFile(ctx, f.dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for attempt := 1; ; attempt++ {
+		if err = f.sync(ctx); err != nil {
+			return err
+		}
+
+		f.state.lock.Lock()
+		err = f.commit(ctx, message, change)
+		f.state.lock.Unlock()
+		if err != nil {
+			return err
+		}
+
+		_, err = f.git(ctx, "push", "-q", "origin", "HEAD:refs/heads/"+f.branch)
+		if err == nil || attempt == gitPushAttempts {
+			return err
+		}
+	}
+}
+
+func (f GitFS) commit(ctx context.Context, message string, change func() (bool, error)) error {
+	changed, err := change()
+	if err != nil || !changed {
+		return err
+	}
+
+	if _, err = f.git(ctx, "add", "-A"); err != nil {
+		return err
+	}
+	if status, err := f.git(ctx, "status", "--porcelain"); err != nil || status == "" {
+		return err
+	}
+	_, err = f.git(ctx, "commit", "-q", "-m", message)
+	return err
+}
+
+func (f GitFS) isBlob(key string) bool {
+	return strings.HasPrefix(path.Clean("/"+key)+"/", RegistryCASPrefix)
+}
+
+func (f GitFS) filePath(key string) string {
+	return filepath.Join(f.dir, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+func (f GitFS) writeFile(key string, data []byte) error {
+	name := f.filePath(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0644)
+}
+
+func (f GitFS) Read(ctx context.Context, key string) ([]byte, error) {
+	if f.isBlob(key) {
+		return f.blobs.Read(ctx, key)
+	}
+
+	if err := f.refresh(ctx); err != nil {
+		return nil, err
+	}
+	f.state.lock.RLock()
+	defer f.state.lock.RUnlock()
+	return os.ReadFile(f.filePath(key))
+}
+
+func (f GitFS) Write(ctx context.Context, key string, data []byte) error {
+	if f.isBlob(key) {
+		return f.blobs.Write(ctx, key, data)
+	}
+
+	return f.update(ctx, "Update "+key, func() (bool, error) {
+		return true, f.writeFile(key, data)
+	})
+}
+
+func (f GitFS) WriteIf(ctx context.Context, key string, data []byte, etag string) error {
+	if f.isBlob(key) {
+		return writeIf(ctx, f.blobs, key, data, etag)
+	}
+
+	return f.update(ctx, "Update "+key, func() (bool, error) {
+		current, err := os.ReadFile(f.filePath(key))
+		if errors.Is(err, os.ErrNotExist) && etag == "" {
+			return true, f.writeFile(key, data)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+		if err != nil || gitBlobId(current) != etag {
+			return false, fmt.Errorf("%w: %s", ErrConditionFailed, key)
+		}
+		return true, f.writeFile(key, data)
+	})
+}
+
+func (f GitFS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if f.isBlob(key) {
+		return f.blobs.Open(ctx, key)
+	}
+
+	data, err := f.Read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (f GitFS) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if f.isBlob(key) {
+		return openRange(ctx, f.blobs, key, offset, length)
+	}
+
+	body, err := f.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return skipRange(body, offset, length)
+}
+
+type gitWriter struct {
+	bytes.Buffer
+	fs  GitFS
+	ctx context.Context
+	key string
+}
+
+func (w *gitWriter) Close() error {
+	return w.fs.WriteIf(w.ctx, w.key, w.Bytes(), "")
+}
+
+func (f GitFS) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	if f.isBlob(key) {
+		return f.blobs.Create(ctx, key)
+	}
+
+	ok, err := f.Exists(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, &fs.PathError{Op: "create", Path: key, Err: fs.ErrExist}
+	}
+	return &gitWriter{fs: f, ctx: ctx, key: key}, nil
+}
+
+// Git does not track directories, they only exist in the local clone.
+func (f GitFS) MakeDir(ctx context.Context, key string) error {
+	if f.isBlob(key) {
+		return f.blobs.MakeDir(ctx, key)
+	}
+
+	f.state.lock.RLock()
+	defer f.state.lock.RUnlock()
+	return os.MkdirAll(f.filePath(key), 0755)
+}
+
+func (f GitFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	if f.isBlob(key) {
+		return f.blobs.ListDir(ctx, key)
+	}
+
+	if err := f.refresh(ctx); err != nil {
+		return NewErrorCursor[Entry](err)
+	}
+	f.state.lock.RLock()
+	defer f.state.lock.RUnlock()
+
+	items, err := os.ReadDir(f.filePath(key))
+	if err != nil {
+		return NewErrorCursor[Entry](err)
+	}
+
+	entries := make([]Entry, 0, len(items))
+	for _, item := range items {
+		if item.Name() == ".git" {
+			continue
+		}
+		entries = append(entries, Entry{
+			Key:      item.Name(),
+			IsPrefix: item.IsDir(),
+		})
+	}
+	return NewSliceCursor(entries)
+}
+
+func (f GitFS) Remove(ctx context.Context, key string) error {
+	if f.isBlob(key) {
+		return f.blobs.Remove(ctx, key)
+	}
+
+	return f.update(ctx, "Delete "+key, func() (bool, error) {
+		return true, os.Remove(f.filePath(key))
+	})
+}
+
+func (f GitFS) RemoveAll(ctx context.Context, key string) error {
+	if f.isBlob(key) {
+		return removeAll(ctx, f.blobs, key)
+	}
+
+	return f.update(ctx, "Delete "+key, func() (bool, error) {
+		return true, os.RemoveAll(f.filePath(key))
+	})
+}
+
+func (f GitFS) Exists(ctx context.Context, key string) (bool, error) {
+	if f.isBlob(key) {
+		return f.blobs.Exists(ctx, key)
+	}
+
+	if err := f.refresh(ctx); err != nil {
+		return false, err
+	}
+	f.state.lock.RLock()
+	defer f.state.lock.RUnlock()
+
+	_, err := os.Stat(f.filePath(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ETag is the git blob id of the content, the same in every clone.
+func (f GitFS) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	if f.isBlob(key) {
+		return f.blobs.Stat(ctx, key)
+	}
+
+	if err := f.refresh(ctx); err != nil {
+		return ObjectInfo{}, err
+	}
+	f.state.lock.RLock()
+	defer f.state.lock.RUnlock()
+
+	name := f.filePath(key)
+	info, err := os.Stat(name)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	objectInfo, err := fileObjectInfo(key, info)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	objectInfo.ETag = gitBlobId(data)
+	return objectInfo, nil
+}
+
+func gitBlobId(data []byte) string {
+	hash := sha1.New()
+	fmt.Fprintf(hash, "blob %d\x00", len(data))
+	hash.Write(data)
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -4,14 +4,26 @@ go 1.22
 
 require (
 	github.com/hashicorp/go-multierror v1.1.1
+	github.com/hirochachacha/go-smb2 v1.1.0
+	github.com/jlaffaye/ftp v0.2.0
+	github.com/klauspost/compress v1.18.0
 	github.com/pelletier/go-toml/v2 v2.2.2
+	github.com/pkg/sftp v1.13.6
 	github.com/spf13/cobra v1.8.1
+	golang.org/x/crypto v0.25.0
+	golang.org/x/net v0.21.0
+	golang.org/x/sys v0.22.0
 	golang.org/x/term v0.22.0
+	golang.org/x/time v0.5.0
 )
 
 require (
+	github.com/cpuguy83/go-md2man/v2 v2.0.5 // indirect
+	github.com/geoffgarside/ber v1.2.0 // indirect
 	github.com/hashicorp/errwrap v1.0.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
-	golang.org/x/sys v0.22.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
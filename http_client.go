@@ -0,0 +1,220 @@
+package shop
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+var (
+	ErrHTTPAuthConflict  = errors.New("Only one of http user and token could be configured")
+	ErrInvalidTLSVersion = errors.New("Invalid TLS version")
+	ErrInvalidCAFile     = errors.New("No certificates found in CA file")
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLS settings of the repository, nil if defaults are used.
+func newTLSConfig(cfg RepositoryConfig) (*tls.Config, error) {
+	var certFile, keyFile string
+	if cfg.HTTP != nil {
+		certFile, keyFile = cfg.HTTP.ClientCert, cfg.HTTP.ClientKey
+	}
+	if cfg.TLS == nil && certFile == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+	if certFile != "" {
+		if keyFile == "" {
+			keyFile = certFile
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.TLS == nil {
+		return config, nil
+	}
+
+	if cfg.TLS.CAFile != "" {
+		pem, err := os.ReadFile(cfg.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCAFile, cfg.TLS.CAFile)
+		}
+	}
+
+	if cfg.TLS.MinVersion != "" {
+		version, ok := tlsVersions[cfg.TLS.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTLSVersion, cfg.TLS.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	config.InsecureSkipVerify = cfg.TLS.InsecureSkipVerify
+	return config, nil
+}
+
+// Plain http client with a dedicated transport, which caps connections to a
+// host by repository jobs. Every job could stream from one object to another
+// at once, so it gets two connections.
+func newHTTPStdClient(cfg RepositoryConfig) (*http.Client, error) {
+	config, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	transport.MaxConnsPerHost = 2 * repositoryJobs(cfg)
+	transport.MaxIdleConnsPerHost = transport.MaxConnsPerHost
+	return &http.Client{Transport: transport}, nil
+}
+
+// Common request helpers for http-based repository backends.
+type httpClient struct {
+	client *http.Client
+	header http.Header
+}
+
+// Client with headers, credentials and TLS settings of the repository
+// configuration applied.
+func newHTTPClient(cfg RepositoryConfig) (httpClient, error) {
+	client, err := newHTTPStdClient(cfg)
+	if err != nil {
+		return httpClient{}, err
+	}
+
+	c := httpClient{
+		client: client,
+		header: http.Header{},
+	}
+	for key, value := range cfg.Headers {
+		c.header.Set(key, value)
+	}
+
+	access := cfg.HTTP
+	if access == nil {
+		return c, nil
+	}
+
+	switch {
+	case access.User != "" && access.Token != "":
+		return c, fmt.Errorf("%w: %s", ErrHTTPAuthConflict, cfg.URL)
+	case access.User != "":
+		auth := base64.StdEncoding.EncodeToString([]byte(access.User + ":" + access.Password))
+		c.header.Set("Authorization", "Basic "+auth)
+	case access.Token != "":
+		c.header.Set("Authorization", "Bearer "+access.Token)
+	}
+
+	for key, value := range access.Headers {
+		c.header.Set(key, value)
+	}
+
+	return c, nil
+}
+
+func (c httpClient) do(ctx context.Context, method, url string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	return c.client.Do(req)
+}
+
+// Perform request and only keep response if status is 2xx.
+func (c httpClient) doOK(ctx context.Context, method, url string, body io.Reader, header http.Header) (*http.Response, error) {
+	resp, err := c.do(ctx, method, url, body, header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, NewHTTPStatusError(resp)
+	}
+	return resp, nil
+}
+
+// Perform request, discarding the response body.
+func (c httpClient) doDiscard(ctx context.Context, method, url string, body io.Reader, header http.Header) error {
+	resp, err := c.doOK(ctx, method, url, body, header)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+type httpUpload struct {
+	*io.PipeWriter
+	done chan error
+}
+
+func (u httpUpload) Close() error {
+	u.PipeWriter.Close()
+	return <-u.done
+}
+
+// Fail the request, so partial body is not stored.
+func (u httpUpload) Abort() error {
+	u.PipeWriter.CloseWithError(ErrUploadAborted)
+	<-u.done
+	return nil
+}
+
+// Stream everything written into the returned writer as a request body.
+// Close returns the result of the request.
+func (c httpClient) upload(ctx context.Context, method, url string, header http.Header) io.WriteCloser {
+	reader, writer := io.Pipe()
+	upload := httpUpload{
+		PipeWriter: writer,
+		done:       make(chan error, 1),
+	}
+
+	go func() {
+		err := c.doDiscard(ctx, method, url, reader, header)
+		reader.CloseWithError(err)
+		upload.done <- err
+	}()
+
+	return upload
+}
+
+// Object info from response headers of HEAD or GET request.
+func httpObjectInfo(key string, resp *http.Response) ObjectInfo {
+	info := ObjectInfo{
+		Key:  key,
+		Size: resp.ContentLength,
+		ETag: resp.Header.Get("ETag"),
+	}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info
+}
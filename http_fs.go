@@ -0,0 +1,210 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+func init() {
+	MustRegisterBackend(Backend{
+		Scheme:       "http",
+		Factory:      NewHTTPFS,
+		Capabilities: BackendCapabilities{Range: true},
+		Jobs:         8,
+	})
+	MustRegisterBackend(Backend{
+		Scheme:       "https",
+		Factory:      NewHTTPFS,
+		Capabilities: BackendCapabilities{Range: true},
+		Jobs:         8,
+	})
+}
+
+const (
+	RepositoryIndexKey = "index.json"
+)
+
+var (
+	ErrReadOnlyRepository = errors.New("Repository backend is read-only")
+)
+
+// Listing of a single prefix, stored as index.json inside of it, so plain
+// web servers and CDNs could serve directory listings.
+type RepositoryIndex struct {
+	ApiVersion string        `json:"api_version"`
+	Entries    []IndexEntry  `json:"entries"`
+	UpdatedAt  UnixTimestamp `json:"updated_at"`
+}
+
+type IndexEntry struct {
+	Key      string `json:"key"`
+	IsPrefix bool   `json:"is_prefix,omitempty"`
+}
+
+// Read-only repository served over plain http(s). Listing relies on the
+// index.json files generated by GenerateRepositoryIndex.
+type HTTPFS struct {
+	httpClient
+	cfg  RepositoryConfig
+	base *url.URL
+}
+
+func NewHTTPFS(ctx context.Context, cfg RepositoryConfig) (RepositoryFS, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	base := *u
+	base.Path = strings.TrimSuffix(base.Path, "/")
+
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return HTTPFS{
+		httpClient: client,
+		cfg:        cfg,
+		base:       &base,
+	}, nil
+}
+
+func (f HTTPFS) url(key string) string {
+	u := *f.base
+	u.Path = path.Join(u.Path, key)
+	return u.String()
+}
+
+func (f HTTPFS) Read(ctx context.Context, key string) ([]byte, error) {
+	body, err := f.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+func (f HTTPFS) Write(ctx context.Context, key string, data []byte) error {
+	return fmt.Errorf("%w: %s", ErrReadOnlyRepository, f.url(key))
+}
+
+func (f HTTPFS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := f.doOK(ctx, http.MethodGet, f.url(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (f HTTPFS) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	return f.openRange(ctx, f.url(key), offset, length)
+}
+
+func (f HTTPFS) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("%w: %s", ErrReadOnlyRepository, f.url(key))
+}
+
+func (f HTTPFS) MakeDir(ctx context.Context, key string) error {
+	return fmt.Errorf("%w: %s", ErrReadOnlyRepository, f.url(key))
+}
+
+func (f HTTPFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	data, err := f.Read(ctx, path.Join(key, RepositoryIndexKey))
+	if err != nil {
+		return NewErrorCursor[Entry](err)
+	}
+
+	var index RepositoryIndex
+	if err = decodeMetadata(data, &index); err != nil {
+		return NewErrorCursor[Entry](err)
+	}
+
+	entries := make([]Entry, 0, len(index.Entries))
+	for _, entry := range index.Entries {
+		entries = append(entries, Entry{
+			Key:      entry.Key,
+			IsPrefix: entry.IsPrefix,
+		})
+	}
+	return NewSliceCursor(entries)
+}
+
+func (f HTTPFS) Remove(ctx context.Context, key string) error {
+	return fmt.Errorf("%w: %s", ErrReadOnlyRepository, f.url(key))
+}
+
+func (f HTTPFS) exists(ctx context.Context, key string) (bool, error) {
+	err := f.doDiscard(ctx, http.MethodHead, f.url(key), nil, nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (f HTTPFS) Exists(ctx context.Context, key string) (bool, error) {
+	ok, err := f.exists(ctx, key)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	// Directories usually are not served by web servers, but they have
+	// index.json in them.
+	return f.exists(ctx, path.Join(key, RepositoryIndexKey))
+}
+
+func (f HTTPFS) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	resp, err := f.doOK(ctx, http.MethodHead, f.url(key), nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+	return httpObjectInfo(key, resp), nil
+}
+
+// Write index.json into prefix and all the prefixes under it, so the
+// repository could be served by HTTPFS.
+func GenerateRepositoryIndex(ctx context.Context, repo Repository, prefix string) error {
+	index := RepositoryIndex{
+		ApiVersion: LatestVersion,
+		Entries:    []IndexEntry{},
+		UpdatedAt:  UnixTimestamp{time.Now()},
+	}
+
+	cursor := repo.List(ctx, prefix)
+	for {
+		entry, err := cursor.GetNext(ctx)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			break
+		}
+
+		if !entry.IsPrefix && entry.Key == RepositoryIndexKey {
+			continue
+		}
+
+		if entry.IsPrefix {
+			if err = GenerateRepositoryIndex(ctx, repo, path.Join(prefix, entry.Key)); err != nil {
+				return err
+			}
+		}
+
+		index.Entries = append(index.Entries, IndexEntry{
+			Key:      entry.Key,
+			IsPrefix: entry.IsPrefix,
+		})
+	}
+
+	return repo.PutJSON(ctx, path.Join(prefix, RepositoryIndexKey), index)
+}
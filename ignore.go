@@ -0,0 +1,152 @@
+package shop
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+const (
+	// Patterns of files left out of archives of the uploaded directory, in
+	// gitignore syntax. Only the one at the root is read.
+	ShopIgnoreFile = ".shopignore"
+)
+
+var (
+	ErrInvalidIgnorePattern = errors.New("Invalid ignore pattern")
+)
+
+type ignorePattern struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// Gitignore rules, the last pattern matching the path decides whether it's
+// ignored. Files in ignored directories are ignored regardless of patterns.
+type IgnoreRules struct {
+	patterns []ignorePattern
+}
+
+// Rules of the ignore file: a pattern per line, blank lines and ones starting
+// with # are skipped.
+func ParseIgnoreRules(data string) (IgnoreRules, error) {
+	var rules IgnoreRules
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.HasSuffix(line, "\\ ") {
+			line = strings.TrimRight(line[:len(line)-2], " ") + "\\ "
+		} else {
+			line = strings.TrimRight(line, " ")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := rules.Add(line); err != nil {
+			return rules, fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+	return rules, nil
+}
+
+// Read ShopIgnoreFile at the root of fsys, no rules if there is none.
+func LoadIgnoreRules(fsys fs.FS) (IgnoreRules, error) {
+	data, err := fs.ReadFile(fsys, ShopIgnoreFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return IgnoreRules{}, nil
+	}
+	if err != nil {
+		return IgnoreRules{}, err
+	}
+	rules, err := ParseIgnoreRules(string(data))
+	if err != nil {
+		return rules, fmt.Errorf("%s: %w", ShopIgnoreFile, err)
+	}
+	return rules, nil
+}
+
+// Add the pattern after the others, so it takes precedence over them.
+// Patterns with a slash other than a trailing one are relative to the root,
+// other ones match names at any depth. ** matches any number of directories.
+func (r *IgnoreRules) Add(pattern string) error {
+	p := ignorePattern{}
+	original := pattern
+	switch {
+	case strings.HasPrefix(pattern, "!"):
+		p.negate, pattern = true, pattern[1:]
+	case strings.HasPrefix(pattern, "\\!"), strings.HasPrefix(pattern, "\\#"):
+		pattern = pattern[1:]
+	}
+	pattern, p.dirOnly = strings.CutSuffix(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" || pattern == "**/" {
+		return fmt.Errorf("%w: %q", ErrInvalidIgnorePattern, original)
+	}
+
+	p.segments = strings.Split(pattern, "/")
+	for _, segment := range p.segments {
+		if _, err := path.Match(segment, ""); err != nil || segment == "" {
+			return fmt.Errorf("%w: %q", ErrInvalidIgnorePattern, original)
+		}
+	}
+	r.patterns = append(r.patterns, p)
+	return nil
+}
+
+// Whether the slash separated path relative to the root is ignored. Its
+// parent directories are expected to be checked before it.
+func (r IgnoreRules) Ignored(name string, isDir bool) bool {
+	segments := strings.Split(name, "/")
+	ignored := false
+	for _, p := range r.patterns {
+		if (!p.dirOnly || isDir) && matchSegments(p.segments, segments) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// Whether the rules used as includes pick the path: it or one of its parent
+// directories matches them. No rules include everything.
+func (r IgnoreRules) includes(name string, isDir bool) bool {
+	if len(r.patterns) == 0 || r.Ignored(name, isDir) {
+		return true
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if r.Ignored(dir, true) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Trailing ** matches everything inside, but not the directory
+			// itself.
+			if len(pattern) == 1 {
+				return len(name) > 0
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
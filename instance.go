@@ -2,13 +2,17 @@ package shop
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"bufio"
 	"context"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -21,6 +25,45 @@ type Instance struct {
 	Id         string        `json:"id"`
 	UploadedAt UnixTimestamp `json:"uploaded_at"`
 	UpdatedAt  UnixTimestamp `json:"updated_at"`
+	// Yanked instances are only resolved by id, unless yanked ones are
+	// allowed in the registry config.
+	Yanked *Deprecation `json:"yanked,omitempty"`
+	// Packages installed along with the instance.
+	Dependencies []Dependency `json:"dependencies,omitempty"`
+	SBOM         *SBOM        `json:"sbom,omitempty"`
+	// Compression of the archive, gzip if not set.
+	Compression string `json:"compression,omitempty"`
+	// Instance of the package the delta archive is stored against.
+	DeltaBase string `json:"delta_base,omitempty"`
+}
+
+// Why and when a package was deprecated or an instance was yanked.
+type Deprecation struct {
+	Reason string        `json:"reason,omitempty"`
+	At     UnixTimestamp `json:"at"`
+}
+
+func NewDeprecation(reason string) *Deprecation {
+	return &Deprecation{
+		Reason: reason,
+		At:     UnixTimestamp{time.Now()},
+	}
+}
+
+func (d Deprecation) String() string {
+	if d.Reason == "" {
+		return "since " + d.At.Format(time.RFC3339)
+	}
+	return d.Reason
+}
+
+// Result of Registry.DeletePackageInstance.
+type InstanceDeletion struct {
+	Package string `json:"package"`
+	Id      string `json:"id"`
+	// Refs which pointed to the instance.
+	Refs           []string `json:"refs"`
+	ArchiveDeleted bool     `json:"archive_deleted"`
 }
 
 func NewInstance(pkg, id string) (instance Instance, err error) {
@@ -41,18 +84,6 @@ func NewInstance(pkg, id string) (instance Instance, err error) {
 	return
 }
 
-func IsValidInstanceId(id string) bool {
-	if len(id) != RegistryPackageInstanceIdLen {
-		return false
-	}
-	for _, c := range id {
-		if !strings.ContainsRune("0123456789abcdef", c) {
-			return false
-		}
-	}
-	return true
-}
-
 type TeeWriter []io.Writer
 
 type teeWriterError struct {
@@ -103,6 +134,10 @@ type stripOwnerFS struct {
 	fs.FS
 }
 
+func (f stripOwnerFS) ReadLink(name string) (string, error) {
+	return readLink(f.FS, name)
+}
+
 func (f stripOwnerFS) Open(path string) (file fs.File, err error) {
 	file, err = f.FS.Open(path)
 	if err == nil {
@@ -154,28 +189,397 @@ func (fi stripOwnerFileInfo) Sys() any {
 	return nil
 }
 
-func MakeArchive(dst io.Writer, fs fs.FS) (id string, err error) {
+// How archives are made, empty options are the defaults.
+type ArchiveOptions struct {
+	IdAlgorithm string
+	Compression string
+	// Keep setuid, setgid and sticky bits instead of failing on them.
+	AllowSpecialModes bool
+	// Files of the tree left out of the archive.
+	Ignore IgnoreRules
+	// Only files matching these rules, or in directories matching them, are
+	// put into the archive if there are any. Ignored ones are still left out.
+	Include IgnoreRules
+}
+
+func MakeArchive(dst io.Writer, fs fs.FS, opts ArchiveOptions) (id string, err error) {
 	fs = stripOwnerFS{fs}
 
-	h := sha1.New()
+	h, err := NewInstanceIdHash(opts.IdAlgorithm)
+	if err != nil {
+		return
+	}
 
 	tee := TeeWriter{dst, h}
-	compressor := gzip.NewWriter(tee)
-	archive := tar.NewWriter(compressor)
-
-	err = archive.AddFS(fs)
+	archive, err := newArchiveWriter(tee, opts.Compression)
 	if err != nil {
 		return
 	}
-	archive.Close()
+
+	err = addFS(archive, fs, opts)
 	if err != nil {
 		return
 	}
-	compressor.Close()
+	err = archive.Close()
 	if err != nil {
 		return
 	}
 
-	id = hex.EncodeToString(h.Sum(nil))
+	id = h.Id()
 	return
 }
+
+// Make an archive with a single file read from src, in the format of
+// MakeArchive.
+func MakeFileArchive(dst io.Writer, src io.Reader, file ArchiveFile, modTime time.Time, opts ArchiveOptions) (id string, err error) {
+	if !fs.ValidPath(file.Path) || file.Path == "." {
+		return "", fmt.Errorf("%w: %s", ErrInvalidArchive, file.Path)
+	}
+	if err = checkSpecialMode(file.Path, file.Mode, opts.AllowSpecialModes); err != nil {
+		return "", err
+	}
+	file.Mode = normalizeMode(file.Mode)
+
+	h, err := NewInstanceIdHash(opts.IdAlgorithm)
+	if err != nil {
+		return "", err
+	}
+	archive, err := newArchiveWriter(TeeWriter{dst, h}, opts.Compression)
+	if err != nil {
+		return "", err
+	}
+
+	err = archive.WriteFile(file, modTime, src)
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		return "", err
+	}
+	return h.Id(), nil
+}
+
+// Copy the archive made elsewhere into dst as is, checking that it could be
+// extracted by ExtractArchive. Modes are not normalized, but special ones are
+// checked. Returns its id and the detected compression, opts.Compression is
+// ignored.
+func CopyArchive(dst io.Writer, src io.Reader, opts ArchiveOptions) (id, compression string, err error) {
+	h, err := NewInstanceIdHash(opts.IdAlgorithm)
+	if err != nil {
+		return "", "", err
+	}
+	buffered := bufio.NewReader(io.TeeReader(src, TeeWriter{dst, h}))
+	header, err := buffered.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", "", err
+	}
+	files, err := ListArchive(buffered)
+	if err != nil {
+		return "", "", err
+	}
+	for _, file := range files {
+		if err = checkSpecialMode(file.Path, file.Mode, opts.AllowSpecialModes); err != nil {
+			return "", "", err
+		}
+	}
+	// Padding after the end of the archive is kept too.
+	if _, err = io.Copy(io.Discard, buffered); err != nil {
+		return "", "", err
+	}
+	return h.Id(), DetectCompression(header), nil
+}
+
+type ExtractOptions struct {
+	// Fail on symlinks to absolute paths or outside of the archive before
+	// they are created.
+	RejectUnsafeSymlinks bool
+}
+
+// Unpack the archive made by MakeArchive into dir. Entries pointing outside
+// of dir are rejected, as are ones under symlinks of the archive, which could
+// be written through them. Permissions are restored regardless of umask,
+// special bits are not.
+func ExtractArchive(src io.Reader, dir string, opts ExtractOptions) error {
+	archive, err := openArchive(src)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	links := map[string]bool{}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name, err := checkArchiveEntry(header)
+		if err != nil {
+			return err
+		}
+		for parent := name; parent != "."; parent = path.Dir(parent) {
+			if links[parent] {
+				return fmt.Errorf("%w: %s: under symlink %s", ErrInvalidArchive, header.Name, parent)
+			}
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		mode := fs.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, mode|0700)
+			if err == nil {
+				err = os.Chmod(target, mode|0700)
+			}
+		case tar.TypeSymlink:
+			if opts.RejectUnsafeSymlinks && isUnsafeSymlink(name, header.Linkname) {
+				return fmt.Errorf("%w: %s -> %s", ErrUnsafeSymlink, name, header.Linkname)
+			}
+			links[name] = true
+			err = extractSymlink(header.Linkname, target)
+		default:
+			err = extractFile(archive, target, mode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Read the whole archive made by MakeArchive, checking that it could be
+// extracted by ExtractArchive.
+func CheckArchive(src io.Reader) error {
+	archive, err := openArchive(src)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	defer archive.Close()
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			_, err = checkArchiveEntry(header)
+		}
+		if err == nil {
+			_, err = io.Copy(io.Discard, archive)
+		}
+		if err != nil {
+			if !errors.Is(err, ErrInvalidArchive) {
+				err = fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+			}
+			return err
+		}
+	}
+}
+
+// File, directory or symlink in the instance archive.
+type ArchiveFile struct {
+	Path string      `json:"path"`
+	Size int64       `json:"size"`
+	Mode fs.FileMode `json:"mode"`
+	// SHA-1 of the file content, empty for directories and symlinks.
+	Hash string `json:"hash,omitempty"`
+	// Target of the symlink as it's stored.
+	Target string `json:"target,omitempty"`
+}
+
+// Read the whole archive made by MakeArchive and list its entries in the
+// archive order.
+func ListArchive(src io.Reader) ([]ArchiveFile, error) {
+	archive, err := openArchive(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	defer archive.Close()
+
+	files := []ArchiveFile{}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		name, err := checkArchiveEntry(header)
+		if err != nil {
+			return nil, err
+		}
+
+		file := ArchiveFile{
+			Path:   name,
+			Size:   header.Size,
+			Mode:   header.FileInfo().Mode(),
+			Target: header.Linkname,
+		}
+		if header.Typeflag == tar.TypeReg {
+			h := sha1.New()
+			if _, err = io.Copy(h, archive); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+			}
+			file.Hash = hex.EncodeToString(h.Sum(nil))
+		}
+		files = append(files, file)
+	}
+}
+
+// Entry name without the trailing slash of directories.
+func checkArchiveEntry(header *tar.Header) (string, error) {
+	name := strings.TrimSuffix(header.Name, "/")
+	if !fs.ValidPath(name) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidArchive, header.Name)
+	}
+	switch header.Typeflag {
+	case tar.TypeDir, tar.TypeReg:
+	case tar.TypeSymlink:
+		if header.Linkname == "" {
+			return "", fmt.Errorf("%w: %s: empty symlink target", ErrInvalidArchive, header.Name)
+		}
+	default:
+		return "", fmt.Errorf("%w: %s: unsupported entry type %q", ErrInvalidArchive, header.Name, header.Typeflag)
+	}
+	return name, nil
+}
+
+func extractSymlink(target, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.Symlink(filepath.FromSlash(target), path)
+}
+
+func extractFile(src io.Reader, path string, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, src)
+	if err == nil {
+		err = file.Chmod(mode)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Download the instance archive into an anonymous temporary file, verified
+// and rewound to the start.
+func DownloadPackageInstanceFile(ctx context.Context, registry Registry, instance Instance) (*os.File, error) {
+	file, err := os.CreateTemp("", fmt.Sprintf("%s_*%s", instance.Id, ArchiveExtension(instance.Compression)))
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Remove(file.Name())
+	if err == nil {
+		err = registry.DownloadPackageInstance(ctx, instance, file)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Download the instance archive, check that its hash matches the instance
+// id and that it could be extracted.
+func VerifyPackageInstance(ctx context.Context, registry Registry, instance Instance) error {
+	file, err := DownloadPackageInstanceFile(ctx, registry, instance)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err = CheckArchive(file); err != nil {
+		return fmt.Errorf("%s@%s: %w", instance.Package, instance.Id, err)
+	}
+	return nil
+}
+
+// Files of the instance archive from the stored file manifest. Archives of
+// instances without one are streamed from the registry and read to the end,
+// so hash mismatches are reported.
+func ListPackageInstanceFiles(ctx context.Context, registry Registry, instance Instance) ([]ArchiveFile, error) {
+	files, err := registry.GetPackageInstanceFiles(ctx, instance)
+	if !errors.Is(err, os.ErrNotExist) {
+		return files, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(registry.DownloadPackageInstance(ctx, instance, writer))
+	}()
+	defer reader.Close()
+
+	files, err = ListArchive(reader)
+	if err == nil {
+		_, err = io.Copy(io.Discard, reader)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s@%s: %w", instance.Package, instance.Id, err)
+	}
+	return files, nil
+}
+
+// Copy content of the file at path in the archive into dst, without
+// extracting other files.
+func ExtractArchiveFile(src io.Reader, path string, dst io.Writer) error {
+	path = strings.TrimLeft(strings.TrimPrefix(path, "./"), "/")
+
+	archive, err := openArchive(src)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	defer archive.Close()
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return fmt.Errorf("%w: %s", ErrFileNotInArchive, path)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		if header.Typeflag == tar.TypeReg && strings.TrimPrefix(header.Name, "./") == path {
+			_, err = io.Copy(dst, archive)
+			return err
+		}
+	}
+}
+
+// Stream the file at path in the instance archive into dst. The rest of the
+// archive is still read to verify its hash, so ErrHashMismatch could be
+// returned after the file is written.
+func CatPackageInstanceFile(ctx context.Context, registry Registry, instance Instance, path string, dst io.Writer) error {
+	r, w := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := registry.DownloadPackageInstance(ctx, instance, w)
+		w.CloseWithError(err)
+		done <- err
+	}()
+
+	err := ExtractArchiveFile(r, path, dst)
+	if err == nil {
+		_, err = io.Copy(io.Discard, r)
+	}
+	r.CloseWithError(err)
+	if downloadErr := <-done; downloadErr != nil && !errors.Is(downloadErr, io.ErrClosedPipe) {
+		err = downloadErr
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+package shop
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+const (
+	InstanceIdSHA1   = "sha1"
+	InstanceIdSHA256 = "sha256"
+	// Algorithm of new instance ids, unless the registry config sets another.
+	DefaultInstanceIdAlgorithm = InstanceIdSHA256
+)
+
+var (
+	ErrUnknownIdAlgorithm = errors.New("Unknown instance id algorithm")
+)
+
+// Instance ids are hex digests of the archive. SHA-1 ids are bare for
+// compatibility with registries made before the algorithm was chosen, others
+// are prefixed with the algorithm, e.g. sha256-9f86d0...
+var instanceIdAlgorithms = map[string]func() hash.Hash{
+	InstanceIdSHA1:   sha1.New,
+	InstanceIdSHA256: sha256.New,
+}
+
+// Algorithm and hex digest of the id, ok is false if the id is invalid.
+func ParseInstanceId(id string) (algorithm, digest string, ok bool) {
+	algorithm, digest, prefixed := strings.Cut(id, "-")
+	if !prefixed {
+		algorithm, digest = InstanceIdSHA1, id
+	} else if algorithm == InstanceIdSHA1 {
+		return "", "", false
+	}
+	newHash, ok := instanceIdAlgorithms[algorithm]
+	if !ok || len(digest) != newHash().Size()*2 {
+		return "", "", false
+	}
+	for _, c := range digest {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return "", "", false
+		}
+	}
+	return algorithm, digest, true
+}
+
+func IsValidInstanceId(id string) bool {
+	_, _, ok := ParseInstanceId(id)
+	return ok
+}
+
+// Hash of the archive computing its id.
+type InstanceIdHash struct {
+	hash.Hash
+	algorithm string
+}
+
+// Empty algorithm is the default one.
+func NewInstanceIdHash(algorithm string) (*InstanceIdHash, error) {
+	if algorithm == "" {
+		algorithm = DefaultInstanceIdAlgorithm
+	}
+	newHash, ok := instanceIdAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownIdAlgorithm, algorithm)
+	}
+	return &InstanceIdHash{Hash: newHash(), algorithm: algorithm}, nil
+}
+
+// Hash in the algorithm of the id, to verify the archive. Invalid ids are
+// hashed with SHA-1, which never matches them.
+func instanceIdHashOf(id string) *InstanceIdHash {
+	algorithm, _, ok := ParseInstanceId(id)
+	if !ok {
+		algorithm = InstanceIdSHA1
+	}
+	return &InstanceIdHash{Hash: instanceIdAlgorithms[algorithm](), algorithm: algorithm}
+}
+
+func (h *InstanceIdHash) Id() string {
+	digest := hex.EncodeToString(h.Sum(nil))
+	if h.algorithm == InstanceIdSHA1 {
+		return digest
+	}
+	return h.algorithm + "-" + digest
+}
@@ -0,0 +1,149 @@
+package shop
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractArchive(t *testing.T) {
+	type entry struct {
+		name     string
+		typeflag byte
+		content  string
+	}
+	tests := []struct {
+		name    string
+		entries []entry
+		opts    ExtractOptions
+		files   testTree
+		err     error
+	}{
+		{
+			name: "files and symlinks",
+			entries: []entry{
+				{name: "d/", typeflag: tar.TypeDir},
+				{name: "d/a", typeflag: tar.TypeReg, content: "a"},
+				{name: "l", typeflag: tar.TypeSymlink, content: "d/a"},
+				{name: "up", typeflag: tar.TypeSymlink, content: "../outside"},
+			},
+			files: testTree{"d/a": "a", "l": "->d/a", "up": "->../outside"},
+		},
+		{
+			name:    "path outside of the dir",
+			entries: []entry{{name: "../outside/x", typeflag: tar.TypeReg, content: "x"}},
+			err:     ErrInvalidArchive,
+		},
+		{
+			name:    "absolute path",
+			entries: []entry{{name: "/x", typeflag: tar.TypeReg, content: "x"}},
+			err:     ErrInvalidArchive,
+		},
+		{
+			name: "file under symlink",
+			entries: []entry{
+				{name: "up", typeflag: tar.TypeSymlink, content: "../outside"},
+				{name: "up/x", typeflag: tar.TypeReg, content: "x"},
+			},
+			files: testTree{"up": "->../outside"},
+			err:   ErrInvalidArchive,
+		},
+		{
+			name: "safe symlink with the policy",
+			entries: []entry{
+				{name: "d/a", typeflag: tar.TypeReg, content: "a"},
+				{name: "d/l", typeflag: tar.TypeSymlink, content: "a"},
+			},
+			opts:  ExtractOptions{RejectUnsafeSymlinks: true},
+			files: testTree{"d/a": "a", "d/l": "->a"},
+		},
+		{
+			name:    "escaping symlink with the policy",
+			entries: []entry{{name: "up", typeflag: tar.TypeSymlink, content: "../outside"}},
+			opts:    ExtractOptions{RejectUnsafeSymlinks: true},
+			files:   testTree{},
+			err:     ErrUnsafeSymlink,
+		},
+		{
+			name:    "absolute symlink with the policy",
+			entries: []entry{{name: "d/etc", typeflag: tar.TypeSymlink, content: "/etc"}},
+			opts:    ExtractOptions{RejectUnsafeSymlinks: true},
+			files:   testTree{},
+			err:     ErrUnsafeSymlink,
+		},
+		{
+			name:    "hard link",
+			entries: []entry{{name: "x", typeflag: tar.TypeLink, content: "y"}},
+			err:     ErrInvalidArchive,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var archive bytes.Buffer
+			w := tar.NewWriter(&archive)
+			for _, entry := range test.entries {
+				header := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Mode: 0644}
+				switch entry.typeflag {
+				case tar.TypeReg:
+					header.Size = int64(len(entry.content))
+				case tar.TypeDir:
+					header.Mode = 0755
+				default:
+					header.Linkname = entry.content
+				}
+				err := w.WriteHeader(header)
+				if err == nil && header.Size > 0 {
+					_, err = w.Write([]byte(entry.content))
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			base := t.TempDir()
+			dir, outside := filepath.Join(base, "dir"), filepath.Join(base, "outside")
+			for _, p := range []string{dir, outside} {
+				if err := os.Mkdir(p, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := ExtractArchive(&archive, dir, test.opts)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+
+			entries, err := os.ReadDir(outside)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("%d files written outside of the dir", len(entries))
+			}
+			checkTree(t, dir, test.files)
+		})
+	}
+}
+
+func TestExtractArchiveCompressions(t *testing.T) {
+	tree := testTree{"a": "a", "d/b": "b", "d/e/c": "c", "l": "->d/b"}
+	for _, compression := range []string{CompressionGzip, CompressionZstd, CompressionNone, CompressionZip} {
+		t.Run(compression, func(t *testing.T) {
+			var archive bytes.Buffer
+			if _, err := MakeArchive(&archive, DirFS(tree.write(t)), ArchiveOptions{Compression: compression}); err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			if err := ExtractArchive(&archive, dir, ExtractOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			checkTree(t, dir, tree)
+		})
+	}
+}
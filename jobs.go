@@ -0,0 +1,70 @@
+package shop
+
+import (
+	"context"
+	"net/url"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	// Parallel operations of batch commands with backends which don't set
+	// their own default.
+	DefaultJobs = 4
+)
+
+// Parallel operations with the repository: from the configuration, or the
+// default of its backend.
+func repositoryJobs(cfg RepositoryConfig) int {
+	if cfg.Jobs > 0 {
+		return cfg.Jobs
+	}
+	if u, err := url.Parse(cfg.URL); err == nil {
+		if backend, ok := LookupBackend(u.Scheme); ok && backend.Jobs > 0 {
+			return backend.Jobs
+		}
+	}
+	return DefaultJobs
+}
+
+// Call fn for every index in [0, n) with at most jobs calls at once. The
+// first error cancels the context passed to running calls, stops starting
+// new ones and is returned.
+func runJobs(ctx context.Context, jobs, n int, fn func(ctx context.Context, i int) error) error {
+	jobsCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var group multierror.Group
+	var once sync.Once
+	var failed error
+	slots := make(chan struct{}, max(jobs, 1))
+
+	for i := 0; i < n; i++ {
+		select {
+		case slots <- struct{}{}:
+		case <-jobsCtx.Done():
+		}
+		if jobsCtx.Err() != nil {
+			break
+		}
+
+		group.Go(func() error {
+			defer func() { <-slots }()
+			err := fn(jobsCtx, i)
+			if err != nil {
+				once.Do(func() {
+					failed = err
+					cancel()
+				})
+			}
+			return err
+		})
+	}
+
+	group.Wait()
+	if failed == nil {
+		failed = ctx.Err()
+	}
+	return failed
+}
@@ -0,0 +1,208 @@
+package shop
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+// RepositoryFS wrapper which logs every operation into slog.Default():
+// successful ones at debug level, failed ones at warning level. Streams are
+// logged once they are closed, with the number of transferred bytes. Wraps
+// the backend directly, so every retry is logged separately.
+type LoggingFS struct {
+	fs  RepositoryFS
+	url string
+}
+
+func NewLoggingFS(fs RepositoryFS, cfg RepositoryConfig) RepositoryFS {
+	rawURL := cfg.URL
+	if u, err := url.Parse(rawURL); err == nil {
+		rawURL = u.Redacted()
+	}
+	return LoggingFS{
+		fs:  fs,
+		url: rawURL,
+	}
+}
+
+func (f LoggingFS) Unwrap() RepositoryFS {
+	return f.fs
+}
+
+func (f LoggingFS) log(ctx context.Context, op, key string, start time.Time, n int64, err error) {
+	level := slog.LevelDebug
+	attrs := []slog.Attr{
+		slog.String("repo", f.url),
+		slog.String("op", op),
+		slog.String("key", key),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if n > 0 {
+		attrs = append(attrs, slog.Int64("bytes", n))
+	}
+	if err != nil {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	slog.Default().LogAttrs(ctx, level, "repository operation", attrs...)
+}
+
+func (f LoggingFS) Read(ctx context.Context, key string) (data []byte, err error) {
+	defer func(start time.Time) { f.log(ctx, "read", key, start, int64(len(data)), err) }(time.Now())
+	return f.fs.Read(ctx, key)
+}
+
+func (f LoggingFS) Write(ctx context.Context, key string, data []byte) (err error) {
+	defer func(start time.Time) { f.log(ctx, "write", key, start, int64(len(data)), err) }(time.Now())
+	return f.fs.Write(ctx, key, data)
+}
+
+type loggingReader struct {
+	io.ReadCloser
+	fs    LoggingFS
+	ctx   context.Context
+	key   string
+	start time.Time
+	n     int64
+	err   error
+}
+
+func (r *loggingReader) Read(data []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(data)
+	r.n += int64(n)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return
+}
+
+func (r *loggingReader) Close() error {
+	err := r.ReadCloser.Close()
+	if r.err == nil {
+		r.err = err
+	}
+	r.fs.log(r.ctx, "open", r.key, r.start, r.n, r.err)
+	return err
+}
+
+func (f LoggingFS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	start := time.Now()
+	body, err := f.fs.Open(ctx, key)
+	if err != nil {
+		f.log(ctx, "open", key, start, 0, err)
+		return nil, err
+	}
+	return &loggingReader{
+		ReadCloser: body,
+		fs:         f,
+		ctx:        ctx,
+		key:        key,
+		start:      start,
+	}, nil
+}
+
+type loggingWriter struct {
+	io.WriteCloser
+	fs    LoggingFS
+	ctx   context.Context
+	key   string
+	start time.Time
+	n     int64
+	err   error
+}
+
+func (w *loggingWriter) Write(data []byte) (n int, err error) {
+	n, err = w.WriteCloser.Write(data)
+	w.n += int64(n)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return
+}
+
+func (w *loggingWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if w.err == nil {
+		w.err = err
+	}
+	w.fs.log(w.ctx, "create", w.key, w.start, w.n, w.err)
+	return err
+}
+
+func (w *loggingWriter) Abort() error {
+	var err error
+	if aborter, ok := w.WriteCloser.(Aborter); ok {
+		err = aborter.Abort()
+	} else {
+		err = w.WriteCloser.Close()
+	}
+	if w.err == nil {
+		w.err = ErrUploadAborted
+	}
+	w.fs.log(w.ctx, "create", w.key, w.start, w.n, w.err)
+	return err
+}
+
+func (f LoggingFS) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	start := time.Now()
+	w, err := f.fs.Create(ctx, key)
+	if err != nil {
+		f.log(ctx, "create", key, start, 0, err)
+		return nil, err
+	}
+	return &loggingWriter{
+		WriteCloser: w,
+		fs:          f,
+		ctx:         ctx,
+		key:         key,
+		start:       start,
+	}, nil
+}
+
+func (f LoggingFS) MakeDir(ctx context.Context, key string) (err error) {
+	defer func(start time.Time) { f.log(ctx, "mkdir", key, start, 0, err) }(time.Now())
+	return f.fs.MakeDir(ctx, key)
+}
+
+type loggingCursor struct {
+	cursor Cursor[Entry]
+	fs     LoggingFS
+	key    string
+	start  time.Time
+}
+
+// Listing is logged once it's finished.
+func (c loggingCursor) GetNext(ctx context.Context) (entry *Entry, err error) {
+	entry, err = c.cursor.GetNext(ctx)
+	if entry == nil || err != nil {
+		c.fs.log(ctx, "list", c.key, c.start, 0, err)
+	}
+	return
+}
+
+func (f LoggingFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	return loggingCursor{
+		cursor: f.fs.ListDir(ctx, key),
+		fs:     f,
+		key:    key,
+		start:  time.Now(),
+	}
+}
+
+func (f LoggingFS) Remove(ctx context.Context, key string) (err error) {
+	defer func(start time.Time) { f.log(ctx, "remove", key, start, 0, err) }(time.Now())
+	return f.fs.Remove(ctx, key)
+}
+
+func (f LoggingFS) Exists(ctx context.Context, key string) (ok bool, err error) {
+	defer func(start time.Time) { f.log(ctx, "exists", key, start, 0, err) }(time.Now())
+	return f.fs.Exists(ctx, key)
+}
+
+func (f LoggingFS) Stat(ctx context.Context, key string) (info ObjectInfo, err error) {
+	defer func(start time.Time) { f.log(ctx, "stat", key, start, 0, err) }(time.Now())
+	return f.fs.Stat(ctx, key)
+}
@@ -0,0 +1,314 @@
+package shop
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	MustRegisterBackend(Backend{
+		Scheme:       "mem",
+		Factory:      NewMemFS,
+		Capabilities: BackendCapabilities{Range: true, ConditionalWrite: true, Copy: true, RemoveAll: true},
+		Jobs:         8,
+	})
+}
+
+var (
+	memFSStoresLock sync.Mutex
+	memFSStores     = map[string]*memFSStore{}
+)
+
+type memFSFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+type memFSStore struct {
+	lock  sync.RWMutex
+	files map[string]memFSFile
+	dirs  map[string]struct{}
+}
+
+func newMemFSStore() *memFSStore {
+	return &memFSStore{
+		files: map[string]memFSFile{},
+		dirs:  map[string]struct{}{"/": struct{}{}},
+	}
+}
+
+// In-memory repository. Repositories with the same url host share the same
+// storage for the lifetime of the process, e.g. mem://test.
+type MemFS struct {
+	cfg   RepositoryConfig
+	store *memFSStore
+	path  string
+}
+
+func NewMemFS(ctx context.Context, cfg RepositoryConfig) (RepositoryFS, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	memFSStoresLock.Lock()
+	defer memFSStoresLock.Unlock()
+
+	store, ok := memFSStores[u.Host]
+	if !ok {
+		store = newMemFSStore()
+		memFSStores[u.Host] = store
+	}
+
+	return MemFS{
+		cfg:   cfg,
+		store: store,
+		path:  path.Clean("/" + u.Path),
+	}, nil
+}
+
+func (f MemFS) key(key string) string {
+	return path.Join(f.path, key)
+}
+
+func (s *memFSStore) makeParents(key string) {
+	for dir := path.Dir(key); ; dir = path.Dir(dir) {
+		s.dirs[dir] = struct{}{}
+		if dir == "/" {
+			break
+		}
+	}
+}
+
+func (f MemFS) Read(ctx context.Context, key string) ([]byte, error) {
+	f.store.lock.RLock()
+	defer f.store.lock.RUnlock()
+
+	file, ok := f.store.files[f.key(key)]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: key, Err: fs.ErrNotExist}
+	}
+	return bytes.Clone(file.data), nil
+}
+
+// Store data at full key, lock must be held.
+func (s *memFSStore) write(key string, data []byte) error {
+	if _, ok := s.dirs[key]; ok {
+		return &fs.PathError{Op: "write", Path: key, Err: fs.ErrExist}
+	}
+
+	s.makeParents(key)
+	s.files[key] = memFSFile{
+		data:    bytes.Clone(data),
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+func (file memFSFile) etag() string {
+	return fmt.Sprintf("%x-%x", file.modTime.UnixNano(), len(file.data))
+}
+
+func (f MemFS) Write(ctx context.Context, key string, data []byte) error {
+	f.store.lock.Lock()
+	defer f.store.lock.Unlock()
+
+	return f.store.write(f.key(key), data)
+}
+
+func (f MemFS) WriteIf(ctx context.Context, key string, data []byte, etag string) error {
+	f.store.lock.Lock()
+	defer f.store.lock.Unlock()
+
+	file, ok := f.store.files[f.key(key)]
+	if ok != (etag != "") || (ok && file.etag() != etag) {
+		return fmt.Errorf("%w: %s", ErrConditionFailed, key)
+	}
+	return f.store.write(f.key(key), data)
+}
+
+func (f MemFS) Copy(ctx context.Context, src, dst string) error {
+	f.store.lock.Lock()
+	defer f.store.lock.Unlock()
+
+	file, ok := f.store.files[f.key(src)]
+	if !ok {
+		return &fs.PathError{Op: "copy", Path: src, Err: fs.ErrNotExist}
+	}
+	if _, ok = f.store.files[f.key(dst)]; ok {
+		return &fs.PathError{Op: "copy", Path: dst, Err: fs.ErrExist}
+	}
+	return f.store.write(f.key(dst), file.data)
+}
+
+func (f MemFS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	data, err := f.Read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (f MemFS) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	data, err := f.Read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	data = data[min(offset, int64(len(data))):]
+	if length >= 0 {
+		data = data[:min(length, int64(len(data)))]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+type memFSWriter struct {
+	bytes.Buffer
+	fs  MemFS
+	ctx context.Context
+	key string
+}
+
+func (w *memFSWriter) Close() error {
+	return w.fs.Write(w.ctx, w.key, w.Bytes())
+}
+
+func (f MemFS) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	ok, err := f.Exists(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, &fs.PathError{Op: "create", Path: key, Err: fs.ErrExist}
+	}
+
+	return &memFSWriter{
+		fs:  f,
+		ctx: ctx,
+		key: key,
+	}, nil
+}
+
+func (f MemFS) MakeDir(ctx context.Context, key string) error {
+	f.store.lock.Lock()
+	defer f.store.lock.Unlock()
+
+	key = f.key(key)
+	if _, ok := f.store.files[key]; ok {
+		return &fs.PathError{Op: "mkdir", Path: key, Err: fs.ErrExist}
+	}
+
+	f.store.makeParents(key)
+	f.store.dirs[key] = struct{}{}
+	return nil
+}
+
+func (f MemFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	f.store.lock.RLock()
+	defer f.store.lock.RUnlock()
+
+	key = f.key(key)
+	if _, ok := f.store.dirs[key]; !ok {
+		return NewErrorCursor[Entry](&fs.PathError{Op: "list", Path: key, Err: fs.ErrNotExist})
+	}
+
+	var entries []Entry
+	for dir := range f.store.dirs {
+		if dir != key && path.Dir(dir) == key {
+			entries = append(entries, Entry{Key: path.Base(dir), IsPrefix: true})
+		}
+	}
+	for file := range f.store.files {
+		if path.Dir(file) == key {
+			entries = append(entries, Entry{Key: path.Base(file)})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return NewSliceCursor(entries)
+}
+
+func (f MemFS) Remove(ctx context.Context, key string) error {
+	f.store.lock.Lock()
+	defer f.store.lock.Unlock()
+
+	key = f.key(key)
+	if _, ok := f.store.files[key]; ok {
+		delete(f.store.files, key)
+		return nil
+	}
+
+	if _, ok := f.store.dirs[key]; !ok {
+		return &fs.PathError{Op: "remove", Path: key, Err: fs.ErrNotExist}
+	}
+
+	prefix := strings.TrimSuffix(key, "/") + "/"
+	for dir := range f.store.dirs {
+		if strings.HasPrefix(dir, prefix) {
+			return &fs.PathError{Op: "remove", Path: key, Err: fs.ErrExist}
+		}
+	}
+	for file := range f.store.files {
+		if strings.HasPrefix(file, prefix) {
+			return &fs.PathError{Op: "remove", Path: key, Err: fs.ErrExist}
+		}
+	}
+
+	delete(f.store.dirs, key)
+	return nil
+}
+
+func (f MemFS) RemoveAll(ctx context.Context, key string) error {
+	f.store.lock.Lock()
+	defer f.store.lock.Unlock()
+
+	key = f.key(key)
+	prefix := strings.TrimSuffix(key, "/") + "/"
+	for file := range f.store.files {
+		if file == key || strings.HasPrefix(file, prefix) {
+			delete(f.store.files, file)
+		}
+	}
+	for dir := range f.store.dirs {
+		if dir != "/" && (dir == key || strings.HasPrefix(dir, prefix)) {
+			delete(f.store.dirs, dir)
+		}
+	}
+	return nil
+}
+
+func (f MemFS) Exists(ctx context.Context, key string) (bool, error) {
+	f.store.lock.RLock()
+	defer f.store.lock.RUnlock()
+
+	key = f.key(key)
+	_, isFile := f.store.files[key]
+	_, isDir := f.store.dirs[key]
+	return isFile || isDir, nil
+}
+
+func (f MemFS) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	f.store.lock.RLock()
+	defer f.store.lock.RUnlock()
+
+	file, ok := f.store.files[f.key(key)]
+	if !ok {
+		return ObjectInfo{}, &fs.PathError{Op: "stat", Path: key, Err: fs.ErrNotExist}
+	}
+	return ObjectInfo{
+		Key:     key,
+		Size:    int64(len(file.data)),
+		ModTime: file.modTime,
+		ETag:    file.etag(),
+	}, nil
+}
@@ -0,0 +1,88 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestMemFS(t *testing.T) {
+	tests := []struct {
+		name string
+		// Files written before the call, by key.
+		files map[string]string
+		call  func(ctx context.Context, fs RepositoryFS) error
+		err   error
+	}{
+		{
+			name:  "read",
+			files: map[string]string{"a/b": "b"},
+			call: func(ctx context.Context, f RepositoryFS) error {
+				data, err := f.Read(ctx, "a/b")
+				if err == nil && string(data) != "b" {
+					err = fmt.Errorf("got %q", data)
+				}
+				return err
+			},
+		},
+		{
+			name:  "parent dir is created on write",
+			files: map[string]string{"a/b": "b"},
+			call: func(ctx context.Context, f RepositoryFS) error {
+				entries, err := CollectCursor(ctx, f.ListDir(ctx, ""))
+				if err == nil && (len(entries) != 1 || entries[0].Key != "a" || !entries[0].IsPrefix) {
+					err = fmt.Errorf("got entries %v, want directory a", entries)
+				}
+				return err
+			},
+		},
+		{
+			name:  "remove non-empty dir",
+			files: map[string]string{"a/b": "b"},
+			call: func(ctx context.Context, f RepositoryFS) error {
+				return f.Remove(ctx, "a")
+			},
+			err: fs.ErrExist,
+		},
+		{
+			name: "remove missing key",
+			call: func(ctx context.Context, f RepositoryFS) error {
+				return f.Remove(ctx, "a")
+			},
+			err: fs.ErrNotExist,
+		},
+		{
+			name:  "store is shared by host",
+			files: map[string]string{"a": "a"},
+			call: func(ctx context.Context, f RepositoryFS) error {
+				other, err := NewMemFS(ctx, RepositoryConfig{URL: strings.TrimSuffix(f.(MemFS).cfg.URL, "/root")})
+				if err == nil {
+					_, err = other.Read(ctx, "root/a")
+				}
+				return err
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			host := strings.NewReplacer("/", "-", "_", "-").Replace(t.Name())
+			f, err := NewMemFS(ctx, RepositoryConfig{URL: "mem://" + host + "/root"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, content := range test.files {
+				if err = f.Write(ctx, key, []byte(content)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = test.call(ctx, f); !errors.Is(err, test.err) {
+				t.Errorf("got error %v, want %v", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package shop
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// Encode metadata object as JSON, gzip-compressed if requested.
+func encodeMetadata(input any, compress bool) ([]byte, error) {
+	data, err := json.Marshal(input)
+	if err != nil || !compress {
+		return data, err
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode metadata object stored either as plain or gzip-compressed JSON.
+// JSON never starts with the gzip magic, so both could be read from the same
+// repository.
+func decodeMetadata(data []byte, output any) error {
+	if bytes.HasPrefix(data, gzipMagic) {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		if data, err = io.ReadAll(r); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, output)
+}
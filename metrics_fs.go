@@ -0,0 +1,243 @@
+package shop
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RepositoryMetricsVar = "shop_repository"
+)
+
+var (
+	// Per repository url, per operation counters: calls, errors, bytes and
+	// latency_seconds (sum over all calls).
+	repositoryMetrics     = expvar.NewMap(RepositoryMetricsVar)
+	repositoryMetricsLock sync.Mutex
+
+	repositoryOperations = []string{"read", "write", "open", "create", "mkdir", "list", "remove", "exists", "stat",
+		"write_if", "copy", "remove_all", "create_multipart", "resume_multipart", "upload_part",
+		"complete_multipart", "abort_multipart", "sign_url", "open_range"}
+)
+
+// RepositoryFS wrapper which records per operation statistics into expvar.
+// Wraps the backend directly, so every retry is counted as a separate call.
+type MetricsFS struct {
+	fs  RepositoryFS
+	ops map[string]*expvar.Map
+}
+
+func repositoryMetricsFor(rawURL string) *expvar.Map {
+	if u, err := url.Parse(rawURL); err == nil {
+		rawURL = u.Redacted()
+	}
+
+	repositoryMetricsLock.Lock()
+	defer repositoryMetricsLock.Unlock()
+
+	if m, ok := repositoryMetrics.Get(rawURL).(*expvar.Map); ok {
+		return m
+	}
+
+	m := new(expvar.Map).Init()
+	for _, op := range repositoryOperations {
+		m.Set(op, new(expvar.Map).Init())
+	}
+	repositoryMetrics.Set(rawURL, m)
+	return m
+}
+
+func NewMetricsFS(fs RepositoryFS, cfg RepositoryConfig) RepositoryFS {
+	metrics := repositoryMetricsFor(cfg.URL)
+	f := MetricsFS{
+		fs:  fs,
+		ops: map[string]*expvar.Map{},
+	}
+	for _, op := range repositoryOperations {
+		f.ops[op] = metrics.Get(op).(*expvar.Map)
+	}
+	return f
+}
+
+func (f MetricsFS) Unwrap() RepositoryFS {
+	return f.fs
+}
+
+func (f MetricsFS) record(op string, start time.Time, n int, err error) {
+	m := f.ops[op]
+	m.Add("calls", 1)
+	if err != nil {
+		m.Add("errors", 1)
+	}
+	m.Add("bytes", int64(n))
+	m.AddFloat("latency_seconds", time.Since(start).Seconds())
+}
+
+func (f MetricsFS) Read(ctx context.Context, key string) (data []byte, err error) {
+	defer func(start time.Time) { f.record("read", start, len(data), err) }(time.Now())
+	return f.fs.Read(ctx, key)
+}
+
+func (f MetricsFS) Write(ctx context.Context, key string, data []byte) (err error) {
+	defer func(start time.Time) { f.record("write", start, len(data), err) }(time.Now())
+	return f.fs.Write(ctx, key, data)
+}
+
+type metricsReader struct {
+	io.ReadCloser
+	metrics *expvar.Map
+}
+
+func (r metricsReader) Read(data []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(data)
+	r.metrics.Add("bytes", int64(n))
+	if err != nil && err != io.EOF {
+		r.metrics.Add("errors", 1)
+	}
+	return
+}
+
+func (f MetricsFS) Open(ctx context.Context, key string) (body io.ReadCloser, err error) {
+	defer func(start time.Time) { f.record("open", start, 0, err) }(time.Now())
+	body, err = f.fs.Open(ctx, key)
+	if err == nil {
+		body = metricsReader{
+			ReadCloser: body,
+			metrics:    f.ops["open"],
+		}
+	}
+	return
+}
+
+type metricsWriter struct {
+	io.WriteCloser
+	metrics *expvar.Map
+}
+
+func (w metricsWriter) Write(data []byte) (n int, err error) {
+	n, err = w.WriteCloser.Write(data)
+	w.metrics.Add("bytes", int64(n))
+	if err != nil {
+		w.metrics.Add("errors", 1)
+	}
+	return
+}
+
+func (w metricsWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if err != nil {
+		w.metrics.Add("errors", 1)
+	}
+	return err
+}
+
+func (w metricsWriter) Abort() error {
+	if aborter, ok := w.WriteCloser.(Aborter); ok {
+		return aborter.Abort()
+	}
+	return w.WriteCloser.Close()
+}
+
+func (f MetricsFS) Create(ctx context.Context, key string) (w io.WriteCloser, err error) {
+	defer func(start time.Time) { f.record("create", start, 0, err) }(time.Now())
+	w, err = f.fs.Create(ctx, key)
+	if err == nil {
+		w = metricsWriter{
+			WriteCloser: w,
+			metrics:     f.ops["create"],
+		}
+	}
+	return
+}
+
+func (f MetricsFS) MakeDir(ctx context.Context, key string) (err error) {
+	defer func(start time.Time) { f.record("mkdir", start, 0, err) }(time.Now())
+	return f.fs.MakeDir(ctx, key)
+}
+
+type metricsCursor struct {
+	cursor  Cursor[Entry]
+	metrics *expvar.Map
+}
+
+func (c metricsCursor) GetNext(ctx context.Context) (entry *Entry, err error) {
+	entry, err = c.cursor.GetNext(ctx)
+	if err != nil {
+		c.metrics.Add("errors", 1)
+	}
+	return
+}
+
+func (f MetricsFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	f.record("list", time.Now(), 0, nil)
+	return metricsCursor{
+		cursor:  f.fs.ListDir(ctx, key),
+		metrics: f.ops["list"],
+	}
+}
+
+func (f MetricsFS) Remove(ctx context.Context, key string) (err error) {
+	defer func(start time.Time) { f.record("remove", start, 0, err) }(time.Now())
+	return f.fs.Remove(ctx, key)
+}
+
+func (f MetricsFS) Exists(ctx context.Context, key string) (ok bool, err error) {
+	defer func(start time.Time) { f.record("exists", start, 0, err) }(time.Now())
+	return f.fs.Exists(ctx, key)
+}
+
+func (f MetricsFS) Stat(ctx context.Context, key string) (info ObjectInfo, err error) {
+	defer func(start time.Time) { f.record("stat", start, 0, err) }(time.Now())
+	return f.fs.Stat(ctx, key)
+}
+
+func escapePrometheusLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// Write repository metrics in Prometheus text exposition format.
+func WriteRepositoryMetrics(w io.Writer) error {
+	metrics := []struct {
+		field, name, kind, help string
+	}{
+		{"calls", "shop_repository_calls_total", "counter", "Repository operations performed."},
+		{"errors", "shop_repository_errors_total", "counter", "Repository operations failed."},
+		{"bytes", "shop_repository_bytes_total", "counter", "Bytes transferred by repository operations."},
+		{"latency_seconds", "shop_repository_latency_seconds_sum", "counter", "Total time spent in repository operations."},
+	}
+
+	type sample struct {
+		labels string
+		value  string
+	}
+	samples := map[string][]sample{}
+	repositoryMetrics.Do(func(repo expvar.KeyValue) {
+		repo.Value.(*expvar.Map).Do(func(op expvar.KeyValue) {
+			labels := fmt.Sprintf(`{repo="%s",op="%s"}`, escapePrometheusLabel(repo.Key), op.Key)
+			op.Value.(*expvar.Map).Do(func(field expvar.KeyValue) {
+				samples[field.Key] = append(samples[field.Key], sample{labels, field.Value.String()})
+			})
+		})
+	})
+
+	for _, metric := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind); err != nil {
+			return err
+		}
+		values := samples[metric.field]
+		sort.Slice(values, func(i, j int) bool { return values[i].labels < values[j].labels })
+		for _, s := range values {
+			if _, err := fmt.Fprintf(w, "%s%s %s\n", metric.name, s.labels, s.value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
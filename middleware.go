@@ -0,0 +1,216 @@
+package shop
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Implemented by RepositoryFS middleware (retries, rate limiting, logging and
+// metrics), so calls of optional capabilities found under it go through its
+// policy as well. n is the number of bytes sent by the call.
+type capabilityMiddleware interface {
+	around(ctx context.Context, op, key string, n int64, call func(ctx context.Context) error) error
+}
+
+// Middleware found on the way to a capability, the outermost first.
+type middlewareChain []capabilityMiddleware
+
+func (c middlewareChain) call(ctx context.Context, op, key string, n int64, call func(ctx context.Context) error) error {
+	for i := len(c) - 1; i >= 0; i-- {
+		m, next := c[i], call
+		call = func(ctx context.Context) error {
+			return m.around(ctx, op, key, n, next)
+		}
+	}
+	return call(ctx)
+}
+
+// Wrap the capability found under the middleware, so its calls go through
+// the middleware. Wrappers implement only the interface they wrap.
+func wrapCapability[T any](capability T, chain middlewareChain) T {
+	var wrapped any
+	switch any((*T)(nil)).(type) {
+	case *ConditionalWriteFS:
+		wrapped = conditionalWriteMiddleware{any(capability).(ConditionalWriteFS), chain}
+	case *CopyFS:
+		wrapped = copyMiddleware{any(capability).(CopyFS), chain}
+	case *RemoveAllFS:
+		wrapped = removeAllMiddleware{any(capability).(RemoveAllFS), chain}
+	case *MultipartFS:
+		fs := any(capability).(MultipartFS)
+		if resumable, ok := fs.(ResumableMultipartFS); ok {
+			wrapped = resumableMultipartMiddleware{multipartMiddleware{fs, chain}, resumable}
+		} else {
+			wrapped = multipartMiddleware{fs, chain}
+		}
+	case *URLSignerFS:
+		wrapped = urlSignerMiddleware{any(capability).(URLSignerFS), chain}
+	case *RangeReaderFS:
+		wrapped = rangeReaderMiddleware{any(capability).(RangeReaderFS), chain}
+	default:
+		return capability
+	}
+	return wrapped.(T)
+}
+
+type conditionalWriteMiddleware struct {
+	fs    ConditionalWriteFS
+	chain middlewareChain
+}
+
+func (f conditionalWriteMiddleware) WriteIf(ctx context.Context, key string, data []byte, etag string) error {
+	return f.chain.call(ctx, "write_if", key, int64(len(data)), func(ctx context.Context) error {
+		return f.fs.WriteIf(ctx, key, data, etag)
+	})
+}
+
+type copyMiddleware struct {
+	fs    CopyFS
+	chain middlewareChain
+}
+
+func (f copyMiddleware) Copy(ctx context.Context, src, dst string) error {
+	return f.chain.call(ctx, "copy", dst, 0, func(ctx context.Context) error {
+		return f.fs.Copy(ctx, src, dst)
+	})
+}
+
+type removeAllMiddleware struct {
+	fs    RemoveAllFS
+	chain middlewareChain
+}
+
+func (f removeAllMiddleware) RemoveAll(ctx context.Context, prefix string) error {
+	return f.chain.call(ctx, "remove_all", prefix, 0, func(ctx context.Context) error {
+		return f.fs.RemoveAll(ctx, prefix)
+	})
+}
+
+type multipartMiddleware struct {
+	fs    MultipartFS
+	chain middlewareChain
+}
+
+func (f multipartMiddleware) MinPartSize() int64 {
+	return f.fs.MinPartSize()
+}
+
+func (f multipartMiddleware) CreateMultipart(ctx context.Context, key string) (upload MultipartUpload, err error) {
+	err = f.chain.call(ctx, "create_multipart", key, 0, func(ctx context.Context) (err error) {
+		upload, err = f.fs.CreateMultipart(ctx, key)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wrapUpload(upload, key, f.chain), nil
+}
+
+type resumableMultipartMiddleware struct {
+	multipartMiddleware
+	resumable ResumableMultipartFS
+}
+
+func (f resumableMultipartMiddleware) ResumeMultipart(ctx context.Context, key, uploadId string) (upload MultipartUpload, parts map[int]int64, err error) {
+	err = f.chain.call(ctx, "resume_multipart", key, 0, func(ctx context.Context) (err error) {
+		upload, parts, err = f.resumable.ResumeMultipart(ctx, key, uploadId)
+		return
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return wrapUpload(upload, key, f.chain), parts, nil
+}
+
+func wrapUpload(upload MultipartUpload, key string, chain middlewareChain) MultipartUpload {
+	wrapped := uploadMiddleware{upload, key, chain}
+	if resumable, ok := upload.(ResumableUpload); ok {
+		return resumableUploadMiddleware{wrapped, resumable}
+	}
+	return wrapped
+}
+
+type uploadMiddleware struct {
+	upload MultipartUpload
+	key    string
+	chain  middlewareChain
+}
+
+func (u uploadMiddleware) UploadPart(ctx context.Context, number int, data []byte) error {
+	return u.chain.call(ctx, "upload_part", u.key, int64(len(data)), func(ctx context.Context) error {
+		return u.upload.UploadPart(ctx, number, data)
+	})
+}
+
+func (u uploadMiddleware) Complete(ctx context.Context) error {
+	return u.chain.call(ctx, "complete_multipart", u.key, 0, u.upload.Complete)
+}
+
+func (u uploadMiddleware) Abort(ctx context.Context) error {
+	return u.chain.call(ctx, "abort_multipart", u.key, 0, u.upload.Abort)
+}
+
+type resumableUploadMiddleware struct {
+	uploadMiddleware
+	resumable ResumableUpload
+}
+
+func (u resumableUploadMiddleware) UploadId() string {
+	return u.resumable.UploadId()
+}
+
+type urlSignerMiddleware struct {
+	fs    URLSignerFS
+	chain middlewareChain
+}
+
+func (f urlSignerMiddleware) SignURL(ctx context.Context, key string, ttl time.Duration) (url string, err error) {
+	err = f.chain.call(ctx, "sign_url", key, 0, func(ctx context.Context) (err error) {
+		url, err = f.fs.SignURL(ctx, key, ttl)
+		return
+	})
+	return
+}
+
+// Only opening of ranges goes through the middleware, bytes read from them
+// are not counted.
+type rangeReaderMiddleware struct {
+	fs    RangeReaderFS
+	chain middlewareChain
+}
+
+func (f rangeReaderMiddleware) OpenRange(ctx context.Context, key string, offset, length int64) (body io.ReadCloser, err error) {
+	err = f.chain.call(ctx, "open_range", key, 0, func(ctx context.Context) (err error) {
+		body, err = f.fs.OpenRange(ctx, key, offset, length)
+		return
+	})
+	return
+}
+
+func (f RetryFS) around(ctx context.Context, op, key string, n int64, call func(ctx context.Context) error) error {
+	return f.retry(ctx, func(int) error {
+		return call(ctx)
+	})
+}
+
+func (f RateLimitFS) around(ctx context.Context, op, key string, n int64, call func(ctx context.Context) error) error {
+	if err := f.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return call(ctx)
+}
+
+func (f LoggingFS) around(ctx context.Context, op, key string, n int64, call func(ctx context.Context) error) error {
+	start := time.Now()
+	err := call(ctx)
+	f.log(ctx, op, key, start, n, err)
+	return err
+}
+
+func (f MetricsFS) around(ctx context.Context, op, key string, n int64, call func(ctx context.Context) error) error {
+	start := time.Now()
+	err := call(ctx)
+	f.record(op, start, int(n), err)
+	return err
+}
@@ -0,0 +1,108 @@
+package shop
+
+import (
+	"context"
+	"expvar"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Backend which fails the first call of every capability with a transient
+// error.
+type flakyFS struct {
+	RepositoryFS
+	calls map[string]int
+}
+
+func (f flakyFS) fail(op string) error {
+	f.calls[op]++
+	if f.calls[op] == 1 {
+		return syscall.ECONNRESET
+	}
+	return nil
+}
+
+func (f flakyFS) WriteIf(ctx context.Context, key string, data []byte, etag string) error {
+	if err := f.fail("write_if"); err != nil {
+		return err
+	}
+	return f.RepositoryFS.(ConditionalWriteFS).WriteIf(ctx, key, data, etag)
+}
+
+func (f flakyFS) Copy(ctx context.Context, src, dst string) error {
+	if err := f.fail("copy"); err != nil {
+		return err
+	}
+	return f.RepositoryFS.(CopyFS).Copy(ctx, src, dst)
+}
+
+func (f flakyFS) RemoveAll(ctx context.Context, prefix string) error {
+	if err := f.fail("remove_all"); err != nil {
+		return err
+	}
+	return f.RepositoryFS.(RemoveAllFS).RemoveAll(ctx, prefix)
+}
+
+func TestCapabilityMiddleware(t *testing.T) {
+	tests := []struct {
+		op   string
+		call func(ctx context.Context, fs RepositoryFS) error
+	}{
+		{"write_if", func(ctx context.Context, fs RepositoryFS) error {
+			return writeIf(ctx, fs, "b", []byte("b"), "")
+		}},
+		{"copy", func(ctx context.Context, fs RepositoryFS) error {
+			copier, ok := findCapability[CopyFS](fs)
+			if !ok {
+				t.Fatal("CopyFS is not found")
+			}
+			return copier.Copy(ctx, "a", "c")
+		}},
+		{"remove_all", func(ctx context.Context, fs RepositoryFS) error {
+			remover, ok := findCapability[RemoveAllFS](fs)
+			if !ok {
+				t.Fatal("RemoveAllFS is not found")
+			}
+			return remover.RemoveAll(ctx, "d")
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.op, func(t *testing.T) {
+			ctx := context.Background()
+			cfg := RepositoryConfig{
+				URL:            "mem://capability-middleware-" + test.op,
+				RetryBaseDelay: Duration{time.Millisecond},
+			}
+			mem, err := NewMemFS(ctx, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = mem.Write(ctx, "a", []byte("a")); err != nil {
+				t.Fatal(err)
+			}
+
+			backend := flakyFS{mem, map[string]int{}}
+			capabilities := BackendCapabilities{ConditionalWrite: true, Copy: true, RemoveAll: true}
+			var fs RepositoryFS = backendFS{backend, capabilities}
+			fs = NewMetricsFS(fs, cfg)
+			fs = NewLoggingFS(fs, cfg)
+			fs = NewRetryFS(fs, cfg)
+
+			if err = test.call(ctx, fs); err != nil {
+				t.Fatal(err)
+			}
+			if calls := backend.calls[test.op]; calls != 2 {
+				t.Errorf("got %d backend calls, want 2", calls)
+			}
+			metrics := repositoryMetricsFor(cfg.URL).Get(test.op).(*expvar.Map)
+			if calls := metrics.Get("calls").String(); calls != "2" {
+				t.Errorf("got %s recorded calls, want 2", calls)
+			}
+			if errors := metrics.Get("errors").String(); errors != "1" {
+				t.Errorf("got %s recorded errors, want 1", errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package shop
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Minisign files are an untrusted comment line followed by base64 of
+// algorithm, key number and key or signature, see
+// https://jedisct1.github.io/minisign/.
+const (
+	minisignUntrustedComment = "untrusted comment: "
+	minisignTrustedComment   = "trusted comment: "
+	minisignKeyNumLen        = 8
+)
+
+var (
+	minisignAlgEd25519   = []byte("Ed")
+	minisignAlgHashed    = []byte("ED")
+	minisignKDFScrypt    = []byte("Sc")
+	minisignKDFNone      = []byte{0, 0}
+	minisignChecksumAlg  = []byte("B2")
+	minisignSecretKeyLen = 2 + 2 + 2 + 32 + 8 + 8 + minisignKeyNumLen + ed25519.PrivateKeySize + 32
+)
+
+// Key id is the key number printed as a little endian hex number, the same
+// way minisign shows it.
+func minisignKeyId(keyNum []byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(keyNum))
+}
+
+// Lines of the file without the untrusted comment, which is optional for
+// public keys given as the bare base64 string.
+func minisignLines(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, minisignUntrustedComment) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func isMinisignFile(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte(minisignUntrustedComment)) || bytes.HasPrefix(bytes.TrimSpace(data), []byte("RW"))
+}
+
+func parseMinisignPublicKey(data []byte) (*SigningKey, ed25519.PublicKey, error) {
+	lines := minisignLines(data)
+	if len(lines) != 1 {
+		return nil, nil, fmt.Errorf("%w: not a minisign public key", ErrInvalidSigningKey)
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(raw) != 2+minisignKeyNumLen+ed25519.PublicKeySize || !bytes.Equal(raw[:2], minisignAlgEd25519) {
+		return nil, nil, fmt.Errorf("%w: not a minisign public key", ErrInvalidSigningKey)
+	}
+
+	key := &SigningKey{
+		Format:    SignatureFormatMinisign,
+		Id:        minisignKeyId(raw[2 : 2+minisignKeyNumLen]),
+		PublicKey: lines[0],
+	}
+	return key, ed25519.PublicKey(raw[2+minisignKeyNumLen:]), nil
+}
+
+type minisignSecretKey struct {
+	keyNum     []byte
+	privateKey ed25519.PrivateKey
+}
+
+// Secret keys are encrypted with a scrypt derived stream, unless generated
+// with minisign -W.
+func parseMinisignSecretKey(data []byte, passphrase func() ([]byte, error)) (*minisignSecretKey, error) {
+	lines := minisignLines(data)
+	if len(lines) != 1 {
+		return nil, fmt.Errorf("%w: not a minisign secret key", ErrInvalidSigningKey)
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(raw) != minisignSecretKeyLen || !bytes.Equal(raw[:2], minisignAlgEd25519) || !bytes.Equal(raw[4:6], minisignChecksumAlg) {
+		return nil, fmt.Errorf("%w: not a minisign secret key", ErrInvalidSigningKey)
+	}
+
+	kdf, salt := raw[2:4], raw[6:38]
+	opsLimit, memLimit := binary.LittleEndian.Uint64(raw[38:46]), binary.LittleEndian.Uint64(raw[46:54])
+	keyNumSK := bytes.Clone(raw[54:])
+
+	switch {
+	case bytes.Equal(kdf, minisignKDFScrypt):
+		password, err := passphrase()
+		if err != nil {
+			return nil, err
+		}
+		n, r, p := minisignScryptParams(opsLimit, memLimit)
+		stream, err := scrypt.Key(password, salt, n, r, p, len(keyNumSK))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidSigningKey, err)
+		}
+		subtle.XORBytes(keyNumSK, keyNumSK, stream)
+	case !bytes.Equal(kdf, minisignKDFNone):
+		return nil, fmt.Errorf("%w: unsupported minisign key derivation %q", ErrInvalidSigningKey, kdf)
+	}
+
+	key := &minisignSecretKey{
+		keyNum:     keyNumSK[:minisignKeyNumLen],
+		privateKey: ed25519.PrivateKey(keyNumSK[minisignKeyNumLen : minisignKeyNumLen+ed25519.PrivateKeySize]),
+	}
+	checksum := blake2b256(minisignAlgEd25519, key.keyNum, key.privateKey)
+	if subtle.ConstantTimeCompare(checksum, keyNumSK[minisignKeyNumLen+ed25519.PrivateKeySize:]) != 1 {
+		return nil, fmt.Errorf("%w: wrong passphrase or corrupted minisign key", ErrInvalidSigningKey)
+	}
+	return key, nil
+}
+
+// Scrypt parameters picked from the limits the same way libsodium does.
+func minisignScryptParams(opsLimit, memLimit uint64) (n, r, p int) {
+	opsLimit = max(opsLimit, 32768)
+	r = 8
+	maxN := memLimit / (uint64(r) * 128)
+	if opsLimit < memLimit/32 {
+		p = 1
+		maxN = opsLimit / (uint64(r) * 4)
+	}
+	logN := 1
+	for ; logN < 63; logN++ {
+		if uint64(1)<<logN > maxN/2 {
+			break
+		}
+	}
+	if opsLimit >= memLimit/32 {
+		maxRP := min((opsLimit/4)/(uint64(1)<<logN), 0x3fffffff)
+		p = int(maxRP) / r
+	}
+	return 1 << logN, r, max(p, 1)
+}
+
+func blake2b256(parts ...[]byte) []byte {
+	h, _ := blake2b.New256(nil)
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+func (k *minisignSecretKey) Public() SigningKey {
+	raw := append(append(bytes.Clone(minisignAlgEd25519), k.keyNum...), k.privateKey.Public().(ed25519.PublicKey)...)
+	return SigningKey{
+		Format:    SignatureFormatMinisign,
+		Id:        minisignKeyId(k.keyNum),
+		PublicKey: base64.StdEncoding.EncodeToString(raw),
+	}
+}
+
+// Prehashed signature, as minisign -S -H makes.
+func (k *minisignSecretKey) Sign(archive io.Reader, name string) (*DetachedSignature, error) {
+	h, _ := blake2b.New512(nil)
+	if _, err := io.Copy(h, archive); err != nil {
+		return nil, err
+	}
+
+	signature := ed25519.Sign(k.privateKey, h.Sum(nil))
+	trustedComment := "timestamp:" + strconv.FormatInt(time.Now().Unix(), 10) + "\tfile:" + name + "\thashed"
+	globalSignature := ed25519.Sign(k.privateKey, append(bytes.Clone(signature), trustedComment...))
+
+	raw := append(append(bytes.Clone(minisignAlgHashed), k.keyNum...), signature...)
+	var data bytes.Buffer
+	data.WriteString(minisignUntrustedComment + "signature from minisign secret key\n")
+	data.WriteString(base64.StdEncoding.EncodeToString(raw) + "\n")
+	data.WriteString(minisignTrustedComment + trustedComment + "\n")
+	data.WriteString(base64.StdEncoding.EncodeToString(globalSignature) + "\n")
+
+	return &DetachedSignature{
+		Format: SignatureFormatMinisign,
+		KeyId:  minisignKeyId(k.keyNum),
+		Data:   data.Bytes(),
+	}, nil
+}
+
+type minisignSignature struct {
+	algorithm       []byte
+	keyId           string
+	signature       []byte
+	trustedComment  string
+	globalSignature []byte
+}
+
+func parseMinisignSignature(data []byte) (*minisignSignature, error) {
+	lines := minisignLines(data)
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], minisignTrustedComment) {
+		return nil, fmt.Errorf("%w: not a minisign signature", ErrInvalidSignature)
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(raw) != 2+minisignKeyNumLen+ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: not a minisign signature", ErrInvalidSignature)
+	}
+	globalSignature, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil || len(globalSignature) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: not a minisign signature", ErrInvalidSignature)
+	}
+	return &minisignSignature{
+		algorithm:       raw[:2],
+		keyId:           minisignKeyId(raw[2 : 2+minisignKeyNumLen]),
+		signature:       raw[2+minisignKeyNumLen:],
+		trustedComment:  strings.TrimPrefix(lines[1], minisignTrustedComment),
+		globalSignature: globalSignature,
+	}, nil
+}
+
+// Legacy signatures are made over the whole archive, so it's buffered.
+type minisignVerifier struct {
+	key       ed25519.PublicKey
+	signature *minisignSignature
+	hash      hash.Hash
+	archive   bytes.Buffer
+}
+
+func newMinisignVerifier(key SigningKey, data []byte) (*minisignVerifier, error) {
+	_, publicKey, err := parseMinisignPublicKey([]byte(key.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	signature, err := parseMinisignSignature(data)
+	if err != nil {
+		return nil, err
+	}
+	if signature.keyId != key.Id {
+		return nil, fmt.Errorf("%w: signed with %s, not %s", ErrInvalidSignature, signature.keyId, key.Id)
+	}
+
+	v := &minisignVerifier{key: publicKey, signature: signature}
+	switch {
+	case bytes.Equal(signature.algorithm, minisignAlgHashed):
+		v.hash, _ = blake2b.New512(nil)
+	case !bytes.Equal(signature.algorithm, minisignAlgEd25519):
+		return nil, fmt.Errorf("%w: unsupported minisign algorithm %q", ErrInvalidSignature, signature.algorithm)
+	}
+	return v, nil
+}
+
+func (v *minisignVerifier) Write(p []byte) (int, error) {
+	if v.hash != nil {
+		return v.hash.Write(p)
+	}
+	return v.archive.Write(p)
+}
+
+func (v *minisignVerifier) Verify() error {
+	message := v.archive.Bytes()
+	if v.hash != nil {
+		message = v.hash.Sum(nil)
+	}
+	if !ed25519.Verify(v.key, message, v.signature.signature) {
+		return fmt.Errorf("%w: minisign signature of %s does not match", ErrInvalidSignature, v.signature.keyId)
+	}
+	if !ed25519.Verify(v.key, append(bytes.Clone(v.signature.signature), v.signature.trustedComment...), v.signature.globalSignature) {
+		return fmt.Errorf("%w: minisign trusted comment of %s does not match", ErrInvalidSignature, v.signature.keyId)
+	}
+	return nil
+}
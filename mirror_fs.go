@@ -0,0 +1,277 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	MirrorStrategyFailover = "failover"
+	MirrorStrategyRace     = "race"
+)
+
+var (
+	ErrUnknownMirrorStrategy = errors.New("Unknown mirror strategy")
+)
+
+// Repository with read-only mirrors. Writes always go to the primary url,
+// reads fall back to mirrors in order when it fails, or are sent to all of
+// them at once with the race strategy. Missing keys reported by the primary
+// are not looked up on mirrors.
+type MirrorFS struct {
+	fss  []RepositoryFS
+	race bool
+}
+
+func NewMirrorFS(ctx context.Context, primary RepositoryFS, cfg RepositoryConfig) (RepositoryFS, error) {
+	f := MirrorFS{
+		fss: []RepositoryFS{primary},
+	}
+	switch cfg.MirrorStrategy {
+	case "", MirrorStrategyFailover:
+	case MirrorStrategyRace:
+		f.race = true
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMirrorStrategy, cfg.MirrorStrategy)
+	}
+
+	for _, url := range cfg.Mirrors {
+		mirrorCfg := cfg
+		mirrorCfg.URL = url
+		mirrorCfg.Mirrors = nil
+		mirrorCfg.Write = false
+		mirrorCfg.Admin = false
+
+		fs, err := newRepositoryFS(ctx, mirrorCfg)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("mirror %s: %w", url, err)
+		}
+		f.fss = append(f.fss, fs)
+	}
+	return f, nil
+}
+
+func (f MirrorFS) Unwrap() RepositoryFS {
+	return f.fss[0]
+}
+
+func (f MirrorFS) Close() error {
+	var errs error
+	for _, fs := range f.fss {
+		errs = multierror.Append(errs, closeRepositoryFS(fs)).ErrorOrNil()
+	}
+	return errs
+}
+
+func (f MirrorFS) primary() RepositoryFS {
+	return f.fss[0]
+}
+
+func failoverMirrors[T any](ctx context.Context, fss []RepositoryFS, op func(context.Context, RepositoryFS) (T, error)) (value T, err error) {
+	for i, fs := range fss {
+		var mirrorErr error
+		value, mirrorErr = op(ctx, fs)
+		if i == 0 {
+			err = mirrorErr
+		}
+		if mirrorErr == nil {
+			return value, nil
+		}
+		if ctx.Err() != nil || (i == 0 && errors.Is(mirrorErr, os.ErrNotExist)) {
+			break
+		}
+	}
+	return value, err
+}
+
+// Run op against every mirror at once and return the first success. Returned
+// cancel function must be called once the value is not used anymore.
+func raceMirrors[T any](ctx context.Context, fss []RepositoryFS, op func(context.Context, RepositoryFS) (T, error), release func(T)) (value T, cancel context.CancelFunc, err error) {
+	type result struct {
+		index int
+		value T
+		err   error
+	}
+
+	results := make(chan result, len(fss))
+	cancels := make([]context.CancelFunc, len(fss))
+	for i, fs := range fss {
+		mirrorCtx, mirrorCancel := context.WithCancel(ctx)
+		cancels[i] = mirrorCancel
+		go func() {
+			value, err := op(mirrorCtx, fs)
+			results <- result{i, value, err}
+		}()
+	}
+
+	winner := -1
+	errs := make([]error, len(fss))
+	for received := 0; received < len(fss) && winner < 0; received++ {
+		r := <-results
+		if r.err != nil {
+			errs[r.index] = r.err
+			cancels[r.index]()
+			continue
+		}
+		winner, value = r.index, r.value
+	}
+
+	if winner < 0 {
+		return value, func() {}, errs[0]
+	}
+
+	for i, cancel := range cancels {
+		if i != winner {
+			cancel()
+		}
+	}
+	// Release results of the slower mirrors.
+	go func(pending int) {
+		for ; pending > 0; pending-- {
+			if r := <-results; r.err == nil {
+				release(r.value)
+			}
+		}
+	}(len(fss) - 1 - countErrors(errs))
+
+	return value, cancels[winner], nil
+}
+
+func countErrors(errs []error) (n int) {
+	for _, err := range errs {
+		if err != nil {
+			n++
+		}
+	}
+	return
+}
+
+func (f MirrorFS) Read(ctx context.Context, key string) ([]byte, error) {
+	op := func(ctx context.Context, fs RepositoryFS) ([]byte, error) {
+		return fs.Read(ctx, key)
+	}
+	if !f.race {
+		return failoverMirrors(ctx, f.fss, op)
+	}
+
+	data, cancel, err := raceMirrors(ctx, f.fss, op, func([]byte) {})
+	cancel()
+	return data, err
+}
+
+func (f MirrorFS) Write(ctx context.Context, key string, data []byte) error {
+	return f.primary().Write(ctx, key, data)
+}
+
+type mirrorReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r mirrorReader) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+func (f MirrorFS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	op := func(ctx context.Context, fs RepositoryFS) (io.ReadCloser, error) {
+		return fs.Open(ctx, key)
+	}
+	if !f.race {
+		return failoverMirrors(ctx, f.fss, op)
+	}
+
+	body, cancel, err := raceMirrors(ctx, f.fss, op, func(body io.ReadCloser) { body.Close() })
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return mirrorReader{
+		ReadCloser: body,
+		cancel:     cancel,
+	}, nil
+}
+
+func (f MirrorFS) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	return f.primary().Create(ctx, key)
+}
+
+func (f MirrorFS) MakeDir(ctx context.Context, key string) error {
+	return f.primary().MakeDir(ctx, key)
+}
+
+// Listing switches to the next mirror only if it fails before returning
+// anything.
+type mirrorCursor struct {
+	fss     []RepositoryFS
+	key     string
+	current int
+	cursor  Cursor[Entry]
+	started bool
+	err     error
+}
+
+func (c *mirrorCursor) GetNext(ctx context.Context) (*Entry, error) {
+	if c.started {
+		return c.cursor.GetNext(ctx)
+	}
+
+	for ; c.current < len(c.fss); c.current++ {
+		c.cursor = c.fss[c.current].ListDir(ctx, c.key)
+		entry, err := c.cursor.GetNext(ctx)
+		if err == nil {
+			c.started = true
+			return entry, nil
+		}
+		if c.current == 0 {
+			c.err = err
+		}
+		if ctx.Err() != nil || (c.current == 0 && errors.Is(err, os.ErrNotExist)) {
+			break
+		}
+	}
+	return nil, c.err
+}
+
+func (f MirrorFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	return &mirrorCursor{
+		fss: f.fss,
+		key: key,
+	}
+}
+
+func (f MirrorFS) Remove(ctx context.Context, key string) error {
+	return f.primary().Remove(ctx, key)
+}
+
+func (f MirrorFS) Exists(ctx context.Context, key string) (bool, error) {
+	op := func(ctx context.Context, fs RepositoryFS) (bool, error) {
+		return fs.Exists(ctx, key)
+	}
+	if !f.race {
+		return failoverMirrors(ctx, f.fss, op)
+	}
+
+	ok, cancel, err := raceMirrors(ctx, f.fss, op, func(bool) {})
+	cancel()
+	return ok, err
+}
+
+func (f MirrorFS) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	op := func(ctx context.Context, fs RepositoryFS) (ObjectInfo, error) {
+		return fs.Stat(ctx, key)
+	}
+	if !f.race {
+		return failoverMirrors(ctx, f.fss, op)
+	}
+
+	info, cancel, err := raceMirrors(ctx, f.fss, op, func(ObjectInfo) {})
+	cancel()
+	return info, err
+}
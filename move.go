@@ -0,0 +1,158 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	// Aliases followed by ResolveVersion, so alias loops don't hang it.
+	MaxPackageMoves = 8
+)
+
+// Copy the package manifest, instances, tags and refs to the new name and
+// delete the old package. With alias, a manifest pointing to the new name is
+// left in place of the old package, so its versions keep resolving. Archives
+// stay in the package repo, since they are shared by id.
+func MovePackage(ctx context.Context, registry Registry, from, to string, alias bool) ([]SyncChange, error) {
+	if !IsValidPackageName(to) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPackageName, to)
+	}
+
+	pkg, err := registry.GetPackage(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	if pkg.MovedTo != "" {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrPackageMoved, from, pkg.MovedTo)
+	}
+	_, err = registry.GetPackage(ctx, to)
+	if err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPackageExists, to)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var changes []SyncChange
+	moved := *pkg
+	moved.Name = to
+	if err = registry.PutPackage(ctx, moved); err != nil {
+		return nil, err
+	}
+	changes = append(changes, SyncChange{Kind: SyncPackage, Package: to})
+
+	instances, err := CollectCursor(ctx, registry.ListPackageInstances(ctx, from))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return changes, err
+	}
+	instanceChanges := make([][]SyncChange, len(instances))
+	err = runJobs(ctx, registry.Jobs(), len(instances), func(ctx context.Context, i int) error {
+		tags, err := CollectCursor(ctx, registry.ListPackageInstanceTags(ctx, instances[i]))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		files, err := registry.GetPackageInstanceFiles(ctx, instances[i])
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		signatures, err := registry.ListPackageInstanceSignatures(ctx, instances[i])
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		provenance, err := registry.GetPackageInstanceProvenance(ctx, instances[i])
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		instance := instances[i]
+		instance.Package = to
+		if instance.SBOM != nil {
+			if err = moveSBOM(ctx, registry, instances[i], instance); err != nil {
+				return err
+			}
+		}
+		if err = registry.PutPackageInstanceInfo(ctx, instance); err != nil {
+			return err
+		}
+		instanceChanges[i] = append(instanceChanges[i], SyncChange{Kind: SyncInstance, Package: to, Object: instance.Id})
+		if files != nil {
+			if err = registry.PutPackageInstanceFiles(ctx, instance, files); err != nil {
+				return err
+			}
+		}
+		for _, signature := range signatures {
+			if err = registry.PutPackageInstanceSignature(ctx, instance, signature); err != nil {
+				return err
+			}
+		}
+		if provenance != nil {
+			if err = registry.PutPackageInstanceProvenance(ctx, instance, *provenance); err != nil {
+				return err
+			}
+		}
+
+		for _, tag := range tags {
+			tag.Package = to
+			if err = registry.PutPackageInstanceTag(ctx, tag); err != nil {
+				return err
+			}
+			instanceChanges[i] = append(instanceChanges[i], SyncChange{Kind: SyncTag, Package: to, Object: fmt.Sprintf("%s:%s@%s", tag.Key, tag.Value, tag.Id)})
+		}
+		return nil
+	})
+	for _, c := range instanceChanges {
+		changes = append(changes, c...)
+	}
+	if err != nil {
+		return changes, err
+	}
+
+	refs, err := CollectCursor(ctx, registry.ListPackageReferences(ctx, from))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return changes, err
+	}
+	for _, ref := range refs {
+		// History of the ref goes along, histories of deleted refs don't.
+		history, err := registry.GetPackageReferenceHistory(ctx, from, ref.Name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return changes, err
+		}
+		ref.Package = to
+		if history != nil {
+			history.Package = to
+			if err = registry.PutPackageReferenceHistory(ctx, *history); err != nil {
+				return changes, err
+			}
+		}
+		if err = registry.PutPackageReference(ctx, ref); err != nil {
+			return changes, err
+		}
+		changes = append(changes, SyncChange{Kind: SyncRef, Package: to, Object: ref.Name})
+	}
+
+	if err = registry.DeletePackage(ctx, from, true); err != nil {
+		return changes, err
+	}
+	if !alias {
+		return changes, nil
+	}
+
+	tombstone, err := NewPackage(from, pkg.Description, pkg.Repo)
+	if err != nil {
+		return changes, err
+	}
+	tombstone.MovedTo = to
+	return changes, registry.PutPackage(ctx, tombstone)
+}
+
+func moveSBOM(ctx context.Context, registry Registry, from, to Instance) error {
+	body, err := registry.GetPackageInstanceSBOM(ctx, from)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return registry.PutPackageInstanceSBOM(ctx, to, body)
+}
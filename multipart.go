@@ -0,0 +1,294 @@
+package shop
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	DefaultMultipartThreshold   = 64 << 20
+	DefaultMultipartPartSize    = 16 << 20
+	DefaultMultipartConcurrency = 4
+
+	// Subdirectory of the cache dir for sessions of interrupted uploads.
+	CacheUploadsDir = "uploads"
+	// Interrupted uploads older than this are started over.
+	UploadSessionMaxAge = 7 * 24 * time.Hour
+)
+
+// Optional RepositoryFS capability for uploading large objects in parts.
+type MultipartFS interface {
+	CreateMultipart(ctx context.Context, key string) (MultipartUpload, error)
+	// Smallest part size accepted by the backend (except for the last part).
+	MinPartSize() int64
+}
+
+type MultipartUpload interface {
+	// Parts are numbered from 1 and could be uploaded concurrently.
+	UploadPart(ctx context.Context, number int, data []byte) error
+	Complete(ctx context.Context) error
+	Abort(ctx context.Context) error
+}
+
+// Optional MultipartFS capability for continuing uploads interrupted in
+// another process. Its uploads implement ResumableUpload.
+type ResumableMultipartFS interface {
+	// Returns sizes of parts uploaded so far by their numbers.
+	ResumeMultipart(ctx context.Context, key, uploadId string) (MultipartUpload, map[int]int64, error)
+}
+
+type ResumableUpload interface {
+	MultipartUpload
+	UploadId() string
+}
+
+// Upload of a CAS object kept under the cache dir until it's complete, so the
+// next upload of the object continues it instead of starting from zero.
+type uploadSession struct {
+	URL       string        `json:"url"`
+	Key       string        `json:"key"`
+	UploadId  string        `json:"upload_id"`
+	PartSize  int64         `json:"part_size"`
+	StartedAt UnixTimestamp `json:"started_at"`
+}
+
+// Path of the session of the upload, only for keys which fix the content
+// uploaded under them.
+func (r repositoryImpl) uploadSessionPath(key string) (string, bool) {
+	key = path.Clean("/" + key)
+	if r.cfg.Cache == nil || r.cfg.Cache.Dir == "" || !isResumableKey(key) {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(r.cfg.URL + "\n" + key))
+	return filepath.Join(r.cfg.Cache.Dir, CacheUploadsDir, hex.EncodeToString(sum[:16])+".json"), true
+}
+
+// Instance archives and files by their hash are the same whenever they are
+// uploaded. Other CAS objects are not: deltas depend on the base they were
+// made against.
+func isResumableKey(key string) bool {
+	if hash, ok := strings.CutPrefix(key, RegistryCASFilesPrefix); ok {
+		return isValidSHA256(hash)
+	}
+	name, ok := strings.CutPrefix(key, RegistryCASPrefix)
+	if !ok || strings.Contains(name, "/") {
+		return false
+	}
+	_, _, ok = ParseCASArchiveName(name)
+	return ok
+}
+
+func saveUploadSession(p string, session uploadSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Continue the upload of the key from its session, or start a new one. Returns
+// sizes of parts uploaded already, and the path of the session if the upload
+// could be resumed later.
+func (r repositoryImpl) startMultipart(ctx context.Context, fs MultipartFS, key string, partSize int64) (MultipartUpload, map[int]int64, string, error) {
+	resumable, ok := fs.(ResumableMultipartFS)
+	sessionPath, cached := r.uploadSessionPath(key)
+	if !ok || !cached {
+		upload, err := fs.CreateMultipart(ctx, key)
+		return upload, nil, "", err
+	}
+
+	var session uploadSession
+	if data, err := os.ReadFile(sessionPath); err == nil && json.Unmarshal(data, &session) == nil &&
+		session.URL == r.cfg.URL && session.Key == key && session.PartSize == partSize &&
+		time.Since(session.StartedAt.Time) < UploadSessionMaxAge {
+		upload, done, err := resumable.ResumeMultipart(ctx, key, session.UploadId)
+		if err == nil {
+			return upload, done, sessionPath, nil
+		}
+		// Upload is gone on the backend, it's started over.
+	}
+
+	upload, err := fs.CreateMultipart(ctx, key)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	started, ok := upload.(ResumableUpload)
+	if !ok {
+		return upload, nil, "", nil
+	}
+	session = uploadSession{
+		URL:       r.cfg.URL,
+		Key:       key,
+		UploadId:  started.UploadId(),
+		PartSize:  partSize,
+		StartedAt: UnixTimestamp{time.Now()},
+	}
+	if err = saveUploadSession(sessionPath, session); err != nil {
+		return upload, nil, "", nil
+	}
+	return upload, nil, sessionPath, nil
+}
+
+type multipartSettings struct {
+	threshold   int64
+	partSize    int64
+	concurrency int
+}
+
+func (r repositoryImpl) multipartSettings(fs MultipartFS) (s multipartSettings) {
+	s = multipartSettings{
+		threshold:   r.cfg.MultipartThreshold,
+		partSize:    r.cfg.MultipartPartSize,
+		concurrency: r.cfg.MultipartConcurrency,
+	}
+	if s.threshold == 0 {
+		s.threshold = DefaultMultipartThreshold
+	}
+	if s.partSize == 0 {
+		s.partSize = DefaultMultipartPartSize
+	}
+	if s.concurrency == 0 {
+		s.concurrency = DefaultMultipartConcurrency
+	}
+	s.partSize = max(s.partSize, fs.MinPartSize())
+	s.threshold = max(s.threshold, s.partSize)
+	return
+}
+
+// Upload body in parts if it is larger than the threshold. Returns false if
+// the body is small enough to be uploaded in one request, data read so far is
+// returned in head in that case.
+func (r repositoryImpl) putMultipart(ctx context.Context, fs MultipartFS, key string, body io.Reader) (head []byte, ok bool, err error) {
+	settings := r.multipartSettings(fs)
+
+	// Buffer grows with the body, small objects don't take the whole
+	// threshold.
+	var buffer bytes.Buffer
+	_, err = io.CopyN(&buffer, body, settings.threshold+1)
+	head = buffer.Bytes()
+	if errors.Is(err, io.EOF) {
+		return head, false, nil
+	}
+	if err != nil {
+		return
+	}
+
+	// Keep exclusive create semantics of RepositoryFS.Create.
+	exists, err := r.fs.Exists(ctx, key)
+	if err != nil {
+		return
+	}
+	if exists {
+		err = fmt.Errorf("%s: %w", key, os.ErrExist)
+		return
+	}
+
+	upload, done, sessionPath, err := r.startMultipart(ctx, fs, key, settings.partSize)
+	if err != nil {
+		return
+	}
+	ok = true
+
+	uploadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var group multierror.Group
+	var once sync.Once
+	var failed error
+	slots := make(chan struct{}, settings.concurrency)
+	fail := func(err error) {
+		once.Do(func() {
+			failed = err
+			cancel()
+		})
+	}
+
+	number := 0
+	reader := io.MultiReader(bytes.NewReader(head), body)
+	for uploadCtx.Err() == nil {
+		part := make([]byte, settings.partSize)
+		n, readErr := io.ReadFull(reader, part)
+		if n > 0 {
+			number++
+		}
+		// Parts uploaded before the upload was interrupted are skipped.
+		if size, uploaded := done[number]; n > 0 && (!uploaded || size != int64(n)) {
+			partNumber, partData := number, part[:n]
+
+			select {
+			case slots <- struct{}{}:
+			case <-uploadCtx.Done():
+			}
+			if uploadCtx.Err() != nil {
+				break
+			}
+
+			group.Go(func() error {
+				defer func() { <-slots }()
+				err := upload.UploadPart(uploadCtx, partNumber, partData)
+				if err != nil {
+					fail(err)
+				}
+				return err
+			})
+		}
+
+		if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+			break
+		}
+		if readErr != nil {
+			fail(readErr)
+			break
+		}
+	}
+
+	group.Wait()
+	if failed == nil {
+		failed = ctx.Err()
+	}
+	if failed != nil && sessionPath != "" {
+		// Parts uploaded so far are kept for the next attempt.
+		return nil, true, failed
+	}
+	if failed == nil {
+		failed = upload.Complete(ctx)
+	}
+	if sessionPath != "" {
+		os.Remove(sessionPath)
+	}
+	if failed != nil {
+		err = multierror.Append(failed, upload.Abort(context.WithoutCancel(ctx))).ErrorOrNil()
+	}
+	return nil, true, err
+}
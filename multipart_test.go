@@ -0,0 +1,216 @@
+package shop
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestIsResumableKey(t *testing.T) {
+	id := "sha256-" + strings.Repeat("a", 64)
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{RegistryCASPrefix + id + ".tar.zst", true},
+		{RegistryCASPrefix + id + ".tar", true},
+		{RegistryCASFilesPrefix + strings.Repeat("b", 64), true},
+		{RegistryCASPrefix + id + ".delta", false},
+		{RegistryCASPrefix + id + ".files.json", false},
+		{RegistryCASFilesPrefix + "b", false},
+		{RegistryCASPrefix + "dir/" + id + ".tar", false},
+		{"/packages/" + id + ".tar", false},
+	}
+	for _, test := range tests {
+		if got := isResumableKey(test.key); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.key, got, test.want)
+		}
+	}
+}
+
+// Backend of resumable uploads kept in memory, which fails upload of the
+// part with the number once.
+type resumableTestFS struct {
+	RepositoryFS
+	lock     sync.Mutex
+	uploads  map[string]*resumableTestUpload
+	failPart int
+	// Numbers of parts uploaded, in order.
+	uploaded []int
+}
+
+func (f *resumableTestFS) MinPartSize() int64 {
+	return 1
+}
+
+func (f *resumableTestFS) CreateMultipart(ctx context.Context, key string) (MultipartUpload, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	upload := &resumableTestUpload{fs: f, key: key, id: fmt.Sprint(len(f.uploads)), parts: map[int][]byte{}}
+	f.uploads[upload.id] = upload
+	return upload, nil
+}
+
+func (f *resumableTestFS) ResumeMultipart(ctx context.Context, key, uploadId string) (MultipartUpload, map[int]int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	upload, ok := f.uploads[uploadId]
+	if !ok || upload.key != key {
+		return nil, nil, fmt.Errorf("%s: %w", uploadId, os.ErrNotExist)
+	}
+	sizes := map[int]int64{}
+	for number, data := range upload.parts {
+		sizes[number] = int64(len(data))
+	}
+	return upload, sizes, nil
+}
+
+type resumableTestUpload struct {
+	fs    *resumableTestFS
+	key   string
+	id    string
+	parts map[int][]byte
+}
+
+func (u *resumableTestUpload) UploadId() string {
+	return u.id
+}
+
+func (u *resumableTestUpload) UploadPart(ctx context.Context, number int, data []byte) error {
+	u.fs.lock.Lock()
+	defer u.fs.lock.Unlock()
+	u.fs.uploaded = append(u.fs.uploaded, number)
+	if number == u.fs.failPart {
+		u.fs.failPart = 0
+		return syscall.ECONNRESET
+	}
+	u.parts[number] = bytes.Clone(data)
+	return nil
+}
+
+func (u *resumableTestUpload) Complete(ctx context.Context) error {
+	u.fs.lock.Lock()
+	numbers := make([]int, 0, len(u.parts))
+	for number := range u.parts {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	var data []byte
+	for _, number := range numbers {
+		data = append(data, u.parts[number]...)
+	}
+	delete(u.fs.uploads, u.id)
+	u.fs.lock.Unlock()
+	return u.fs.Write(ctx, u.key, data)
+}
+
+func (u *resumableTestUpload) Abort(ctx context.Context) error {
+	u.fs.lock.Lock()
+	defer u.fs.lock.Unlock()
+	delete(u.fs.uploads, u.id)
+	return nil
+}
+
+func TestPutMultipartResume(t *testing.T) {
+	body := []byte("0123456789abcdefgh")
+	resumable := RegistryCASFilesPrefix + strings.Repeat("b", 64)
+
+	tests := []struct {
+		name    string
+		key     string
+		noCache bool
+		// Upload is lost on the backend after the first attempt.
+		lost bool
+		// Part size of the second attempt.
+		partSize int64
+		// Parts uploaded by the second attempt.
+		want []int
+	}{
+		{name: "resumed", key: resumable, want: []int{3, 4, 5}},
+		{name: "key is not resumable", key: "/packages/test/pkg/file", want: []int{1, 2, 3, 4, 5}},
+		{name: "no cache dir", key: resumable, noCache: true, want: []int{1, 2, 3, 4, 5}},
+		{name: "upload is lost", key: resumable, lost: true, want: []int{1, 2, 3, 4, 5}},
+		{name: "part size changed", key: resumable, partSize: 6, want: []int{1, 2, 3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			cfg := RepositoryConfig{
+				URL:                  "mem://" + strings.ReplaceAll(t.Name(), "/", "-"),
+				MultipartThreshold:   4,
+				MultipartPartSize:    4,
+				MultipartConcurrency: 1,
+			}
+			cacheDir := t.TempDir()
+			if !test.noCache {
+				cfg.Cache = &CacheConfig{Dir: cacheDir}
+			}
+			mem, err := NewMemFS(ctx, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fs := &resumableTestFS{RepositoryFS: mem, uploads: map[string]*resumableTestUpload{}, failPart: 3}
+			r := repositoryImpl{cfg: cfg, fs: fs}
+
+			if _, _, err = r.putMultipart(ctx, fs, test.key, bytes.NewReader(body)); err == nil {
+				t.Fatal("first attempt succeeded, want it to fail")
+			}
+			if test.lost {
+				fs.uploads = map[string]*resumableTestUpload{}
+			}
+			if test.partSize != 0 {
+				r.cfg.MultipartPartSize = test.partSize
+			}
+			fs.uploaded = nil
+			if _, _, err = r.putMultipart(ctx, fs, test.key, bytes.NewReader(body)); err != nil {
+				t.Fatal(err)
+			}
+
+			if fmt.Sprint(fs.uploaded) != fmt.Sprint(test.want) {
+				t.Errorf("got parts %v uploaded by the second attempt, want %v", fs.uploaded, test.want)
+			}
+			data, err := mem.Read(ctx, test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, body) {
+				t.Errorf("got %q uploaded, want %q", data, body)
+			}
+			sessions, _ := os.ReadDir(filepath.Join(cacheDir, CacheUploadsDir))
+			if len(sessions) != 0 {
+				t.Errorf("got %d sessions left, want none", len(sessions))
+			}
+		})
+	}
+}
+
+func TestPutMultipartSmallBody(t *testing.T) {
+	ctx := context.Background()
+	cfg := RepositoryConfig{URL: "mem://put-multipart-small-body"}
+	mem, err := NewMemFS(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := &resumableTestFS{RepositoryFS: mem, uploads: map[string]*resumableTestUpload{}}
+	r := repositoryImpl{cfg: cfg, fs: fs}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	head, ok, err := r.putMultipart(ctx, fs, "key", strings.NewReader("data"))
+	runtime.ReadMemStats(&after)
+	if err != nil || ok || string(head) != "data" {
+		t.Fatalf("got %q, %v, %v, want the body back to be written at once", head, ok, err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > DefaultMultipartThreshold/16 {
+		t.Errorf("allocated %d bytes for a small body", allocated)
+	}
+}
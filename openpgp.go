@@ -0,0 +1,212 @@
+package shop
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// OpenPGP keys are read with golang.org/x/crypto/openpgp, which supports RSA,
+// DSA and ECDSA keys, but not EdDSA ones GnuPG makes by default. Keys for
+// signing instances are made with e.g. gpg --quick-gen-key name rsa3072.
+const (
+	openPGPPublicKeyBlock  = "PGP PUBLIC KEY BLOCK"
+	openPGPPrivateKeyBlock = "PGP PRIVATE KEY BLOCK"
+	openPGPSignatureBlock  = "PGP SIGNATURE"
+)
+
+func isArmoredOpenPGP(data []byte, blockType string) bool {
+	return bytes.Contains(data, []byte("-----BEGIN "+blockType+"-----"))
+}
+
+// Armored or binary key ring with a single key, as gpg --export makes.
+func readOpenPGPEntity(data []byte, blockType string) (*openpgp.Entity, error) {
+	var entities openpgp.EntityList
+	var err error
+	if isArmoredOpenPGP(data, blockType) {
+		entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSigningKey, err)
+	}
+	if len(entities) != 1 {
+		return nil, fmt.Errorf("%w: expected a single OpenPGP key, got %d", ErrInvalidSigningKey, len(entities))
+	}
+	return entities[0], nil
+}
+
+func parseOpenPGPPublicKey(data []byte) (*SigningKey, *openpgp.Entity, error) {
+	entity, err := readOpenPGPEntity(data, openPGPPublicKeyBlock)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := openPGPSigningKey(entity)
+	return key, entity, err
+}
+
+// Public part of the entity, armored, with the first user id as comment.
+func openPGPSigningKey(entity *openpgp.Entity) (*SigningKey, error) {
+	var armored bytes.Buffer
+	w, err := armor.Encode(&armored, openPGPPublicKeyBlock, nil)
+	if err == nil {
+		err = entity.Serialize(w)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSigningKey, err)
+	}
+
+	key := &SigningKey{
+		Format:    SignatureFormatGPG,
+		Id:        entity.PrimaryKey.KeyIdString(),
+		PublicKey: armored.String(),
+	}
+	for name := range entity.Identities {
+		key.Comment = name
+		break
+	}
+	return key, nil
+}
+
+type openPGPSecretKey struct {
+	public SigningKey
+	signer *packet.PrivateKey
+}
+
+// Signing subkey is preferred over the primary key, as GnuPG does.
+func parseOpenPGPSecretKey(data []byte, passphrase func() ([]byte, error)) (*openPGPSecretKey, error) {
+	entity, err := readOpenPGPEntity(data, openPGPPrivateKeyBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	signer := entity.PrivateKey
+	for _, subkey := range entity.Subkeys {
+		if subkey.PrivateKey != nil && subkey.Sig.FlagsValid && subkey.Sig.FlagSign && !subkey.Sig.KeyExpired(time.Now()) {
+			signer = subkey.PrivateKey
+		}
+	}
+	if signer == nil {
+		return nil, fmt.Errorf("%w: no OpenPGP secret key", ErrInvalidSigningKey)
+	}
+
+	if signer.Encrypted {
+		password, err := passphrase()
+		if err != nil {
+			return nil, err
+		}
+		if err = signer.Decrypt(password); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidSigningKey, err)
+		}
+	}
+	public, err := openPGPSigningKey(entity)
+	if err != nil {
+		return nil, err
+	}
+	return &openPGPSecretKey{public: *public, signer: signer}, nil
+}
+
+func (k *openPGPSecretKey) Public() SigningKey {
+	return k.public
+}
+
+// Armored binary signature, as gpg --detach-sign --armor makes.
+func (k *openPGPSecretKey) Sign(archive io.Reader, name string) (*DetachedSignature, error) {
+	sig := &packet.Signature{
+		SigType:      packet.SigTypeBinary,
+		PubKeyAlgo:   k.signer.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Now(),
+		IssuerKeyId:  &k.signer.KeyId,
+	}
+	h := sig.Hash.New()
+	if _, err := io.Copy(h, archive); err != nil {
+		return nil, err
+	}
+	if err := sig.Sign(h, k.signer, nil); err != nil {
+		return nil, err
+	}
+
+	var data bytes.Buffer
+	w, err := armor.Encode(&data, openPGPSignatureBlock, nil)
+	if err == nil {
+		err = sig.Serialize(w)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	data.WriteString("\n")
+
+	return &DetachedSignature{
+		Format: SignatureFormatGPG,
+		KeyId:  k.public.Id,
+		Data:   data.Bytes(),
+	}, nil
+}
+
+type openPGPVerifier struct {
+	keyId     string
+	publicKey *packet.PublicKey
+	signature *packet.Signature
+	hash      hash.Hash
+}
+
+func newOpenPGPVerifier(key SigningKey, data []byte) (*openPGPVerifier, error) {
+	_, entity, err := parseOpenPGPPublicKey([]byte(key.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader = bytes.NewReader(data)
+	if isArmoredOpenPGP(data, openPGPSignatureBlock) {
+		block, err := armor.Decode(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+		}
+		body = block.Body
+	}
+	p, err := packet.Read(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+	signature, ok := p.(*packet.Signature)
+	if !ok || signature.IssuerKeyId == nil || signature.SigType != packet.SigTypeBinary || !signature.Hash.Available() {
+		return nil, fmt.Errorf("%w: not an OpenPGP binary signature", ErrInvalidSignature)
+	}
+
+	keys := openpgp.EntityList{entity}.KeysByIdUsage(*signature.IssuerKeyId, packet.KeyFlagSign)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: signed with %X, not %s", ErrInvalidSignature, *signature.IssuerKeyId, key.Id)
+	}
+	return &openPGPVerifier{
+		keyId:     key.Id,
+		publicKey: keys[0].PublicKey,
+		signature: signature,
+		hash:      signature.Hash.New(),
+	}, nil
+}
+
+func (v *openPGPVerifier) Write(p []byte) (int, error) {
+	return v.hash.Write(p)
+}
+
+func (v *openPGPVerifier) Verify() error {
+	if err := v.publicKey.VerifySignature(v.hash, v.signature); err != nil {
+		return fmt.Errorf("%w: OpenPGP signature of %s: %w", ErrInvalidSignature, v.keyId, err)
+	}
+	return nil
+}
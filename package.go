@@ -88,6 +88,15 @@ type Package struct {
 	Description string        `json:"description,omitempty"`
 	Repo        string        `json:"repo,omitempty"`
 	UpdatedAt   UnixTimestamp `json:"package"`
+	// Used by PrunePackage unless overridden.
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+	// Set on the alias left by MovePackage. Versions of the alias are
+	// resolved in the package it was moved to.
+	MovedTo string `json:"moved_to,omitempty"`
+	// Instances of deprecated packages are resolved like yanked ones.
+	Deprecated *Deprecation `json:"deprecated,omitempty"`
+	// Used by uploads unless overridden, gzip if not set.
+	Compression string `json:"compression,omitempty"`
 }
 
 func NewPackage(name, description, repo string) (pkg Package, err error) {
@@ -0,0 +1,159 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+const (
+	// Variables of package name templates, e.g. "tools/cmake/${os}-${arch}".
+	// Platform is "${os}-${arch}".
+	TemplateOS       = "os"
+	TemplateArch     = "arch"
+	TemplatePlatform = "platform"
+)
+
+var (
+	ErrInvalidTemplate = errors.New("Invalid template")
+	ErrInvalidPlatform = errors.New("Invalid platform")
+
+	// Platforms checked by CheckPlatformVariants by default.
+	DefaultPlatforms = []Platform{
+		{OS: "linux", Arch: "amd64"},
+		{OS: "linux", Arch: "arm64"},
+		{OS: "darwin", Arch: "amd64"},
+		{OS: "darwin", Arch: "arm64"},
+		{OS: "windows", Arch: "amd64"},
+	}
+)
+
+// Host platform, named as GOOS and GOARCH.
+type Platform struct {
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+}
+
+func CurrentPlatform() Platform {
+	return Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+}
+
+// Parse "os-arch".
+func ParsePlatform(s string) (Platform, error) {
+	goos, arch, ok := strings.Cut(s, "-")
+	if !ok || goos == "" || arch == "" || strings.ContainsAny(arch, "-/$") {
+		return Platform{}, fmt.Errorf("%w: %s", ErrInvalidPlatform, s)
+	}
+	return Platform{OS: goos, Arch: arch}, nil
+}
+
+func (p Platform) String() string {
+	return p.OS + "-" + p.Arch
+}
+
+// Substitute ${os}, ${arch} and ${platform} in the template.
+func (p Platform) Expand(template string) (string, error) {
+	var result strings.Builder
+	rest := template
+	for {
+		start := strings.Index(rest, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("%w: unterminated variable: %s", ErrInvalidTemplate, template)
+		}
+
+		result.WriteString(rest[:start])
+		switch name := rest[start+2 : start+end]; name {
+		case TemplateOS:
+			result.WriteString(p.OS)
+		case TemplateArch:
+			result.WriteString(p.Arch)
+		case TemplatePlatform:
+			result.WriteString(p.String())
+		default:
+			return "", fmt.Errorf("%w: unknown variable %q: %s", ErrInvalidTemplate, name, template)
+		}
+		rest = rest[start+end+1:]
+	}
+	result.WriteString(rest)
+	return result.String(), nil
+}
+
+// Package name, which is valid once expanded for any platform.
+func IsValidPackageNameTemplate(template string) bool {
+	name, err := DefaultPlatforms[0].Expand(template)
+	return err == nil && IsValidPackageName(name)
+}
+
+// Ensure file with templates in package names and versions expanded for
+// the platform.
+func (f EnsureFile) Expand(platform Platform) (*EnsureFile, error) {
+	expanded := &EnsureFile{
+		Root:     f.Root,
+		Packages: make([]EnsurePackage, 0, len(f.Packages)),
+		Closed:   f.Closed,
+	}
+	seen := map[string]string{}
+	for _, pkg := range f.Packages {
+		name, err := platform.Expand(pkg.Package)
+		if err != nil {
+			return nil, err
+		}
+		version, err := platform.Expand(pkg.Version)
+		if err != nil {
+			return nil, err
+		}
+		if !IsValidPackageName(name) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPackageName, name)
+		}
+		if previous, ok := seen[name]; ok {
+			return nil, fmt.Errorf("%w: %s and %s are both %s on %s", ErrInvalidEnsureFile, previous, pkg.Package, name, platform)
+		}
+		seen[name] = pkg.Package
+		expanded.Packages = append(expanded.Packages, EnsurePackage{Package: name, Version: version})
+	}
+	return expanded, nil
+}
+
+// Package of the ensure file expanded for one of the platforms. Id is empty
+// if the version does not resolve.
+type PlatformVariant struct {
+	Platform string `json:"platform"`
+	Package  string `json:"package"`
+	Version  string `json:"version"`
+	Id       string `json:"id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Resolve packages of the ensure file expanded for each platform, so missing
+// variants are found before the file is used on another host. Only versions
+// which are not found are reported as missing, other errors are returned.
+func CheckPlatformVariants(ctx context.Context, registry Registry, file EnsureFile, platforms []Platform) ([]PlatformVariant, error) {
+	var result []PlatformVariant
+	for _, platform := range platforms {
+		expanded, err := file.Expand(platform)
+		if err != nil {
+			return nil, err
+		}
+		for _, pkg := range expanded.Packages {
+			variant := PlatformVariant{Platform: platform.String(), Package: pkg.Package, Version: pkg.Version}
+			instance, err := registry.ResolveVersion(ctx, pkg.Package, pkg.Version)
+			switch {
+			case errors.Is(err, ErrVersionNotFound) || errors.Is(err, os.ErrNotExist):
+				variant.Error = err.Error()
+			case err != nil:
+				return nil, err
+			default:
+				variant.Id = instance.Id
+			}
+			result = append(result, variant)
+		}
+	}
+	return result, nil
+}
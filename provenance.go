@@ -0,0 +1,252 @@
+package shop
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	InTotoStatementType         = "https://in-toto.io/Statement/v1"
+	SLSAProvenancePredicateType = "https://slsa.dev/provenance/v1"
+	// Build type of provenance made on upload, its external parameters are
+	// ProvenanceParameters.
+	ProvenanceBuildType = "https://github.com/alex-ac/shop/upload/v1"
+
+	// Statement is stored under the instance prefix. It's trusted as much as
+	// write access to the registry, signatures of the archive cover the rest.
+	RegistryPackageInstanceProvenanceKey = "provenance.json"
+)
+
+var (
+	ErrInvalidProvenance = errors.New("Invalid provenance")
+	ErrNoProvenance      = errors.New("Instance has no provenance")
+)
+
+type InTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []InTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     SLSAProvenance  `json:"predicate"`
+}
+
+type InTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type SLSAProvenance struct {
+	BuildDefinition SLSABuildDefinition `json:"buildDefinition"`
+	RunDetails      SLSARunDetails      `json:"runDetails"`
+}
+
+type SLSABuildDefinition struct {
+	BuildType            string                   `json:"buildType"`
+	ExternalParameters   ProvenanceParameters     `json:"externalParameters"`
+	ResolvedDependencies []SLSAResourceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+// Source of the instance. Commit is in the digest of the first resolved
+// dependency, as SLSA recommends.
+type ProvenanceParameters struct {
+	Package    string `json:"package"`
+	Repository string `json:"repository,omitempty"`
+	Ref        string `json:"ref,omitempty"`
+}
+
+type SLSAResourceDescriptor struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+type SLSARunDetails struct {
+	Builder  SLSABuilder       `json:"builder"`
+	Metadata SLSABuildMetadata `json:"metadata"`
+}
+
+type SLSABuilder struct {
+	Id string `json:"id"`
+}
+
+type SLSABuildMetadata struct {
+	InvocationId string    `json:"invocationId,omitempty"`
+	FinishedOn   time.Time `json:"finishedOn"`
+}
+
+// What the provenance says about the build, detected from the CI
+// environment and overridden by flags.
+type ProvenanceOptions struct {
+	BuilderId    string
+	SourceRepo   string
+	SourceRef    string
+	SourceCommit string
+	InvocationId string
+	Inputs       []SLSAResourceDescriptor
+}
+
+// Options of GitHub Actions and GitLab CI jobs. Builder id is the workflow,
+// so it could be matched like sigstore identities.
+func DetectProvenanceOptions() ProvenanceOptions {
+	var opts ProvenanceOptions
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true":
+		server, repo := os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY")
+		opts.BuilderId = server + "/" + os.Getenv("GITHUB_WORKFLOW_REF")
+		opts.SourceRepo = "git+" + server + "/" + repo
+		opts.SourceRef = os.Getenv("GITHUB_REF")
+		opts.SourceCommit = os.Getenv("GITHUB_SHA")
+		opts.InvocationId = server + "/" + repo + "/actions/runs/" + os.Getenv("GITHUB_RUN_ID") + "/attempts/" + os.Getenv("GITHUB_RUN_ATTEMPT")
+	case os.Getenv("GITLAB_CI") == "true":
+		opts.BuilderId = os.Getenv("CI_PROJECT_URL") + "//" + os.Getenv("CI_CONFIG_PATH")
+		opts.SourceRepo = "git+" + os.Getenv("CI_PROJECT_URL")
+		opts.SourceRef = os.Getenv("CI_COMMIT_REF_NAME")
+		opts.SourceCommit = os.Getenv("CI_COMMIT_SHA")
+		opts.InvocationId = os.Getenv("CI_JOB_URL")
+	}
+	return opts
+}
+
+// Input as uri, or uri@algorithm:hex, e.g.
+// https://example.com/sdk.tar.gz@sha256:9f86d0...
+func ParseProvenanceInput(spec string) (SLSAResourceDescriptor, error) {
+	input := SLSAResourceDescriptor{URI: spec}
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		algorithm, digest, ok := strings.Cut(spec[i+1:], ":")
+		if _, err := hex.DecodeString(digest); ok && err == nil && digest != "" && !strings.Contains(algorithm, "/") {
+			input = SLSAResourceDescriptor{URI: spec[:i], Digest: map[string]string{algorithm: strings.ToLower(digest)}}
+		}
+	}
+	if input.URI == "" {
+		return input, fmt.Errorf("%w: empty input uri: %s", ErrInvalidProvenance, spec)
+	}
+	return input, nil
+}
+
+// Statement about the instance archive, with its SHA-256 digest and id.
+func NewProvenance(instance Instance, archiveSHA256 []byte, opts ProvenanceOptions) (*InTotoStatement, error) {
+	if opts.BuilderId == "" {
+		return nil, fmt.Errorf("%w: builder id is not set and not detected from CI", ErrInvalidProvenance)
+	}
+
+	definition := SLSABuildDefinition{
+		BuildType: ProvenanceBuildType,
+		ExternalParameters: ProvenanceParameters{
+			Package:    instance.Package,
+			Repository: opts.SourceRepo,
+			Ref:        opts.SourceRef,
+		},
+	}
+	if opts.SourceRepo != "" {
+		source := SLSAResourceDescriptor{URI: opts.SourceRepo}
+		if opts.SourceRef != "" {
+			source.URI += "@" + opts.SourceRef
+		}
+		if opts.SourceCommit != "" {
+			source.Digest = map[string]string{"gitCommit": opts.SourceCommit}
+		}
+		definition.ResolvedDependencies = append(definition.ResolvedDependencies, source)
+	}
+	definition.ResolvedDependencies = append(definition.ResolvedDependencies, opts.Inputs...)
+
+	// Digest of the id is the same one for SHA-256 ids.
+	digest := map[string]string{"sha256": hex.EncodeToString(archiveSHA256)}
+	algorithm, id, _ := ParseInstanceId(instance.Id)
+	digest[algorithm] = id
+
+	return &InTotoStatement{
+		Type: InTotoStatementType,
+		Subject: []InTotoSubject{{
+			Name:   instance.Package + "@" + instance.Id,
+			Digest: digest,
+		}},
+		PredicateType: SLSAProvenancePredicateType,
+		Predicate: SLSAProvenance{
+			BuildDefinition: definition,
+			RunDetails: SLSARunDetails{
+				Builder: SLSABuilder{Id: opts.BuilderId},
+				Metadata: SLSABuildMetadata{
+					InvocationId: opts.InvocationId,
+					FinishedOn:   time.Now().UTC().Truncate(time.Second),
+				},
+			},
+		},
+	}, nil
+}
+
+func (s InTotoStatement) BuilderId() string {
+	return s.Predicate.RunDetails.Builder.Id
+}
+
+func (s InTotoStatement) SourceRepo() string {
+	return s.Predicate.BuildDefinition.ExternalParameters.Repository
+}
+
+// Commit from the source dependency, empty if unknown.
+func (s InTotoStatement) SourceCommit() string {
+	for _, dependency := range s.Predicate.BuildDefinition.ResolvedDependencies {
+		if commit, ok := dependency.Digest["gitCommit"]; ok {
+			return commit
+		}
+	}
+	return ""
+}
+
+// Check that the statement is SLSA provenance of the archive.
+func (s InTotoStatement) verifySubject(archiveSHA256 []byte) error {
+	if s.Type != InTotoStatementType || s.PredicateType != SLSAProvenancePredicateType {
+		return fmt.Errorf("%w: %s is not SLSA provenance", ErrInvalidProvenance, s.PredicateType)
+	}
+	digest := hex.EncodeToString(archiveSHA256)
+	for _, subject := range s.Subject {
+		if subject.Digest["sha256"] == digest {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: subject digest does not match the archive", ErrInvalidProvenance)
+}
+
+// Upload the provenance of the archive, which is given.
+func PutProvenance(ctx context.Context, registry Registry, instance Instance, archiveSHA256 []byte, opts ProvenanceOptions) (*InTotoStatement, error) {
+	statement, err := NewProvenance(instance, archiveSHA256, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = registry.PutPackageInstanceProvenance(ctx, instance, *statement); err != nil {
+		return nil, err
+	}
+	return statement, nil
+}
+
+// Provenance of the instance, checked to be about its archive. Fails with
+// ErrNoProvenance if there is none.
+func VerifyPackageInstanceProvenance(ctx context.Context, registry Registry, instance Instance) (*InTotoStatement, error) {
+	statement, err := registry.GetPackageInstanceProvenance(ctx, instance)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s@%s", ErrNoProvenance, instance.Package, instance.Id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	digest, err := downloadArchiveDigest(ctx, registry, instance)
+	if err != nil {
+		return nil, err
+	}
+	if err = statement.verifySubject(digest); err != nil {
+		return nil, err
+	}
+	return statement, nil
+}
+
+// Whether the value is allowed: listed, or matched by a listed prefix
+// ending with "*".
+func matchesAny(patterns []string, value string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		prefix, wildcard := strings.CutSuffix(pattern, "*")
+		return pattern == value || (wildcard && strings.HasPrefix(value, prefix))
+	})
+}
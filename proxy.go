@@ -0,0 +1,259 @@
+package shop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Secondary repositories are served under repos/<name>/.
+	ProxyReposPrefix = "repos"
+)
+
+var (
+	ErrProxyUnsupported = errors.New("Registry could not be served")
+)
+
+// Read-only http handler, which serves registry repositories in the layout
+// read by HTTPFS: objects under their keys, and listings of prefixes as
+// generated index.json. Registry manifest is rewritten to point to the
+// secondary repositories served under ProxyReposPrefix, so clients only need
+// access to the proxy.
+type RegistryProxy struct {
+	registry *RegistryImpl
+}
+
+func NewRegistryProxy(registry Registry) (*RegistryProxy, error) {
+	impl, ok := registry.(*RegistryImpl)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrProxyUnsupported, registry)
+	}
+	return &RegistryProxy{registry: impl}, nil
+}
+
+func (p *RegistryProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	status, err := p.serve(w, r)
+	level := slog.LevelInfo
+	attrs := []slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", status),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	// Missing objects are routine, clients probe for them.
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelWarn
+	}
+	slog.Default().LogAttrs(r.Context(), level, "proxy request", attrs...)
+}
+
+func (p *RegistryProxy) serve(w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		return p.fail(w, http.StatusMethodNotAllowed, nil)
+	}
+
+	name, key := p.resolve(r.URL.Path)
+	repo := p.registry.rootRepository
+	if name != "" {
+		var ok bool
+		if repo, ok = p.registry.repositories[name]; !ok {
+			return p.fail(w, http.StatusNotFound, fmt.Errorf("%w: %s", ErrUnknownRepo, name))
+		}
+	}
+
+	ctx := r.Context()
+	switch {
+	case name == "" && key == RegistryManifestKey:
+		return p.serveManifest(ctx, w, r)
+	case key == "":
+		return p.serveIndex(ctx, w, r, repo, "")
+	case path.Base(key) == RepositoryIndexKey:
+		return p.serveIndex(ctx, w, r, repo, strings.TrimPrefix(path.Dir(key), "."))
+	default:
+		return p.serveObject(ctx, w, r, repo, key)
+	}
+}
+
+// Repository name (empty for the root one) and key of the request path.
+func (p *RegistryProxy) resolve(urlPath string) (name, key string) {
+	key = strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if rest, ok := strings.CutPrefix(key, ProxyReposPrefix+"/"); ok {
+		name, key, _ = strings.Cut(rest, "/")
+	}
+	return
+}
+
+func (p *RegistryProxy) fail(w http.ResponseWriter, status int, err error) (int, error) {
+	http.Error(w, http.StatusText(status), status)
+	return status, err
+}
+
+func proxyStatus(err error) int {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, os.ErrPermission):
+		return http.StatusForbidden
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func (p *RegistryProxy) writeJSON(w http.ResponseWriter, r *http.Request, output any) (int, error) {
+	data, err := json.Marshal(output)
+	if err != nil {
+		return p.fail(w, http.StatusInternalServerError, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+	return http.StatusOK, nil
+}
+
+// Registry manifest with repository urls pointing to the proxy.
+func (p *RegistryProxy) serveManifest(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	manifest, err := p.registry.GetManifest(ctx)
+	if err != nil {
+		return p.fail(w, proxyStatus(err), err)
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	base := scheme + "://" + r.Host
+
+	manifest.RootRepo.URL = base
+	manifest.RootRepo.ReadOnlyURL = ""
+	for name, repo := range manifest.Repos {
+		repo.URL = base + "/" + path.Join(ProxyReposPrefix, name)
+		repo.ReadOnlyURL = ""
+		manifest.Repos[name] = repo
+	}
+	return p.writeJSON(w, r, manifest)
+}
+
+func (p *RegistryProxy) serveIndex(ctx context.Context, w http.ResponseWriter, r *http.Request, repo Repository, prefix string) (int, error) {
+	entries, err := CollectCursor(ctx, repo.List(ctx, prefix))
+	if err != nil {
+		return p.fail(w, proxyStatus(err), err)
+	}
+
+	index := RepositoryIndex{
+		ApiVersion: LatestVersion,
+		Entries:    make([]IndexEntry, 0, len(entries)),
+		UpdatedAt:  UnixTimestamp{time.Now()},
+	}
+	for _, entry := range entries {
+		index.Entries = append(index.Entries, IndexEntry{Key: entry.Key, IsPrefix: entry.IsPrefix})
+	}
+	return p.writeJSON(w, r, index)
+}
+
+// Plain GET streams the object through the repository cache without Stat,
+// HEAD and ranged GET need its size.
+func (p *RegistryProxy) serveObject(ctx context.Context, w http.ResponseWriter, r *http.Request, repo Repository, key string) (int, error) {
+	header := w.Header()
+	header.Set("Accept-Ranges", "bytes")
+
+	rangeHeader := r.Header.Get("Range")
+	if r.Method == http.MethodGet && rangeHeader == "" {
+		body, err := repo.Get(ctx, key)
+		if err != nil {
+			return p.fail(w, proxyStatus(err), err)
+		}
+		defer body.Close()
+		return p.copyBody(w, http.StatusOK, body)
+	}
+
+	info, err := repo.Stat(ctx, key)
+	if err != nil {
+		return p.fail(w, proxyStatus(err), err)
+	}
+	if info.ETag != "" {
+		header.Set("ETag", info.ETag)
+	}
+	if !info.ModTime.IsZero() {
+		header.Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	start, end, ok := parseByteRange(rangeHeader, info.Size)
+	if !ok {
+		header.Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusOK)
+			return http.StatusOK, nil
+		}
+		body, err := repo.Get(ctx, key)
+		if err != nil {
+			return p.fail(w, proxyStatus(err), err)
+		}
+		defer body.Close()
+		return p.copyBody(w, http.StatusOK, body)
+	}
+
+	header.Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, info.Size))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusPartialContent)
+		return http.StatusPartialContent, nil
+	}
+	body, err := repo.GetRange(ctx, key, start, end-start+1)
+	if err != nil {
+		return p.fail(w, proxyStatus(err), err)
+	}
+	defer body.Close()
+	return p.copyBody(w, http.StatusPartialContent, body)
+}
+
+func (p *RegistryProxy) copyBody(w http.ResponseWriter, status int, body io.Reader) (int, error) {
+	w.WriteHeader(status)
+	// Headers are sent already, so on failure the client only sees a short
+	// body.
+	_, err := io.Copy(w, body)
+	return status, err
+}
+
+// Single "bytes=start-end" or "bytes=start-" range within the object.
+// Anything else is served as the whole object.
+func parseByteRange(value string, size int64) (start, end int64, ok bool) {
+	spec, ok := strings.CutPrefix(value, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end = size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end, true
+}
@@ -0,0 +1,96 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"time"
+)
+
+var (
+	ErrEmptyRetentionPolicy = errors.New("Retention policy does not keep anything")
+)
+
+// Instances kept by PrunePackage: the Keep newest ones, ones uploaded less
+// than OlderThan ago and, with KeepRefs, ones pointed to by refs. Stored in
+// the package manifest and used unless overridden.
+type RetentionPolicy struct {
+	Keep      int      `json:"keep,omitempty"`
+	KeepRefs  bool     `json:"keep_refs,omitempty"`
+	OlderThan Duration `json:"older_than"`
+}
+
+// Policy which would delete every instance.
+func (p RetentionPolicy) IsEmpty() bool {
+	return p.Keep <= 0 && !p.KeepRefs && p.OlderThan.Duration <= 0
+}
+
+// Delete instances of the package which the policy doesn't keep, along with
+// refs pointing to them. With dryRun only the deletions are returned.
+func PrunePackage(ctx context.Context, registry Registry, name string, policy RetentionPolicy, dryRun bool) ([]InstanceDeletion, error) {
+	if policy.IsEmpty() {
+		return nil, ErrEmptyRetentionPolicy
+	}
+
+	instances, err := CollectCursor(ctx, registry.ListPackageInstances(ctx, name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	sort.SliceStable(instances, func(i, j int) bool {
+		return instances[i].UploadedAt.After(instances[j].UploadedAt.Time)
+	})
+
+	refs, err := CollectCursor(ctx, registry.ListPackageReferences(ctx, name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	instanceRefs := map[string][]string{}
+	for _, ref := range refs {
+		instanceRefs[ref.Id] = append(instanceRefs[ref.Id], ref.Name)
+	}
+
+	var pruned []Instance
+	for i, instance := range instances {
+		switch {
+		case i < policy.Keep:
+		case policy.KeepRefs && len(instanceRefs[instance.Id]) > 0:
+		case policy.OlderThan.Duration > 0 && time.Since(instance.UploadedAt.Time) < policy.OlderThan.Duration:
+		default:
+			pruned = append(pruned, instance)
+		}
+	}
+
+	deletions := make([]InstanceDeletion, len(pruned))
+	if dryRun {
+		for i, instance := range pruned {
+			deletions[i] = InstanceDeletion{
+				Package: instance.Package,
+				Id:      instance.Id,
+				Refs:    append([]string{}, instanceRefs[instance.Id]...),
+			}
+		}
+		return deletions, nil
+	}
+
+	done := make([]bool, len(pruned))
+	err = runJobs(ctx, registry.Jobs(), len(pruned), func(ctx context.Context, i int) error {
+		deletion, err := registry.DeletePackageInstance(ctx, pruned[i], false)
+		if deletion != nil {
+			deletions[i] = *deletion
+			done[i] = true
+		}
+		return err
+	})
+	if err != nil {
+		// Report what was deleted before the failure.
+		deleted := []InstanceDeletion{}
+		for i, deletion := range deletions {
+			if done[i] {
+				deleted = append(deleted, deletion)
+			}
+		}
+		return deleted, err
+	}
+	return deletions, nil
+}
@@ -0,0 +1,94 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+var (
+	ErrInvalidRange = errors.New("Invalid byte range")
+)
+
+// Optional RepositoryFS capability for reading a part of an object. Negative
+// length reads until the end of the object.
+type RangeReaderFS interface {
+	OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+}
+
+func openRange(ctx context.Context, fs RepositoryFS, key string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: %s: offset %d", ErrInvalidRange, key, offset)
+	}
+	if fs, ok := findCapability[RangeReaderFS](fs); ok {
+		return fs.OpenRange(ctx, key, offset, length)
+	}
+
+	body, err := fs.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return skipRange(body, offset, length)
+}
+
+type rangeBody struct {
+	io.Reader
+	io.Closer
+}
+
+// Drop everything outside of the range from the full object body.
+func skipRange(body io.ReadCloser, offset, length int64) (io.ReadCloser, error) {
+	if _, err := io.CopyN(io.Discard, body, offset); err != nil && !errors.Is(err, io.EOF) {
+		body.Close()
+		return nil, err
+	}
+	return limitRange(body, length), nil
+}
+
+func limitRange(body io.ReadCloser, length int64) io.ReadCloser {
+	if length < 0 {
+		return body
+	}
+	return rangeBody{io.LimitReader(body, length), body}
+}
+
+// Range of files and other seekable objects.
+func seekRange(file io.ReadSeekCloser, offset, length int64) (io.ReadCloser, error) {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return limitRange(file, length), nil
+}
+
+func httpRangeHeader(offset, length int64) http.Header {
+	header := http.Header{}
+	if length < 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+	return header
+}
+
+// Body of ranged GET response. Servers without range support reply with the
+// whole object, it's cut on the client then.
+func httpRangeBody(resp *http.Response, offset, length int64) (io.ReadCloser, error) {
+	if resp.StatusCode == http.StatusPartialContent {
+		return limitRange(resp.Body, length), nil
+	}
+	return skipRange(resp.Body, offset, length)
+}
+
+func (c httpClient) openRange(ctx context.Context, url string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return http.NoBody, nil
+	}
+	resp, err := c.doOK(ctx, http.MethodGet, url, nil, httpRangeHeader(offset, length))
+	if err != nil {
+		return nil, err
+	}
+	return httpRangeBody(resp, offset, length)
+}
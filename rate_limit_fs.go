@@ -0,0 +1,95 @@
+package shop
+
+import (
+	"context"
+	"io"
+	"math"
+
+	"golang.org/x/time/rate"
+)
+
+// RepositoryFS wrapper which limits the rate of backend operations with a
+// token bucket. Every call counts as one operation, data streamed through
+// Open and Create is not limited.
+type RateLimitFS struct {
+	fs      RepositoryFS
+	limiter *rate.Limiter
+}
+
+func NewRateLimitFS(fs RepositoryFS, cfg RepositoryConfig) RepositoryFS {
+	burst := cfg.RateLimitBurst
+	if burst == 0 {
+		burst = max(1, int(math.Ceil(cfg.RateLimit)))
+	}
+	return RateLimitFS{
+		fs:      fs,
+		limiter: rate.NewLimiter(rate.Limit(cfg.RateLimit), burst),
+	}
+}
+
+func (f RateLimitFS) Unwrap() RepositoryFS {
+	return f.fs
+}
+
+func (f RateLimitFS) Read(ctx context.Context, key string) ([]byte, error) {
+	if err := f.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return f.fs.Read(ctx, key)
+}
+
+func (f RateLimitFS) Write(ctx context.Context, key string, data []byte) error {
+	if err := f.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return f.fs.Write(ctx, key, data)
+}
+
+func (f RateLimitFS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := f.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return f.fs.Open(ctx, key)
+}
+
+func (f RateLimitFS) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	if err := f.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return f.fs.Create(ctx, key)
+}
+
+func (f RateLimitFS) MakeDir(ctx context.Context, key string) error {
+	if err := f.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return f.fs.MakeDir(ctx, key)
+}
+
+func (f RateLimitFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	if err := f.limiter.Wait(ctx); err != nil {
+		return NewErrorCursor[Entry](err)
+	}
+	return f.fs.ListDir(ctx, key)
+}
+
+func (f RateLimitFS) Remove(ctx context.Context, key string) error {
+	if err := f.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return f.fs.Remove(ctx, key)
+}
+
+func (f RateLimitFS) Exists(ctx context.Context, key string) (bool, error) {
+	if err := f.limiter.Wait(ctx); err != nil {
+		return false, err
+	}
+	return f.fs.Exists(ctx, key)
+}
+
+func (f RateLimitFS) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	if err := f.limiter.Wait(ctx); err != nil {
+		return ObjectInfo{}, err
+	}
+	return f.fs.Stat(ctx, key)
+}
@@ -1,10 +1,24 @@
 package shop
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"time"
 )
 
+const (
+	// Changes kept in the history of a ref, older ones are dropped.
+	ReferenceHistoryLimit = 100
+
+	referenceHistoryUpdateAttempts = 5
+)
+
+var (
+	ErrReferenceNotSet = errors.New("Reference did not point to an instance at the time")
+)
+
 type Reference struct {
 	ApiVersion string        `json:"api_version"`
 	Package    string        `json:"package"`
@@ -37,3 +51,81 @@ func IsValidRefName(v string) bool {
 	// refs & tags has the same name format.
 	return IsValidTagName(v)
 }
+
+// Value the ref had from UpdatedAt until ReplacedAt, when it was moved to
+// ReplacedBy or deleted.
+type ReferenceHistoryEntry struct {
+	Id         string         `json:"id"`
+	UpdatedAt  UnixTimestamp  `json:"updated_at"`
+	ReplacedAt *UnixTimestamp `json:"replaced_at,omitempty"`
+	ReplacedBy string         `json:"replaced_by,omitempty"`
+}
+
+// Previous values of the ref, oldest first. It's kept when the ref is
+// deleted, and deleted along with the package.
+type ReferenceHistory struct {
+	ApiVersion string                  `json:"api_version"`
+	Package    string                  `json:"package"`
+	Name       string                  `json:"name"`
+	Entries    []ReferenceHistoryEntry `json:"entries"`
+}
+
+func (h *ReferenceHistory) append(entry ReferenceHistoryEntry) {
+	h.ApiVersion = LatestVersion
+	h.Entries = append(h.Entries, entry)
+	if len(h.Entries) > ReferenceHistoryLimit {
+		h.Entries = h.Entries[len(h.Entries)-ReferenceHistoryLimit:]
+	}
+}
+
+// Values of the ref, newest first. The current one, if the ref exists, has
+// no ReplacedAt.
+func ListReferenceHistory(ctx context.Context, registry Registry, pkg, name string) ([]ReferenceHistoryEntry, error) {
+	var entries []ReferenceHistoryEntry
+	ref, err := registry.GetPackageReference(ctx, pkg, name)
+	switch {
+	case err == nil:
+		entries = append(entries, ReferenceHistoryEntry{Id: ref.Id, UpdatedAt: ref.UpdatedAt})
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+
+	history, err := registry.GetPackageReferenceHistory(ctx, pkg, name)
+	switch {
+	case err == nil:
+		for i := len(history.Entries) - 1; i >= 0; i-- {
+			entries = append(entries, history.Entries[i])
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: %s@%s:%s", ErrVersionNotFound, pkg, VersionRefPrefix, name)
+	}
+	return entries, nil
+}
+
+// Value the ref had at the time. Fails with ErrReferenceNotSet if it didn't
+// exist then, or the change is older than the history keeps.
+func ReferenceAt(ctx context.Context, registry Registry, pkg, name string, at time.Time) (*Reference, error) {
+	entries, err := ListReferenceHistory(ctx, registry, pkg, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.UpdatedAt.After(at) {
+			continue
+		}
+		if entry.ReplacedAt != nil && !entry.ReplacedAt.After(at) {
+			break
+		}
+		return &Reference{
+			ApiVersion: LatestVersion,
+			Package:    pkg,
+			Name:       name,
+			Id:         entry.Id,
+			UpdatedAt:  entry.UpdatedAt,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %s@%s:%s at %s", ErrReferenceNotSet, pkg, VersionRefPrefix, name, at.Format(time.RFC3339))
+}
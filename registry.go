@@ -2,8 +2,8 @@ package shop
 
 import (
 	"context"
-	"crypto/sha1"
 	"io"
+	"time"
 )
 
 const (
@@ -12,13 +12,18 @@ const (
 	RegistryPackagesPrefix             = "/packages/"
 	RegistryPackageManifestKey         = "package.json"
 	RegistryPackageReferencesPrefix    = "/refs/"
+	RegistryPackageRefHistoryPrefix    = "/ref_history/"
 	RegistryPackageInstancesPrefix     = "/instances/"
 	RegistryPackageInstanceManifestKey = "instance.json"
+	RegistryPackageInstanceFilesKey    = "files.json"
 	RegistryPackageTagsPrefix          = "/tags/"
 	RegistryPackageInstanceTagsPrefix  = "/tags/"
-	RegistryPackageInstanceIdLen       = sha1.Size * 2
 	RegistryCASPrefix                  = "/cas/"
 	RegistryCASArchiveExtension        = ".tgz"
+
+	// Explicit version forms, ref:name and tag:key=value.
+	VersionRefPrefix = "ref"
+	VersionTagPrefix = "tag"
 )
 
 type RegistryManifest struct {
@@ -26,7 +31,9 @@ type RegistryManifest struct {
 	Name       string                        `json:"name"`
 	RootRepo   RepositoryManifest            `json:"root_repo"`
 	Repos      map[string]RepositoryManifest `json:"repos"`
-	UpdatedAt  UnixTimestamp                 `json:"updated_at"`
+	// Keys trusted to make detached signatures of instance archives.
+	SigningKeys []SigningKey  `json:"signing_keys,omitempty"`
+	UpdatedAt   UnixTimestamp `json:"updated_at"`
 }
 
 type PackageOrPrefix struct {
@@ -36,27 +43,58 @@ type PackageOrPrefix struct {
 
 type Registry interface {
 	GetConfig() RegistryConfig
+	// Number of parallel operations used by batch commands.
+	Jobs() int
+	// Close connections to repositories.
+	Close() error
 
 	Initialize(ctx context.Context, name string) error
 
 	GetManifest(ctx context.Context) (*RegistryManifest, error)
 	PutManifest(context.Context, RegistryManifest) error
+	RemoveRepository(ctx context.Context, name string, force bool) ([]string, error)
+	GetACL(ctx context.Context) (*ACL, error)
+	UpdateACL(ctx context.Context, update func(*ACL) error) (*ACL, error)
 
 	GetPackage(ctx context.Context, name string) (*Package, error)
 	ListPackages(ctx context.Context, prefix string) Cursor[PackageOrPrefix]
+	WalkPackages(ctx context.Context, prefix string, fn func(Package) error) error
 	PutPackage(ctx context.Context, pkg Package) error
+	DeletePackage(ctx context.Context, name string, keepArchives bool) error
 
-	UploadPackageInstance(ctx context.Context, name, id string, reader io.Reader) (*Instance, error)
+	UploadPackageInstance(ctx context.Context, name, id string, reader io.Reader, opts UploadOptions) (*Instance, error)
 	ListPackageInstances(ctx context.Context, name string) Cursor[Instance]
 	GetPackageInstanceInfo(ctx context.Context, name, id string) (*Instance, error)
 	PutPackageInstanceInfo(ctx context.Context, instance Instance) error
 	DeletePackageInstanceInfo(ctx context.Context, instance Instance) error
+	DeletePackageInstance(ctx context.Context, instance Instance, keepArchive bool) (*InstanceDeletion, error)
 	ListPackageInstanceTags(ctx context.Context, instance Instance) Cursor[Tag]
+	GetPackageInstanceFiles(ctx context.Context, instance Instance) ([]ArchiveFile, error)
+	PutPackageInstanceFiles(ctx context.Context, instance Instance, files []ArchiveFile) error
+	GetPackageInstanceURL(ctx context.Context, instance Instance, ttl time.Duration) (string, error)
+	DownloadPackageInstance(ctx context.Context, instance Instance, dst io.Writer) error
+	DownloadPackageInstanceFrom(ctx context.Context, instance Instance, partial io.Reader, dst io.Writer) error
+	DeletePackageInstanceArchive(ctx context.Context, instance Instance) error
+	GetPackageInstanceSigstoreBundle(ctx context.Context, instance Instance) (*SigstoreBundle, error)
+	PutPackageInstanceSigstoreBundle(ctx context.Context, instance Instance, bundle SigstoreBundle) error
+	ListPackageInstanceSignatures(ctx context.Context, instance Instance) ([]DetachedSignature, error)
+	PutPackageInstanceSignature(ctx context.Context, instance Instance, signature DetachedSignature) error
+	GetPackageInstanceSBOM(ctx context.Context, instance Instance) (io.ReadCloser, error)
+	PutPackageInstanceSBOM(ctx context.Context, instance Instance, body io.Reader) error
+	GetPackageInstanceProvenance(ctx context.Context, instance Instance) (*InTotoStatement, error)
+	PutPackageInstanceProvenance(ctx context.Context, instance Instance, statement InTotoStatement) error
+	CollectGarbage(ctx context.Context, minAge time.Duration, dryRun bool) ([]ArchiveInfo, error)
+	CheckIntegrity(ctx context.Context, repair bool) ([]Problem, error)
+	CollectStats(ctx context.Context) (*RegistryStats, error)
+	Diagnose(ctx context.Context, probe bool) []Diagnostic
+	ResolveVersion(ctx context.Context, pkg, version string) (*Instance, error)
 
 	ListPackageReferences(ctx context.Context, name string) Cursor[Reference]
 	GetPackageReference(ctx context.Context, pkg, name string) (*Reference, error)
 	PutPackageReference(ctx context.Context, ref Reference) error
 	DeletePackageReference(ctx context.Context, ref Reference) error
+	GetPackageReferenceHistory(ctx context.Context, pkg, name string) (*ReferenceHistory, error)
+	PutPackageReferenceHistory(ctx context.Context, history ReferenceHistory) error
 
 	ListPackageTags(ctx context.Context, names string) Cursor[PackageTag]
 	ListPackageTagValues(ctx context.Context, tag PackageTag) Cursor[PackageTagValue]
@@ -1,10 +1,14 @@
 package shop
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
@@ -14,12 +18,28 @@ type RegistryImpl struct {
 	cfg            RegistryConfig
 	rootRepository Repository
 	repositories   map[string]Repository
+	// Keys from the manifest, detached signatures by them are verified on
+	// download.
+	signingKeys []SigningKey
 }
 
 func (c *RegistryImpl) GetConfig() RegistryConfig {
 	return c.cfg
 }
 
+// Configured jobs, or the default of the root repository backend.
+func (c *RegistryImpl) Jobs() int {
+	return repositoryJobs(c.rootRepository.GetConfig())
+}
+
+func (c *RegistryImpl) Close() error {
+	errs := c.rootRepository.Close()
+	for _, repo := range c.repositories {
+		errs = multierror.Append(errs, repo.Close()).ErrorOrNil()
+	}
+	return errs
+}
+
 func (c *RegistryImpl) GetManifest(ctx context.Context) (manifest *RegistryManifest, err error) {
 	manifest = &RegistryManifest{}
 	err = c.rootRepository.GetJSON(ctx, RegistryManifestKey, manifest)
@@ -66,6 +86,81 @@ func (c *RegistryImpl) PutManifest(ctx context.Context, manifest RegistryManifes
 	return c.rootRepository.PutJSON(ctx, RegistryManifestKey, manifest)
 }
 
+// Remove the repo from the manifest. Fails with ErrRepoInUse if packages
+// still store archives there, unless force is set: then their archives are
+// copied into the root repo and packages are moved there. Returns names of
+// the affected packages.
+func (c *RegistryImpl) RemoveRepository(ctx context.Context, name string, force bool) ([]string, error) {
+	if !c.cfg.Admin {
+		return nil, fmt.Errorf("%w: RemoveRepository: %s", ErrRegistryAdminIsNotAllowed, name)
+	}
+
+	manifest, err := c.GetManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := manifest.Repos[name]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRepo, name)
+	}
+
+	var affected []Package
+	err = c.WalkPackages(ctx, "", func(pkg Package) error {
+		if pkg.Repo == name {
+			affected = append(affected, pkg)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(affected))
+	for _, pkg := range affected {
+		names = append(names, pkg.Name)
+	}
+	if len(affected) > 0 && !force {
+		return names, fmt.Errorf("%w: %s: %s", ErrRepoInUse, name, strings.Join(names, ", "))
+	}
+
+	for _, pkg := range affected {
+		if err = c.moveToRootRepository(ctx, pkg); err != nil {
+			return names, err
+		}
+	}
+
+	delete(manifest.Repos, name)
+	if err = c.PutManifest(ctx, *manifest); err != nil {
+		return names, err
+	}
+	delete(c.repositories, name)
+	return names, nil
+}
+
+func (c *RegistryImpl) moveToRootRepository(ctx context.Context, pkg Package) error {
+	repo, err := c.packageRepository(ctx, pkg.Name)
+	if err != nil {
+		return err
+	}
+
+	instances, err := CollectCursor(ctx, c.ListPackageInstances(ctx, pkg.Name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(instances) > 0 {
+		if err = c.rootRepository.EnsurePrefix(ctx, RegistryCASPrefix); err != nil {
+			return err
+		}
+	}
+	for _, instance := range instances {
+		if err = copyCASObjects(ctx, repo, c.rootRepository, instance); err != nil {
+			return fmt.Errorf("%s@%s: %w", pkg.Name, instance.Id, err)
+		}
+	}
+
+	pkg.Repo = ""
+	return c.PutPackage(ctx, pkg)
+}
+
 func (c *RegistryImpl) isPackage(ctx context.Context, name string) (bool, error) {
 	key := filepath.Join(RegistryPackagesPrefix, name, RegistryPackageManifestKey)
 	return c.rootRepository.ResourceExists(ctx, key)
@@ -151,6 +246,65 @@ func (c *RegistryImpl) ListPackages(ctx context.Context, prefix string) Cursor[P
 	}
 }
 
+// Call fn with the name of every package under prefix, including packages
+// nested under other packages.
+func (c *RegistryImpl) walkPackageNames(ctx context.Context, prefix string, fn func(name string) error) error {
+	cursor := c.rootRepository.List(ctx, filepath.Join(RegistryPackagesPrefix, prefix))
+	entries, err := CollectCursor(ctx, cursor)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	isPackage := false
+	if prefix != "" {
+		if isPackage, err = c.isPackage(ctx, prefix); err != nil {
+			return err
+		}
+		if isPackage {
+			if err = fn(prefix); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		if !entry.IsPrefix {
+			continue
+		}
+		if isPackage && isPackageSubdir(entry.Key) {
+			continue
+		}
+		if err = c.walkPackageNames(ctx, filepath.Join(prefix, entry.Key), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isPackageSubdir(name string) bool {
+	for _, subdir := range []string{RegistryPackageInstancesPrefix, RegistryPackageReferencesPrefix, RegistryPackageRefHistoryPrefix, RegistryPackageTagsPrefix} {
+		if name == strings.Trim(subdir, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Call fn for every package under prefix, including packages nested under
+// other packages.
+func (c *RegistryImpl) WalkPackages(ctx context.Context, prefix string, fn func(Package) error) error {
+	return c.walkPackageNames(ctx, prefix, func(name string) error {
+		pkg, err := c.GetPackage(ctx, name)
+		if err != nil {
+			return err
+		}
+		return fn(*pkg)
+	})
+}
+
 func (c *RegistryImpl) PutPackage(ctx context.Context, pkg Package) error {
 	pkg.ApiVersion = LatestVersion
 	pkg.UpdatedAt = UnixTimestamp{time.Now()}
@@ -174,7 +328,7 @@ func (c *RegistryImpl) PutPackage(ctx context.Context, pkg Package) error {
 		c.rootRepository.EnsurePrefix(ctx, filepath.Join(prefix, RegistryPackageInstancesPrefix)),
 		c.rootRepository.EnsurePrefix(ctx, filepath.Join(prefix, RegistryPackageReferencesPrefix)),
 		c.rootRepository.EnsurePrefix(ctx, filepath.Join(prefix, RegistryPackageTagsPrefix)),
-	)
+	).ErrorOrNil()
 	if err != nil {
 		return err
 	}
@@ -182,6 +336,53 @@ func (c *RegistryImpl) PutPackage(ctx context.Context, pkg Package) error {
 	return nil
 }
 
+// Deletes package manifest with all its instances, refs and tags. Packages
+// nested under its name are kept. Archives are deleted last, unless
+// keepArchives is set or instances of other packages share them, so a
+// failure never leaves instances without archives.
+func (c *RegistryImpl) DeletePackage(ctx context.Context, name string, keepArchives bool) error {
+	prefix := filepath.Join(RegistryPackagesPrefix, name)
+	if !c.cfg.Admin {
+		return fmt.Errorf("%w: DeletePackage: %s", ErrRegistryAdminIsNotAllowed, name)
+	}
+
+	// Package repository is unknown once the manifest is gone.
+	repo, err := c.packageRepository(ctx, name)
+	if err != nil {
+		return err
+	}
+	var instances []Instance
+	if !keepArchives {
+		instances, err = CollectCursor(ctx, c.ListPackageInstances(ctx, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	for _, subdir := range []string{RegistryPackageInstancesPrefix, RegistryPackageReferencesPrefix, RegistryPackageRefHistoryPrefix, RegistryPackageTagsPrefix} {
+		if err := c.rootRepository.DeleteAll(ctx, filepath.Join(prefix, subdir)); err != nil {
+			return err
+		}
+	}
+
+	// Package dir itself is kept, removing it deletes everything in it on
+	// some backends.
+	if err = c.rootRepository.Delete(ctx, filepath.Join(prefix, RegistryPackageManifestKey)); err != nil {
+		return err
+	}
+
+	for _, instance := range instances {
+		shared, err := c.isArchiveShared(ctx, repo, instance)
+		if err == nil && !shared {
+			err = deleteArchive(ctx, repo, instance.Id, instance.Compression)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type registryListPackageInstancesCursor struct {
 	cursor Cursor[Entry]
 	pkg    string
@@ -222,7 +423,7 @@ func (c *RegistryImpl) ListPackageInstances(ctx context.Context, name string) Cu
 }
 
 func (c *RegistryImpl) GetPackageInstanceInfo(ctx context.Context, name, id string) (instance *Instance, err error) {
-	key := filepath.Join(RegistryPackagesPrefix, name, RegistryPackagesPrefix, id, RegistryPackageInstanceManifestKey)
+	key := filepath.Join(RegistryPackagesPrefix, name, RegistryPackageInstancesPrefix, id, RegistryPackageInstanceManifestKey)
 	instance = &Instance{}
 	err = c.rootRepository.GetJSON(ctx, key, instance)
 	if err != nil {
@@ -231,38 +432,102 @@ func (c *RegistryImpl) GetPackageInstanceInfo(ctx context.Context, name, id stri
 	return
 }
 
-func (c *RegistryImpl) UploadPackageInstance(ctx context.Context, name, id string, reader io.Reader) (*Instance, error) {
-	if !c.cfg.Write {
-		return nil, fmt.Errorf("%w: %s@%s", ErrRegistryWriteIsNotAllowed, name, id)
-	}
+// Repository which stores archives of the package.
+func (c *RegistryImpl) packageRepository(ctx context.Context, name string) (Repository, error) {
 	pkg, err := c.GetPackage(ctx, name)
 	if err != nil {
 		return nil, err
 	}
 
-	var repo Repository
-	if pkg.Repo != "" {
-		var ok bool
-		repo, ok = c.repositories[pkg.Repo]
-		if !ok {
-			return nil, fmt.Errorf("%w: %s", ErrUnknownRepo, pkg.Repo)
-		}
-	} else {
-		repo = c.rootRepository
+	if pkg.Repo == "" {
+		return c.rootRepository, nil
+	}
+
+	repo, ok := c.repositories[pkg.Repo]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRepo, pkg.Repo)
+	}
+	return repo, nil
+}
+
+type UploadOptions struct {
+	// Don't upload archives which are in CAS already. If the instance
+	// manifest exists too, it's returned with ErrInstanceExists.
+	IfNotExists bool
+	// Compression of the archive, recorded in the instance.
+	Compression string
+	// Instance of the package the archive is stored as a delta against,
+	// with the delta compression. Its archive must be in the repository.
+	DeltaBase string
+}
+
+func (c *RegistryImpl) UploadPackageInstance(ctx context.Context, name, id string, reader io.Reader, opts UploadOptions) (*Instance, error) {
+	if !c.cfg.Write {
+		return nil, fmt.Errorf("%w: %s@%s", ErrRegistryWriteIsNotAllowed, name, id)
+	}
+	repo, err := c.packageRepository(ctx, name)
+	if err != nil {
+		return nil, err
 	}
 
 	instance, err := NewInstance(name, id)
 	if err != nil {
 		return nil, err
 	}
+	if !IsValidCompression(opts.Compression) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCompression, opts.Compression)
+	}
+	instance.Compression = opts.Compression
+
+	var base *Instance
+	switch {
+	case opts.Compression == CompressionDelta && opts.DeltaBase == "":
+		return nil, fmt.Errorf("%w: delta archive of %s@%s has no base", ErrInvalidDeltaBase, name, id)
+	case opts.Compression != CompressionDelta && opts.DeltaBase != "":
+		return nil, fmt.Errorf("%w: %s archive of %s@%s has a base", ErrInvalidDeltaBase, opts.Compression, name, id)
+	case opts.DeltaBase == id:
+		return nil, fmt.Errorf("%w: %s@%s is its own base", ErrInvalidDeltaBase, name, id)
+	case opts.DeltaBase != "":
+		if base, err = c.GetPackageInstanceInfo(ctx, name, opts.DeltaBase); err != nil {
+			return nil, fmt.Errorf("%w: %s@%s: %w", ErrInvalidDeltaBase, name, opts.DeltaBase, err)
+		}
+		// Bases are stored in full, so reading a delta never walks a chain.
+		if base.Compression == CompressionDelta {
+			return nil, fmt.Errorf("%w: %s@%s is a delta itself", ErrInvalidDeltaBase, name, base.Id)
+		}
+		instance.DeltaBase = base.Id
+	}
+
+	if opts.IfNotExists {
+		ok, err := repo.ResourceExists(ctx, instance.CASKey())
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			existing, err := c.GetPackageInstanceInfo(ctx, name, id)
+			if err == nil {
+				return existing, fmt.Errorf("%w: %s@%s", ErrInstanceExists, name, id)
+			}
+			if !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			return &instance, nil
+		}
+	}
 
 	err = repo.EnsurePrefix(ctx, RegistryCASPrefix)
 	if err != nil {
 		return nil, err
 	}
 
-	casKey := filepath.Join(RegistryCASPrefix, id+RegistryCASArchiveExtension)
-	err = repo.Put(ctx, casKey, reader)
+	switch instance.Compression {
+	case CompressionFiles:
+		err = uploadFileManifest(ctx, repo, instance, reader)
+	case CompressionDelta:
+		err = uploadDelta(ctx, repo, instance, *base, reader)
+	default:
+		err = repo.Put(ctx, instance.CASKey(), reader)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -271,6 +536,163 @@ func (c *RegistryImpl) UploadPackageInstance(ctx context.Context, name, id strin
 	return &instance, nil
 }
 
+func (c *RegistryImpl) GetPackageInstanceURL(ctx context.Context, instance Instance, ttl time.Duration) (string, error) {
+	repo, err := c.packageRepository(ctx, instance.Package)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := storedExtensions[instance.Compression]; ok {
+		return "", fmt.Errorf("%w: %s@%s", ErrNoArchiveURL, instance.Package, instance.Id)
+	}
+	return repo.GetURL(ctx, instance.CASKey(), ttl)
+}
+
+// Copy the instance archive into dst. ErrHashMismatch, ErrInvalidSignature
+// and ErrSignaturePolicy are returned after the whole archive is written, so
+// dst should be discarded on error. Detached signatures by keys of the
+// registry manifest are verified, others are ignored.
+func (c *RegistryImpl) DownloadPackageInstance(ctx context.Context, instance Instance, dst io.Writer) error {
+	return c.DownloadPackageInstanceFrom(ctx, instance, nil, dst)
+}
+
+// Continue the download of the instance archive, of which partial has the
+// first bytes. Partial is checked along with the rest of the archive, only the
+// rest is written into dst. ErrInvalidRange is returned if partial is longer
+// than the archive.
+func (c *RegistryImpl) DownloadPackageInstanceFrom(ctx context.Context, instance Instance, partial io.Reader, dst io.Writer) error {
+	repo, err := c.packageRepository(ctx, instance.Package)
+	if err != nil {
+		return err
+	}
+
+	verifier, err := c.newArchiveVerifier(ctx, repo, instance)
+	if err != nil {
+		return err
+	}
+
+	h := instanceIdHashOf(instance.Id)
+	var offset int64
+	if partial != nil {
+		if offset, err = io.Copy(io.MultiWriter(h, verifier), partial); err != nil {
+			return err
+		}
+	}
+	w := io.MultiWriter(dst, h, verifier)
+	_, stored := storedExtensions[instance.Compression]
+	switch {
+	case stored && offset == 0:
+		err = writeStoredArchive(ctx, repo, instance, w)
+	case stored:
+		// Archives made from manifests are the same every time, the part
+		// which is there already is dropped.
+		err = writeStoredArchive(ctx, repo, instance, &skipWriter{w, offset})
+	default:
+		err = copyArchiveFrom(ctx, repo, instance, offset, w)
+	}
+	if err != nil {
+		return err
+	}
+	if id := h.Id(); id != instance.Id {
+		return fmt.Errorf("%w: %s@%s: got %s", ErrHashMismatch, instance.Package, instance.Id, id)
+	}
+	return verifier.Verify()
+}
+
+// Deletes the instance archive from CAS. Fails with ErrArchiveShared if
+// identical instances of other packages in the same repository, or deltas
+// stored against it, need it.
+func (c *RegistryImpl) DeletePackageInstanceArchive(ctx context.Context, instance Instance) error {
+	if !c.cfg.Admin {
+		return fmt.Errorf("%w: %s@%s", ErrRegistryAdminIsNotAllowed, instance.Package, instance.Id)
+	}
+	repo, err := c.packageRepository(ctx, instance.Package)
+	if err != nil {
+		return err
+	}
+
+	shared, err := c.isArchiveShared(ctx, repo, instance)
+	if err != nil {
+		return err
+	}
+	if shared {
+		return fmt.Errorf("%w: %s@%s", ErrArchiveShared, instance.Package, instance.Id)
+	}
+	return deleteArchive(ctx, repo, instance.Id, instance.Compression)
+}
+
+// Delete the CAS archive along with its signature.
+func deleteArchive(ctx context.Context, repo Repository, id, compression string) error {
+	for _, key := range []string{InstanceSigstoreBundleKey(id), InstanceCASKey(id, compression)} {
+		if err := repo.Delete(ctx, key); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *RegistryImpl) GetPackageInstanceSigstoreBundle(ctx context.Context, instance Instance) (*SigstoreBundle, error) {
+	repo, err := c.packageRepository(ctx, instance.Package)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &SigstoreBundle{}
+	if err = repo.GetJSON(ctx, InstanceSigstoreBundleKey(instance.Id), bundle); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+func (c *RegistryImpl) PutPackageInstanceSigstoreBundle(ctx context.Context, instance Instance, bundle SigstoreBundle) error {
+	if !c.cfg.Write {
+		return fmt.Errorf("%w: %s@%s", ErrRegistryWriteIsNotAllowed, instance.Package, instance.Id)
+	}
+	repo, err := c.packageRepository(ctx, instance.Package)
+	if err != nil {
+		return err
+	}
+	return repo.PutJSON(ctx, InstanceSigstoreBundleKey(instance.Id), bundle)
+}
+
+func (c *RegistryImpl) ListPackageInstanceSignatures(ctx context.Context, instance Instance) ([]DetachedSignature, error) {
+	prefix := filepath.Join(RegistryPackagesPrefix, instance.Package, RegistryPackageInstancesPrefix, instance.Id, RegistryPackageInstanceSignaturesPrefix)
+	entries, err := CollectCursor(ctx, c.rootRepository.List(ctx, prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	var signatures []DetachedSignature
+	for _, entry := range entries {
+		signature, ok := parseDetachedSignatureName(entry.Key)
+		if entry.IsPrefix || !ok {
+			continue
+		}
+		body, err := c.rootRepository.Get(ctx, filepath.Join(prefix, entry.Key))
+		if err != nil {
+			return nil, err
+		}
+		signature.Data, err = io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, signature)
+	}
+	return signatures, nil
+}
+
+// Signature by the same key replaces the previous one.
+func (c *RegistryImpl) PutPackageInstanceSignature(ctx context.Context, instance Instance, signature DetachedSignature) error {
+	prefix := filepath.Join(RegistryPackagesPrefix, instance.Package, RegistryPackageInstancesPrefix, instance.Id, RegistryPackageInstanceSignaturesPrefix)
+	if !c.cfg.Write {
+		return fmt.Errorf("%w: %s@%s", ErrRegistryWriteIsNotAllowed, instance.Package, instance.Id)
+	}
+	if err := c.rootRepository.EnsurePrefix(ctx, prefix); err != nil {
+		return err
+	}
+	return c.rootRepository.Put(ctx, filepath.Join(prefix, signature.fileName()), bytes.NewReader(signature.Data))
+}
+
 func (c *RegistryImpl) PutPackageInstanceInfo(ctx context.Context, instance Instance) error {
 	key := filepath.Join(RegistryPackagesPrefix, instance.Package, RegistryPackageInstancesPrefix, instance.Id, RegistryPackageInstanceManifestKey)
 	prefix := filepath.Dir(key)
@@ -291,33 +713,231 @@ func (c *RegistryImpl) PutPackageInstanceInfo(ctx context.Context, instance Inst
 	).ErrorOrNil()
 }
 
+// File manifest of the instance archive, stored since upload. Instances
+// uploaded before don't have it.
+func (c *RegistryImpl) GetPackageInstanceFiles(ctx context.Context, instance Instance) (files []ArchiveFile, err error) {
+	key := filepath.Join(RegistryPackagesPrefix, instance.Package, RegistryPackageInstancesPrefix, instance.Id, RegistryPackageInstanceFilesKey)
+	err = c.rootRepository.GetJSON(ctx, key, &files)
+	if err != nil {
+		files = nil
+	}
+	return
+}
+
+func (c *RegistryImpl) PutPackageInstanceFiles(ctx context.Context, instance Instance, files []ArchiveFile) error {
+	key := filepath.Join(RegistryPackagesPrefix, instance.Package, RegistryPackageInstancesPrefix, instance.Id, RegistryPackageInstanceFilesKey)
+	if !c.cfg.Write {
+		return fmt.Errorf("%w: %s / %s", ErrRegistryWriteIsNotAllowed, instance.Package, instance.Id)
+	}
+	return c.rootRepository.PutJSON(ctx, key, files)
+}
+
+func (c *RegistryImpl) GetPackageInstanceSBOM(ctx context.Context, instance Instance) (io.ReadCloser, error) {
+	key := filepath.Join(RegistryPackagesPrefix, instance.Package, RegistryPackageInstancesPrefix, instance.Id, RegistryPackageInstanceSBOMKey)
+	return c.rootRepository.Get(ctx, key)
+}
+
+func (c *RegistryImpl) PutPackageInstanceSBOM(ctx context.Context, instance Instance, body io.Reader) error {
+	key := filepath.Join(RegistryPackagesPrefix, instance.Package, RegistryPackageInstancesPrefix, instance.Id, RegistryPackageInstanceSBOMKey)
+	if !c.cfg.Write {
+		return fmt.Errorf("%w: %s / %s", ErrRegistryWriteIsNotAllowed, instance.Package, instance.Id)
+	}
+	if err := c.rootRepository.EnsurePrefix(ctx, filepath.Dir(key)); err != nil {
+		return err
+	}
+	return c.rootRepository.Put(ctx, key, body)
+}
+
+func (c *RegistryImpl) GetPackageInstanceProvenance(ctx context.Context, instance Instance) (*InTotoStatement, error) {
+	key := filepath.Join(RegistryPackagesPrefix, instance.Package, RegistryPackageInstancesPrefix, instance.Id, RegistryPackageInstanceProvenanceKey)
+	statement := &InTotoStatement{}
+	if err := c.rootRepository.GetJSON(ctx, key, statement); err != nil {
+		return nil, err
+	}
+	return statement, nil
+}
+
+func (c *RegistryImpl) PutPackageInstanceProvenance(ctx context.Context, instance Instance, statement InTotoStatement) error {
+	key := filepath.Join(RegistryPackagesPrefix, instance.Package, RegistryPackageInstancesPrefix, instance.Id, RegistryPackageInstanceProvenanceKey)
+	if !c.cfg.Write {
+		return fmt.Errorf("%w: %s / %s", ErrRegistryWriteIsNotAllowed, instance.Package, instance.Id)
+	}
+	if err := c.rootRepository.EnsurePrefix(ctx, filepath.Dir(key)); err != nil {
+		return err
+	}
+	return c.rootRepository.PutJSON(ctx, key, statement)
+}
+
+// Deletes instance metadata along with its tags. The archive stays in CAS,
+// since other packages could share it, and is left for garbage collection.
 func (c *RegistryImpl) DeletePackageInstanceInfo(ctx context.Context, instance Instance) error {
-	key := filepath.Join(RegistryPackagesPrefix, instance.Package, RegistryPackagesPrefix, instance.Id)
+	prefix := filepath.Join(RegistryPackagesPrefix, instance.Package, RegistryPackageInstancesPrefix, instance.Id)
 	if !c.cfg.Admin {
 		return fmt.Errorf("%w: %s / %s", ErrRegistryAdminIsNotAllowed, instance.Package, instance.Id)
 	}
-	return c.rootRepository.Delete(ctx, key)
+
+	var tags []Tag
+	cursor := c.ListPackageInstanceTags(ctx, instance)
+	for {
+		tag, err := cursor.GetNext(ctx)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if tag == nil {
+			break
+		}
+		tags = append(tags, *tag)
+	}
+
+	for _, tag := range tags {
+		key := filepath.Join(RegistryPackagesPrefix, tag.Package, RegistryPackageTagsPrefix, tag.Key, tag.Value, tag.Id)
+		if err := c.rootRepository.Delete(ctx, key); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return c.rootRepository.DeleteAll(ctx, prefix)
+}
+
+// Delete the instance with its tags and refs pointing to it. Its CAS archive
+// is deleted too, unless keepArchive is set or instances of other packages
+// in the same storage share it.
+func (c *RegistryImpl) DeletePackageInstance(ctx context.Context, instance Instance, keepArchive bool) (*InstanceDeletion, error) {
+	if !c.cfg.Admin {
+		return nil, fmt.Errorf("%w: %s / %s", ErrRegistryAdminIsNotAllowed, instance.Package, instance.Id)
+	}
+
+	repo, err := c.packageRepository(ctx, instance.Package)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &InstanceDeletion{
+		Package: instance.Package,
+		Id:      instance.Id,
+		Refs:    []string{},
+	}
+	refs, err := CollectCursor(ctx, c.ListPackageReferences(ctx, instance.Package))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, ref := range refs {
+		if ref.Id != instance.Id {
+			continue
+		}
+		if err = c.DeletePackageReference(ctx, ref); err != nil {
+			return result, err
+		}
+		result.Refs = append(result.Refs, ref.Name)
+	}
+
+	if err = c.DeletePackageInstanceInfo(ctx, instance); err != nil {
+		return result, err
+	}
+	if keepArchive {
+		return result, nil
+	}
+
+	shared, err := c.isArchiveShared(ctx, repo, instance)
+	if err != nil || shared {
+		return result, err
+	}
+	err = deleteArchive(ctx, repo, instance.Id, instance.Compression)
+	result.ArchiveDeleted = err == nil
+	return result, err
 }
 
+// Whether instances of other packages stored in the repo have the same id,
+// or deltas of the package are stored against the instance, directly or
+// through their bases.
+func (c *RegistryImpl) isArchiveShared(ctx context.Context, repo Repository, instance Instance) (bool, error) {
+	// Instances could be deleted concurrently, e.g. by prune.
+	ids, err := c.listInstanceIds(ctx, instance.Package)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range ids {
+		other, err := c.GetPackageInstanceInfo(ctx, instance.Package, id)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if ok, err := isDeltaBase(ctx, repo, *other, instance.Id); err != nil || ok {
+			return ok, err
+		}
+	}
+
+	url := repo.GetConfig().URL
+	shared := false
+	err = c.walkPackageNames(ctx, "", func(name string) error {
+		if shared || name == instance.Package {
+			return nil
+		}
+		otherRepo, err := c.packageRepository(ctx, name)
+		if err != nil {
+			return err
+		}
+		if otherRepo.GetConfig().URL != url {
+			return nil
+		}
+		ids, err := c.listInstanceIds(ctx, name)
+		for _, id := range ids {
+			shared = shared || id == instance.Id
+		}
+		return err
+	})
+	return shared, err
+}
+
+// Tags are stored as tags/<key>/<value>, so it walks over keys and lists
+// values for each of them.
 type registryInstanceTagsCursor struct {
-	cursor   Cursor[Entry]
+	keys     Cursor[Entry]
+	values   Cursor[Entry]
+	key      string
 	instance Instance
 	client   *RegistryImpl
 }
 
-func (c registryInstanceTagsCursor) GetNext(ctx context.Context) (tag *Tag, err error) {
+func (c *registryInstanceTagsCursor) prefix() string {
+	return filepath.Join(RegistryPackagesPrefix, c.instance.Package, RegistryPackageInstancesPrefix, c.instance.Id, RegistryPackageInstanceTagsPrefix)
+}
+
+func (c *registryInstanceTagsCursor) GetNext(ctx context.Context) (tag *Tag, err error) {
 	for {
+		if c.values == nil {
+			var entry *Entry
+			entry, err = c.keys.GetNext(ctx)
+			if err != nil || entry == nil {
+				break
+			}
+			if !entry.IsPrefix {
+				continue
+			}
+
+			c.key = entry.Key
+			c.values = c.client.rootRepository.List(ctx, filepath.Join(c.prefix(), c.key))
+		}
+
 		var entry *Entry
-		entry, err = c.cursor.GetNext(ctx)
-		if err != nil || entry == nil {
+		entry, err = c.values.GetNext(ctx)
+		if err != nil {
 			break
 		}
-
+		if entry == nil {
+			c.values = nil
+			continue
+		}
 		if entry.IsPrefix {
 			continue
 		}
 
-		key := filepath.Join(RegistryPackagesPrefix, c.instance.Package, RegistryPackageInstancesPrefix, c.instance.Id, RegistryPackageInstanceTagsPrefix, entry.Key)
+		key := filepath.Join(c.prefix(), c.key, entry.Key)
 		tag = &Tag{}
 		err = c.client.rootRepository.GetJSON(ctx, key, tag)
 		if err != nil {
@@ -329,12 +949,12 @@ func (c registryInstanceTagsCursor) GetNext(ctx context.Context) (tag *Tag, err
 }
 
 func (c *RegistryImpl) ListPackageInstanceTags(ctx context.Context, instance Instance) Cursor[Tag] {
-	prefix := filepath.Join(RegistryPackagesPrefix, instance.Package, RegistryPackageInstancesPrefix, instance.Id, RegistryPackageInstanceTagsPrefix)
-	return registryInstanceTagsCursor{
-		cursor:   c.rootRepository.List(ctx, prefix),
+	cursor := &registryInstanceTagsCursor{
 		instance: instance,
 		client:   c,
 	}
+	cursor.keys = c.rootRepository.List(ctx, cursor.prefix())
+	return cursor
 }
 
 type registryListRefsCursor struct {
@@ -372,7 +992,7 @@ func (c *RegistryImpl) ListPackageReferences(ctx context.Context, name string) C
 }
 
 func (c *RegistryImpl) GetPackageReference(ctx context.Context, pkg, name string) (ref *Reference, err error) {
-	key := filepath.Join(RegistryPackagesPrefix, name, RegistryPackageReferencesPrefix, name)
+	key := filepath.Join(RegistryPackagesPrefix, pkg, RegistryPackageReferencesPrefix, name)
 	ref = &Reference{}
 	err = c.rootRepository.GetJSON(ctx, key, ref)
 	if err != nil {
@@ -381,11 +1001,26 @@ func (c *RegistryImpl) GetPackageReference(ctx context.Context, pkg, name string
 	return
 }
 
+// Previous value of the ref goes to its history first, so it's never lost.
+// Setting the ref to the same instance keeps the time it was moved there.
 func (c *RegistryImpl) PutPackageReference(ctx context.Context, ref Reference) error {
 	key := filepath.Join(RegistryPackagesPrefix, ref.Package, RegistryPackageReferencesPrefix, ref.Name)
 	if !c.cfg.Write {
 		return fmt.Errorf("%w: %s / %s", ErrRegistryWriteIsNotAllowed, ref.Package, ref.Name)
 	}
+
+	prev, err := c.GetPackageReference(ctx, ref.Package, ref.Name)
+	switch {
+	case err == nil && prev.Id == ref.Id:
+		ref.UpdatedAt = prev.UpdatedAt
+	case err == nil:
+		change := ReferenceHistoryEntry{Id: prev.Id, UpdatedAt: prev.UpdatedAt, ReplacedAt: &ref.UpdatedAt, ReplacedBy: ref.Id}
+		if err = c.appendReferenceHistory(ctx, ref, change); err != nil {
+			return err
+		}
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return err
+	}
 	return c.rootRepository.PutJSON(ctx, key, ref)
 }
 
@@ -394,9 +1029,61 @@ func (c *RegistryImpl) DeletePackageReference(ctx context.Context, ref Reference
 	if !c.cfg.Admin {
 		return fmt.Errorf("%w: %s / %s", ErrRegistryAdminIsNotAllowed, ref.Package, ref.Name)
 	}
+	change := ReferenceHistoryEntry{Id: ref.Id, UpdatedAt: ref.UpdatedAt, ReplacedAt: &UnixTimestamp{time.Now()}}
+	if err := c.appendReferenceHistory(ctx, ref, change); err != nil {
+		return err
+	}
 	return c.rootRepository.Delete(ctx, key)
 }
 
+func (c *RegistryImpl) GetPackageReferenceHistory(ctx context.Context, pkg, name string) (*ReferenceHistory, error) {
+	key := filepath.Join(RegistryPackagesPrefix, pkg, RegistryPackageRefHistoryPrefix, name)
+	history := &ReferenceHistory{}
+	if err := c.rootRepository.GetJSON(ctx, key, history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func (c *RegistryImpl) PutPackageReferenceHistory(ctx context.Context, history ReferenceHistory) error {
+	key := filepath.Join(RegistryPackagesPrefix, history.Package, RegistryPackageRefHistoryPrefix, history.Name)
+	if !c.cfg.Write {
+		return fmt.Errorf("%w: %s / %s", ErrRegistryWriteIsNotAllowed, history.Package, history.Name)
+	}
+	if err := c.rootRepository.EnsurePrefix(ctx, filepath.Dir(key)); err != nil {
+		return err
+	}
+	return c.rootRepository.PutJSON(ctx, key, history)
+}
+
+// The history is retried if it's changed concurrently, like the ACL.
+func (c *RegistryImpl) appendReferenceHistory(ctx context.Context, ref Reference, change ReferenceHistoryEntry) error {
+	key := filepath.Join(RegistryPackagesPrefix, ref.Package, RegistryPackageRefHistoryPrefix, ref.Name)
+	if err := c.rootRepository.EnsurePrefix(ctx, filepath.Dir(key)); err != nil {
+		return err
+	}
+
+	var err error
+	for attempt := 0; attempt < referenceHistoryUpdateAttempts; attempt++ {
+		history := &ReferenceHistory{}
+		var etag string
+		etag, err = c.rootRepository.GetJSONWithETag(ctx, key, history)
+		if errors.Is(err, os.ErrNotExist) {
+			history, etag, err = &ReferenceHistory{Package: ref.Package, Name: ref.Name}, "", nil
+		}
+		if err != nil {
+			return err
+		}
+
+		history.append(change)
+		err = c.rootRepository.PutJSONIf(ctx, key, history, etag)
+		if !errors.Is(err, ErrConditionFailed) {
+			return err
+		}
+	}
+	return err
+}
+
 type registryListTagsCursor struct {
 	cursor Cursor[Entry]
 	pkg    string
@@ -484,7 +1171,7 @@ func (c registryListPackageInstancesByTagCursor) GetNext(ctx context.Context) (t
 			continue
 		}
 
-		key := filepath.Join(RegistryPackagesPrefix, c.tag.Package, c.tag.Key, c.tag.Value, entry.Key)
+		key := filepath.Join(RegistryPackagesPrefix, c.tag.Package, RegistryPackageTagsPrefix, c.tag.Key, c.tag.Value, entry.Key)
 
 		tag = &Tag{}
 		err = c.client.rootRepository.GetJSON(ctx, key, tag)
@@ -537,7 +1224,7 @@ func (c *RegistryImpl) DeletePackageInstanceTag(ctx context.Context, tag Tag) er
 	}
 
 	key1 := filepath.Join(RegistryPackagesPrefix, tag.Package, RegistryPackageTagsPrefix, tag.Key, tag.Value, tag.Id)
-	key2 := filepath.Join(RegistryPackagesPrefix, tag.Package, RegistryPackageInstancesPrefix, tag.Id, RegistryPackageInstanceTagsPrefix, tag.Key)
+	key2 := filepath.Join(RegistryPackagesPrefix, tag.Package, RegistryPackageInstancesPrefix, tag.Id, RegistryPackageInstanceTagsPrefix, tag.Key, tag.Value)
 
 	return multierror.Append(
 		c.rootRepository.Delete(ctx, key1),
@@ -545,12 +1232,179 @@ func (c *RegistryImpl) DeletePackageInstanceTag(ctx context.Context, tag Tag) er
 	).ErrorOrNil()
 }
 
+// Find the instance by version, which is either an instance id, ref:name,
+// tag:key=value attached to exactly one instance, or shorter name and
+// key:value forms of them. Versions of moved packages are resolved in the
+// package they were moved to. Yanked instances and instances of deprecated
+// packages are only resolved by id, unless the config allows them.
+func (c *RegistryImpl) ResolveVersion(ctx context.Context, pkg, version string) (*Instance, error) {
+	instance, err := c.resolveVersion(ctx, pkg, version)
+	for moves := 0; errors.Is(err, ErrVersionNotFound) && moves < MaxPackageMoves; moves++ {
+		manifest, getErr := c.GetPackage(ctx, pkg)
+		if getErr != nil || manifest.MovedTo == "" {
+			break
+		}
+		pkg = manifest.MovedTo
+		instance, err = c.resolveVersion(ctx, pkg, version)
+	}
+	if err != nil || c.cfg.AllowYanked || IsValidInstanceId(version) {
+		return instance, err
+	}
+
+	if instance.Yanked != nil {
+		return nil, fmt.Errorf("%w: %s@%s: %s", ErrInstanceYanked, instance.Package, instance.Id, instance.Yanked)
+	}
+	manifest, err := c.GetPackage(ctx, instance.Package)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Deprecated != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrPackageDeprecated, instance.Package, manifest.Deprecated)
+	}
+	return instance, nil
+}
+
+func (c *RegistryImpl) resolveVersion(ctx context.Context, pkg, version string) (*Instance, error) {
+	var id string
+	var err error
+	switch key, value, isTag := strings.Cut(version, ":"); {
+	case IsValidInstanceId(version):
+		id = version
+	case IsSemVerQuery(version):
+		id, err = c.resolveSemVer(ctx, pkg, strings.TrimPrefix(version, VersionTagKey+":"))
+		if errors.Is(err, ErrInvalidSemVerRange) && key == VersionTagKey {
+			// Version tags set before they were validated.
+			id, err = c.resolveTag(ctx, pkg, key, value)
+		}
+	case key == VersionRefPrefix:
+		id, err = c.resolveRef(ctx, pkg, value)
+	case key == VersionTagPrefix:
+		key, value, isTag = strings.Cut(value, "=")
+		if isTag {
+			id, err = c.resolveTag(ctx, pkg, key, value)
+		}
+	case isTag:
+		id, err = c.resolveTag(ctx, pkg, key, value)
+	default:
+		id, err = c.resolveRef(ctx, pkg, version)
+		if err == nil && id == "" && version == DefaultVersion {
+			// Packages without the ref resolve to the newest version tag.
+			id, err = c.resolveSemVer(ctx, pkg, version)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if id == "" {
+		return nil, fmt.Errorf("%w: %s@%s", ErrVersionNotFound, pkg, version)
+	}
+
+	instance, err := c.GetPackageInstanceInfo(ctx, pkg, id)
+	if errors.Is(err, os.ErrNotExist) {
+		err = fmt.Errorf("%w: %s@%s", ErrVersionNotFound, pkg, version)
+	}
+	return instance, err
+}
+
+func (c *RegistryImpl) resolveRef(ctx context.Context, pkg, name string) (string, error) {
+	if !IsValidRefName(name) {
+		return "", nil
+	}
+
+	ref, err := c.GetPackageReference(ctx, pkg, name)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return ref.Id, nil
+}
+
+// Newest instance with the version tag in the range, skipping yanked ones
+// unless they are allowed.
+func (c *RegistryImpl) resolveSemVer(ctx context.Context, pkg, query string) (string, error) {
+	r, err := ParseSemVerRange(query)
+	if err != nil {
+		return "", err
+	}
+	versions, err := FindSemVers(ctx, c, pkg, r)
+	if err != nil {
+		return "", err
+	}
+
+	for _, v := range versions {
+		id, err := c.resolveTag(ctx, pkg, VersionTagKey, v.String())
+		if err != nil || id == "" {
+			return id, err
+		}
+		if c.cfg.AllowYanked {
+			return id, nil
+		}
+		instance, err := c.GetPackageInstanceInfo(ctx, pkg, id)
+		if err != nil {
+			return "", err
+		}
+		if instance.Yanked == nil {
+			return id, nil
+		}
+	}
+	return "", nil
+}
+
+func (c *RegistryImpl) resolveTag(ctx context.Context, pkg, key, value string) (id string, err error) {
+	if !IsValidTagName(key) || !IsValidTagValue(value) {
+		return "", nil
+	}
+
+	cursor := c.ListPackageInstancesByTag(ctx, PackageTagValue{
+		PackageTag: PackageTag{Package: pkg, Key: key},
+		Value:      value,
+	})
+	for {
+		tag, err := cursor.GetNext(ctx)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if tag == nil {
+			break
+		}
+		if id != "" {
+			return "", fmt.Errorf("%w: %s@%s:%s", ErrAmbiguousVersion, pkg, key, value)
+		}
+		id = tag.Id
+	}
+	return id, nil
+}
+
 var _ Registry = (*RegistryImpl)(nil)
 
 func NewRegistry(ctx context.Context, cfg RegistryConfig) (Registry, error) {
 	if cfg.RootRepo.URL == "" {
 		cfg.RootRepo.URL = cfg.URL
 	}
+	if cfg.RootRepo.Cache == nil {
+		cfg.RootRepo.Cache = cfg.Cache
+	}
+	if cfg.RootRepo.Headers == nil {
+		cfg.RootRepo.Headers = cfg.Headers
+	}
+	if cfg.RootRepo.DryRun == nil {
+		cfg.RootRepo.DryRun = cfg.DryRun
+	}
+	if cfg.RootRepo.Jobs == 0 {
+		cfg.RootRepo.Jobs = cfg.Jobs
+	}
+	if cfg.Credential != nil {
+		cfg.RootRepo = cfg.Credential.apply(cfg.RootRepo)
+	}
+	if cfg.Repos == nil {
+		cfg.Repos = map[string]RepositoryConfig{}
+	}
 
 	repository, err := NewRepository(ctx, cfg.RootRepo)
 	if err != nil {
@@ -567,8 +1421,10 @@ func NewRegistry(ctx context.Context, cfg RegistryConfig) (Registry, error) {
 
 	manifest, err := registryClient.GetManifest(ctx)
 	if err != nil {
+		registryClient.Close()
 		return nil, err
 	}
+	registryClient.signingKeys = manifest.SigningKeys
 
 	for key, repoManifest := range manifest.Repos {
 		repoCfg, ok := cfg.Repos[key]
@@ -578,9 +1434,25 @@ func NewRegistry(ctx context.Context, cfg RegistryConfig) (Registry, error) {
 			repoCfg.Write = repoCfg.Admin || cfg.Write || repoCfg.Write
 			cfg.Repos[key] = repoCfg
 		}
+		if repoCfg.Cache == nil {
+			repoCfg.Cache = cfg.Cache
+		}
+		if repoCfg.Headers == nil {
+			repoCfg.Headers = cfg.Headers
+		}
+		if repoCfg.DryRun == nil {
+			repoCfg.DryRun = cfg.DryRun
+		}
+		if repoCfg.Jobs == 0 {
+			repoCfg.Jobs = cfg.Jobs
+		}
+		if cfg.Credential != nil {
+			repoCfg = cfg.Credential.apply(repoCfg)
+		}
 
 		repo, err := NewRepository(ctx, repoCfg)
 		if err != nil {
+			registryClient.Close()
 			return nil, err
 		}
 		registryClient.repositories[key] = repo
@@ -0,0 +1,218 @@
+package shop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// Registry in a MemFS of its own, with the package test/pkg.
+func newTestRegistry(t *testing.T) *RegistryImpl {
+	t.Helper()
+	ctx := context.Background()
+	cfg := RepositoryConfig{
+		URL:   "mem://" + strings.NewReplacer("/", "-", "_", "-").Replace(t.Name()),
+		Admin: true,
+		Write: true,
+	}
+	repo, err := NewRepository(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := RepositoryManifest{ApiVersion: LatestVersion, URL: cfg.URL, Name: "root"}
+	err = multierror.Append(
+		repo.PutManifest(ctx, manifest),
+		repo.PutJSON(ctx, RegistryManifestKey, RegistryManifest{ApiVersion: LatestVersion, Name: "test", RootRepo: manifest}),
+	).ErrorOrNil()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := NewRegistry(ctx, RegistryConfig{URL: cfg.URL, RootRepo: cfg, Admin: true, Write: true})
+	if err == nil {
+		err = registry.Initialize(ctx, "test")
+	}
+	if err == nil {
+		err = registry.PutPackage(ctx, Package{Name: "test/pkg"})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry.(*RegistryImpl)
+}
+
+// Upload the tree as an instance of test/pkg, against the base if it's set.
+func uploadTestInstance(t *testing.T, registry *RegistryImpl, tree testTree, compression string, base *Instance) (*Instance, error) {
+	t.Helper()
+	ctx := context.Background()
+	var archive bytes.Buffer
+	id, err := MakeArchive(&archive, DirFS(tree.write(t)), ArchiveOptions{Compression: compression})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := UploadOptions{Compression: compression}
+	if base != nil {
+		opts.DeltaBase = base.Id
+	}
+	instance, err := registry.UploadPackageInstance(ctx, "test/pkg", id, &archive, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = registry.PutPackageInstanceInfo(ctx, *instance); err != nil {
+		t.Fatal(err)
+	}
+	return instance, nil
+}
+
+func TestUploadDeltaBase(t *testing.T) {
+	registry := newTestRegistry(t)
+	full, err := uploadTestInstance(t, registry, testTree{"a": "a"}, CompressionFiles, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta, err := uploadTestInstance(t, registry, testTree{"a": "a", "b": "b"}, CompressionDelta, full)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = uploadTestInstance(t, registry, testTree{"c": "c"}, CompressionDelta, delta)
+	if !errors.Is(err, ErrInvalidDeltaBase) {
+		t.Errorf("got error %v for a delta against a delta, want %v", err, ErrInvalidDeltaBase)
+	}
+}
+
+func TestNewRepositoryFactoryError(t *testing.T) {
+	errFactory := errors.New("factory failed")
+	MustRegisterBackend(Backend{
+		Scheme: "failing-test",
+		Factory: func(ctx context.Context, cfg RepositoryConfig) (RepositoryFS, error) {
+			return nil, errFactory
+		},
+	})
+	_, err := NewRepository(context.Background(), RepositoryConfig{URL: "failing-test://host"})
+	if !errors.Is(err, errFactory) {
+		t.Errorf("got error %v, want %v", err, errFactory)
+	}
+}
+
+func TestRegistryMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(ctx context.Context, registry *RegistryImpl, instance Instance) error
+		err  error
+	}{
+		{
+			name: "package",
+			call: func(ctx context.Context, registry *RegistryImpl, instance Instance) error {
+				pkg, err := registry.GetPackage(ctx, "test/pkg")
+				if err == nil && pkg.Name != "test/pkg" {
+					err = fmt.Errorf("got package %s", pkg.Name)
+				}
+				return err
+			},
+		},
+		{
+			name: "instance info",
+			call: func(ctx context.Context, registry *RegistryImpl, instance Instance) error {
+				info, err := registry.GetPackageInstanceInfo(ctx, "test/pkg", instance.Id)
+				if err == nil && (info.Id != instance.Id || info.Compression != instance.Compression) {
+					err = fmt.Errorf("got instance %s (%s)", info.Id, info.Compression)
+				}
+				return err
+			},
+		},
+		{
+			name: "missing instance info",
+			call: func(ctx context.Context, registry *RegistryImpl, instance Instance) error {
+				_, err := registry.GetPackageInstanceInfo(ctx, "test/pkg", "sha256-"+strings.Repeat("0", 64))
+				return err
+			},
+			err: os.ErrNotExist,
+		},
+		{
+			name: "missing package",
+			call: func(ctx context.Context, registry *RegistryImpl, instance Instance) error {
+				_, err := registry.GetPackage(ctx, "test/missing")
+				return err
+			},
+			err: os.ErrNotExist,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := newTestRegistry(t)
+			instance, err := uploadTestInstance(t, registry, testTree{"a": "a"}, CompressionGzip, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = test.call(context.Background(), registry, *instance); !errors.Is(err, test.err) {
+				t.Errorf("got error %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestDeletePackage(t *testing.T) {
+	tests := []struct {
+		name         string
+		keepArchives bool
+		// Identical instance is uploaded to another package.
+		shared bool
+		kept   bool
+	}{
+		{name: "archives deleted"},
+		{name: "archives kept", keepArchives: true, kept: true},
+		{name: "shared archive kept", shared: true, kept: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			registry := newTestRegistry(t)
+			instance, err := uploadTestInstance(t, registry, testTree{"a": "a"}, CompressionGzip, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo, err := registry.packageRepository(ctx, "test/pkg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.shared {
+				other := *instance
+				other.Package = "test/other"
+				err = registry.PutPackage(ctx, Package{Name: other.Package})
+				if err == nil {
+					err = registry.PutPackageInstanceInfo(ctx, other)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = registry.DeletePackageInstanceArchive(ctx, *instance); !errors.Is(err, ErrArchiveShared) {
+					t.Errorf("got error %v deleting the shared archive, want %v", err, ErrArchiveShared)
+				}
+			}
+
+			if err = registry.DeletePackage(ctx, "test/pkg", test.keepArchives); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = registry.GetPackage(ctx, "test/pkg"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("got error %v for the deleted package, want %v", err, os.ErrNotExist)
+			}
+			kept, err := repo.ResourceExists(ctx, instance.CASKey())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept != test.kept {
+				t.Errorf("archive kept: %v, want %v", kept, test.kept)
+			}
+		})
+	}
+}
@@ -1,12 +1,14 @@
 package shop
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/url"
+	"os"
+	"path"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
@@ -17,7 +19,6 @@ const (
 )
 
 var (
-	RepositoryFactories      = map[string]func(context.Context, RepositoryConfig) (RepositoryFS, error){}
 	ErrRepoWriteIsNotAllowed = errors.New("Write to the repository is not enabled in configuration")
 	ErrRepoAdminIsNotAllowed = errors.New("Admin action on the repository is not enabled in configuration")
 )
@@ -27,6 +28,16 @@ type Entry struct {
 	IsPrefix bool
 }
 
+// Object metadata returned by Stat. Prefixes are not objects and are
+// reported as not existing.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	// Opaque version of the object, changes whenever its content changes.
+	ETag string
+}
+
 type RepositoryManifest struct {
 	ApiVersion  string        `json:"api_version"`
 	URL         string        `json:"url"`
@@ -39,20 +50,43 @@ type Repository interface {
 	GetConfig() RepositoryConfig
 
 	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Read length bytes starting at offset, or everything after offset if
+	// length is negative.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
 	Put(ctx context.Context, key string, body io.Reader) error
 
 	GetJSON(ctx context.Context, key string, output any) error
 	PutJSON(ctx context.Context, key string, input any) error
 
+	// Read object along with its ETag, to update it with PutJSONIf later.
+	GetJSONWithETag(ctx context.Context, key string, output any) (string, error)
+	// Write object only if it was not changed since it was read with the
+	// etag, or does not exist if etag is empty. Fails with
+	// ErrConditionFailed otherwise.
+	PutJSONIf(ctx context.Context, key string, input any, etag string) error
+
 	List(ctx context.Context, prefix string) Cursor[Entry]
 
 	EnsurePrefix(ctx context.Context, key string) error
 	Delete(ctx context.Context, key string) error
+	// Delete prefix with everything under it. Missing prefix is not an
+	// error.
+	DeleteAll(ctx context.Context, prefix string) error
+	// Copy object inside of the repository, preferably without downloading
+	// it. Fails if dst already exists.
+	Copy(ctx context.Context, src, dst string) error
 
 	GetManifest(ctx context.Context) (RepositoryManifest, error)
 	PutManifest(ctx context.Context, manifest RepositoryManifest) error
 
 	ResourceExists(ctx context.Context, key string) (bool, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+
+	// Time-limited link to download the key without repository credentials.
+	GetURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+
+	// Close connections to the backend.
+	Close() error
 }
 
 type RepositoryFS interface {
@@ -64,6 +98,62 @@ type RepositoryFS interface {
 	ListDir(context.Context, string) Cursor[Entry]
 	Remove(context.Context, string) error
 	Exists(context.Context, string) (bool, error)
+	Stat(context.Context, string) (ObjectInfo, error)
+}
+
+// Writers returned by RepositoryFS.Create could implement Aborter to discard
+// partially written data instead of committing it on Close.
+type Aborter interface {
+	Abort() error
+}
+
+// Implemented by RepositoryFS wrappers, so optional capabilities of the
+// wrapped backend could be found.
+type UnwrapFS interface {
+	Unwrap() RepositoryFS
+}
+
+// Calls of the capability found under middleware go through the middleware.
+func findCapability[T any](fs RepositoryFS) (capability T, ok bool) {
+	var chain middlewareChain
+	for fs != nil {
+		if capability, ok = fs.(T); ok {
+			if len(chain) > 0 {
+				capability = wrapCapability(capability, chain)
+			}
+			return
+		}
+		if m, isMiddleware := fs.(capabilityMiddleware); isMiddleware {
+			chain = append(chain, m)
+		}
+		if backend, isBackend := fs.(backendFS); isBackend && !backend.capabilities.allows((*T)(nil)) {
+			return
+		}
+		if wrapper, isWrapper := fs.(UnwrapFS); isWrapper {
+			fs = wrapper.Unwrap()
+		} else {
+			fs = nil
+		}
+	}
+	return
+}
+
+// Optional RepositoryFS capability for deleting whole prefixes at once.
+// Missing prefix is not an error.
+type RemoveAllFS interface {
+	RemoveAll(ctx context.Context, prefix string) error
+}
+
+// Optional RepositoryFS capability for copying objects on the server side.
+// Fails with os.ErrExist if dst exists. Could fail with ErrUnimplemented for
+// objects it can't copy, they are copied through the client then.
+type CopyFS interface {
+	Copy(ctx context.Context, src, dst string) error
+}
+
+// Optional RepositoryFS capability for generating download links.
+type URLSignerFS interface {
+	SignURL(ctx context.Context, key string, ttl time.Duration) (string, error)
 }
 
 type repositoryImpl struct {
@@ -71,17 +161,54 @@ type repositoryImpl struct {
 	fs  RepositoryFS
 }
 
-func NewRepository(ctx context.Context, cfg RepositoryConfig) (repository Repository, err error) {
+// Backend for a single url with the common middleware applied.
+func newRepositoryFS(ctx context.Context, cfg RepositoryConfig) (fs RepositoryFS, err error) {
 	url, err := url.Parse(cfg.URL)
 	if err != nil {
 		return
 	}
 
-	var fs RepositoryFS
-	if factory, ok := RepositoryFactories[url.Scheme]; ok {
-		fs, err = factory(ctx, cfg)
-	} else {
+	backend, ok := LookupBackend(url.Scheme)
+	if !ok {
 		err = fmt.Errorf("Unknown url schema: %s", url.Scheme)
+		return
+	}
+	fs, err = backend.Factory(ctx, cfg)
+	if err != nil {
+		return
+	}
+
+	fs = backendFS{fs, backend.Capabilities}
+	fs = NewMetricsFS(fs, cfg)
+	fs = NewLoggingFS(fs, cfg)
+	// Rate limiter is inside of retries, so every attempt takes a token.
+	if cfg.RateLimit > 0 {
+		fs = NewRateLimitFS(fs, cfg)
+	}
+	if cfg.RetryMaxAttempts != 1 {
+		fs = NewRetryFS(fs, cfg)
+	}
+	return
+}
+
+func NewRepository(ctx context.Context, cfg RepositoryConfig) (repository Repository, err error) {
+	fs, err := newRepositoryFS(ctx, cfg)
+	if err != nil {
+		return
+	}
+
+	if len(cfg.Mirrors) > 0 {
+		fs, err = NewMirrorFS(ctx, fs, cfg)
+		if err != nil {
+			return
+		}
+	}
+
+	if cfg.Cache != nil && cfg.Cache.Dir != "" {
+		fs = NewCacheFS(fs, cfg)
+	}
+	if cfg.DryRun != nil {
+		fs = NewDryRunFS(fs, cfg)
 	}
 
 	return repositoryImpl{
@@ -94,41 +221,104 @@ func (r repositoryImpl) GetConfig() RepositoryConfig {
 	return r.cfg
 }
 
+// Failed operations carry the object key for diagnostics.
+func (r repositoryImpl) wrapError(op, key string, err error) error {
+	var repoErr *RepositoryError
+	if err == nil || errors.As(err, &repoErr) {
+		return err
+	}
+	rawURL := r.cfg.URL
+	if u, parseErr := url.Parse(rawURL); parseErr == nil {
+		rawURL = u.Redacted()
+	}
+	return &RepositoryError{Repo: rawURL, Op: op, Key: key, Err: err}
+}
+
 func (r repositoryImpl) Get(ctx context.Context, key string) (io.ReadCloser, error) {
-	return r.fs.Open(ctx, key)
+	body, err := r.fs.Open(ctx, key)
+	return body, r.wrapError("get", key, err)
+}
+
+func (r repositoryImpl) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	body, err := openRange(ctx, r.fs, key, offset, length)
+	return body, r.wrapError("get", key, err)
+}
+
+// Whether GetRange reads only the range, and archives are not read through
+// the cache or mirrors, so they could be downloaded in parallel ranges.
+func (r repositoryImpl) parallelRanges() bool {
+	if r.cfg.Cache != nil && r.cfg.Cache.Dir != "" || len(r.cfg.Mirrors) > 0 {
+		return false
+	}
+	_, ok := findCapability[RangeReaderFS](r.fs)
+	return ok
 }
 
 func (r repositoryImpl) Put(ctx context.Context, key string, body io.Reader) (err error) {
+	defer func() { err = r.wrapError("put", key, err) }()
+	if !r.cfg.Write {
+		return fmt.Errorf("%w: %s / %s", ErrRepoWriteIsNotAllowed, r.cfg.URL, key)
+	}
+
+	if fs, ok := findCapability[MultipartFS](r.fs); ok {
+		head, done, err := r.putMultipart(ctx, fs, key, body)
+		if done || err != nil {
+			return err
+		}
+		body = bytes.NewReader(head)
+	}
+
 	w, err := r.fs.Create(ctx, key)
 	if err != nil {
 		return
 	}
 
-	defer func() {
-		err = multierror.Append(err, w.Close()).ErrorOrNil()
-	}()
-
 	_, err = io.Copy(w, body)
-	return
+	if aborter, ok := w.(Aborter); ok && err != nil {
+		return multierror.Append(err, aborter.Abort()).ErrorOrNil()
+	}
+
+	return multierror.Append(err, w.Close()).ErrorOrNil()
 }
 
 func (r repositoryImpl) GetJSON(ctx context.Context, key string, output any) error {
 	data, err := r.fs.Read(ctx, key)
 	if err == nil {
-		err = json.Unmarshal(data, output)
+		err = decodeMetadata(data, output)
 	}
-	return err
+	return r.wrapError("get", key, err)
 }
 
 func (r repositoryImpl) PutJSON(ctx context.Context, key string, input any) error {
 	if !r.cfg.Write {
 		return fmt.Errorf("%w: %s / %s", ErrRepoWriteIsNotAllowed, r.cfg.URL, key)
 	}
-	data, err := json.Marshal(input)
+	data, err := encodeMetadata(input, r.cfg.CompressMetadata)
+	if err != nil {
+		return err
+	}
+	return r.wrapError("put", key, r.fs.Write(ctx, key, data))
+}
+
+func (r repositoryImpl) GetJSONWithETag(ctx context.Context, key string, output any) (string, error) {
+	// Stat goes first, so the etag could only be older than the data, which
+	// makes the following PutJSONIf fail rather than lose an update.
+	info, err := r.fs.Stat(ctx, key)
+	if err != nil {
+		return "", r.wrapError("stat", key, err)
+	}
+	return info.ETag, r.GetJSON(ctx, key, output)
+}
+
+func (r repositoryImpl) PutJSONIf(ctx context.Context, key string, input any, etag string) error {
+	if !r.cfg.Write {
+		return fmt.Errorf("%w: %s / %s", ErrRepoWriteIsNotAllowed, r.cfg.URL, key)
+	}
+	data, err := encodeMetadata(input, r.cfg.CompressMetadata)
 	if err != nil {
 		return err
 	}
-	return r.fs.Write(ctx, key, data)
+	return r.wrapError("put", key, writeIf(ctx, r.fs, key, data, etag))
 }
 
 func (r repositoryImpl) List(ctx context.Context, prefix string) Cursor[Entry] {
@@ -146,7 +336,70 @@ func (r repositoryImpl) Delete(ctx context.Context, key string) error {
 	if !r.cfg.Write {
 		return fmt.Errorf("%w: %s / %s", ErrRepoAdminIsNotAllowed, r.cfg.URL, key)
 	}
-	return r.fs.Remove(ctx, key)
+	return r.wrapError("delete", key, r.fs.Remove(ctx, key))
+}
+
+func (r repositoryImpl) DeleteAll(ctx context.Context, prefix string) error {
+	if !r.cfg.Write {
+		return fmt.Errorf("%w: %s / %s", ErrRepoAdminIsNotAllowed, r.cfg.URL, prefix)
+	}
+	return r.wrapError("delete", prefix, removeAll(ctx, r.fs, prefix))
+}
+
+func removeAll(ctx context.Context, fs RepositoryFS, prefix string) error {
+	if fs, ok := findCapability[RemoveAllFS](fs); ok {
+		return fs.RemoveAll(ctx, prefix)
+	}
+
+	cursor := fs.ListDir(ctx, prefix)
+	for {
+		entry, err := cursor.GetNext(ctx)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			break
+		}
+
+		key := path.Join(prefix, entry.Key)
+		if entry.IsPrefix {
+			err = removeAll(ctx, fs, key)
+		} else {
+			err = fs.Remove(ctx, key)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	// Object stores have no directories to remove.
+	if err := fs.Remove(ctx, prefix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (r repositoryImpl) Copy(ctx context.Context, src, dst string) error {
+	if !r.cfg.Write {
+		return fmt.Errorf("%w: %s / %s", ErrRepoWriteIsNotAllowed, r.cfg.URL, dst)
+	}
+
+	if fs, ok := findCapability[CopyFS](r.fs); ok {
+		err := fs.Copy(ctx, src, dst)
+		if !errors.Is(err, ErrUnimplemented) {
+			return r.wrapError("copy", dst, err)
+		}
+	}
+
+	body, err := r.fs.Open(ctx, src)
+	if err != nil {
+		return r.wrapError("get", src, err)
+	}
+	defer body.Close()
+	return r.Put(ctx, dst, body)
 }
 
 func (r repositoryImpl) GetManifest(ctx context.Context) (manifest RepositoryManifest, err error) {
@@ -163,5 +416,30 @@ func (r repositoryImpl) PutManifest(ctx context.Context, manifest RepositoryMani
 }
 
 func (r repositoryImpl) ResourceExists(ctx context.Context, key string) (bool, error) {
-	return r.fs.Exists(ctx, key)
+	ok, err := r.fs.Exists(ctx, key)
+	return ok, r.wrapError("stat", key, err)
+}
+
+func (r repositoryImpl) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := r.fs.Stat(ctx, key)
+	return info, r.wrapError("stat", key, err)
+}
+
+func (r repositoryImpl) GetURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if signer, ok := findCapability[URLSignerFS](r.fs); ok {
+		return signer.SignURL(ctx, key, ttl)
+	}
+	return "", fmt.Errorf("%w: download links for %s", ErrUnimplemented, r.cfg.URL)
+}
+
+func (r repositoryImpl) Close() error {
+	return closeRepositoryFS(r.fs)
+}
+
+// Backends holding connections implement io.Closer.
+func closeRepositoryFS(fs RepositoryFS) error {
+	if closer, ok := findCapability[io.Closer](fs); ok {
+		return closer.Close()
+	}
+	return nil
 }
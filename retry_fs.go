@@ -0,0 +1,193 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultRetryMaxAttempts = 3
+	DefaultRetryBaseDelay   = 200 * time.Millisecond
+	retryMaxDelay           = 10 * time.Second
+)
+
+// Errors could implement it to tell whether the failed operation is worth
+// retrying.
+type transientError interface {
+	Transient() bool
+}
+
+// Reports whether err is caused by a temporary condition, like server
+// overload, throttling or a dropped connection.
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var transient transientError
+	if errors.As(err, &transient) {
+		return transient.Transient()
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// RepositoryFS wrapper which retries operations failed with transient errors
+// with exponential backoff and jitter. Streams returned by Open and Create are
+// not retried once they are handed out.
+type RetryFS struct {
+	fs        RepositoryFS
+	attempts  int
+	baseDelay time.Duration
+}
+
+func NewRetryFS(fs RepositoryFS, cfg RepositoryConfig) RepositoryFS {
+	f := RetryFS{
+		fs:        fs,
+		attempts:  cfg.RetryMaxAttempts,
+		baseDelay: cfg.RetryBaseDelay.Duration,
+	}
+	if f.attempts == 0 {
+		f.attempts = DefaultRetryMaxAttempts
+	}
+	if f.baseDelay == 0 {
+		f.baseDelay = DefaultRetryBaseDelay
+	}
+	return f
+}
+
+func (f RetryFS) Unwrap() RepositoryFS {
+	return f.fs
+}
+
+func (f RetryFS) retry(ctx context.Context, op func(attempt int) error) error {
+	delay := f.baseDelay
+	for attempt := 1; ; attempt++ {
+		err := op(attempt)
+		if attempt >= f.attempts || !IsTransientError(err) {
+			return err
+		}
+
+		// Sleep somewhere between half and full delay, so clients failed at
+		// the same time don't retry at the same time.
+		sleep := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return err
+		}
+		delay = min(delay*2, retryMaxDelay)
+	}
+}
+
+func (f RetryFS) Read(ctx context.Context, key string) (data []byte, err error) {
+	err = f.retry(ctx, func(int) (err error) {
+		data, err = f.fs.Read(ctx, key)
+		return
+	})
+	return
+}
+
+func (f RetryFS) Write(ctx context.Context, key string, data []byte) error {
+	return f.retry(ctx, func(int) error {
+		return f.fs.Write(ctx, key, data)
+	})
+}
+
+func (f RetryFS) Open(ctx context.Context, key string) (body io.ReadCloser, err error) {
+	err = f.retry(ctx, func(int) (err error) {
+		body, err = f.fs.Open(ctx, key)
+		return
+	})
+	return
+}
+
+func (f RetryFS) Create(ctx context.Context, key string) (w io.WriteCloser, err error) {
+	err = f.retry(ctx, func(int) (err error) {
+		w, err = f.fs.Create(ctx, key)
+		return
+	})
+	return
+}
+
+func (f RetryFS) MakeDir(ctx context.Context, key string) error {
+	return f.retry(ctx, func(int) error {
+		return f.fs.MakeDir(ctx, key)
+	})
+}
+
+// Listing is restarted only if it fails before returning anything, as
+// cursors could not be rewound.
+type retryCursor struct {
+	fs      RetryFS
+	key     string
+	cursor  Cursor[Entry]
+	started bool
+}
+
+func (c *retryCursor) GetNext(ctx context.Context) (entry *Entry, err error) {
+	if c.started {
+		return c.cursor.GetNext(ctx)
+	}
+
+	err = c.fs.retry(ctx, func(int) (err error) {
+		if c.cursor == nil {
+			c.cursor = c.fs.fs.ListDir(ctx, c.key)
+		}
+		entry, err = c.cursor.GetNext(ctx)
+		if err != nil {
+			c.cursor = nil
+		}
+		return
+	})
+	c.started = err == nil
+	return
+}
+
+func (f RetryFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	return &retryCursor{
+		fs:  f,
+		key: key,
+	}
+}
+
+func (f RetryFS) Remove(ctx context.Context, key string) error {
+	return f.retry(ctx, func(attempt int) error {
+		err := f.fs.Remove(ctx, key)
+		// Previous attempt could have succeeded without us knowing.
+		if attempt > 1 && errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return err
+	})
+}
+
+func (f RetryFS) Exists(ctx context.Context, key string) (ok bool, err error) {
+	err = f.retry(ctx, func(int) (err error) {
+		ok, err = f.fs.Exists(ctx, key)
+		return
+	})
+	return
+}
+
+func (f RetryFS) Stat(ctx context.Context, key string) (info ObjectInfo, err error) {
+	err = f.retry(ctx, func(int) (err error) {
+		info, err = f.fs.Stat(ctx, key)
+		return
+	})
+	return
+}
@@ -0,0 +1,100 @@
+package shop
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	SBOMFormatSPDX      = "spdx"
+	SBOMFormatCycloneDX = "cyclonedx"
+
+	// SBOM is stored under the instance prefix as is, its format is recorded
+	// in the instance manifest.
+	RegistryPackageInstanceSBOMKey = "sbom"
+)
+
+var (
+	ErrInvalidSBOM = errors.New("Not an SPDX or CycloneDX document")
+	ErrNoSBOM      = errors.New("Instance has no SBOM")
+)
+
+// Software bill of materials attached to the instance.
+type SBOM struct {
+	Format    string `json:"format"`
+	MediaType string `json:"media_type"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+}
+
+// Recognize SPDX (JSON or tag-value) and CycloneDX (JSON or XML) documents.
+func DetectSBOM(data []byte) (*SBOM, error) {
+	sbom := &SBOM{Size: int64(len(data))}
+	digest := sha256.Sum256(data)
+	sbom.SHA256 = hex.EncodeToString(digest[:])
+
+	trimmed := bytes.TrimSpace(data)
+	var document struct {
+		SPDXVersion string `json:"spdxVersion"`
+		BOMFormat   string `json:"bomFormat"`
+	}
+	isJSON := json.Unmarshal(trimmed, &document) == nil
+	switch {
+	case isJSON && document.SPDXVersion != "":
+		sbom.Format, sbom.MediaType = SBOMFormatSPDX, "application/spdx+json"
+	case isJSON && document.BOMFormat == "CycloneDX":
+		sbom.Format, sbom.MediaType = SBOMFormatCycloneDX, "application/vnd.cyclonedx+json"
+	case bytes.HasPrefix(trimmed, []byte("SPDXVersion:")):
+		sbom.Format, sbom.MediaType = SBOMFormatSPDX, "text/spdx"
+	case bytes.HasPrefix(trimmed, []byte("<")) && bytes.Contains(trimmed, []byte("http://cyclonedx.org/schema/bom")):
+		sbom.Format, sbom.MediaType = SBOMFormatCycloneDX, "application/vnd.cyclonedx+xml"
+	default:
+		return nil, ErrInvalidSBOM
+	}
+	return sbom, nil
+}
+
+// Store the SBOM of the instance. It's returned to be recorded in the
+// instance manifest, which is put after it.
+func PutSBOM(ctx context.Context, registry Registry, instance Instance, data []byte) (*SBOM, error) {
+	sbom, err := DetectSBOM(data)
+	if err != nil {
+		return nil, err
+	}
+	if err = registry.PutPackageInstanceSBOM(ctx, instance, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return sbom, nil
+}
+
+// Copy the SBOM of the instance into dst. Fails with ErrNoSBOM if the
+// instance has none.
+func GetSBOM(ctx context.Context, registry Registry, instance Instance, dst io.Writer) error {
+	if instance.SBOM == nil {
+		return fmt.Errorf("%w: %s@%s", ErrNoSBOM, instance.Package, instance.Id)
+	}
+	body, err := registry.GetPackageInstanceSBOM(ctx, instance)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s@%s", ErrNoSBOM, instance.Package, instance.Id)
+	}
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(dst, h), body); err != nil {
+		return err
+	}
+	if digest := hex.EncodeToString(h.Sum(nil)); digest != instance.SBOM.SHA256 {
+		return fmt.Errorf("%w: SBOM of %s@%s: got %s", ErrHashMismatch, instance.Package, instance.Id, digest)
+	}
+	return nil
+}
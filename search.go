@@ -0,0 +1,53 @@
+package shop
+
+import (
+	"context"
+	"path"
+	"strings"
+)
+
+func isGlob(query string) bool {
+	return strings.ContainsAny(query, "*?[")
+}
+
+// Glob query is matched against the whole package name, or its last
+// component if the glob has no slashes. Other queries are case-insensitive
+// substrings of the name or the description.
+func MatchPackage(pkg Package, query string) (bool, error) {
+	switch {
+	case isGlob(query) && strings.Contains(query, "/"):
+		return path.Match(query, pkg.Name)
+	case isGlob(query):
+		return path.Match(query, path.Base(pkg.Name))
+	}
+
+	query = strings.ToLower(query)
+	return strings.Contains(strings.ToLower(pkg.Name), query) ||
+		strings.Contains(strings.ToLower(pkg.Description), query), nil
+}
+
+// Find packages matching the query. Only the part of the registry which
+// could match the glob is walked.
+func SearchPackages(ctx context.Context, registry Registry, query string) ([]Package, error) {
+	if _, err := path.Match(query, ""); err != nil {
+		return nil, err
+	}
+
+	prefix := ""
+	if isGlob(query) {
+		literal := query[:strings.IndexAny(query, "*?[")]
+		if i := strings.LastIndex(literal, "/"); i >= 0 {
+			prefix = literal[:i]
+		}
+	}
+
+	var result []Package
+	err := registry.WalkPackages(ctx, prefix, func(pkg Package) error {
+		ok, err := MatchPackage(pkg, query)
+		if ok {
+			result = append(result, pkg)
+		}
+		return err
+	})
+	return result, err
+}
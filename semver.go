@@ -0,0 +1,256 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Tag with the semantic version of the instance, e.g. version:1.4.2.
+	// Values are validated by NewTag, "version:range" resolves to the newest
+	// instance in the range.
+	VersionTagKey = "version"
+)
+
+var (
+	ErrInvalidSemVer      = errors.New("Invalid semantic version")
+	ErrInvalidSemVerRange = errors.New("Invalid semantic version range")
+)
+
+// MAJOR.MINOR.PATCH[-PRERELEASE]. Build metadata is not supported, as "+"
+// is not allowed in tag values.
+type SemVer struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+}
+
+func ParseSemVer(s string) (SemVer, error) {
+	v, parts, err := parsePartialSemVer(s)
+	if err == nil && parts != 3 {
+		err = fmt.Errorf("%w: %s", ErrInvalidSemVer, s)
+	}
+	return v, err
+}
+
+func IsValidSemVer(s string) bool {
+	_, err := ParseSemVer(s)
+	return err == nil
+}
+
+// Version with MINOR and PATCH optional, the number of parts given is
+// returned. Prerelease requires all three.
+func parsePartialSemVer(s string) (SemVer, int, error) {
+	var v SemVer
+	core, prerelease, hasPrerelease := strings.Cut(s, "-")
+	fields := strings.Split(core, ".")
+	if len(fields) > 3 || (hasPrerelease && len(fields) != 3) {
+		return v, 0, fmt.Errorf("%w: %s", ErrInvalidSemVer, s)
+	}
+
+	numbers := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 || field[0] == '+' || (len(field) > 1 && field[0] == '0') {
+			return v, 0, fmt.Errorf("%w: %s", ErrInvalidSemVer, s)
+		}
+		*numbers[i] = n
+	}
+
+	if hasPrerelease {
+		for _, id := range strings.Split(prerelease, ".") {
+			if id == "" || strings.IndexFunc(id, func(r rune) bool {
+				return !(r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r == '-')
+			}) >= 0 {
+				return v, 0, fmt.Errorf("%w: %s", ErrInvalidSemVer, s)
+			}
+		}
+		v.Prerelease = prerelease
+	}
+	return v, len(fields), nil
+}
+
+func (v SemVer) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// -1, 0 or 1 as v is lower, equal or greater than other in semver order.
+func (v SemVer) Compare(other SemVer) int {
+	for _, d := range []int{v.Major - other.Major, v.Minor - other.Minor, v.Patch - other.Patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+
+	switch {
+	case v.Prerelease == other.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case other.Prerelease == "":
+		return -1
+	}
+
+	a, b := strings.Split(v.Prerelease, "."), strings.Split(other.Prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		an, aErr := strconv.Atoi(a[i])
+		bn, bErr := strconv.Atoi(b[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			return sign(an - bn)
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			return strings.Compare(a[i], b[i])
+		}
+	}
+	return sign(len(a) - len(b))
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+type semVerBound struct {
+	op string
+	v  SemVer
+}
+
+// Comma separated constraints which all have to match:
+//
+//	^1.4      >=1.4.0, <2.0.0 (^0.4 is >=0.4.0, <0.5.0)
+//	~1.4.2    >=1.4.2, <1.5.0
+//	1.4, =1.4 >=1.4.0, <1.5.0
+//	>=1.2,<2  comparisons, missing parts are zeros
+//	*, latest any version
+//
+// Prereleases only match if some constraint has a prerelease.
+type SemVerRange struct {
+	bounds     []semVerBound
+	prerelease bool
+}
+
+func ParseSemVerRange(s string) (SemVerRange, error) {
+	var r SemVerRange
+	for _, constraint := range strings.Split(s, ",") {
+		constraint = strings.TrimSpace(constraint)
+		if constraint == "*" || constraint == DefaultVersion {
+			continue
+		}
+
+		op := constraint[:len(constraint)-len(strings.TrimLeft(constraint, "^~<>="))]
+		v, parts, err := parsePartialSemVer(strings.TrimSpace(constraint[len(op):]))
+		if err != nil {
+			return r, fmt.Errorf("%w: %s", ErrInvalidSemVerRange, s)
+		}
+		if v.Prerelease != "" {
+			r.prerelease = true
+		}
+
+		upper := v
+		upper.Prerelease = ""
+		switch {
+		case op == "^" && (v.Major > 0 || parts == 1):
+			upper = SemVer{Major: v.Major + 1}
+		case op == "^" && (v.Minor > 0 || parts == 2):
+			upper = SemVer{Minor: v.Minor + 1}
+		case op == "^":
+			upper = SemVer{Patch: v.Patch + 1}
+		case (op == "~" || op == "=" || op == "") && parts == 1:
+			upper = SemVer{Major: v.Major + 1}
+		case (op == "~" || op == "=" || op == "") && parts == 2, op == "~":
+			upper = SemVer{Major: v.Major, Minor: v.Minor + 1}
+		}
+
+		switch op {
+		case "^", "~":
+			r.bounds = append(r.bounds, semVerBound{">=", v}, semVerBound{"<", upper})
+		case "", "=":
+			if parts == 3 {
+				r.bounds = append(r.bounds, semVerBound{"=", v})
+			} else {
+				r.bounds = append(r.bounds, semVerBound{">=", v}, semVerBound{"<", upper})
+			}
+		case "<", "<=", ">", ">=":
+			r.bounds = append(r.bounds, semVerBound{op, v})
+		default:
+			return r, fmt.Errorf("%w: %s", ErrInvalidSemVerRange, s)
+		}
+	}
+	return r, nil
+}
+
+func (r SemVerRange) Contains(v SemVer) bool {
+	if v.Prerelease != "" && !r.prerelease {
+		return false
+	}
+	for _, bound := range r.bounds {
+		c := v.Compare(bound.v)
+		ok := false
+		switch bound.op {
+		case "=":
+			ok = c == 0
+		case "<":
+			ok = c < 0
+		case "<=":
+			ok = c <= 0
+		case ">":
+			ok = c > 0
+		case ">=":
+			ok = c >= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Version which is resolved as a range of version tags: "version:range",
+// or a range starting with an operator, e.g. "^1.4".
+func IsSemVerQuery(version string) bool {
+	return strings.HasPrefix(version, VersionTagKey+":") || strings.IndexAny(version, "^~<>=") == 0
+}
+
+// Version tag values of the package in the range, newest first. Values which
+// are not valid versions are skipped.
+func FindSemVers(ctx context.Context, registry Registry, pkg string, r SemVerRange) ([]SemVer, error) {
+	values, err := CollectCursor(ctx, registry.ListPackageTagValues(ctx, PackageTag{Package: pkg, Key: VersionTagKey}))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var result []SemVer
+	for _, value := range values {
+		v, err := ParseSemVer(value.Value)
+		if err == nil && r.Contains(v) {
+			result = append(result, v)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Compare(result[j]) > 0
+	})
+	return result, nil
+}
@@ -0,0 +1,214 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+func init() {
+	MustRegisterBackend(Backend{
+		Scheme:       "sftp",
+		Factory:      NewSFTPFS,
+		Capabilities: BackendCapabilities{Range: true, RemoveAll: true},
+	})
+}
+
+type SFTPFS struct {
+	cfg    RepositoryConfig
+	conn   *ssh.Client
+	client *sftp.Client
+	path   string
+}
+
+func NewSFTPFS(ctx context.Context, cfg RepositoryConfig) (RepositoryFS, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := DialSSH(ctx, u, cfg.SSH)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	root := u.Path
+	if root == "" {
+		root = "."
+	}
+
+	return SFTPFS{
+		cfg:    cfg,
+		conn:   conn,
+		client: client,
+		path:   root,
+	}, nil
+}
+
+func (f SFTPFS) Close() error {
+	err := f.client.Close()
+	return multierror.Append(err, f.conn.Close()).ErrorOrNil()
+}
+
+func (f SFTPFS) Read(ctx context.Context, key string) ([]byte, error) {
+	file, err := f.client.Open(path.Join(f.path, key))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+func (f SFTPFS) Write(ctx context.Context, key string, data []byte) error {
+	w, err := f.create(key, false)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return multierror.Append(err, w.Abort()).ErrorOrNil()
+	}
+	return w.Close()
+}
+
+func (f SFTPFS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return f.client.Open(path.Join(f.path, key))
+}
+
+func (f SFTPFS) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	file, err := f.client.Open(path.Join(f.path, key))
+	if err != nil {
+		return nil, err
+	}
+	return seekRange(file, offset, length)
+}
+
+func (f SFTPFS) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	return f.create(key, true)
+}
+
+func (f SFTPFS) create(key string, exclusive bool) (*sftpFSWriter, error) {
+	target := path.Join(f.path, key)
+	tmp, err := remoteTempPath(target)
+	if err != nil {
+		return nil, err
+	}
+	file, err := f.client.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFSWriter{
+		File:      file,
+		client:    f.client,
+		target:    target,
+		exclusive: exclusive,
+	}, nil
+}
+
+// Writes into a temporary file next to the target, which is moved into place
+// on Close, so readers never see partial files.
+type sftpFSWriter struct {
+	*sftp.File
+	client    *sftp.Client
+	target    string
+	exclusive bool
+}
+
+func (w *sftpFSWriter) Close() error {
+	tmp := w.File.Name()
+	err := w.File.Close()
+	if err == nil {
+		err = w.commit(tmp)
+	}
+	// Linked temporary file is removed as well.
+	if err != nil || w.exclusive {
+		w.client.Remove(tmp)
+	}
+	return err
+}
+
+func (w *sftpFSWriter) commit(tmp string) error {
+	if w.exclusive {
+		// Hardlink fails if target exists, which makes it atomic
+		// exclusive create.
+		if err := w.client.Link(tmp, w.target); err != nil {
+			if _, statErr := w.client.Lstat(w.target); statErr == nil {
+				return fmt.Errorf("%s: %w", w.target, os.ErrExist)
+			}
+			return err
+		}
+		return nil
+	}
+	return w.client.PosixRename(tmp, w.target)
+}
+
+func (w *sftpFSWriter) Abort() error {
+	w.File.Close()
+	return w.client.Remove(w.File.Name())
+}
+
+func (f SFTPFS) MakeDir(ctx context.Context, key string) error {
+	return f.client.MkdirAll(path.Join(f.path, key))
+}
+
+func (f SFTPFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	infos, err := f.client.ReadDir(path.Join(f.path, key))
+	if err != nil {
+		return NewErrorCursor[Entry](err)
+	}
+
+	entries := make([]Entry, 0, len(infos))
+	for _, info := range infos {
+		if isRemoteTempName(info.Name()) {
+			continue
+		}
+		entries = append(entries, Entry{
+			Key:      info.Name(),
+			IsPrefix: info.IsDir(),
+		})
+	}
+	return NewSliceCursor(entries)
+}
+
+func (f SFTPFS) Remove(ctx context.Context, key string) error {
+	return f.client.Remove(path.Join(f.path, key))
+}
+
+func (f SFTPFS) RemoveAll(ctx context.Context, key string) error {
+	err := f.client.RemoveAll(path.Join(f.path, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (f SFTPFS) Exists(ctx context.Context, key string) (ok bool, err error) {
+	_, err = f.client.Stat(path.Join(f.path, key))
+	ok = err == nil
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return
+}
+
+func (f SFTPFS) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := f.client.Stat(path.Join(f.path, key))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return fileObjectInfo(key, info)
+}
@@ -0,0 +1,229 @@
+package shop
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// Rules of the signature policy, named in violations.
+	SignaturePolicyRequireSigned = "require_signed"
+	SignaturePolicyIdentities    = "identities"
+	SignaturePolicyKeys          = "keys"
+
+	SignaturePolicyRequireProvenance = "require_provenance"
+	SignaturePolicyBuilders          = "builders"
+	SignaturePolicySourceRepos       = "source_repos"
+)
+
+var (
+	ErrSignaturePolicy = errors.New("Signature policy violation")
+)
+
+// Policy with the longest prefix covering the package, nil if there is none.
+func (c RegistryConfig) SignaturePolicy(pkg string) *SignaturePolicy {
+	var result *SignaturePolicy
+	for i, policy := range c.SignaturePolicies {
+		prefix := strings.Trim(policy.Prefix, "/")
+		if prefix != "" && prefix != pkg && !strings.HasPrefix(pkg, prefix+"/") {
+			continue
+		}
+		if result == nil || len(prefix) > len(strings.Trim(result.Prefix, "/")) {
+			result = &c.SignaturePolicies[i]
+		}
+	}
+	return result
+}
+
+func (p SignaturePolicy) String() string {
+	if prefix := strings.Trim(p.Prefix, "/"); prefix != "" {
+		return prefix
+	}
+	return "/"
+}
+
+func (p SignaturePolicy) violation(rule string, format string, args ...any) error {
+	return fmt.Errorf("%w: rule %s of policy %s: %s", ErrSignaturePolicy, rule, p, fmt.Sprintf(format, args...))
+}
+
+// Policy identities, or ones from the sigstore settings.
+func (p SignaturePolicy) sigstoreConfig(cfg *SigstoreConfig) SigstoreConfig {
+	var result SigstoreConfig
+	if cfg != nil {
+		result = *cfg
+	}
+	if len(p.Identities) > 0 {
+		result.Identities = p.Identities
+	}
+	return result
+}
+
+func (p SignaturePolicy) allowsKey(key SigningKey) bool {
+	return len(p.Keys) == 0 || slices.ContainsFunc(p.Keys, func(id string) bool {
+		return strings.EqualFold(id, key.Id) || strings.EqualFold(id, key.String())
+	})
+}
+
+// Valid signatures of the instance archive, checked against the policy.
+// Signer is set if the sigstore bundle is signed by an allowed identity,
+// UntrustedSigner if it's signed by another one.
+type InstanceSignatures struct {
+	Signer          *Signer
+	UntrustedSigner error
+	Keys            []SigningKey
+}
+
+// Nil if the signatures satisfy the policy at the time, otherwise an
+// ErrSignaturePolicy naming the failed rule.
+func (p SignaturePolicy) Check(instance Instance, signatures InstanceSignatures, now time.Time) error {
+	var allowed, rejected []SigningKey
+	for _, key := range signatures.Keys {
+		if p.allowsKey(key) {
+			allowed = append(allowed, key)
+		} else {
+			rejected = append(rejected, key)
+		}
+	}
+
+	switch {
+	case signatures.UntrustedSigner != nil:
+		return p.violation(SignaturePolicyIdentities, "%s", signatures.UntrustedSigner)
+	case len(rejected) > 0:
+		return p.violation(SignaturePolicyKeys, "signed by %s, which is not allowed", rejected[0])
+	case signatures.Signer != nil || len(allowed) > 0:
+		return nil
+	case !p.RequireSigned:
+		return nil
+	case p.GracePeriod.Duration > 0 && now.Sub(instance.UploadedAt.Time) < p.GracePeriod.Duration:
+		return nil
+	default:
+		return p.violation(SignaturePolicyRequireSigned, "not signed by an allowed signer")
+	}
+}
+
+func (p SignaturePolicy) checksProvenance() bool {
+	return p.RequireProvenance || len(p.Builders) > 0 || len(p.SourceRepos) > 0
+}
+
+// Nil if the provenance of the archive satisfies the policy, otherwise an
+// ErrSignaturePolicy naming the failed rule. Statement is nil if the
+// instance has none.
+func (p SignaturePolicy) CheckProvenance(statement *InTotoStatement) error {
+	switch {
+	case statement == nil && p.RequireProvenance:
+		return p.violation(SignaturePolicyRequireProvenance, "instance has no provenance")
+	case statement == nil:
+		return nil
+	case len(p.Builders) > 0 && !matchesAny(p.Builders, statement.BuilderId()):
+		return p.violation(SignaturePolicyBuilders, "built by %q, which is not allowed", statement.BuilderId())
+	case len(p.SourceRepos) > 0 && !matchesAny(p.SourceRepos, statement.SourceRepo()):
+		return p.violation(SignaturePolicySourceRepos, "built from %q, which is not allowed", statement.SourceRepo())
+	default:
+		return nil
+	}
+}
+
+// Checks made while the archive is downloaded: detached signatures by keys
+// of the registry are verified, and the signature policy of the package is
+// enforced.
+type archiveVerifier struct {
+	instance Instance
+	policy   *SignaturePolicy
+	sigstore SigstoreConfig
+	bundle   *SigstoreBundle
+	// Provenance is only fetched if the policy has rules about it.
+	provenance *InTotoStatement
+	digest     hash.Hash
+	detached   *detachedSignaturesVerifier
+}
+
+func (c *RegistryImpl) newArchiveVerifier(ctx context.Context, repo Repository, instance Instance) (*archiveVerifier, error) {
+	v := &archiveVerifier{
+		instance: instance,
+		policy:   c.cfg.SignaturePolicy(instance.Package),
+	}
+
+	var signatures []DetachedSignature
+	if len(c.signingKeys) > 0 {
+		var err error
+		signatures, err = c.ListPackageInstanceSignatures(ctx, instance)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	detached, err := newDetachedSignaturesVerifier(c.signingKeys, signatures)
+	if err != nil {
+		return nil, err
+	}
+	v.detached = detached
+
+	// Sigstore bundle only counts if the policy allows some identities.
+	if v.policy != nil {
+		v.sigstore = v.policy.sigstoreConfig(c.cfg.Sigstore)
+	}
+	if len(v.sigstore.Identities) > 0 {
+		bundle := &SigstoreBundle{}
+		err = repo.GetJSON(ctx, InstanceSigstoreBundleKey(instance.Id), bundle)
+		switch {
+		case err == nil:
+			v.bundle = bundle
+			v.digest = sha256.New()
+		case !errors.Is(err, os.ErrNotExist):
+			return nil, err
+		}
+	}
+
+	if v.policy != nil && v.policy.checksProvenance() {
+		v.provenance, err = c.GetPackageInstanceProvenance(ctx, instance)
+		switch {
+		case err == nil:
+			v.digest = sha256.New()
+		case !errors.Is(err, os.ErrNotExist):
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func (v *archiveVerifier) Write(p []byte) (int, error) {
+	if v.digest != nil {
+		v.digest.Write(p)
+	}
+	return v.detached.Write(p)
+}
+
+// Invalid signatures fail regardless of the policy.
+func (v *archiveVerifier) Verify() error {
+	keys, err := v.detached.Verify()
+	if err != nil || v.policy == nil {
+		return err
+	}
+
+	signatures := InstanceSignatures{Keys: keys}
+	if v.bundle != nil {
+		signatures.Signer, err = VerifySigstoreBundle(v.sigstore, *v.bundle, v.digest.Sum(nil))
+		if errors.Is(err, ErrUntrustedSigner) {
+			signatures.UntrustedSigner, err = err, nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err = v.policy.Check(v.instance, signatures, time.Now()); err != nil {
+		return err
+	}
+
+	if v.provenance != nil {
+		if err = v.provenance.verifySubject(v.digest.Sum(nil)); err != nil {
+			return err
+		}
+	}
+	return v.policy.CheckProvenance(v.provenance)
+}
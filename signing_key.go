@@ -0,0 +1,253 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Detached signatures made with local keys, for hosts without OIDC.
+	SignatureFormatMinisign = "minisign"
+	SignatureFormatGPG      = "gpg"
+
+	// Detached signatures are stored under the instance prefix, as
+	// signatures/<key id>.minisig or .asc, so they could be checked with
+	// minisign -V or gpg --verify as well.
+	RegistryPackageInstanceSignaturesPrefix = "/signatures/"
+	MinisignSignatureExtension              = ".minisig"
+	GPGSignatureExtension                   = ".asc"
+
+	// Passphrase of the local signing key. It's asked on the terminal if
+	// the variable is not set.
+	SigningKeyPassphraseEnv = "SHOP_SIGNING_KEY_PASSPHRASE"
+)
+
+var (
+	ErrInvalidSigningKey = errors.New("Invalid signing key")
+	ErrSigningKeyExists  = errors.New("Signing key already exists")
+	ErrUnknownSigningKey = errors.New("Unknown signing key")
+)
+
+// Public key trusted to sign instance archives, listed in the registry
+// manifest. PublicKey is the base64 minisign key or the armored OpenPGP key.
+type SigningKey struct {
+	Format    string `json:"format"`
+	Id        string `json:"id"`
+	PublicKey string `json:"public_key"`
+	Comment   string `json:"comment,omitempty"`
+}
+
+func (k SigningKey) String() string {
+	return k.Format + ":" + k.Id
+}
+
+// Minisign public key, with or without the comment line, or an OpenPGP
+// public key, armored or binary.
+func ParseSigningKey(data []byte) (*SigningKey, error) {
+	if isMinisignFile(data) {
+		key, _, err := parseMinisignPublicKey(data)
+		return key, err
+	}
+	key, _, err := parseOpenPGPPublicKey(data)
+	return key, err
+}
+
+// Local key which makes detached signatures.
+type SecretSigningKey interface {
+	Public() SigningKey
+	Sign(archive io.Reader, name string) (*DetachedSignature, error)
+}
+
+// Minisign or OpenPGP secret key. Passphrase is only asked for if the key
+// is encrypted.
+func ReadSecretSigningKey(data []byte, passphrase func() ([]byte, error)) (SecretSigningKey, error) {
+	if isMinisignFile(data) {
+		return parseMinisignSecretKey(data, passphrase)
+	}
+	return parseOpenPGPSecretKey(data, passphrase)
+}
+
+func (m *RegistryManifest) AddSigningKey(key SigningKey) error {
+	if _, ok := m.SigningKey(key.Format, key.Id); ok {
+		return fmt.Errorf("%w: %s", ErrSigningKeyExists, key)
+	}
+	m.SigningKeys = append(m.SigningKeys, key)
+	return nil
+}
+
+// Remove keys with the id, of any format.
+func (m *RegistryManifest) RemoveSigningKey(id string) error {
+	keys := m.SigningKeys[:0]
+	for _, key := range m.SigningKeys {
+		if !strings.EqualFold(key.Id, id) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == len(m.SigningKeys) {
+		return fmt.Errorf("%w: %s", ErrUnknownSigningKey, id)
+	}
+	m.SigningKeys = keys
+	return nil
+}
+
+func (m RegistryManifest) SigningKey(format, id string) (SigningKey, bool) {
+	return findSigningKey(m.SigningKeys, format, id)
+}
+
+func findSigningKey(keys []SigningKey, format, id string) (SigningKey, bool) {
+	for _, key := range keys {
+		if key.Format == format && strings.EqualFold(key.Id, id) {
+			return key, true
+		}
+	}
+	return SigningKey{}, false
+}
+
+// Signature file stored under the instance prefix.
+type DetachedSignature struct {
+	Format string
+	KeyId  string
+	Data   []byte
+}
+
+func (s DetachedSignature) fileName() string {
+	if s.Format == SignatureFormatGPG {
+		return s.KeyId + GPGSignatureExtension
+	}
+	return s.KeyId + MinisignSignatureExtension
+}
+
+// Format and key id from the signature file name, false for other files.
+func parseDetachedSignatureName(name string) (DetachedSignature, bool) {
+	switch ext := filepath.Ext(name); ext {
+	case MinisignSignatureExtension:
+		return DetachedSignature{Format: SignatureFormatMinisign, KeyId: strings.TrimSuffix(name, ext)}, true
+	case GPGSignatureExtension:
+		return DetachedSignature{Format: SignatureFormatGPG, KeyId: strings.TrimSuffix(name, ext)}, true
+	default:
+		return DetachedSignature{}, false
+	}
+}
+
+// Hashes the archive while it's read and checks the signature at the end.
+type signatureVerifier interface {
+	io.Writer
+	Verify() error
+}
+
+func (k SigningKey) newVerifier(signature DetachedSignature) (signatureVerifier, error) {
+	switch k.Format {
+	case SignatureFormatMinisign:
+		return newMinisignVerifier(k, signature.Data)
+	case SignatureFormatGPG:
+		return newOpenPGPVerifier(k, signature.Data)
+	default:
+		return nil, fmt.Errorf("%w: unknown format %s", ErrInvalidSigningKey, k.Format)
+	}
+}
+
+// Verifiers of signatures made with the trusted keys, others are skipped.
+type detachedSignaturesVerifier struct {
+	keys      []SigningKey
+	verifiers []signatureVerifier
+}
+
+func newDetachedSignaturesVerifier(trusted []SigningKey, signatures []DetachedSignature) (*detachedSignaturesVerifier, error) {
+	v := &detachedSignaturesVerifier{}
+	for _, signature := range signatures {
+		key, ok := findSigningKey(trusted, signature.Format, signature.KeyId)
+		if !ok {
+			continue
+		}
+		verifier, err := key.newVerifier(signature)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, key)
+		v.verifiers = append(v.verifiers, verifier)
+	}
+	return v, nil
+}
+
+func (v *detachedSignaturesVerifier) Write(p []byte) (int, error) {
+	for _, verifier := range v.verifiers {
+		if _, err := verifier.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Keys of valid signatures. Any invalid one fails verification.
+func (v *detachedSignaturesVerifier) Verify() ([]SigningKey, error) {
+	for _, verifier := range v.verifiers {
+		if err := verifier.Verify(); err != nil {
+			return nil, err
+		}
+	}
+	return v.keys, nil
+}
+
+// Sign the instance archive with the local key and store the signature under
+// the instance prefix. The archive is downloaded, unless it's given.
+func SignPackageInstanceDetached(ctx context.Context, registry Registry, instance Instance, archive io.Reader, key SecretSigningKey) (*DetachedSignature, error) {
+	if archive == nil {
+		reader, writer := io.Pipe()
+		go func() {
+			writer.CloseWithError(registry.DownloadPackageInstance(ctx, instance, writer))
+		}()
+		defer reader.Close()
+		archive = reader
+	}
+
+	signature, err := key.Sign(archive, instance.Id+ArchiveExtension(instance.Compression))
+	if err != nil {
+		return nil, err
+	}
+	if err = registry.PutPackageInstanceSignature(ctx, instance, *signature); err != nil {
+		return nil, err
+	}
+	return signature, nil
+}
+
+// Keys of the registry manifest which signed the instance archive. Fails
+// with ErrInstanceNotSigned if none of them did, and with ErrInvalidSignature
+// if any of their signatures doesn't match.
+func VerifyPackageInstanceDetachedSignatures(ctx context.Context, registry Registry, instance Instance) ([]SigningKey, error) {
+	manifest, err := registry.GetManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	signatures, err := registry.ListPackageInstanceSignatures(ctx, instance)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	verifier, err := newDetachedSignaturesVerifier(manifest.SigningKeys, signatures)
+	if err != nil {
+		return nil, fmt.Errorf("%s@%s: %w", instance.Package, instance.Id, err)
+	}
+	if len(verifier.verifiers) == 0 {
+		return nil, fmt.Errorf("%w: %s@%s: no signatures by registry keys", ErrInstanceNotSigned, instance.Package, instance.Id)
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(registry.DownloadPackageInstance(ctx, instance, writer))
+	}()
+	defer reader.Close()
+	if _, err = io.Copy(verifier, reader); err != nil {
+		return nil, err
+	}
+
+	keys, err := verifier.Verify()
+	if err != nil {
+		return nil, fmt.Errorf("%s@%s: %w", instance.Package, instance.Id, err)
+	}
+	return keys, nil
+}
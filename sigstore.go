@@ -0,0 +1,628 @@
+package shop
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Bundle is stored next to the CAS archive, as <id>.sigstore.json.
+	SigstoreBundleExtension = ".sigstore.json"
+	SigstoreBundleMediaType = "application/vnd.dev.sigstore.bundle.v0.3+json"
+	SigstoreDigestSHA256    = "SHA2_256"
+
+	DefaultFulcioURL = "https://fulcio.sigstore.dev"
+	DefaultRekorURL  = "https://rekor.sigstore.dev"
+
+	// OIDC identity token used for keyless signing. In GitHub Actions it is
+	// requested from the runner if the variable is not set.
+	SigstoreIDTokenEnv = "SIGSTORE_ID_TOKEN"
+)
+
+var (
+	ErrNoIDToken         = errors.New("No OIDC identity token for keyless signing")
+	ErrInstanceNotSigned = errors.New("Instance is not signed")
+	ErrInvalidSignature  = errors.New("Invalid instance signature")
+	ErrUntrustedSigner   = errors.New("Signer is not allowed by the policy")
+	ErrNoTrustedRoots    = errors.New("No trusted roots are configured for signature verification")
+
+	// Fulcio certificate extensions with the OIDC issuer, as a DER string
+	// and as raw bytes in older certificates.
+	oidFulcioIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+	oidFulcioIssuer   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+)
+
+func InstanceSigstoreBundleKey(id string) string {
+	return filepath.Join(RegistryCASPrefix, id+SigstoreBundleExtension)
+}
+
+// Signature of the archive SHA-256 digest with a short-lived certificate,
+// in the sigstore bundle format, so it could be checked by other tools too.
+type SigstoreBundle struct {
+	MediaType            string                       `json:"mediaType"`
+	VerificationMaterial SigstoreVerificationMaterial `json:"verificationMaterial"`
+	MessageSignature     SigstoreMessageSignature     `json:"messageSignature"`
+}
+
+type SigstoreVerificationMaterial struct {
+	Certificate SigstoreCertificate `json:"certificate"`
+	TlogEntries []SigstoreTlogEntry `json:"tlogEntries,omitempty"`
+}
+
+type SigstoreCertificate struct {
+	RawBytes []byte `json:"rawBytes"`
+}
+
+type SigstoreMessageSignature struct {
+	MessageDigest SigstoreMessageDigest `json:"messageDigest"`
+	Signature     []byte                `json:"signature"`
+}
+
+type SigstoreMessageDigest struct {
+	Algorithm string `json:"algorithm"`
+	Digest    []byte `json:"digest"`
+}
+
+type SigstoreTlogEntry struct {
+	LogIndex          string                    `json:"logIndex"`
+	LogId             SigstoreLogId             `json:"logId"`
+	KindVersion       SigstoreKindVersion       `json:"kindVersion"`
+	IntegratedTime    string                    `json:"integratedTime"`
+	InclusionPromise  *SigstoreInclusionPromise `json:"inclusionPromise,omitempty"`
+	CanonicalizedBody []byte                    `json:"canonicalizedBody"`
+}
+
+type SigstoreLogId struct {
+	KeyId []byte `json:"keyId"`
+}
+
+type SigstoreKindVersion struct {
+	Kind    string `json:"kind"`
+	Version string `json:"version"`
+}
+
+type SigstoreInclusionPromise struct {
+	SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+}
+
+// Identity from the signing certificate.
+type Signer struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+}
+
+func (s Signer) String() string {
+	return s.Subject + " (" + s.Issuer + ")"
+}
+
+// Token from SIGSTORE_ID_TOKEN, or requested from the GitHub Actions runner.
+func SigstoreIDToken(ctx context.Context) (string, error) {
+	if token := os.Getenv(SigstoreIDTokenEnv); token != "" {
+		return token, nil
+	}
+
+	requestURL, requestToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL"), os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if requestURL == "" || requestToken == "" {
+		return "", fmt.Errorf("%w: set %s", ErrNoIDToken, SigstoreIDTokenEnv)
+	}
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("audience", "sigstore")
+	u.RawQuery = query.Encode()
+
+	var response struct {
+		Value string `json:"value"`
+	}
+	header := http.Header{"Authorization": {"Bearer " + requestToken}}
+	if err = sigstoreRequest(ctx, http.MethodGet, u.String(), nil, header, &response); err != nil {
+		return "", err
+	}
+	return response.Value, nil
+}
+
+// Sign the digest with an ephemeral key certified by Fulcio for the token
+// identity, and record the signature in Rekor unless it's disabled.
+func SignDigestKeyless(ctx context.Context, cfg SigstoreConfig, digest []byte, token string) (*SigstoreBundle, error) {
+	subject, err := idTokenSubject(token)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := requestSigningCertificate(ctx, cfg, key, subject, token)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &SigstoreBundle{
+		MediaType: SigstoreBundleMediaType,
+		VerificationMaterial: SigstoreVerificationMaterial{
+			Certificate: SigstoreCertificate{RawBytes: cert.Raw},
+		},
+		MessageSignature: SigstoreMessageSignature{
+			MessageDigest: SigstoreMessageDigest{Algorithm: SigstoreDigestSHA256, Digest: digest},
+			Signature:     signature,
+		},
+	}
+	if !cfg.SkipTransparencyLog {
+		entry, err := logSignature(ctx, cfg, cert, digest, signature)
+		if err != nil {
+			return nil, err
+		}
+		bundle.VerificationMaterial.TlogEntries = []SigstoreTlogEntry{*entry}
+	}
+	return bundle, nil
+}
+
+// Email, or subject if the token has no email, which Fulcio expects to be
+// signed as the proof of possession of the key.
+func idTokenSubject(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%w: not a JWT", ErrNoIDToken)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrNoIDToken, err)
+	}
+
+	var claims struct {
+		Subject string `json:"sub"`
+		Email   string `json:"email"`
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrNoIDToken, err)
+	}
+	if claims.Email != "" {
+		return claims.Email, nil
+	}
+	return claims.Subject, nil
+}
+
+func requestSigningCertificate(ctx context.Context, cfg SigstoreConfig, key *ecdsa.PrivateKey, subject, token string) (*x509.Certificate, error) {
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	subjectDigest := sha256.Sum256([]byte(subject))
+	proof, err := ecdsa.SignASN1(rand.Reader, key, subjectDigest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	type chain struct {
+		Chain struct {
+			Certificates []string `json:"certificates"`
+		} `json:"chain"`
+	}
+	var response struct {
+		Embedded *chain `json:"signedCertificateEmbeddedSct"`
+		Detached *chain `json:"signedCertificateDetachedSct"`
+	}
+	request := map[string]any{
+		"credentials": map[string]string{"oidcIdentityToken": token},
+		"publicKeyRequest": map[string]any{
+			"publicKey": map[string]string{
+				"algorithm": "ECDSA",
+				"content":   string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+			},
+			"proofOfPossession": proof,
+		},
+	}
+	fulcioURL := strings.TrimSuffix(valueOr(cfg.FulcioURL, DefaultFulcioURL), "/") + "/api/v2/signingCert"
+	if err = sigstoreRequest(ctx, http.MethodPost, fulcioURL, request, nil, &response); err != nil {
+		return nil, err
+	}
+
+	issued := response.Embedded
+	if issued == nil {
+		issued = response.Detached
+	}
+	if issued == nil || len(issued.Chain.Certificates) == 0 {
+		return nil, fmt.Errorf("%w: no certificate from %s", ErrInvalidSignature, fulcioURL)
+	}
+	block, _ := pem.Decode([]byte(issued.Chain.Certificates[0]))
+	if block == nil {
+		return nil, fmt.Errorf("%w: invalid certificate from %s", ErrInvalidSignature, fulcioURL)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// Rekor entry of the "hashedrekord" kind and its canonical form, which the
+// signed entry timestamp covers.
+type rekorEntry struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+	Verification   *struct {
+		SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+	} `json:"verification,omitempty"`
+}
+
+type hashedRekord struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+func logSignature(ctx context.Context, cfg SigstoreConfig, cert *x509.Certificate, digest, signature []byte) (*SigstoreTlogEntry, error) {
+	record := hashedRekord{APIVersion: "0.0.1", Kind: "hashedrekord"}
+	record.Spec.Data.Hash.Algorithm = "sha256"
+	record.Spec.Data.Hash.Value = hex.EncodeToString(digest)
+	record.Spec.Signature.Content = signature
+	record.Spec.Signature.PublicKey.Content = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+
+	var response map[string]rekorEntry
+	rekorURL := strings.TrimSuffix(valueOr(cfg.RekorURL, DefaultRekorURL), "/") + "/api/v1/log/entries"
+	if err := sigstoreRequest(ctx, http.MethodPost, rekorURL, record, nil, &response); err != nil {
+		return nil, err
+	}
+	for _, entry := range response {
+		body, err := base64.StdEncoding.DecodeString(entry.Body)
+		if err != nil {
+			return nil, err
+		}
+		logID, err := hex.DecodeString(entry.LogID)
+		if err != nil {
+			return nil, err
+		}
+		result := &SigstoreTlogEntry{
+			LogIndex:          strconv.FormatInt(entry.LogIndex, 10),
+			LogId:             SigstoreLogId{KeyId: logID},
+			KindVersion:       SigstoreKindVersion{Kind: record.Kind, Version: record.APIVersion},
+			IntegratedTime:    strconv.FormatInt(entry.IntegratedTime, 10),
+			CanonicalizedBody: body,
+		}
+		if entry.Verification != nil {
+			result.InclusionPromise = &SigstoreInclusionPromise{SignedEntryTimestamp: entry.Verification.SignedEntryTimestamp}
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("%w: no entry from %s", ErrInvalidSignature, rekorURL)
+}
+
+func sigstoreRequest(ctx context.Context, method, url string, request any, header http.Header, response any) error {
+	var body io.Reader
+	if request != nil {
+		data, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+		if header == nil {
+			header = http.Header{}
+		}
+		header.Set("Content-Type", "application/json")
+	}
+
+	client := httpClient{client: http.DefaultClient, header: http.Header{"Accept": {"application/json"}}}
+	resp, err := client.doOK(ctx, method, url, body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// Check that the bundle signs the digest with a certificate issued by the
+// trusted roots to one of the allowed identities. If the Rekor key is
+// configured, the signature must be in the transparency log and the
+// certificate is checked at the time it was logged.
+func VerifySigstoreBundle(cfg SigstoreConfig, bundle SigstoreBundle, digest []byte) (*Signer, error) {
+	signature := bundle.MessageSignature
+	if signature.MessageDigest.Algorithm != SigstoreDigestSHA256 || !bytes.Equal(signature.MessageDigest.Digest, digest) {
+		return nil, fmt.Errorf("%w: signed digest does not match the archive", ErrInvalidSignature)
+	}
+
+	cert, err := x509.ParseCertificate(bundle.VerificationMaterial.Certificate.RawBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+	if err = verifyDigestSignature(cert, digest, signature.Signature); err != nil {
+		return nil, err
+	}
+
+	// Certificates live for minutes, so they are checked at signing time.
+	signedAt := cert.NotBefore
+	if cfg.RekorPublicKey != "" {
+		if signedAt, err = verifyTlogEntries(cfg, bundle, cert, digest); err != nil {
+			return nil, err
+		}
+	}
+	if err = verifyCertificateChain(cfg, cert, signedAt); err != nil {
+		return nil, err
+	}
+
+	signer, err := certificateSigner(cert)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSigner(cfg, *signer); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+func verifyDigestSignature(cert *x509.Certificate, digest, signature []byte) error {
+	ok := false
+	switch key := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(key, digest, signature)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, signature) == nil
+	}
+	if !ok {
+		return fmt.Errorf("%w: signature does not match the certificate", ErrInvalidSignature)
+	}
+	return nil
+}
+
+func verifyCertificateChain(cfg SigstoreConfig, cert *x509.Certificate, at time.Time) error {
+	if cfg.TrustedRoots == "" {
+		return ErrNoTrustedRoots
+	}
+	data, err := os.ReadFile(cfg.TrustedRoots)
+	if err != nil {
+		return err
+	}
+
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("%s: %w", cfg.TrustedRoots, err)
+		}
+		if bytes.Equal(ca.RawIssuer, ca.RawSubject) {
+			roots.AddCert(ca)
+		} else {
+			intermediates.AddCert(ca)
+		}
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+	return nil
+}
+
+// Time the signature was logged at, from the first entry with a valid
+// signed entry timestamp for this signature.
+func verifyTlogEntries(cfg SigstoreConfig, bundle SigstoreBundle, cert *x509.Certificate, digest []byte) (time.Time, error) {
+	data, err := os.ReadFile(cfg.RekorPublicKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("%s: no PEM key", cfg.RekorPublicKey)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", cfg.RekorPublicKey, err)
+	}
+	rekorKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%s: not an ECDSA key", cfg.RekorPublicKey)
+	}
+
+	for _, entry := range bundle.VerificationMaterial.TlogEntries {
+		if entry.InclusionPromise == nil {
+			continue
+		}
+		var record hashedRekord
+		if json.Unmarshal(entry.CanonicalizedBody, &record) != nil ||
+			record.Spec.Data.Hash.Value != hex.EncodeToString(digest) ||
+			!bytes.Equal(record.Spec.Signature.Content, bundle.MessageSignature.Signature) {
+			continue
+		}
+
+		integratedTime, err1 := strconv.ParseInt(entry.IntegratedTime, 10, 64)
+		logIndex, err2 := strconv.ParseInt(entry.LogIndex, 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		// Fields are in the order of the canonical JSON Rekor signs.
+		payload, err := json.Marshal(rekorEntry{
+			Body:           base64.StdEncoding.EncodeToString(entry.CanonicalizedBody),
+			IntegratedTime: integratedTime,
+			LogID:          hex.EncodeToString(entry.LogId.KeyId),
+			LogIndex:       logIndex,
+		})
+		if err != nil {
+			return time.Time{}, err
+		}
+		payloadDigest := sha256.Sum256(payload)
+		if !ecdsa.VerifyASN1(rekorKey, payloadDigest[:], entry.InclusionPromise.SignedEntryTimestamp) {
+			continue
+		}
+
+		at := time.Unix(integratedTime, 0)
+		if at.Before(cert.NotBefore) || at.After(cert.NotAfter) {
+			return time.Time{}, fmt.Errorf("%w: logged at %s, outside of the certificate validity", ErrInvalidSignature, at.Format(time.RFC3339))
+		}
+		return at, nil
+	}
+	return time.Time{}, fmt.Errorf("%w: signature is not in the transparency log", ErrInvalidSignature)
+}
+
+func certificateSigner(cert *x509.Certificate) (*Signer, error) {
+	signer := &Signer{}
+	switch {
+	case len(cert.EmailAddresses) > 0:
+		signer.Subject = cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		signer.Subject = cert.URIs[0].String()
+	default:
+		return nil, fmt.Errorf("%w: certificate has no subject", ErrInvalidSignature)
+	}
+
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidFulcioIssuerV2):
+			if _, err := asn1.Unmarshal(ext.Value, &signer.Issuer); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+			}
+		case ext.Id.Equal(oidFulcioIssuer) && signer.Issuer == "":
+			signer.Issuer = string(ext.Value)
+		}
+	}
+	if signer.Issuer == "" {
+		return nil, fmt.Errorf("%w: certificate has no issuer", ErrInvalidSignature)
+	}
+	return signer, nil
+}
+
+func checkSigner(cfg SigstoreConfig, signer Signer) error {
+	for _, identity := range cfg.Identities {
+		if identity.Issuer != signer.Issuer {
+			continue
+		}
+		if identity.Subject != "" && identity.Subject == signer.Subject {
+			return nil
+		}
+		if identity.SubjectRegexp != "" {
+			re, err := regexp.Compile("^(?:" + identity.SubjectRegexp + ")$")
+			if err != nil {
+				return err
+			}
+			if re.MatchString(signer.Subject) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUntrustedSigner, signer)
+}
+
+// SHA-256 digest of the instance archive, which is signed. The archive is
+// read to the end, so hash mismatches are reported.
+func ArchiveDigest(r io.Reader) ([]byte, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+func downloadArchiveDigest(ctx context.Context, registry Registry, instance Instance) ([]byte, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(registry.DownloadPackageInstance(ctx, instance, writer))
+	}()
+	defer reader.Close()
+	return ArchiveDigest(reader)
+}
+
+// Sign the instance archive keylessly and store the bundle next to it. The
+// archive is downloaded to be hashed, unless it's given.
+func SignPackageInstance(ctx context.Context, registry Registry, instance Instance, archive io.Reader, token string) (*SigstoreBundle, error) {
+	var digest []byte
+	var err error
+	if archive != nil {
+		digest, err = ArchiveDigest(archive)
+	} else {
+		digest, err = downloadArchiveDigest(ctx, registry, instance)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg SigstoreConfig
+	if registryCfg := registry.GetConfig(); registryCfg.Sigstore != nil {
+		cfg = *registryCfg.Sigstore
+	}
+	bundle, err := SignDigestKeyless(ctx, cfg, digest, token)
+	if err != nil {
+		return nil, err
+	}
+	if err = registry.PutPackageInstanceSigstoreBundle(ctx, instance, *bundle); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// Signer of the instance archive allowed by the sigstore policy of the
+// registry. Fails with ErrInstanceNotSigned if there is no signature.
+func VerifyPackageInstanceSignature(ctx context.Context, registry Registry, instance Instance) (*Signer, error) {
+	var cfg SigstoreConfig
+	if registryCfg := registry.GetConfig(); registryCfg.Sigstore != nil {
+		cfg = *registryCfg.Sigstore
+	}
+
+	bundle, err := registry.GetPackageInstanceSigstoreBundle(ctx, instance)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s@%s", ErrInstanceNotSigned, instance.Package, instance.Id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	digest, err := downloadArchiveDigest(ctx, registry, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := VerifySigstoreBundle(cfg, *bundle, digest)
+	if err != nil {
+		return nil, fmt.Errorf("%s@%s: %w", instance.Package, instance.Id, err)
+	}
+	return signer, nil
+}
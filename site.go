@@ -0,0 +1,496 @@
+package shop
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Directory in the site root with the state of installed packages.
+	SiteStateDir         = ".shop"
+	SitePackagesDir      = "packages"
+	SitePackageExtension = ".json"
+
+	// Kinds of SiteFileChange.
+	SiteFileModified = "modified"
+	SiteFileMissing  = "missing"
+)
+
+var (
+	ErrSiteFileConflict = errors.New("File is installed by another package")
+	ErrSiteSymlinkPath  = errors.New("Path in the site goes through a symlink")
+)
+
+// Package installed into a site root.
+type SitePackage struct {
+	ApiVersion string   `json:"api_version"`
+	Package    string   `json:"package"`
+	Id         string   `json:"id"`
+	Files      []string `json:"files"`
+	// SHA-1 of the installed files by path, missing in state written by
+	// older shop versions.
+	Hashes      map[string]string `json:"hashes,omitempty"`
+	InstalledAt UnixTimestamp     `json:"installed_at"`
+}
+
+// File of an installed package changed since it was installed.
+type SiteFileChange struct {
+	Package string `json:"package"`
+	Path    string `json:"path"`
+	Kind    string `json:"kind"`
+}
+
+// Deployment root, which keeps track of installed packages and their files.
+type Deployer interface {
+	Installed(pkg string) (*SitePackage, error)
+	List() ([]SitePackage, error)
+	Install(ctx context.Context, instance Instance, archive io.Reader) (*SitePackage, error)
+	InstallDir(ctx context.Context, instance Instance, dir string) (*SitePackage, error)
+	InstallFrom(ctx context.Context, registry Registry, instance Instance) (*SitePackage, error)
+	Uninstall(ctx context.Context, pkg string) error
+	Check(pkg string) ([]SiteFileChange, error)
+}
+
+// Deployer for a local directory. Files of every installed package are
+// tracked in the state dir, so packages could be upgraded in place.
+type Site struct {
+	Root string
+	// Fail to install instances with symlinks to absolute paths or outside
+	// of the instance.
+	RejectUnsafeSymlinks bool
+}
+
+var _ Deployer = Site{}
+
+func NewSite(root string) Site {
+	return Site{Root: root}
+}
+
+func (s Site) stateDir() string {
+	return filepath.Join(s.Root, SiteStateDir)
+}
+
+func (s Site) packagePath(pkg string) string {
+	return filepath.Join(s.stateDir(), SitePackagesDir, filepath.FromSlash(pkg)+SitePackageExtension)
+}
+
+// Installed package, os.ErrNotExist if it's not installed.
+func (s Site) Installed(pkg string) (*SitePackage, error) {
+	data, err := os.ReadFile(s.packagePath(pkg))
+	if err != nil {
+		return nil, err
+	}
+
+	installed := &SitePackage{}
+	if err = json.Unmarshal(data, installed); err != nil {
+		return nil, fmt.Errorf("%s: %w", pkg, err)
+	}
+	return installed, nil
+}
+
+// All installed packages sorted by name.
+func (s Site) List() ([]SitePackage, error) {
+	var result []SitePackage
+	dir := filepath.Join(s.stateDir(), SitePackagesDir)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(path, SitePackageExtension) {
+			return err
+		}
+
+		name, err := filepath.Rel(dir, strings.TrimSuffix(path, SitePackageExtension))
+		if err != nil {
+			return err
+		}
+		installed, err := s.Installed(filepath.ToSlash(name))
+		if err != nil {
+			return err
+		}
+		result = append(result, *installed)
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Package < result[j].Package
+	})
+	return result, err
+}
+
+// Extract the instance archive into the site. Files of the previously
+// installed instance of the package, which are not in the new one, are
+// removed. Files which are the same in both instances, and were not modified
+// locally, are left in place.
+func (s Site) Install(ctx context.Context, instance Instance, archive io.Reader) (*SitePackage, error) {
+	return s.install(ctx, instance, func(staging string) error {
+		return ExtractArchive(archive, staging, ExtractOptions{RejectUnsafeSymlinks: s.RejectUnsafeSymlinks})
+	})
+}
+
+// Same as Install, but with the instance already extracted into dir (see
+// ExtractCachedInstance). Files are copied, so dir is left intact.
+func (s Site) InstallDir(ctx context.Context, instance Instance, dir string) (*SitePackage, error) {
+	return s.install(ctx, instance, func(staging string) error {
+		return copyTree(dir, staging)
+	})
+}
+
+// Download the instance from the registry and install it, through the
+// extracted instances in the cache dir if the registry has one.
+func (s Site) InstallFrom(ctx context.Context, registry Registry, instance Instance) (*SitePackage, error) {
+	local, err := fetchInstance(ctx, registry, instance)
+	if err != nil {
+		return nil, err
+	}
+	defer local.Close()
+	return s.installLocal(ctx, instance, local)
+}
+
+func (s Site) install(ctx context.Context, instance Instance, unpack func(staging string) error) (*SitePackage, error) {
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	installed, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	owners := map[string]string{}
+	previous := SitePackage{}
+	for _, other := range installed {
+		if other.Package == instance.Package {
+			previous = other
+			continue
+		}
+		for _, file := range other.Files {
+			owners[file] = other.Package
+		}
+	}
+
+	// Archive is extracted next to the site, so files could be moved into
+	// place with rename.
+	staging, err := os.MkdirTemp(s.stateDir(), "install-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	if err = unpack(staging); err != nil {
+		return nil, err
+	}
+	// Instances copied from the cache were extracted without the policy.
+	if s.RejectUnsafeSymlinks {
+		if err = CheckSymlinks(staging); err != nil {
+			return nil, err
+		}
+	}
+
+	files, err := siteFiles(staging)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if owner, ok := owners[file]; ok {
+			return nil, fmt.Errorf("%w: %s: %s", ErrSiteFileConflict, file, owner)
+		}
+		if file == SiteStateDir || strings.HasPrefix(file, SiteStateDir+"/") {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidArchive, file)
+		}
+	}
+
+	// Files the new instance doesn't have are removed first, so none of its
+	// files is moved in through a symlink the previous one had.
+	current := map[string]struct{}{}
+	for _, file := range files {
+		current[file] = struct{}{}
+	}
+	for _, file := range previous.Files {
+		if _, ok := current[file]; !ok {
+			if err = s.remove(file); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	hashes := map[string]string{}
+	for _, file := range files {
+		staged := filepath.Join(staging, filepath.FromSlash(file))
+		target := filepath.Join(s.Root, filepath.FromSlash(file))
+		if err = s.checkParents(file); err != nil {
+			return nil, err
+		}
+		if hashes[file], err = hashFile(staged); err != nil {
+			return nil, err
+		}
+
+		if hash, ok := previous.Hashes[file]; ok && hash == hashes[file] {
+			if current, err := hashFile(target); err == nil && current == hash {
+				if err = syncFileMode(staged, target); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		if err = os.Rename(staged, target); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &SitePackage{
+		ApiVersion:  LatestVersion,
+		Package:     instance.Package,
+		Id:          instance.Id,
+		Files:       files,
+		Hashes:      hashes,
+		InstalledAt: UnixTimestamp{time.Now()},
+	}
+	return result, s.save(*result)
+}
+
+// Files of the installed package which were modified or removed since it was
+// installed. Only missing files are found for packages installed by older
+// shop versions, which didn't record hashes.
+func (s Site) Check(pkg string) ([]SiteFileChange, error) {
+	installed, err := s.Installed(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []SiteFileChange{}
+	for _, file := range installed.Files {
+		hash, err := hashFile(filepath.Join(s.Root, filepath.FromSlash(file)))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			changes = append(changes, SiteFileChange{Package: pkg, Path: file, Kind: SiteFileMissing})
+		case err != nil:
+			return nil, err
+		case installed.Hashes[file] != "" && installed.Hashes[file] != hash:
+			changes = append(changes, SiteFileChange{Package: pkg, Path: file, Kind: SiteFileModified})
+		}
+	}
+	return changes, nil
+}
+
+// Remove files of the installed package. Fails with os.ErrNotExist if it's
+// not installed.
+func (s Site) Uninstall(ctx context.Context, pkg string) error {
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	installed, err := s.Installed(pkg)
+	if err != nil {
+		return err
+	}
+	for _, file := range installed.Files {
+		if err = s.remove(file); err != nil {
+			return err
+		}
+	}
+
+	path := s.packagePath(pkg)
+	if err = os.Remove(path); err != nil {
+		return err
+	}
+	packagesDir := filepath.Join(s.stateDir(), SitePackagesDir)
+	for dir := filepath.Dir(path); dir != packagesDir; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Site modifications are serialized with a lock file in the state dir.
+func (s Site) lock(ctx context.Context) (unlock func(), err error) {
+	if err = os.MkdirAll(s.stateDir(), 0755); err != nil {
+		return
+	}
+	return lockFile(ctx, filepath.Join(s.stateDir(), "install"))
+}
+
+func (s Site) save(installed SitePackage) error {
+	data, err := json.MarshalIndent(installed, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := s.packagePath(installed.Package)
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Fail with ErrSiteSymlinkPath if any directory between the root and the
+// file is a symlink, so nothing is written or removed outside of the root.
+func (s Site) checkParents(file string) error {
+	dir := s.Root
+	parents := strings.Split(file, "/")
+	for _, name := range parents[:len(parents)-1] {
+		dir = filepath.Join(dir, name)
+		info, err := os.Lstat(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s", ErrSiteSymlinkPath, file)
+		}
+	}
+	return nil
+}
+
+// Remove the file and directories left empty after it.
+func (s Site) remove(file string) error {
+	if err := s.checkParents(file); err != nil {
+		return err
+	}
+	path := filepath.Join(s.Root, filepath.FromSlash(file))
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for dir := filepath.Dir(path); dir != filepath.Clean(s.Root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Copy directories, regular files and symlinks under src into dst, cloning
+// file blocks where the filesystem supports it.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(src, path)
+		if err != nil || name == "." {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, name)
+		if entry.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if entry.Type() == fs.ModeSymlink {
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%w: %s", ErrInvalidArchive, name)
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+func copyFile(src, dst string, mode fs.FileMode) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if reflinkFile(source, target) != nil {
+		_, err = io.Copy(target, source)
+	}
+	if err == nil {
+		err = target.Chmod(mode)
+	}
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// SHA-1 of the file content, or of the target of symlinks.
+func hashFile(path string) (string, error) {
+	if target, err := os.Readlink(path); err == nil {
+		h := sha1.Sum([]byte(filepath.ToSlash(target)))
+		return hex.EncodeToString(h[:]), nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha1.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Give target the permissions of src. Symlinks have none of their own.
+func syncFileMode(src, target string) error {
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	targetInfo, err := os.Lstat(target)
+	if err != nil {
+		return err
+	}
+	if srcInfo.Mode().Perm() == targetInfo.Mode().Perm() || srcInfo.Mode()&fs.ModeSymlink != 0 {
+		return nil
+	}
+	return os.Chmod(target, srcInfo.Mode().Perm())
+}
+
+// Regular files and symlinks under dir as sorted slash separated paths.
+func siteFiles(dir string) (files []string, err error) {
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		name, err := filepath.Rel(dir, path)
+		if err == nil {
+			files = append(files, filepath.ToSlash(name))
+		}
+		return err
+	})
+	sort.Strings(files)
+	return
+}
@@ -0,0 +1,229 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Instance contents for tests: regular files by path, and symlinks for
+// values starting with "->".
+type testTree map[string]string
+
+func (tree testTree) write(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	tree.writeInto(t, dir)
+	return dir
+}
+
+func (tree testTree) writeInto(t *testing.T, dir string) {
+	t.Helper()
+	for name, content := range tree {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		if target, ok := strings.CutPrefix(content, "->"); ok {
+			err = os.Symlink(target, path)
+		} else {
+			err = os.WriteFile(path, []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSiteInstall(t *testing.T) {
+	type step struct {
+		pkg  string
+		tree testTree
+		err  error
+	}
+	// Symlink at the top of the root to a directory next to it.
+	outside := "->" + filepath.Join("..", "outside")
+
+	tests := []struct {
+		name  string
+		steps []step
+		files testTree
+	}{
+		{
+			name: "upgrade",
+			steps: []step{
+				{pkg: "a", tree: testTree{"bin/a": "1", "old": "x"}},
+				{pkg: "a", tree: testTree{"bin/a": "2", "lib/b": "y"}},
+			},
+			files: testTree{"bin/a": "2", "lib/b": "y"},
+		},
+		{
+			name: "upgrade replaces symlink with directory",
+			steps: []step{
+				{pkg: "a", tree: testTree{"d": outside}},
+				{pkg: "a", tree: testTree{"d/x": "inside"}},
+			},
+			files: testTree{"d/x": "inside"},
+		},
+		{
+			name: "file under symlink of another package",
+			steps: []step{
+				{pkg: "a", tree: testTree{"d": outside}},
+				{pkg: "b", tree: testTree{"d/x": "inside"}, err: ErrSiteSymlinkPath},
+			},
+			files: testTree{"d": outside},
+		},
+		{
+			name: "file of another package",
+			steps: []step{
+				{pkg: "a", tree: testTree{"f": "a"}},
+				{pkg: "b", tree: testTree{"f": "b"}, err: ErrSiteFileConflict},
+			},
+			files: testTree{"f": "a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := t.TempDir()
+			root := filepath.Join(base, "root")
+			if err := os.Mkdir(filepath.Join(base, "outside"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			site := NewSite(root)
+			if err := os.Mkdir(root, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			for i, step := range test.steps {
+				instance := Instance{Package: step.pkg, Id: "test-" + string(rune('0'+i))}
+				_, err := site.InstallDir(context.Background(), instance, step.tree.write(t))
+				if !errors.Is(err, step.err) {
+					t.Fatalf("step %d: got error %v, want %v", i, err, step.err)
+				}
+			}
+
+			entries, err := os.ReadDir(filepath.Join(base, "outside"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("%d files written outside of the root", len(entries))
+			}
+			checkTree(t, root, test.files)
+		})
+	}
+}
+
+func checkTree(t *testing.T, root string, want testTree) {
+	t.Helper()
+	files, err := siteFiles(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := testTree{}
+	for _, file := range files {
+		if strings.HasPrefix(file, SiteStateDir+"/") {
+			continue
+		}
+		path := filepath.Join(root, filepath.FromSlash(file))
+		if target, err := os.Readlink(path); err == nil {
+			got[file] = "->" + target
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[file] = string(data)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got files %v, want %v", got, want)
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("%s: got %q, want %q", name, got[name], content)
+		}
+	}
+}
+
+func TestSiteInstallFrom(t *testing.T) {
+	type step struct {
+		tree        testTree
+		compression string
+	}
+	tests := []struct {
+		name      string
+		steps     []step
+		uninstall bool
+		files     testTree
+	}{
+		{
+			name: "upgrade",
+			steps: []step{
+				{tree: testTree{"bin/a": "1", "old": "x"}, compression: CompressionGzip},
+				{tree: testTree{"bin/a": "2", "lib/b": "y", "l": "->bin/a"}, compression: CompressionZip},
+			},
+			files: testTree{"bin/a": "2", "lib/b": "y", "l": "->bin/a"},
+		},
+		{
+			name: "upgrade to delta",
+			steps: []step{
+				{tree: testTree{"bin/a": "1", "lib/b": "y"}, compression: CompressionFiles},
+				{tree: testTree{"bin/a": "2", "lib/b": "y"}, compression: CompressionDelta},
+			},
+			files: testTree{"bin/a": "2", "lib/b": "y"},
+		},
+		{
+			name: "uninstall",
+			steps: []step{
+				{tree: testTree{"bin/a": "1"}, compression: CompressionZstd},
+				{tree: testTree{"bin/a": "2", "lib/b": "y"}, compression: CompressionZstd},
+			},
+			uninstall: true,
+			files:     testTree{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			registry := newTestRegistry(t)
+			root := t.TempDir()
+			site := NewSite(root)
+
+			var last *Instance
+			for i, step := range test.steps {
+				var base *Instance
+				if step.compression == CompressionDelta {
+					base = last
+				}
+				instance, err := uploadTestInstance(t, registry, step.tree, step.compression, base)
+				if err != nil {
+					t.Fatal(err)
+				}
+				installed, err := site.InstallFrom(ctx, registry, *instance)
+				if err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+				if installed.Id != instance.Id {
+					t.Errorf("step %d: got %s installed, want %s", i, installed.Id, instance.Id)
+				}
+				last = instance
+			}
+			if test.uninstall {
+				if err := site.Uninstall(ctx, "test/pkg"); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := site.Installed("test/pkg"); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("got error %v for the uninstalled package, want %v", err, os.ErrNotExist)
+				}
+			}
+			checkTree(t, root, test.files)
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hirochachacha/go-smb2"
+)
+
+func init() {
+	MustRegisterBackend(Backend{
+		Scheme:       "smb",
+		Factory:      NewSMBFS,
+		Capabilities: BackendCapabilities{Range: true, RemoveAll: true},
+	})
+}
+
+const (
+	DefaultSMBPort = "445"
+
+	SMBAuthNTLM     = "ntlm"
+	SMBAuthKerberos = "kerberos"
+)
+
+var (
+	ErrInvalidSMBURL          = errors.New("SMB url must be smb://host/share[/path]")
+	ErrUnknownSMBAuth         = errors.New("Unknown SMB auth method")
+	ErrSMBNoCredentials       = errors.New("SMB user is not configured")
+	ErrSMBKerberosUnsupported = errors.New("Kerberos auth is not supported by the SMB client")
+)
+
+// Repository on a Windows file share: smb://host[:port]/share[/path].
+type SMBFS struct {
+	cfg   RepositoryConfig
+	share *smb2.Share
+	path  string
+}
+
+func NewSMBFS(ctx context.Context, cfg RepositoryConfig) (RepositoryFS, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(strings.Trim(u.Path, "/"), "/", 2)
+	if u.Host == "" || parts[0] == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSMBURL, cfg.URL)
+	}
+
+	initiator, err := smbInitiator(u, cfg.SMB)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), DefaultSMBPort)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := (&smb2.Dialer{Initiator: initiator}).DialContext(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	share, err := session.Mount(parts[0])
+	if err != nil {
+		session.Logoff()
+		return nil, err
+	}
+
+	f := SMBFS{
+		cfg:   cfg,
+		share: share,
+	}
+	if len(parts) > 1 {
+		f.path = parts[1]
+	}
+	return f, nil
+}
+
+func smbInitiator(u *url.URL, cfg *SMBAccessConfig) (smb2.Initiator, error) {
+	access := SMBAccessConfig{}
+	if cfg != nil {
+		access = *cfg
+	}
+	if u.User != nil && u.User.Username() != "" {
+		access.User = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			access.Password = password
+		}
+	}
+	if access.Password == "" {
+		access.Password = os.Getenv("SHOP_SMB_PASSWORD")
+	}
+	// DOMAIN\user is the usual way to write Windows accounts.
+	if domain, user, ok := strings.Cut(access.User, `\`); ok {
+		access.Domain, access.User = domain, user
+	}
+
+	switch access.Auth {
+	case "", SMBAuthNTLM:
+	case SMBAuthKerberos:
+		return nil, ErrSMBKerberosUnsupported
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSMBAuth, access.Auth)
+	}
+
+	if access.User == "" {
+		return nil, ErrSMBNoCredentials
+	}
+	return &smb2.NTLMInitiator{
+		User:     access.User,
+		Password: access.Password,
+		Domain:   access.Domain,
+	}, nil
+}
+
+// Share paths are relative to its root.
+func (f SMBFS) filePath(key string) string {
+	return strings.TrimPrefix(path.Join("/", f.path, key), "/")
+}
+
+func (f SMBFS) Read(ctx context.Context, key string) ([]byte, error) {
+	return f.share.WithContext(ctx).ReadFile(f.filePath(key))
+}
+
+func (f SMBFS) Write(ctx context.Context, key string, data []byte) error {
+	return f.share.WithContext(ctx).WriteFile(f.filePath(key), data, 0644)
+}
+
+func (f SMBFS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return f.share.WithContext(ctx).Open(f.filePath(key))
+}
+
+func (f SMBFS) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	file, err := f.share.WithContext(ctx).Open(f.filePath(key))
+	if err != nil {
+		return nil, err
+	}
+	return seekRange(file, offset, length)
+}
+
+func (f SMBFS) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	return f.share.WithContext(ctx).OpenFile(f.filePath(key), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+}
+
+func (f SMBFS) MakeDir(ctx context.Context, key string) error {
+	return f.share.WithContext(ctx).MkdirAll(f.filePath(key), 0755)
+}
+
+func (f SMBFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	infos, err := f.share.WithContext(ctx).ReadDir(f.filePath(key))
+	if err != nil {
+		return NewErrorCursor[Entry](err)
+	}
+
+	entries := make([]Entry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, Entry{
+			Key:      info.Name(),
+			IsPrefix: info.IsDir(),
+		})
+	}
+	return NewSliceCursor(entries)
+}
+
+func (f SMBFS) Remove(ctx context.Context, key string) error {
+	return f.share.WithContext(ctx).Remove(f.filePath(key))
+}
+
+func (f SMBFS) RemoveAll(ctx context.Context, key string) error {
+	return f.share.WithContext(ctx).RemoveAll(f.filePath(key))
+}
+
+func (f SMBFS) Exists(ctx context.Context, key string) (ok bool, err error) {
+	_, err = f.share.WithContext(ctx).Stat(f.filePath(key))
+	ok = err == nil
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return
+}
+
+func (f SMBFS) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := f.share.WithContext(ctx).Stat(f.filePath(key))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return fileObjectInfo(key, info)
+}
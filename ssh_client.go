@@ -0,0 +1,141 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	DefaultSSHPort = "22"
+)
+
+var (
+	ErrNoSSHAuthMethods = errors.New("No SSH authentication methods available")
+
+	defaultSSHIdentityFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+)
+
+func sshUserName(u *url.URL, cfg *SSHAccessConfig) (string, error) {
+	if u.User != nil && u.User.Username() != "" {
+		return u.User.Username(), nil
+	}
+	if cfg != nil && cfg.User != "" {
+		return cfg.User, nil
+	}
+	current, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return current.Username, nil
+}
+
+func sshAuthMethods(cfg *SSHAccessConfig) (methods []ssh.AuthMethod, err error) {
+	var identityFiles []string
+	if cfg != nil && cfg.IdentityFile != "" {
+		identityFiles = []string{cfg.IdentityFile}
+	} else if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range defaultSSHIdentityFiles {
+			identityFiles = append(identityFiles, filepath.Join(home, ".ssh", name))
+		}
+	}
+
+	var signers []ssh.Signer
+	for _, path := range identityFiles {
+		data, readErr := os.ReadFile(path)
+		if errors.Is(readErr, os.ErrNotExist) && (cfg == nil || cfg.IdentityFile == "") {
+			continue
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		signer, parseErr := ssh.ParsePrivateKey(data)
+		if parseErr != nil {
+			return nil, fmt.Errorf("%s: %w", path, parseErr)
+		}
+		signers = append(signers, signer)
+	}
+
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, dialErr := net.Dial("unix", socket); dialErr == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	if len(methods) == 0 {
+		err = ErrNoSSHAuthMethods
+	}
+	return
+}
+
+func sshHostKeyCallback(cfg *SSHAccessConfig) (ssh.HostKeyCallback, error) {
+	path := ""
+	if cfg != nil {
+		path = cfg.KnownHostsFile
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	return knownhosts.New(path)
+}
+
+// Connect to the host from url using key-based auth and known_hosts
+// verification.
+func DialSSH(ctx context.Context, u *url.URL, cfg *SSHAccessConfig) (*ssh.Client, error) {
+	userName, err := sshUserName(u, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	methods, err := sshAuthMethods(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := sshHostKeyCallback(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = DefaultSSHPort
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            userName,
+		Auth:            methods,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
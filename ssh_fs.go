@@ -0,0 +1,394 @@
+package shop
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"golang.org/x/crypto/ssh"
+)
+
+func init() {
+	MustRegisterBackend(Backend{
+		Scheme:       "ssh",
+		Factory:      NewSSHFS,
+		Capabilities: BackendCapabilities{Copy: true, RemoveAll: true},
+	})
+}
+
+const (
+	// Exit code used by remote scripts to report missing file.
+	sshNotExistExitCode = 44
+	// Exit code used by remote scripts to report existing file.
+	sshExistExitCode = 45
+)
+
+// Repository on a remote host, accessed by running POSIX shell commands over
+// ssh. Writes go to a temporary file first and are moved into place with a
+// server-side rename, so readers never see partial files.
+type SSHFS struct {
+	cfg    RepositoryConfig
+	client *ssh.Client
+	path   string
+}
+
+func NewSSHFS(ctx context.Context, cfg RepositoryConfig) (RepositoryFS, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := DialSSH(ctx, u, cfg.SSH)
+	if err != nil {
+		return nil, err
+	}
+
+	root := u.Path
+	if root == "" {
+		root = "."
+	}
+
+	return SSHFS{
+		cfg:    cfg,
+		client: client,
+		path:   root,
+	}, nil
+}
+
+func (f SSHFS) Close() error {
+	return f.client.Close()
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (f SSHFS) remotePath(key string) string {
+	return shellQuote(path.Join(f.path, key))
+}
+
+func (f SSHFS) tempPath(key string) (string, error) {
+	tmp, err := remoteTempPath(path.Join(f.path, key))
+	return shellQuote(tmp), err
+}
+
+// Random hidden name next to p for the file being written into p.
+func remoteTempPath(p string) (string, error) {
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return "", err
+	}
+	return path.Join(path.Dir(p), "."+path.Base(p)+".tmp-"+hex.EncodeToString(suffix[:])), nil
+}
+
+// Files of writes in progress are not listed.
+func isRemoteTempName(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, ".tmp-")
+}
+
+func sshCommandError(key, command string, err error, stderr []byte) error {
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		switch exitErr.ExitStatus() {
+		case sshNotExistExitCode:
+			return fmt.Errorf("%s: %w", key, os.ErrNotExist)
+		case sshExistExitCode:
+			return fmt.Errorf("%s: %w", key, os.ErrExist)
+		}
+	}
+	if msg := strings.TrimSpace(string(stderr)); msg != "" {
+		return fmt.Errorf("%s: %w: %s", command, err, msg)
+	}
+	return fmt.Errorf("%s: %w", command, err)
+}
+
+func (f SSHFS) run(ctx context.Context, key, command string, stdin io.Reader) ([]byte, error) {
+	session, err := f.client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdin = stdin
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(command)
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		session.Signal(ssh.SIGTERM)
+		return nil, ctx.Err()
+	}
+
+	if err != nil {
+		return nil, sshCommandError(key, command, err, stderr.Bytes())
+	}
+	return stdout.Bytes(), nil
+}
+
+func (f SSHFS) Read(ctx context.Context, key string) ([]byte, error) {
+	p := f.remotePath(key)
+	return f.run(ctx, key, fmt.Sprintf("test -f %s || exit %d; cat -- %s", p, sshNotExistExitCode, p), nil)
+}
+
+// Command which moves the temporary file into place. When exclusive is set,
+// fails if the target already exists.
+func commitCommand(tmp, p string, exclusive bool) string {
+	if exclusive {
+		// Hardlink fails if target exists, which makes it atomic
+		// exclusive create.
+		return fmt.Sprintf("{ ln -- %[1]s %[2]s 2>/dev/null || { rm -f -- %[1]s; exit %[3]d; }; } && rm -f -- %[1]s", tmp, p, sshExistExitCode)
+	}
+	return fmt.Sprintf("mv -f -- %[1]s %[2]s || { rm -f -- %[1]s; exit 1; }", tmp, p)
+}
+
+func (f SSHFS) Write(ctx context.Context, key string, data []byte) error {
+	w, err := f.create(ctx, key, false)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return multierror.Append(err, w.Abort()).ErrorOrNil()
+	}
+	return w.Close()
+}
+
+type sshFSReader struct {
+	reader  io.Reader
+	session *ssh.Session
+	stderr  *bytes.Buffer
+	key     string
+	command string
+	eof     bool
+}
+
+func (r *sshFSReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.eof = r.eof || err == io.EOF
+	return n, err
+}
+
+// Readers closed before the end kill the remote command, instead of reading
+// the rest of the file over the connection.
+func (r *sshFSReader) Close() error {
+	defer r.session.Close()
+	if !r.eof {
+		r.session.Signal(ssh.SIGKILL)
+		return nil
+	}
+	if err := r.session.Wait(); err != nil {
+		return sshCommandError(r.key, r.command, err, r.stderr.Bytes())
+	}
+	return nil
+}
+
+func (f SSHFS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	// Check existence first, so missing files are reported on open.
+	if ok, err := f.Exists(ctx, key); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	}
+
+	session, err := f.client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	stderr := &bytes.Buffer{}
+	session.Stderr = stderr
+
+	command := "cat -- " + f.remotePath(key)
+	if err = session.Start(command); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	return &sshFSReader{
+		reader:  bufio.NewReader(stdout),
+		session: session,
+		stderr:  stderr,
+		key:     key,
+		command: command,
+	}, nil
+}
+
+// Writes into the temporary file, which is moved into place by a separate
+// command on Close. A dropped connection ends the stream like a regular EOF
+// would, so it must never commit the file on its own.
+type sshFSWriter struct {
+	io.WriteCloser
+	fs        SSHFS
+	ctx       context.Context
+	session   *ssh.Session
+	stderr    *bytes.Buffer
+	key       string
+	command   string
+	tmp       string
+	exclusive bool
+}
+
+func (w *sshFSWriter) Close() error {
+	defer w.session.Close()
+	w.WriteCloser.Close()
+	if err := w.session.Wait(); err != nil {
+		err = sshCommandError(w.key, w.command, err, w.stderr.Bytes())
+		return multierror.Append(err, w.removeTemp()).ErrorOrNil()
+	}
+	_, err := w.fs.run(w.ctx, w.key, commitCommand(w.tmp, w.fs.remotePath(w.key), w.exclusive), nil)
+	return err
+}
+
+// Kills the remote cat and removes the temporary file.
+func (w *sshFSWriter) Abort() error {
+	w.session.Signal(ssh.SIGKILL)
+	w.session.Close()
+	w.session.Wait()
+	return w.removeTemp()
+}
+
+func (w *sshFSWriter) removeTemp() error {
+	_, err := w.fs.run(w.ctx, w.key, "rm -f -- "+w.tmp, nil)
+	return err
+}
+
+func (f SSHFS) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	return f.create(ctx, key, true)
+}
+
+func (f SSHFS) create(ctx context.Context, key string, exclusive bool) (*sshFSWriter, error) {
+	tmp, err := f.tempPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := f.client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	stderr := &bytes.Buffer{}
+	session.Stderr = stderr
+
+	command := "cat > " + tmp
+	if err = session.Start(command); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	return &sshFSWriter{
+		WriteCloser: stdin,
+		fs:          f,
+		ctx:         ctx,
+		session:     session,
+		stderr:      stderr,
+		key:         key,
+		command:     command,
+		tmp:         tmp,
+		exclusive:   exclusive,
+	}, nil
+}
+
+func (f SSHFS) Copy(ctx context.Context, src, dst string) error {
+	tmp, err := f.tempPath(dst)
+	if err != nil {
+		return err
+	}
+
+	s, p := f.remotePath(src), f.remotePath(dst)
+	_, err = f.run(ctx, dst, fmt.Sprintf("test -f %[1]s || exit %[4]d; cp -- %[1]s %[2]s && { ln -- %[2]s %[3]s 2>/dev/null || { rm -f -- %[2]s; exit %[5]d; }; } && rm -f -- %[2]s", s, tmp, p, sshNotExistExitCode, sshExistExitCode), nil)
+	return err
+}
+
+func (f SSHFS) MakeDir(ctx context.Context, key string) error {
+	_, err := f.run(ctx, key, "mkdir -p -- "+f.remotePath(key), nil)
+	return err
+}
+
+func (f SSHFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	p := f.remotePath(key)
+	output, err := f.run(ctx, key, fmt.Sprintf("test -d %s || exit %d; ls -1Ap -- %s", p, sshNotExistExitCode, p), nil)
+	if err != nil {
+		return NewErrorCursor[Entry](err)
+	}
+
+	var entries []Entry
+	for _, line := range strings.Split(string(output), "\n") {
+		if line == "" || isRemoteTempName(strings.TrimSuffix(line, "/")) {
+			continue
+		}
+		entries = append(entries, Entry{
+			Key:      strings.TrimSuffix(line, "/"),
+			IsPrefix: strings.HasSuffix(line, "/"),
+		})
+	}
+	return NewSliceCursor(entries)
+}
+
+func (f SSHFS) Remove(ctx context.Context, key string) error {
+	p := f.remotePath(key)
+	_, err := f.run(ctx, key, fmt.Sprintf("if test -d %[1]s; then rmdir -- %[1]s; elif test -e %[1]s; then rm -- %[1]s; else exit %[2]d; fi", p, sshNotExistExitCode), nil)
+	return err
+}
+
+func (f SSHFS) RemoveAll(ctx context.Context, key string) error {
+	_, err := f.run(ctx, key, "rm -rf -- "+f.remotePath(key), nil)
+	return err
+}
+
+func (f SSHFS) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := f.run(ctx, key, fmt.Sprintf("test -e %s || exit %d", f.remotePath(key), sshNotExistExitCode), nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (f SSHFS) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	p := f.remotePath(key)
+	// GNU stat first, BSD stat as a fallback.
+	output, err := f.run(ctx, key, fmt.Sprintf("test -f %[1]s || exit %[2]d; stat -c '%%s %%Y %%i' -- %[1]s 2>/dev/null || stat -f '%%z %%m %%i' -- %[1]s", p, sshNotExistExitCode), nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	var size, modTime, inode int64
+	if _, err = fmt.Sscan(string(output), &size, &modTime, &inode); err != nil {
+		return ObjectInfo{}, fmt.Errorf("stat %s: %w", key, err)
+	}
+	return ObjectInfo{
+		Key:     key,
+		Size:    size,
+		ModTime: time.Unix(modTime, 0),
+		ETag:    fmt.Sprintf("%x-%x-%x", inode, modTime, size),
+	}, nil
+}
@@ -0,0 +1,321 @@
+package shop
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Client of an in-process ssh server, which runs commands with the local sh
+// and serves the sftp subsystem.
+func newTestSSHClient(t *testing.T) *ssh.Client {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		serverConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(serverConn, serverConfig)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go serveTestSSHSession(channel, requests)
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func serveTestSSHSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	var lock sync.Mutex
+	var cmd *exec.Cmd
+
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			command := string(req.Payload[4:])
+			lock.Lock()
+			cmd = exec.Command("sh", "-c", command)
+			cmd.Stdout = channel
+			cmd.Stderr = channel.Stderr()
+			stdin, _ := cmd.StdinPipe()
+			err := cmd.Start()
+			lock.Unlock()
+			req.Reply(err == nil, nil)
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(stdin, channel)
+				stdin.Close()
+			}()
+			go func() {
+				var status [4]byte
+				var exitErr *exec.ExitError
+				if err := cmd.Wait(); errors.As(err, &exitErr) {
+					code := exitErr.ExitCode()
+					if code < 0 {
+						code = 128 + int(syscall.SIGKILL)
+					}
+					binary.BigEndian.PutUint32(status[:], uint32(code))
+				}
+				channel.SendRequest("exit-status", false, status[:])
+				channel.Close()
+			}()
+		case "subsystem":
+			req.Reply(string(req.Payload[4:]) == "sftp", nil)
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				return
+			}
+			go func() {
+				server.Serve()
+				server.Close()
+			}()
+		case "signal":
+			lock.Lock()
+			if cmd != nil && cmd.Process != nil {
+				cmd.Process.Signal(syscall.SIGKILL)
+			}
+			lock.Unlock()
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// Backends over the test ssh server, with files in dir.
+var sshTestBackends = map[string]func(t *testing.T, dir string) RepositoryFS{
+	"ssh": func(t *testing.T, dir string) RepositoryFS {
+		return SSHFS{client: newTestSSHClient(t), path: dir}
+	},
+	"sftp": func(t *testing.T, dir string) RepositoryFS {
+		client, err := sftp.NewClient(newTestSSHClient(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return SFTPFS{client: client, path: dir}
+	},
+}
+
+func TestSSHWriters(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing bool
+		write    bool
+		abort    bool
+		want     string
+		err      error
+	}{
+		{name: "close", want: "data"},
+		{name: "abort", abort: true},
+		{name: "existing", existing: true, want: "old", err: os.ErrExist},
+		{name: "write replaces existing", existing: true, write: true, want: "data"},
+	}
+
+	for backend, newFS := range sshTestBackends {
+		for _, test := range tests {
+			t.Run(backend+"/"+test.name, func(t *testing.T) {
+				ctx := context.Background()
+				dir := t.TempDir()
+				fs := newFS(t, dir)
+				if test.existing {
+					if err := os.WriteFile(filepath.Join(dir, "key"), []byte("old"), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				var err error
+				if test.write {
+					err = fs.Write(ctx, "key", []byte("data"))
+				} else {
+					err = createKey(ctx, fs, test.abort)
+				}
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+
+				entries, err := os.ReadDir(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var names []string
+				for _, entry := range entries {
+					names = append(names, entry.Name())
+				}
+				data, err := os.ReadFile(filepath.Join(dir, "key"))
+				if test.want == "" {
+					if len(names) != 0 {
+						t.Errorf("got files %v, want none", names)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != test.want || len(names) != 1 {
+					t.Errorf("got files %v with %q, want only key with %q", names, data, test.want)
+				}
+			})
+		}
+	}
+}
+
+func createKey(ctx context.Context, fs RepositoryFS, abort bool) error {
+	w, err := fs.Create(ctx, "key")
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write([]byte("data")); err != nil {
+		return err
+	}
+	if abort {
+		return w.(Aborter).Abort()
+	}
+	return w.Close()
+}
+
+func TestSSHListDir(t *testing.T) {
+	for _, backend := range []string{"ssh", "sftp"} {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+			fs := sshTestBackends[backend](t, dir)
+			testTree{
+				"a":             "a",
+				".b":            "b",
+				".a.tmp-0123":   "",
+				"d/x":           "x",
+				".d.tmp-4567/x": "",
+			}.writeInto(t, dir)
+
+			entries, err := CollectCursor(context.Background(), fs.ListDir(context.Background(), ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]bool{}
+			for _, entry := range entries {
+				got[entry.Key] = entry.IsPrefix
+			}
+			want := map[string]bool{"a": false, ".b": false, "d": true}
+			if len(got) != len(want) {
+				t.Fatalf("got entries %v, want %v", got, want)
+			}
+			for key, isPrefix := range want {
+				if prefix, ok := got[key]; !ok || prefix != isPrefix {
+					t.Errorf("got entries %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSSHReaderClose(t *testing.T) {
+	tests := []struct {
+		name string
+		// Endless file, which could not be read to the end.
+		endless bool
+	}{
+		{name: "read to the end"},
+		{name: "closed early", endless: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			fs := SSHFS{client: newTestSSHClient(t), path: dir}
+			p := filepath.Join(dir, "key")
+			if test.endless {
+				if err := syscall.Mkfifo(p, 0644); err != nil {
+					t.Fatal(err)
+				}
+				go func() {
+					fifo, err := os.OpenFile(p, os.O_WRONLY, 0)
+					if err != nil {
+						return
+					}
+					defer fifo.Close()
+					data := make([]byte, 1<<16)
+					for {
+						if _, err := fifo.Write(data); err != nil {
+							return
+						}
+					}
+				}()
+			} else if err := os.WriteFile(p, []byte("data"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := fs.Open(ctx, "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.endless {
+				_, err = io.ReadFull(r, make([]byte, 10))
+			} else {
+				_, err = io.ReadAll(r)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			done := make(chan error, 1)
+			go func() { done <- r.Close() }()
+			select {
+			case err = <-done:
+				if err != nil {
+					t.Errorf("got error %v on close", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("close reads the rest of the file")
+			}
+		})
+	}
+}
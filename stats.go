@@ -0,0 +1,218 @@
+package shop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CAS usage of a repository, the root one has empty Repo. Repositories
+// sharing the storage are reported once, under the first name.
+type RepoStats struct {
+	Repo     string `json:"repo"`
+	Archives int    `json:"archives"`
+	Size     int64  `json:"size"`
+}
+
+// Archives shared between packages are counted in each of them.
+type PackageStats struct {
+	Name      string `json:"name"`
+	Instances int    `json:"instances"`
+	Size      int64  `json:"size"`
+}
+
+// Registry usage at a point in time. Could be saved as a snapshot and
+// compared with later stats.
+type RegistryStats struct {
+	CollectedAt UnixTimestamp `json:"collected_at"`
+	Packages    int           `json:"packages"`
+	Instances   int           `json:"instances"`
+	Size        int64         `json:"size"`
+	// Sorted by name.
+	Repos []RepoStats `json:"repos"`
+	// Sorted by size, largest first.
+	PackageStats []PackageStats `json:"package_stats"`
+}
+
+// Difference between stats and an earlier snapshot.
+type StatsGrowth struct {
+	Since     UnixTimestamp `json:"since"`
+	Packages  int           `json:"packages"`
+	Instances int           `json:"instances"`
+	Size      int64         `json:"size"`
+	Repos     []RepoStats   `json:"repos"`
+}
+
+// Count packages and instances, and sizes of CAS archives found by listing
+// every repository.
+func (c *RegistryImpl) CollectStats(ctx context.Context) (*RegistryStats, error) {
+	stats := &RegistryStats{
+		CollectedAt:  UnixTimestamp{time.Now()},
+		Repos:        []RepoStats{},
+		PackageStats: []PackageStats{},
+	}
+
+	names := []string{""}
+	repos := map[string]Repository{"": c.rootRepository}
+	for name, repo := range c.repositories {
+		names = append(names, name)
+		repos[name] = repo
+	}
+	sort.Strings(names)
+
+	// Archive sizes keyed by repository url, then by id.
+	sizes := map[string]map[string]int64{}
+	for _, name := range names {
+		url := repos[name].GetConfig().URL
+		if _, ok := sizes[url]; ok {
+			continue
+		}
+		archives, err := c.collectArchiveSizes(ctx, repos[name])
+		if err != nil {
+			return nil, err
+		}
+		sizes[url] = archives
+
+		repoStats := RepoStats{Repo: name, Archives: len(archives)}
+		for _, size := range archives {
+			repoStats.Size += size
+		}
+		stats.Repos = append(stats.Repos, repoStats)
+		stats.Size += repoStats.Size
+	}
+
+	var packages []string
+	err := c.walkPackageNames(ctx, "", func(name string) error {
+		packages = append(packages, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats.PackageStats = make([]PackageStats, len(packages))
+	err = runJobs(ctx, c.Jobs(), len(packages), func(ctx context.Context, i int) error {
+		repo, err := c.packageRepository(ctx, packages[i])
+		if err != nil {
+			return err
+		}
+		ids, err := c.listInstanceIds(ctx, packages[i])
+		if err != nil {
+			return err
+		}
+
+		pkgStats := PackageStats{Name: packages[i], Instances: len(ids)}
+		for _, id := range ids {
+			pkgStats.Size += sizes[repo.GetConfig().URL][id]
+		}
+		stats.PackageStats[i] = pkgStats
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Packages = len(packages)
+	for _, pkgStats := range stats.PackageStats {
+		stats.Instances += pkgStats.Instances
+	}
+	sort.SliceStable(stats.PackageStats, func(i, j int) bool {
+		return stats.PackageStats[i].Size > stats.PackageStats[j].Size
+	})
+	return stats, nil
+}
+
+// Sizes of CAS archives in the repo by id, from Stat of every listed one.
+func (c *RegistryImpl) collectArchiveSizes(ctx context.Context, repo Repository) (map[string]int64, error) {
+	entries, err := CollectCursor(ctx, repo.List(ctx, RegistryCASPrefix))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var ids, keys []string
+	for _, entry := range entries {
+		id, compression, ok := parseCASName(entry.Key)
+		if !entry.IsPrefix && ok {
+			ids = append(ids, id)
+			keys = append(keys, InstanceCASKey(id, compression))
+		}
+	}
+
+	var lock sync.Mutex
+	sizes := map[string]int64{}
+	err = runJobs(ctx, repositoryJobs(repo.GetConfig()), len(ids), func(ctx context.Context, i int) error {
+		info, err := repo.Stat(ctx, keys[i])
+		if errors.Is(err, os.ErrNotExist) {
+			// Deleted since listing.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		sizes[ids[i]] = info.Size
+		return nil
+	})
+	return sizes, err
+}
+
+// The n largest packages.
+func (s RegistryStats) Largest(n int) []PackageStats {
+	return s.PackageStats[:min(n, len(s.PackageStats))]
+}
+
+func (s RegistryStats) GrowthSince(snapshot RegistryStats) StatsGrowth {
+	growth := StatsGrowth{
+		Since:     snapshot.CollectedAt,
+		Packages:  s.Packages - snapshot.Packages,
+		Instances: s.Instances - snapshot.Instances,
+		Size:      s.Size - snapshot.Size,
+		Repos:     []RepoStats{},
+	}
+
+	previous := map[string]RepoStats{}
+	for _, repo := range snapshot.Repos {
+		previous[repo.Repo] = repo
+	}
+	for _, repo := range s.Repos {
+		growth.Repos = append(growth.Repos, RepoStats{
+			Repo:     repo.Repo,
+			Archives: repo.Archives - previous[repo.Repo].Archives,
+			Size:     repo.Size - previous[repo.Repo].Size,
+		})
+	}
+	return growth
+}
+
+func LoadRegistryStats(path string) (*RegistryStats, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &RegistryStats{}
+	if err = json.Unmarshal(data, stats); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return stats, nil
+}
+
+func SaveRegistryStats(stats RegistryStats, path string) error {
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
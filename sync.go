@@ -0,0 +1,366 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	SyncPackage  = "package"
+	SyncInstance = "instance"
+	SyncArchive  = "archive"
+	SyncTag      = "tag"
+	SyncRef      = "ref"
+)
+
+type SyncOptions struct {
+	// Only packages under the prefix are copied.
+	Prefix string
+	// Only report changes.
+	DryRun bool
+	// Store archives of packages created in dst in its root repo, instead of
+	// the repo with the same name as in src.
+	RootRepo bool
+	// Instances of a package copied at once, the smaller of src and dst
+	// Jobs() if zero.
+	Jobs int
+}
+
+// Object copied (or to be copied) by SyncRegistries.
+type SyncChange struct {
+	Kind    string `json:"kind"`
+	Package string `json:"package"`
+	Object  string `json:"object,omitempty"`
+}
+
+type registrySyncer struct {
+	src, dst Registry
+	opts     SyncOptions
+	// Keys of tags to copy, all if nil.
+	tagKeys map[string]bool
+	changes []SyncChange
+}
+
+// Copy packages with their instances, archives, tags and refs from src to
+// dst. Sync is incremental: instances and tags are immutable and copied only
+// when missing, packages and refs are copied when missing or updated in src
+// after dst. Nothing is deleted from dst.
+func SyncRegistries(ctx context.Context, src, dst Registry, opts SyncOptions) ([]SyncChange, error) {
+	if opts.Jobs < 1 {
+		opts.Jobs = min(src.Jobs(), dst.Jobs())
+	}
+	s := &registrySyncer{
+		src:  src,
+		dst:  dst,
+		opts: opts,
+	}
+
+	err := src.WalkPackages(ctx, opts.Prefix, func(pkg Package) error {
+		return s.syncPackage(ctx, pkg)
+	})
+	return s.changes, err
+}
+
+func (s *registrySyncer) change(kind, pkg, object string, apply func() error) error {
+	s.changes = append(s.changes, SyncChange{Kind: kind, Package: pkg, Object: object})
+	if s.opts.DryRun {
+		return nil
+	}
+	return apply()
+}
+
+func (s *registrySyncer) syncPackage(ctx context.Context, pkg Package) error {
+	if err := s.syncPackageManifest(ctx, pkg); err != nil {
+		return err
+	}
+
+	instances, err := CollectCursor(ctx, s.src.ListPackageInstances(ctx, pkg.Name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// Every instance gets its own syncer, so changes are reported in order.
+	syncers := make([]registrySyncer, len(instances))
+	err = runJobs(ctx, s.opts.Jobs, len(instances), func(ctx context.Context, i int) error {
+		syncers[i] = registrySyncer{src: s.src, dst: s.dst, opts: s.opts, tagKeys: s.tagKeys}
+		return syncers[i].syncInstance(ctx, instances[i])
+	})
+	for _, syncer := range syncers {
+		s.changes = append(s.changes, syncer.changes...)
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.syncRefs(ctx, pkg.Name)
+}
+
+func (s *registrySyncer) syncPackageManifest(ctx context.Context, pkg Package) error {
+	dstPkg, err := s.dst.GetPackage(ctx, pkg.Name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// Archives of existing packages stay where they are.
+	if dstPkg != nil {
+		pkg.Repo = dstPkg.Repo
+	} else if s.opts.RootRepo {
+		pkg.Repo = ""
+	}
+	if dstPkg == nil || pkg.UpdatedAt.After(dstPkg.UpdatedAt.Time) {
+		return s.change(SyncPackage, pkg.Name, "", func() error {
+			return s.dst.PutPackage(ctx, pkg)
+		})
+	}
+	return nil
+}
+
+func (s *registrySyncer) syncInstance(ctx context.Context, instance Instance) error {
+	_, err := s.dst.GetPackageInstanceInfo(ctx, instance.Package, instance.Id)
+	if errors.Is(err, os.ErrNotExist) {
+		// Archive goes first, so instances are never left without one.
+		err = s.change(SyncArchive, instance.Package, instance.Id, func() error {
+			if err := s.copyArchive(ctx, &instance); err != nil {
+				return err
+			}
+			return s.copySigstoreBundle(ctx, instance)
+		})
+		if err == nil {
+			err = s.change(SyncInstance, instance.Package, instance.Id, func() error {
+				if err := s.copySBOM(ctx, instance); err != nil {
+					return err
+				}
+				return s.dst.PutPackageInstanceInfo(ctx, instance)
+			})
+		}
+		if err == nil && !s.opts.DryRun {
+			err = s.copyFiles(ctx, instance)
+		}
+		if err == nil && !s.opts.DryRun {
+			err = s.copySignatures(ctx, instance)
+		}
+		if err == nil && !s.opts.DryRun {
+			err = s.copyProvenance(ctx, instance)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	tags, err := CollectCursor(ctx, s.src.ListPackageInstanceTags(ctx, instance))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	dstTags, err := CollectCursor(ctx, s.dst.ListPackageInstanceTags(ctx, instance))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	existing := map[Tag]bool{}
+	for _, tag := range dstTags {
+		existing[tagIndexKey(tag)] = true
+	}
+
+	for _, tag := range tags {
+		if existing[tagIndexKey(tag)] || (s.tagKeys != nil && !s.tagKeys[tag.Key]) {
+			continue
+		}
+		err = s.change(SyncTag, instance.Package, fmt.Sprintf("%s:%s@%s", tag.Key, tag.Value, tag.Id), func() error {
+			return s.dst.PutPackageInstanceTag(ctx, tag)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// File manifest goes along with the instance, if the source has one.
+func (s *registrySyncer) copyFiles(ctx context.Context, instance Instance) error {
+	files, err := s.src.GetPackageInstanceFiles(ctx, instance)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.dst.PutPackageInstanceFiles(ctx, instance, files)
+}
+
+// SBOM goes before the instance manifest which refers to it.
+func (s *registrySyncer) copySBOM(ctx context.Context, instance Instance) error {
+	if instance.SBOM == nil {
+		return nil
+	}
+	body, err := s.src.GetPackageInstanceSBOM(ctx, instance)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return s.dst.PutPackageInstanceSBOM(ctx, instance, body)
+}
+
+// Provenance goes along with the instance, if the source has one.
+func (s *registrySyncer) copyProvenance(ctx context.Context, instance Instance) error {
+	statement, err := s.src.GetPackageInstanceProvenance(ctx, instance)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.dst.PutPackageInstanceProvenance(ctx, instance, *statement)
+}
+
+// Detached signatures go along with the instance.
+func (s *registrySyncer) copySignatures(ctx context.Context, instance Instance) error {
+	signatures, err := s.src.ListPackageInstanceSignatures(ctx, instance)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, signature := range signatures {
+		if err = s.dst.PutPackageInstanceSignature(ctx, instance, signature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Signature goes along with the archive, if the source has one.
+func (s *registrySyncer) copySigstoreBundle(ctx context.Context, instance Instance) error {
+	bundle, err := s.src.GetPackageInstanceSigstoreBundle(ctx, instance)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.dst.PutPackageInstanceSigstoreBundle(ctx, instance, *bundle)
+}
+
+// Stream the archive between registries. Download errors, including a hash
+// mismatch found at the end of the archive, fail the upload. Deltas whose
+// base is not in dst yet are stored file by file there, and the instance is
+// changed to match.
+func (s *registrySyncer) copyArchive(ctx context.Context, instance *Instance) error {
+	opts := UploadOptions{Compression: instance.Compression, DeltaBase: instance.DeltaBase}
+	if instance.DeltaBase != "" {
+		_, err := s.dst.GetPackageInstanceInfo(ctx, instance.Package, instance.DeltaBase)
+		if errors.Is(err, os.ErrNotExist) {
+			opts = UploadOptions{Compression: CompressionFiles}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(s.src.DownloadPackageInstance(ctx, *instance, writer))
+	}()
+	defer reader.Close()
+
+	uploaded, err := s.dst.UploadPackageInstance(ctx, instance.Package, instance.Id, reader, opts)
+	if err != nil {
+		return err
+	}
+	instance.Compression, instance.DeltaBase = uploaded.Compression, uploaded.DeltaBase
+	return nil
+}
+
+func (s *registrySyncer) syncRefs(ctx context.Context, pkg string) error {
+	refs, err := CollectCursor(ctx, s.src.ListPackageReferences(ctx, pkg))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for _, ref := range refs {
+		dstRef, err := s.dst.GetPackageReference(ctx, pkg, ref.Name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if dstRef != nil && (dstRef.Id == ref.Id || !ref.UpdatedAt.After(dstRef.UpdatedAt.Time)) {
+			continue
+		}
+
+		err = s.change(SyncRef, pkg, ref.Name, func() error {
+			return s.dst.PutPackageReference(ctx, ref)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type CopyInstanceOptions struct {
+	// Keys of tags to copy, all if nil.
+	Tags []string
+	// Refs to point to the instance in dst. If nil, refs pointing to it in
+	// src are copied.
+	Refs []string
+	// Only report changes.
+	DryRun bool
+}
+
+// Copy the instance with its archive, tags and refs into another registry,
+// preserving its id. The package is created in the dst root repo if it's
+// missing there.
+func CopyInstance(ctx context.Context, src, dst Registry, instance Instance, opts CopyInstanceOptions) ([]SyncChange, error) {
+	s := &registrySyncer{
+		src:  src,
+		dst:  dst,
+		opts: SyncOptions{DryRun: opts.DryRun, RootRepo: true},
+	}
+	if opts.Tags != nil {
+		s.tagKeys = map[string]bool{}
+		for _, key := range opts.Tags {
+			s.tagKeys[key] = true
+		}
+	}
+
+	pkg, err := src.GetPackage(ctx, instance.Package)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.syncPackageManifest(ctx, *pkg); err != nil {
+		return s.changes, err
+	}
+	if err = s.syncInstance(ctx, instance); err != nil {
+		return s.changes, err
+	}
+
+	names := opts.Refs
+	if names == nil {
+		refs, err := CollectCursor(ctx, src.ListPackageReferences(ctx, instance.Package))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return s.changes, err
+		}
+		for _, ref := range refs {
+			if ref.Id == instance.Id {
+				names = append(names, ref.Name)
+			}
+		}
+	}
+
+	for _, name := range names {
+		ref, err := NewReference(instance.Package, name, instance.Id)
+		if err != nil {
+			return s.changes, err
+		}
+		dstRef, err := dst.GetPackageReference(ctx, instance.Package, name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return s.changes, err
+		}
+		if dstRef != nil && dstRef.Id == instance.Id {
+			continue
+		}
+
+		err = s.change(SyncRef, instance.Package, name, func() error {
+			return dst.PutPackageReference(ctx, ref)
+		})
+		if err != nil {
+			return s.changes, err
+		}
+	}
+	return s.changes, nil
+}
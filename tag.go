@@ -30,8 +30,10 @@ func NewTag(pkg, key, value, id string) (tag Tag, err error) {
 		err = fmt.Errorf("%w: %s", ErrInvalidPackageName, pkg)
 	case !IsValidTagName(key):
 		err = fmt.Errorf("%w: %s:%s", ErrInvalidTagName, key, value)
-	case !IsValidTagValue(key):
+	case !IsValidTagValue(value):
 		err = fmt.Errorf("%w: %s:%s", ErrInvalidTagValue, key, value)
+	case key == VersionTagKey && !IsValidSemVer(value):
+		err = fmt.Errorf("%w: %s:%s: %w", ErrInvalidTagValue, key, value, ErrInvalidSemVer)
 	case !IsValidInstanceId(id):
 		err = fmt.Errorf("%w: %s", ErrInvalidInstanceId, id)
 	default:
@@ -77,7 +79,7 @@ func IsValidTagValue(v string) bool {
 		ok := (r >= 'A' && r <= 'Z') ||
 			(r >= 'a' && r <= 'z') ||
 			(r >= '0' && r <= '9') ||
-			(r == '_' && r == '-' && r == '@') ||
+			(r == '_' || r == '-' || r == '@') ||
 			(i != 0 && i != len(v)-1 && r == '.')
 
 		if !ok {
@@ -0,0 +1,74 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Ref moved to another instance. Previous is empty for the instance the ref
+// points to when watching starts.
+type ReferenceChange struct {
+	Package  string        `json:"package"`
+	Ref      string        `json:"ref"`
+	Id       string        `json:"id"`
+	Previous string        `json:"previous,omitempty"`
+	MovedAt  UnixTimestamp `json:"moved_at"`
+}
+
+// Poll the ref every interval and call fn when it points to another
+// instance, starting with the instance it points to now. Until the ref is
+// created, and after it's deleted, nothing is reported. Failed calls of fn
+// are repeated on the next poll. Failed polls are logged and retried, except
+// for the first one, which is returned. Returns nil once ctx is done.
+func WatchReference(ctx context.Context, registry Registry, pkg, name string, interval time.Duration, fn func(ctx context.Context, change ReferenceChange) error) error {
+	if !IsValidRefName(name) {
+		return fmt.Errorf("%w: %s", ErrInvalidReferenceName, name)
+	}
+	// Missing refs are waited for, missing packages are errors.
+	if _, err := registry.GetPackage(ctx, pkg); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var current string
+	for first := true; ; first = false {
+		ref, err := registry.GetPackageReference(ctx, pkg, name)
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil && first:
+			return err
+		case err != nil:
+			slog.Default().WarnContext(ctx, "ref poll failed", "package", pkg, "ref", name, "error", err)
+		case ref.Id != current:
+			change := ReferenceChange{
+				Package:  pkg,
+				Ref:      name,
+				Id:       ref.Id,
+				Previous: current,
+				MovedAt:  ref.UpdatedAt,
+			}
+			if err = fn(ctx, change); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				slog.Default().WarnContext(ctx, "ref change handler failed", "package", pkg, "ref", name, "id", ref.Id, "error", err)
+			} else {
+				current = ref.Id
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
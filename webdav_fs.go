@@ -0,0 +1,295 @@
+package shop
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	MustRegisterBackend(Backend{
+		Scheme:       "webdav",
+		Factory:      NewWebDAVFS,
+		Capabilities: BackendCapabilities{Range: true, ConditionalWrite: true, Copy: true, RemoveAll: true},
+		Jobs:         8,
+	})
+	MustRegisterBackend(Backend{
+		Scheme:       "webdavs",
+		Factory:      NewWebDAVFS,
+		Capabilities: BackendCapabilities{Range: true, ConditionalWrite: true, Copy: true, RemoveAll: true},
+		Jobs:         8,
+	})
+}
+
+var (
+	webDAVSchemes = map[string]string{
+		"webdav":  "http",
+		"webdavs": "https",
+	}
+)
+
+const webDAVPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getcontentlength/><D:getlastmodified/><D:getetag/></D:prop></D:propfind>`
+
+type WebDAVFS struct {
+	httpClient
+	cfg  RepositoryConfig
+	base *url.URL
+}
+
+func NewWebDAVFS(ctx context.Context, cfg RepositoryConfig) (RepositoryFS, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	base := *u
+	if scheme, ok := webDAVSchemes[u.Scheme]; ok {
+		base.Scheme = scheme
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return WebDAVFS{
+		httpClient: client,
+		cfg:        cfg,
+		base:       &base,
+	}, nil
+}
+
+func (f WebDAVFS) url(key string, isDir bool) string {
+	u := *f.base
+	u.Path = path.Join(u.Path, key)
+	if isDir {
+		u.Path += "/"
+	}
+	return u.String()
+}
+
+func (f WebDAVFS) Read(ctx context.Context, key string) ([]byte, error) {
+	body, err := f.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+func (f WebDAVFS) Write(ctx context.Context, key string, data []byte) error {
+	return f.doDiscard(ctx, http.MethodPut, f.url(key, false), bytes.NewReader(data), nil)
+}
+
+func (f WebDAVFS) WriteIf(ctx context.Context, key string, data []byte, etag string) error {
+	header := http.Header{}
+	if etag == "" {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", etag)
+	}
+
+	err := f.doDiscard(ctx, http.MethodPut, f.url(key, false), bytes.NewReader(data), header)
+	if errors.Is(err, ErrConditionFailed) {
+		err = fmt.Errorf("%w: %s", ErrConditionFailed, key)
+	}
+	return err
+}
+
+func (f WebDAVFS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := f.doOK(ctx, http.MethodGet, f.url(key, false), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (f WebDAVFS) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	return f.openRange(ctx, f.url(key, false), offset, length)
+}
+
+func (f WebDAVFS) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	header := http.Header{}
+	header.Set("If-None-Match", "*")
+	return f.upload(ctx, http.MethodPut, f.url(key, false), header), nil
+}
+
+func (f WebDAVFS) Copy(ctx context.Context, src, dst string) error {
+	header := http.Header{}
+	header.Set("Destination", f.url(dst, false))
+	header.Set("Overwrite", "F")
+	return f.doDiscard(ctx, "COPY", f.url(src, false), nil, header)
+}
+
+func (f WebDAVFS) MakeDir(ctx context.Context, key string) error {
+	u := *f.base
+	u.Path = "/"
+	for _, part := range strings.Split(path.Join(f.base.Path, key), "/") {
+		if part == "" {
+			continue
+		}
+		u.Path = path.Join(u.Path, part) + "/"
+
+		resp, err := f.do(ctx, "MKCOL", u.String(), nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		// 405 Method Not Allowed means that collection already exists.
+		if resp.StatusCode != http.StatusMethodNotAllowed && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+			return NewHTTPStatusError(resp)
+		}
+	}
+	return nil
+}
+
+type webDAVMultistatus struct {
+	Responses []webDAVResponse `xml:"DAV: response"`
+}
+
+type webDAVResponse struct {
+	Href     string           `xml:"DAV: href"`
+	Propstat []webDAVPropstat `xml:"DAV: propstat"`
+}
+
+type webDAVPropstat struct {
+	Prop struct {
+		ResourceType struct {
+			Collection *struct{} `xml:"DAV: collection"`
+		} `xml:"DAV: resourcetype"`
+		ContentLength string `xml:"DAV: getcontentlength"`
+		LastModified  string `xml:"DAV: getlastmodified"`
+		ETag          string `xml:"DAV: getetag"`
+	} `xml:"DAV: prop"`
+}
+
+func (r webDAVResponse) isCollection() bool {
+	for _, propstat := range r.Propstat {
+		if propstat.Prop.ResourceType.Collection != nil {
+			return true
+		}
+	}
+	return strings.HasSuffix(r.Href, "/")
+}
+
+func (f WebDAVFS) propfind(ctx context.Context, key string, depth string) (*webDAVMultistatus, error) {
+	header := http.Header{}
+	header.Set("Depth", depth)
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := f.doOK(ctx, "PROPFIND", f.url(key, depth != "0"), strings.NewReader(webDAVPropfindBody), header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	multistatus := &webDAVMultistatus{}
+	err = xml.NewDecoder(resp.Body).Decode(multistatus)
+	if err != nil {
+		return nil, err
+	}
+	return multistatus, nil
+}
+
+func (f WebDAVFS) ListDir(ctx context.Context, key string) Cursor[Entry] {
+	multistatus, err := f.propfind(ctx, key, "1")
+	if err != nil {
+		return NewErrorCursor[Entry](err)
+	}
+
+	self := path.Join(f.base.Path, key)
+	var entries []Entry
+	for _, response := range multistatus.Responses {
+		href, err := url.Parse(response.Href)
+		if err != nil {
+			return NewErrorCursor[Entry](err)
+		}
+
+		p := path.Clean("/" + href.Path)
+		if p == path.Clean("/"+self) {
+			continue
+		}
+
+		entries = append(entries, Entry{
+			Key:      path.Base(p),
+			IsPrefix: response.isCollection(),
+		})
+	}
+
+	return NewSliceCursor(entries)
+}
+
+// DELETE of a collection is recursive, so only empty ones are deleted here.
+func (f WebDAVFS) Remove(ctx context.Context, key string) error {
+	multistatus, err := f.propfind(ctx, key, "0")
+	if err != nil {
+		return err
+	}
+	if len(multistatus.Responses) == 0 || !multistatus.Responses[0].isCollection() {
+		return f.doDiscard(ctx, http.MethodDelete, f.url(key, false), nil, nil)
+	}
+
+	entries, err := CollectCursor(ctx, f.ListDir(ctx, key))
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return &os.PathError{Op: "remove", Path: key, Err: os.ErrExist}
+	}
+	return f.doDiscard(ctx, http.MethodDelete, f.url(key, true), nil, nil)
+}
+
+// DELETE of a collection removes everything in it.
+func (f WebDAVFS) RemoveAll(ctx context.Context, key string) error {
+	err := f.doDiscard(ctx, http.MethodDelete, f.url(key, true), nil, nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (f WebDAVFS) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := f.propfind(ctx, key, "0")
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (f WebDAVFS) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	multistatus, err := f.propfind(ctx, key, "0")
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if len(multistatus.Responses) == 0 || multistatus.Responses[0].isCollection() {
+		return ObjectInfo{}, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	}
+
+	info := ObjectInfo{Key: key}
+	for _, propstat := range multistatus.Responses[0].Propstat {
+		prop := propstat.Prop
+		if size, err := strconv.ParseInt(prop.ContentLength, 10, 64); err == nil {
+			info.Size = size
+		}
+		if modTime, err := http.ParseTime(prop.LastModified); err == nil {
+			info.ModTime = modTime
+		}
+		if prop.ETag != "" {
+			info.ETag = prop.ETag
+		}
+	}
+	return info, nil
+}
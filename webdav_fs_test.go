@@ -0,0 +1,91 @@
+package shop
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAVRemove(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		dirs  []string
+		key   string
+		err   error
+		// Keys expected to exist after the call.
+		kept []string
+	}{
+		{
+			name:  "file",
+			files: map[string]string{"d/a": "a", "d/b": "b"},
+			key:   "d/a",
+			kept:  []string{"d/b"},
+		},
+		{
+			name:  "non-empty dir",
+			files: map[string]string{"d/a": "a"},
+			key:   "d",
+			err:   fs.ErrExist,
+			kept:  []string{"d/a"},
+		},
+		{
+			name: "empty dir",
+			dirs: []string{"d"},
+			key:  "d",
+		},
+		{
+			name: "missing key",
+			key:  "d",
+			err:  fs.ErrNotExist,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			server := httptest.NewServer(&webdav.Handler{
+				FileSystem: webdav.NewMemFS(),
+				LockSystem: webdav.NewMemLS(),
+			})
+			defer server.Close()
+			f, err := NewWebDAVFS(ctx, RepositoryConfig{URL: strings.Replace(server.URL, "http:", "webdav:", 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, dir := range test.dirs {
+				if err = f.MakeDir(ctx, dir); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for key, content := range test.files {
+				if err = f.MakeDir(ctx, path.Dir(key)); err != nil {
+					t.Fatal(err)
+				}
+				if err = f.Write(ctx, key, []byte(content)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err = f.Remove(ctx, test.key); !errors.Is(err, test.err) {
+				t.Errorf("got error %v, want %v", err, test.err)
+			}
+			if test.err == nil {
+				if exists, err := f.Exists(ctx, test.key); err != nil || exists {
+					t.Errorf("%s exists after removal (error %v)", test.key, err)
+				}
+			}
+			for _, key := range test.kept {
+				if exists, err := f.Exists(ctx, key); err != nil || !exists {
+					t.Errorf("%s was removed (error %v)", key, err)
+				}
+			}
+		})
+	}
+}